@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+const apiPrefix = "/api/"
+
+type ApiBookList struct {
+	Data       []database.Book `json:"data"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type ApiError struct {
+	Error ApiErrorBody `json:"error"`
+}
+
+type ApiErrorBody struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// readOnlyBookFields are ignored when a client sends them in a body.
+var readOnlyBookFields = map[string]bool{"id": true, "created_at": true, "updated_at": true}
+
+func apiError(c echo.Context, status int, message string, fields map[string]string) error {
+	return c.JSON(status, ApiError{
+		Error: ApiErrorBody{
+			Status:  status,
+			Message: message,
+			Fields:  fields,
+		},
+	})
+}
+
+// httpErrorHandler answers API requests with the same JSON error body the API
+// handlers use and leaves every other request to echo.
+func httpErrorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if !strings.HasPrefix(c.Request().URL.Path, apiPrefix) || c.Response().Committed {
+			e.DefaultHTTPErrorHandler(err, c)
+			return
+		}
+
+		status := http.StatusInternalServerError
+		message := http.StatusText(status)
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			status = he.Code
+			message = fmt.Sprint(he.Message)
+		} else {
+			c.Logger().Error(err)
+		}
+		apiError(c, status, message, nil)
+	}
+}
+
+func apiBookId(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+	return id, nil
+}
+
+func apiGetBook(c echo.Context, id int) (*database.Book, error) {
+	book, err := database.GetBookById(currentLibrary(c).Id, id)
+	if err != nil {
+		return nil, err
+	}
+	if book.Id == 0 {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+	return book, nil
+}
+
+// expectedVersion reads the version a write is based on from the If-Match
+// header, falling back to the version in the body.
+func expectedVersion(c echo.Context, bodyVersion int) int {
+	ifMatch := strings.Trim(strings.TrimPrefix(c.Request().Header.Get("If-Match"), "W/"), `"`)
+	if version, err := strconv.Atoi(ifMatch); err == nil {
+		return version
+	}
+	return bodyVersion
+}
+
+func setBookETag(c echo.Context, book *database.Book) {
+	c.Response().Header().Set("ETag", fmt.Sprintf(`"%d"`, book.Version))
+}
+
+// saveApiBook saves book and turns the outcome into a JSON response.
+func saveApiBook(c echo.Context, book *database.Book, status int) error {
+	if message := book.SetCopyrightDate(book.CopyrightDateString); message != "" {
+		return apiError(c, http.StatusUnprocessableEntity, "validation failed", map[string]string{
+			"copyright_date": message,
+		})
+	}
+
+	errorMap, err := book.Save()
+	if errors.Is(err, database.ErrVersionConflict) {
+		return apiError(c, http.StatusConflict, "book was changed by someone else, fetch it again and reapply your changes", nil)
+	}
+	if errors.Is(err, database.ErrBookNotFound) {
+		return apiError(c, http.StatusNotFound, "book not found", nil)
+	}
+	if err != nil {
+		return err
+	}
+	if len(errorMap) > 0 {
+		return apiError(c, http.StatusUnprocessableEntity, "validation failed", errorMap)
+	}
+
+	saved, err := apiGetBook(c, book.Id)
+	if err != nil {
+		return err
+	}
+	setBookETag(c, saved)
+	if status == http.StatusCreated {
+		c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/v1/books/%d", saved.Id))
+	}
+	return c.JSON(status, saved)
+}
+
+func ApiListBooks(c echo.Context) error {
+	query := database.BookQuery{
+		Search:      c.QueryParam("q"),
+		Genre:       c.QueryParam("genre"),
+		AuthorLast:  c.QueryParam("author_last"),
+		AuthorFirst: c.QueryParam("author_first"),
+		Sort:        strings.TrimPrefix(c.QueryParam("sort"), "-"),
+		Desc:        strings.HasPrefix(c.QueryParam("sort"), "-"),
+		Cursor:      c.QueryParam("cursor"),
+		Limit:       25,
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > database.MAX_BOOK_QUERY_LIMIT {
+			return apiError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", database.MAX_BOOK_QUERY_LIMIT), nil)
+		}
+	}
+
+	page, err := database.ListBooks(currentLibrary(c).Id, query)
+	if errors.Is(err, database.ErrInvalidQuery) {
+		return apiError(c, http.StatusBadRequest, err.Error(), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	books := page.Books
+	if books == nil {
+		books = []database.Book{}
+	}
+	return c.JSON(http.StatusOK, ApiBookList{
+		Data:       books,
+		NextCursor: page.NextCursor,
+	})
+}
+
+func ApiGetBook(c echo.Context) error {
+	id, err := apiBookId(c)
+	if err != nil {
+		return err
+	}
+	book, err := apiGetBook(c, id)
+	if err != nil {
+		return err
+	}
+	setBookETag(c, book)
+	return c.JSON(http.StatusOK, book)
+}
+
+func ApiCreateBook(c echo.Context) error {
+	var book database.Book
+	err := json.NewDecoder(c.Request().Body).Decode(&book)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "request body must be a JSON book", nil)
+	}
+	book.Id = -1
+	book.LibraryId = currentLibrary(c).Id
+	return saveApiBook(c, &book, http.StatusCreated)
+}
+
+func ApiUpdateBook(c echo.Context) error {
+	id, err := apiBookId(c)
+	if err != nil {
+		return err
+	}
+
+	var book database.Book
+	err = json.NewDecoder(c.Request().Body).Decode(&book)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "request body must be a JSON book", nil)
+	}
+	book.Id = id
+	book.LibraryId = currentLibrary(c).Id
+	book.Version = expectedVersion(c, book.Version)
+	if book.Version == 0 {
+		return apiError(c, http.StatusPreconditionRequired, "send the version being replaced in the body or an If-Match header", nil)
+	}
+	return saveApiBook(c, &book, http.StatusOK)
+}
+
+// ApiPatchBook applies a JSON merge patch: only the fields present in the
+// body change.
+func ApiPatchBook(c echo.Context) error {
+	id, err := apiBookId(c)
+	if err != nil {
+		return err
+	}
+
+	var patch map[string]json.RawMessage
+	err = json.NewDecoder(c.Request().Body).Decode(&patch)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "request body must be a JSON object", nil)
+	}
+
+	stored, err := apiGetBook(c, id)
+	if err != nil {
+		return err
+	}
+	var patchVersion int
+	if raw, ok := patch["version"]; ok {
+		json.Unmarshal(raw, &patchVersion)
+	}
+
+	current, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	var merged map[string]json.RawMessage
+	err = json.Unmarshal(current, &merged)
+	if err != nil {
+		return err
+	}
+	for field, value := range patch {
+		if _, known := merged[field]; !known {
+			return apiError(c, http.StatusBadRequest, fmt.Sprintf("unknown field %q", field), nil)
+		}
+		if readOnlyBookFields[field] {
+			continue
+		}
+		if string(value) == "null" {
+			value = json.RawMessage(`""`)
+		}
+		merged[field] = value
+	}
+
+	mergedJson, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	var book database.Book
+	err = json.Unmarshal(mergedJson, &book)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "book fields must be strings", nil)
+	}
+	book.Id = stored.Id
+	book.LibraryId = stored.LibraryId
+	book.Version = expectedVersion(c, patchVersion)
+	if book.Version == 0 {
+		book.Version = stored.Version
+	}
+	return saveApiBook(c, &book, http.StatusOK)
+}
+
+func ApiDeleteBook(c echo.Context) error {
+	id, err := apiBookId(c)
+	if err != nil {
+		return err
+	}
+	book, err := apiGetBook(c, id)
+	if err != nil {
+		return err
+	}
+	if version := expectedVersion(c, 0); version != 0 && version != book.Version {
+		return apiError(c, http.StatusConflict, "book was changed by someone else", nil)
+	}
+
+	err = database.DeleteBook(book.LibraryId, book.Id)
+	if err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
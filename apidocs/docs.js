@@ -0,0 +1,194 @@
+// Renders the OpenAPI document served by the application and lets you try
+// each operation against the running server.
+(function () {
+  "use strict";
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (key) {
+      if (key === "text") {
+        node.textContent = attrs[key];
+      } else {
+        node.setAttribute(key, attrs[key]);
+      }
+    });
+    (children || []).forEach(function (child) {
+      node.appendChild(child);
+    });
+    return node;
+  }
+
+  function resolve(spec, schema) {
+    if (schema && schema.$ref) {
+      return spec.components.schemas[schema.$ref.split("/").pop()];
+    }
+    return schema;
+  }
+
+  // example builds a sample value for a schema, leaving out read only fields
+  function example(spec, schema) {
+    schema = resolve(spec, schema);
+    if (!schema) {
+      return null;
+    }
+    if (schema.type === "object") {
+      var value = {};
+      Object.keys(schema.properties || {}).forEach(function (name) {
+        var property = schema.properties[name];
+        if (!property.readOnly) {
+          value[name] = example(spec, property);
+        }
+      });
+      return value;
+    }
+    if (schema.type === "array") {
+      return [example(spec, schema.items)];
+    }
+    if (schema.type === "integer") {
+      return 0;
+    }
+    if (schema.format === "date") {
+      return "2000-01-31";
+    }
+    if (schema.format === "edtf") {
+      return "1954";
+    }
+    return "";
+  }
+
+  function renderOperation(spec, base, path, method, operation, shared) {
+    var parameters = (shared || []).concat(operation.parameters || []);
+    var inputs = {};
+    var form = el("form");
+
+    parameters.forEach(function (parameter) {
+      var input = el("input", { name: parameter.name, placeholder: parameter.in });
+      if (parameter.schema && parameter.schema.enum) {
+        input.setAttribute("list", operation.operationId + "-" + parameter.name);
+        form.appendChild(el("datalist", { id: operation.operationId + "-" + parameter.name },
+          parameter.schema.enum.map(function (value) { return el("option", { value: value }); })));
+      }
+      inputs[parameter.name] = { parameter: parameter, input: input };
+      form.appendChild(el("label", { text: parameter.name + (parameter.required ? " *" : "") + (parameter.description ? " - " + parameter.description : "") }));
+      form.appendChild(input);
+    });
+
+    var body = null;
+    if (operation.requestBody) {
+      var content = operation.requestBody.content;
+      var mediaType = Object.keys(content)[0];
+      body = el("textarea", { name: "body" });
+      body.value = JSON.stringify(example(spec, content[mediaType].schema), null, 2);
+      form.appendChild(el("label", { text: "Body (" + mediaType + ")" }));
+      form.appendChild(body);
+    }
+
+    var output = el("pre", { text: "" });
+    form.appendChild(el("button", { type: "submit", text: "Send" }));
+    form.addEventListener("submit", function (event) {
+      event.preventDefault();
+      var url = base + path;
+      var query = new URLSearchParams();
+      var headers = { "Accept": "application/json" };
+      Object.keys(inputs).forEach(function (name) {
+        var value = inputs[name].input.value;
+        var location = inputs[name].parameter.in;
+        if (value === "") {
+          return;
+        }
+        if (location === "path") {
+          url = url.replace("{" + name + "}", encodeURIComponent(value));
+        } else if (location === "query") {
+          query.append(name, value);
+        } else if (location === "header") {
+          headers[name] = value;
+        }
+      });
+      if (query.toString()) {
+        url += "?" + query.toString();
+      }
+      var init = { method: method.toUpperCase(), headers: headers, credentials: "same-origin" };
+      if (body) {
+        headers["Content-Type"] = "application/json";
+        init.body = body.value;
+      }
+      output.textContent = init.method + " " + url + "\n...";
+      fetch(url, init).then(function (response) {
+        return response.text().then(function (text) {
+          var etag = response.headers.get("ETag");
+          try {
+            text = JSON.stringify(JSON.parse(text), null, 2);
+          } catch (e) {
+            // Not JSON, show it as is
+          }
+          output.textContent = init.method + " " + url + "\n" + response.status + " " + response.statusText +
+            (etag ? "\nETag: " + etag : "") + "\n\n" + text;
+        });
+      }).catch(function (err) {
+        output.textContent = String(err);
+      });
+    });
+
+    var responses = el("table", {}, Object.keys(operation.responses).map(function (status) {
+      return el("tr", {}, [
+        el("td", { text: status }),
+        el("td", { text: operation.responses[status].description })
+      ]);
+    }));
+
+    return el("details", { "class": "operation" }, [
+      el("summary", {}, [
+        el("span", { "class": "method", text: method }),
+        el("code", { text: path }),
+        el("span", { text: " " + (operation.summary || "") })
+      ]),
+      el("p", { text: operation.description || "" }),
+      responses,
+      form,
+      output
+    ]);
+  }
+
+  function renderSchema(name, schema) {
+    var rows = Object.keys(schema.properties || {}).map(function (property) {
+      var p = schema.properties[property];
+      var type = p.$ref ? p.$ref.split("/").pop() : p.type + (p.format ? " (" + p.format + ")" : "");
+      var notes = [];
+      if ((schema.required || []).indexOf(property) !== -1) {
+        notes.push("required");
+      }
+      if (p.readOnly) {
+        notes.push("read only");
+      }
+      if (p.description) {
+        notes.push(p.description);
+      }
+      return el("tr", {}, [el("td", {}, [el("code", { text: property })]), el("td", { text: type }), el("td", { text: notes.join(", ") })]);
+    });
+    return el("div", {}, [el("h3", { text: name }), el("table", {}, rows)]);
+  }
+
+  fetch("/api/openapi.json").then(function (response) {
+    return response.json();
+  }).then(function (spec) {
+    var base = (spec.servers && spec.servers[0] && spec.servers[0].url) || "";
+    document.title = spec.info.title;
+    document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+    document.getElementById("description").textContent = spec.info.description || "";
+
+    var operations = document.getElementById("operations");
+    Object.keys(spec.paths).forEach(function (path) {
+      var item = spec.paths[path];
+      ["get", "post", "put", "patch", "delete"].forEach(function (method) {
+        if (item[method]) {
+          operations.appendChild(renderOperation(spec, base, path, method, item[method], item.parameters));
+        }
+      });
+    });
+
+    var schemas = document.getElementById("schemas");
+    Object.keys(spec.components.schemas).forEach(function (name) {
+      schemas.appendChild(renderSchema(name, spec.components.schemas[name]));
+    });
+  });
+})();
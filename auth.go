@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/oidc"
+
+	"github.com/labstack/echo/v4"
+)
+
+const sessionCookie = "session"
+const oidcLoginCookie = "oidc_login"
+const sessionLength = 12 * time.Hour
+
+// Instance settings that configure OpenID Connect sign in. Sign in is
+// required once both the issuer and client id are set.
+const (
+	settingOidcIssuer             = "oidc.issuer"
+	settingOidcClientId           = "oidc.client_id"
+	settingOidcClientSecret       = "oidc.client_secret"
+	settingOidcRedirectURL        = "oidc.redirect_url"
+	settingOidcScopes             = "oidc.scopes"
+	settingOidcGroupsClaim        = "oidc.groups_claim"
+	settingOidcRoleMap            = "oidc.role_map"
+	settingOidcDefaultRole        = "oidc.default_role"
+	settingOidcDefaultLibrary     = "oidc.default_library"
+	settingOidcPostLogoutRedirect = "oidc.post_logout_redirect_url"
+)
+
+type oidcSettings struct {
+	Config             oidc.Config
+	GroupsClaim        string
+	RoleMap            map[string]string
+	DefaultRole        string
+	DefaultLibrary     int
+	PostLogoutRedirect string
+}
+
+// oidcLogin is kept in a short lived cookie between sending the browser to
+// the provider and handling the callback.
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+var roleRank = map[string]int{
+	database.RoleViewer:    1,
+	database.RoleLibrarian: 2,
+	database.RoleAdmin:     3,
+}
+
+// loadOidcSettings reads the sign in configuration from the instance
+// settings. It returns nil when sign in is not configured.
+func loadOidcSettings(c echo.Context) (*oidcSettings, error) {
+	values := map[string]string{}
+	settings, err := database.GetSettings(database.InstanceLibraryId)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		values[s.Key] = s.Value
+	}
+	if values[settingOidcIssuer] == "" || values[settingOidcClientId] == "" {
+		return nil, nil
+	}
+
+	scopes := strings.Fields(values[settingOidcScopes])
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	redirectURL := values[settingOidcRedirectURL]
+	if redirectURL == "" {
+		redirectURL = baseURL(c) + "/auth/callback"
+	}
+	groupsClaim := values[settingOidcGroupsClaim]
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	defaultRole := values[settingOidcDefaultRole]
+	if _, ok := roleRank[defaultRole]; !ok {
+		defaultRole = database.RoleViewer
+	}
+	defaultLibrary, err := strconv.Atoi(values[settingOidcDefaultLibrary])
+	if err != nil {
+		defaultLibrary = 1
+	}
+
+	return &oidcSettings{
+		Config: oidc.Config{
+			Issuer:       values[settingOidcIssuer],
+			ClientId:     values[settingOidcClientId],
+			ClientSecret: values[settingOidcClientSecret],
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		GroupsClaim:        groupsClaim,
+		RoleMap:            parseRoleMap(values[settingOidcRoleMap]),
+		DefaultRole:        defaultRole,
+		DefaultLibrary:     defaultLibrary,
+		PostLogoutRedirect: values[settingOidcPostLogoutRedirect],
+	}, nil
+}
+
+// parseRoleMap reads "group=role" pairs separated by commas, for example
+// "library-admins=admin, staff=librarian".
+func parseRoleMap(value string) map[string]string {
+	roleMap := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		role = strings.TrimSpace(role)
+		if _, known := roleRank[role]; known {
+			roleMap[strings.TrimSpace(group)] = role
+		}
+	}
+	return roleMap
+}
+
+// roleForGroups picks the highest role any of the user's groups maps to.
+func (s *oidcSettings) roleForGroups(groups []string) string {
+	role := s.DefaultRole
+	for _, group := range groups {
+		if mapped, ok := s.RoleMap[group]; ok && roleRank[mapped] > roleRank[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// isPublicPath reports whether a path is served without signing in. The
+// catalog services among them only read, and name their library in the path.
+func isPublicPath(path string) bool {
+	return strings.HasPrefix(path, "/auth/") ||
+		strings.HasPrefix(path, oaiPath+"/") ||
+		strings.HasPrefix(path, sruPath+"/") ||
+		strings.HasPrefix(path, opdsPath+"/") ||
+		strings.HasPrefix(path, "/css/") ||
+		strings.HasPrefix(path, "/api/docs") ||
+		path == "/api/openapi.json"
+}
+
+// AuthMiddleware requires a signed in user once OpenID Connect is configured.
+// Viewers may only read; every other method needs at least the librarian role.
+func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isPublicPath(c.Request().URL.Path) {
+			return next(c)
+		}
+		settings, err := loadOidcSettings(c)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		if settings == nil {
+			return next(c)
+		}
+
+		cookie, err := c.Cookie(sessionCookie)
+		if err != nil {
+			return redirectToLogin(c)
+		}
+		user, _, err := database.GetSessionUser(cookie.Value)
+		if errors.Is(err, database.ErrSessionNotFound) {
+			return redirectToLogin(c)
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		c.Set("user", user)
+
+		// GraphQL queries are POSTed too, so mutations check roles themselves
+		method := c.Request().Method
+		if method != http.MethodGet && c.Request().URL.Path != graphqlPath && method != http.MethodHead && !hasRole(c, database.RoleLibrarian) {
+			return echo.NewHTTPError(http.StatusForbidden, "your role does not allow changes")
+		}
+		return next(c)
+	}
+}
+
+// currentUser returns the signed in user, or nil when sign in is disabled.
+func currentUser(c echo.Context) *database.User {
+	user, _ := c.Get("user").(*database.User)
+	return user
+}
+
+// currentUserId is the id of the signed in user, or 0 while sign in is
+// disabled.
+func currentUserId(c echo.Context) int {
+	if user := currentUser(c); user != nil {
+		return user.Id
+	}
+	return 0
+}
+
+// hasRole reports whether the current user has at least role. Everyone has
+// every role while sign in is disabled.
+func hasRole(c echo.Context, role string) bool {
+	user := currentUser(c)
+	if user == nil {
+		return true
+	}
+	return roleRank[user.Role] >= roleRank[role]
+}
+
+func redirectToLogin(c echo.Context) error {
+	if strings.HasPrefix(c.Request().URL.Path, apiPrefix) {
+		return apiError(c, http.StatusUnauthorized, "sign in required", nil)
+	}
+	if c.Request().URL.Path == graphqlPath {
+		return graphqlError(c, http.StatusUnauthorized, "sign in required")
+	}
+	target := "/auth/login?next=" + c.Request().URL.RequestURI()
+	if c.Request().Header.Get("HX-Request") != "" {
+		c.Response().Header().Set("HX-Redirect", target)
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	return c.Redirect(http.StatusFound, target)
+}
+
+func Login(c echo.Context) error {
+	settings, err := loadOidcSettings(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if settings == nil {
+		return c.Redirect(http.StatusFound, "/books")
+	}
+
+	provider, err := oidc.NewProvider(c.Request().Context(), settings.Config)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusBadGateway, "unable to reach identity provider")
+	}
+
+	login := oidcLogin{Next: c.QueryParam("next")}
+	if !strings.HasPrefix(login.Next, "/") || strings.HasPrefix(login.Next, "//") {
+		login.Next = "/books"
+	}
+	for _, value := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		*value, err = oidc.RandomString()
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(encoded),
+		Path:     "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, provider.AuthCodeURL(login.State, login.Nonce, login.Verifier))
+}
+
+func LoginCallback(c echo.Context) error {
+	settings, err := loadOidcSettings(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if settings == nil {
+		return c.Redirect(http.StatusFound, "/books")
+	}
+
+	if providerError := c.QueryParam("error"); providerError != "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("sign in failed: %s %s", providerError, c.QueryParam("error_description")))
+	}
+
+	cookie, err := c.Cookie(oidcLoginCookie)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "sign in expired, please try again")
+	}
+	c.SetCookie(&http.Cookie{Name: oidcLoginCookie, Path: "/auth/", MaxAge: -1})
+
+	var login oidcLogin
+	decoded, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err == nil {
+		err = json.Unmarshal(decoded, &login)
+	}
+	if err != nil || login.State == "" || login.State != c.QueryParam("state") {
+		return echo.NewHTTPError(http.StatusBadRequest, "sign in state mismatch, please try again")
+	}
+
+	ctx := c.Request().Context()
+	provider, err := oidc.NewProvider(ctx, settings.Config)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusBadGateway, "unable to reach identity provider")
+	}
+	token, err := provider.Exchange(ctx, c.QueryParam("code"), login.Verifier)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "sign in failed")
+	}
+	claims, err := provider.VerifyIdToken(ctx, token.IdToken, login.Nonce)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "sign in failed")
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	if name == "" {
+		name = claims.Email
+	}
+	role := settings.roleForGroups(claims.Strings(settings.GroupsClaim))
+	user, err := database.UpsertOidcUser(claims.Issuer, claims.Subject, name, claims.Email, role)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	libraries, err := database.GetUserLibraries(user.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if len(libraries) == 0 {
+		err = database.AddLibraryUser(settings.DefaultLibrary, user.Id)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+	}
+
+	sessionToken, err := database.CreateSession(user.Id, token.IdToken, time.Now().Add(sessionLength))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionLength),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusFound, login.Next)
+}
+
+func Logout(c echo.Context) error {
+	var idToken string
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		_, idToken, _ = database.GetSessionUser(cookie.Value)
+		err = database.DeleteSession(cookie.Value)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+	}
+	c.SetCookie(&http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+
+	signedOut := baseURL(c) + "/auth/signed-out"
+	settings, err := loadOidcSettings(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if settings != nil {
+		if settings.PostLogoutRedirect != "" {
+			signedOut = settings.PostLogoutRedirect
+		}
+		provider, err := oidc.NewProvider(c.Request().Context(), settings.Config)
+		if err == nil {
+			if logoutURL := provider.LogoutURL(idToken, signedOut); logoutURL != "" {
+				return c.Redirect(http.StatusFound, logoutURL)
+			}
+		}
+	}
+	return c.Redirect(http.StatusFound, signedOut)
+}
+
+func SignedOut(c echo.Context) error {
+	return c.Render(http.StatusOK, "signed-out", BookContent{
+		Header: Header{
+			Title: "Signed Out",
+		},
+	})
+}
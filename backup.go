@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"mlibrary-htmx/pkg/covers"
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+// A backup is a zip of a manifest, a snapshot of the database and the
+// covers.
+const (
+	BACKUP_FORMAT   = "mlibrary-backup"
+	BACKUP_VERSION  = 1
+	BACKUP_MANIFEST = "manifest.json"
+	BACKUP_DATABASE = "library.db"
+	BACKUP_COVERS   = "covers/"
+)
+
+var backupCoverName = regexp.MustCompile(`^covers/([0-9]+)\.jpg$`)
+
+type BackupManifest struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Books         int       `json:"books"`
+	Covers        int       `json:"covers"`
+}
+
+type RestoreResult struct {
+	Manifest     BackupManifest
+	MigratedFrom int
+	Migrated     bool
+	Error        string
+}
+
+const COUNT_BOOKS_QUERY = `SELECT COUNT(*) FROM master_books`
+
+func GetBackup(c echo.Context) error {
+	if !hasRole(c, database.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can back up")
+	}
+
+	snapshot, err := os.CreateTemp("", "mlibrary-backup-*.db")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	snapshot.Close()
+	defer os.Remove(snapshot.Name())
+	err = database.Snapshot(snapshot.Name())
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	manifest := BackupManifest{
+		Format:    BACKUP_FORMAT,
+		Version:   BACKUP_VERSION,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	db, err := sql.Open("sqlite3", "file:"+snapshot.Name()+"?mode=ro")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	manifest.SchemaVersion, err = database.GetSchemaVersion(db)
+	if err == nil {
+		err = db.QueryRow(COUNT_BOOKS_QUERY).Scan(&manifest.Books)
+	}
+	db.Close()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	coverIds, err := covers.Ids()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	manifest.Covers = len(coverIds)
+
+	filename := fmt.Sprintf("mlibrary-backup-%s.zip", manifest.CreatedAt.Format("20060102-150405"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%s", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	entry, err := archive.Create(BACKUP_MANIFEST)
+	if err == nil {
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(manifest)
+	}
+	if err == nil {
+		err = addBackupFile(archive, BACKUP_DATABASE, snapshot.Name())
+	}
+	for _, id := range coverIds {
+		if err != nil {
+			break
+		}
+		err = addBackupFile(archive, BACKUP_COVERS+strconv.Itoa(id)+".jpg", covers.Path(id))
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// The headers are sent, so all that is left is to cut the archive short
+		c.Logger().Error(err)
+	}
+	return nil
+}
+
+func addBackupFile(archive *zip.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
+
+func RestoreBackup(c echo.Context) error {
+	if !hasRole(c, database.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can restore backups")
+	}
+	file, err := c.FormFile("backup")
+	if err != nil {
+		return c.Render(http.StatusOK, "restore-result", RestoreResult{Error: "Choose a backup to restore."})
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	defer src.Close()
+
+	upload, err := os.CreateTemp("", "mlibrary-restore-*.zip")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+	size, err := io.Copy(upload, src)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	result, err := restoreArchive(upload, size)
+	if err != nil {
+		c.Logger().Error(err)
+		result.Error = err.Error()
+	}
+	return c.Render(http.StatusOK, "restore-result", result)
+}
+
+// restoreArchive checks a backup, migrates its database when it is older
+// than this build, and puts it and its covers in place of the current
+// ones, which are kept beside them.
+func restoreArchive(f io.ReaderAt, size int64) (RestoreResult, error) {
+	var result RestoreResult
+	archive, err := zip.NewReader(f, size)
+	if err != nil {
+		return result, fmt.Errorf("file is not a backup archive")
+	}
+	var dbEntry *zip.File
+	var coverEntries []*zip.File
+	for _, entry := range archive.File {
+		switch {
+		case entry.Name == BACKUP_MANIFEST:
+			err = readManifest(entry, &result.Manifest)
+			if err != nil {
+				return result, err
+			}
+		case entry.Name == BACKUP_DATABASE:
+			dbEntry = entry
+		case backupCoverName.MatchString(entry.Name):
+			coverEntries = append(coverEntries, entry)
+		}
+	}
+	manifest := result.Manifest
+	if manifest.Format != BACKUP_FORMAT {
+		return result, fmt.Errorf("file is not a backup archive")
+	}
+	if manifest.Version > BACKUP_VERSION || manifest.SchemaVersion > database.SchemaVersion {
+		return result, fmt.Errorf("backup was made by a newer version and cannot be restored")
+	}
+	if dbEntry == nil {
+		return result, fmt.Errorf("backup has no %s", BACKUP_DATABASE)
+	}
+
+	restored, err := os.CreateTemp("", "mlibrary-restore-*.db")
+	if err != nil {
+		return result, err
+	}
+	restored.Close()
+	defer os.Remove(restored.Name())
+	err = extractFile(dbEntry, restored.Name())
+	if err != nil {
+		return result, fmt.Errorf("unable to read backup: %v", err)
+	}
+	result.MigratedFrom, err = prepareDatabase(restored.Name())
+	if err != nil {
+		return result, err
+	}
+	result.Migrated = result.MigratedFrom < database.SchemaVersion
+
+	stamp := time.Now().Format("20060102-150405.000")
+	coversDir, err := extractCovers(coverEntries, stamp)
+	if err != nil {
+		return result, err
+	}
+	err = database.Snapshot(database.FilePath + ".before-restore-" + stamp)
+	if err != nil {
+		os.RemoveAll(coversDir)
+		return result, err
+	}
+	err = database.Restore(restored.Name())
+	if err != nil {
+		os.RemoveAll(coversDir)
+		return result, err
+	}
+	err = swapCovers(coversDir, stamp)
+	if err != nil {
+		return result, fmt.Errorf("database restored, but unable to restore covers: %v", err)
+	}
+	return result, nil
+}
+
+func readManifest(entry *zip.File, manifest *BackupManifest) error {
+	r, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("unable to read backup manifest: %v", err)
+	}
+	defer r.Close()
+	err = json.NewDecoder(r).Decode(manifest)
+	if err != nil {
+		return fmt.Errorf("unable to read backup manifest: %v", err)
+	}
+	return nil
+}
+
+func extractFile(entry *zip.File, path string) error {
+	r, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// prepareDatabase checks the database of a backup and brings its schema up
+// to date, returning the schema version it had.
+func prepareDatabase(path string) (int, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	err = database.CheckIntegrity(db)
+	if err != nil {
+		return 0, err
+	}
+	migrations, err := fs.Sub(sqlFiles, "sql")
+	if err != nil {
+		return 0, err
+	}
+	return database.Migrate(db, migrations)
+}
+
+// extractCovers writes the covers of a backup to a directory beside the
+// covers directory, ready to take its place.
+func extractCovers(entries []*zip.File, stamp string) (string, error) {
+	dir := covers.Dir() + ".restore-" + stamp
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("unable to restore covers: %v", err)
+	}
+	for _, entry := range entries {
+		name := backupCoverName.FindStringSubmatch(entry.Name)[1] + ".jpg"
+		err = extractFile(entry, filepath.Join(dir, name))
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("unable to restore covers: %v", err)
+		}
+	}
+	return dir, nil
+}
+
+// swapCovers moves the covers directory aside and puts dir in its place.
+func swapCovers(dir string, stamp string) error {
+	err := os.Rename(covers.Dir(), covers.Dir()+".before-restore-"+stamp)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(dir, covers.Dir())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mlibrary-htmx/pkg/covers"
+	"mlibrary-htmx/pkg/database"
+)
+
+// BASE_SCHEMA is master_books as it was before the first migration.
+const BASE_SCHEMA = `CREATE TABLE master_books (
+  id INTEGER PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  lccn TEXT DEFAULT NULL,
+  isbn TEXT DEFAULT NULL,
+  title TEXT DEFAULT NULL,
+  author_first TEXT DEFAULT NULL,
+  author_last TEXT DEFAULT NULL,
+  copyright_date DATE DEFAULT NULL,
+  publisher TEXT DEFAULT NULL,
+  location TEXT DEFAULT NULL,
+  genre TEXT DEFAULT NULL,
+  pages TEXT DEFAULT NULL
+)`
+
+// createDatabase writes a database of the base schema with one book to
+// path, applying every migration when migrate is set.
+func createDatabase(t *testing.T, path string, title string, migrate bool) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(BASE_SCHEMA)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO master_books (title, author_last, copyright_date) VALUES (?, 'Tolkien', '1937-09-21')`, title)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrate {
+		migrations, err := fs.Sub(sqlFiles, "sql")
+		if err == nil {
+			_, err = database.Migrate(db, migrations)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// useLiveDatabase makes a migrated database with one book and a cover the
+// one restores replace.
+func useLiveDatabase(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "live.db")
+	db := createDatabase(t, path, "Live", true)
+
+	previousDb, previousPath := database.Db, database.FilePath
+	database.Db, database.FilePath = db, path
+	t.Cleanup(func() { database.Db, database.FilePath = previousDb, previousPath })
+
+	t.Setenv("COVERS_DIR", filepath.Join(dir, "covers"))
+	if err := os.MkdirAll(covers.Dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(covers.Path(1), []byte("live cover"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeBackup zips a manifest, the database at dbPath unless it is "",
+// and a cover for book 1.
+func writeBackup(t *testing.T, manifest BackupManifest, dbPath string) *bytes.Reader {
+	t.Helper()
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	entry, err := archive.Create(BACKUP_MANIFEST)
+	if err == nil {
+		err = json.NewEncoder(entry).Encode(manifest)
+	}
+	if err == nil && dbPath != "" {
+		err = addBackupFile(archive, BACKUP_DATABASE, dbPath)
+	}
+	if err == nil {
+		entry, err = archive.Create(BACKUP_COVERS + "1.jpg")
+	}
+	if err == nil {
+		_, err = entry.Write([]byte("backup cover"))
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(b.Bytes())
+}
+
+func liveTitles(t *testing.T) []string {
+	t.Helper()
+	books, err := database.GetBooksList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+// An old backup is migrated and replaces the database and covers, which
+// are kept beside the restored ones.
+func TestRestoreArchive(t *testing.T) {
+	dir := useLiveDatabase(t)
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	createDatabase(t, backupPath, "Backup", false).Close()
+	backup := writeBackup(t, BackupManifest{Format: BACKUP_FORMAT, Version: BACKUP_VERSION, Books: 1, Covers: 1}, backupPath)
+
+	result, err := restoreArchive(backup, backup.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Migrated || result.MigratedFrom != 0 {
+		t.Errorf("migrated %v from %d, want from 0", result.Migrated, result.MigratedFrom)
+	}
+	if titles := liveTitles(t); len(titles) != 1 || titles[0] != "Backup" {
+		t.Errorf("books after restoring = %q", titles)
+	}
+	version, err := database.GetSchemaVersion(database.Db)
+	if err != nil || version != database.SchemaVersion {
+		t.Errorf("schema version %d, %v after restoring, want %d", version, err, database.SchemaVersion)
+	}
+	if cover, err := os.ReadFile(covers.Path(1)); err != nil || string(cover) != "backup cover" {
+		t.Errorf("cover after restoring = %q, %v", cover, err)
+	}
+
+	keptDatabase, _ := filepath.Glob(filepath.Join(dir, "live.db.before-restore-*"))
+	if len(keptDatabase) != 1 {
+		t.Errorf("kept databases %q, want 1", keptDatabase)
+	}
+	keptCovers, _ := filepath.Glob(filepath.Join(dir, "covers.before-restore-*", "1.jpg"))
+	if len(keptCovers) != 1 {
+		t.Fatalf("kept covers %q, want 1", keptCovers)
+	}
+	if cover, err := os.ReadFile(keptCovers[0]); err != nil || string(cover) != "live cover" {
+		t.Errorf("kept cover = %q, %v", cover, err)
+	}
+}
+
+// A backup that cannot be restored leaves the database and covers alone.
+func TestRestoreArchiveRejects(t *testing.T) {
+	useLiveDatabase(t)
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	createDatabase(t, backupPath, "Backup", true).Close()
+	damagedPath := filepath.Join(t.TempDir(), "damaged.db")
+	if err := os.WriteFile(damagedPath, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := BackupManifest{Format: BACKUP_FORMAT, Version: BACKUP_VERSION, SchemaVersion: database.SchemaVersion}
+	newer := manifest
+	newer.SchemaVersion++
+	other := manifest
+	other.Format = "something-else"
+	tests := []struct {
+		name   string
+		backup *bytes.Reader
+	}{
+		{"not a zip", bytes.NewReader([]byte("PK not really"))},
+		{"other format", writeBackup(t, other, backupPath)},
+		{"newer schema", writeBackup(t, newer, backupPath)},
+		{"no database", writeBackup(t, manifest, "")},
+		{"damaged database", writeBackup(t, manifest, damagedPath)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := restoreArchive(test.backup, test.backup.Size()); err == nil {
+				t.Fatal("backup was restored")
+			}
+			if titles := liveTitles(t); len(titles) != 1 || titles[0] != "Live" {
+				t.Errorf("books = %q, want the live book", titles)
+			}
+			if cover, err := os.ReadFile(covers.Path(1)); err != nil || string(cover) != "live cover" {
+				t.Errorf("cover = %q, %v, want the live cover", cover, err)
+			}
+		})
+	}
+}
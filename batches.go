@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ImportBatchesPage struct {
+	Header  Header
+	Batches []database.ImportBatch
+}
+
+type ImportBatchPage struct {
+	Header Header
+	Batch  *database.ImportBatch
+	Undo   *database.ImportUndo
+	Error  string
+}
+
+// GetImportBatches lists the imports into the current library, newest
+// first.
+func GetImportBatches(c echo.Context) error {
+	library := currentLibrary(c)
+	batches, err := database.GetImportBatches(library.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, "imports", ImportBatchesPage{
+		Header: Header{
+			Title: "Imports",
+		},
+		Batches: batches,
+	})
+}
+
+// GetImportBatch shows an import with the records it skipped.
+func GetImportBatch(c echo.Context) error {
+	batch, err := findImportBatch(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(http.StatusOK, "import-batch", ImportBatchPage{
+		Header: Header{
+			Title: "Import of " + batch.Filename,
+		},
+		Batch: batch,
+	})
+}
+
+// UndoImportBatch deletes the books an import added and restores the books
+// it updated.
+func UndoImportBatch(c echo.Context) error {
+	library := currentLibrary(c)
+	batch, err := findImportBatch(c)
+	if err != nil {
+		return err
+	}
+
+	page := ImportBatchPage{Batch: batch}
+	page.Undo, err = database.UndoImportBatch(library.Id, batch.Id)
+	if errors.Is(err, database.ErrImportRunning) || errors.Is(err, database.ErrImportUndone) {
+		page.Error = err.Error()
+		return c.Render(http.StatusOK, "import-batch-details", page)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	page.Batch, err = database.GetImportBatch(library.Id, batch.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, "import-batch-details", page)
+}
+
+// findImportBatch finds an import into the current library.
+func findImportBatch(c echo.Context) (*database.ImportBatch, error) {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, database.ErrImportNotFound.Error())
+	}
+	batch, err := database.GetImportBatch(library.Id, id)
+	if errors.Is(err, database.ErrImportNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return nil, err
+	}
+	return batch, nil
+}
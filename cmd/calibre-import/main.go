@@ -0,0 +1,195 @@
+// Command calibre-import adds the books of a Calibre library to a library,
+// copying their covers with -covers. It writes to the database DB_FILE
+// names, like the server.
+//
+//	DB_FILE=./foo.db go run ./cmd/calibre-import -library 1 -covers ~/Calibre\ Library
+//
+// Books already in the library are skipped, or updated or added again as
+// -duplicates says. The run is listed with the server's imports, where it
+// can be undone.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"mlibrary-htmx/pkg/calibre"
+	"mlibrary-htmx/pkg/covers"
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/importer"
+)
+
+// BATCH_SIZE is how many books are written per transaction.
+const BATCH_SIZE = 200
+
+// batch is the books waiting to be written, with the covers to copy for
+// them once they have ids.
+type batch struct {
+	libraryId    int
+	batchId      int
+	copyCovers   bool
+	creates      []database.Book
+	updates      []database.Book
+	createCovers []string
+	updateCovers []string
+	added        int
+	updated      int
+	skipped      int
+	coversCopied int
+	decider      *importer.Decider
+	errors       []database.ImportError
+}
+
+func main() {
+	libraryId := flag.Int("library", 1, "id of the library to import into")
+	duplicates := flag.String("duplicates", "skip", "what to do with books already in the library: skip, update or create")
+	copyCovers := flag.Bool("covers", false, "copy each book's cover.jpg")
+	verbose := flag.Bool("v", false, "print what happens to every book")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: calibre-import [flags] <library directory or metadata.db>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	policy, err := importer.ParsePolicy(*duplicates)
+	if err != nil || policy == importer.PolicyAsk {
+		log.Fatalf("-duplicates must be skip, update or create")
+	}
+
+	err = database.InitDb()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = database.GetLibraryById(*libraryId)
+	if err != nil {
+		log.Fatalf("library %d: %v", *libraryId, err)
+	}
+
+	reader, err := calibre.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer reader.Close()
+
+	b := &batch{libraryId: *libraryId, copyCovers: *copyCovers, decider: importer.NewDecider(*libraryId, policy)}
+	b.batchId, err = database.CreateImportBatch(*libraryId, 0, flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for number := 1; ; number++ {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			b.fail(err)
+		}
+		result, warnings, err := b.add(number, entry)
+		if err != nil {
+			b.fail(err)
+		}
+		if result == "skipped" {
+			b.errors = append(b.errors, database.ImportError{
+				Number:     number,
+				Identifier: entry.Id,
+				Title:      entry.Book.Title,
+				Message:    strings.Join(warnings, "; "),
+			})
+		}
+		if *verbose || !entry.Ok {
+			fmt.Printf("%s %q: %s\n", entry.Id, entry.Book.Title, result)
+			for _, warning := range warnings {
+				fmt.Printf("  %s\n", warning)
+			}
+		}
+		if len(b.creates)+len(b.updates) >= BATCH_SIZE {
+			err = b.flush()
+			if err != nil {
+				b.fail(err)
+			}
+		}
+	}
+	err = b.flush()
+	if err != nil {
+		b.fail(err)
+	}
+	err = database.FinishImportBatch(b.batchId, "done", b.skipped, 0, "", b.errors)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d books added, %d updated, %d skipped", b.added, b.updated, b.skipped)
+	if b.copyCovers {
+		fmt.Printf(", %d covers copied", b.coversCopied)
+	}
+	fmt.Println()
+}
+
+// add decides what to do with book number of the Calibre library, the way
+// the upload page does, returning the result and the warnings that go with
+// it.
+func (b *batch) add(number int, entry *calibre.Entry) (string, []string, error) {
+	if !entry.Ok {
+		b.skipped++
+		return "skipped", entry.Warnings, nil
+	}
+	decision, err := b.decider.Decide(number, entry.Book)
+	if err != nil {
+		return "", nil, err
+	}
+	warnings := append(entry.Warnings, decision.Warnings...)
+	switch decision.Action {
+	case importer.Create:
+		b.creates = append(b.creates, decision.Book)
+		b.createCovers = append(b.createCovers, entry.Cover)
+		b.added++
+		return "added", warnings, nil
+	case importer.Update:
+		b.updates = append(b.updates, decision.Book)
+		b.updateCovers = append(b.updateCovers, entry.Cover)
+		b.updated++
+		return fmt.Sprintf("updated book %d", decision.Book.Id), warnings, nil
+	}
+	b.skipped++
+	return "skipped", warnings, nil
+}
+
+// fail records that the import stopped, keeping the books already
+// written, and exits.
+func (b *batch) fail(err error) {
+	finishErr := database.FinishImportBatch(b.batchId, "failed", b.skipped, 0, err.Error(), b.errors)
+	if finishErr != nil {
+		log.Print(finishErr)
+	}
+	log.Fatal(err)
+}
+
+// flush writes the waiting books, then copies their covers.
+func (b *batch) flush() error {
+	err := database.ImportBooks(b.libraryId, b.batchId, b.creates, b.updates)
+	if err != nil {
+		return err
+	}
+	if b.copyCovers {
+		books := append(b.creates, b.updates...)
+		paths := append(b.createCovers, b.updateCovers...)
+		for i, path := range paths {
+			if path == "" {
+				continue
+			}
+			err := covers.Copy(books[i].Id, path)
+			if err != nil {
+				return err
+			}
+			b.coversCopied++
+		}
+	}
+	b.creates, b.updates, b.createCovers, b.updateCovers = nil, nil, nil, nil
+	return nil
+}
@@ -0,0 +1,285 @@
+// Command mockoidc is a tiny OpenID Connect provider for trying out sign in
+// locally. It signs every ID token with a key generated at startup and lets
+// you choose the identity on a form, or skip the form with -auto.
+//
+//	go run ./cmd/mockoidc -groups librarians
+//
+// Then set the instance settings oidc.issuer to http://localhost:9999 and
+// oidc.client_id to mlibrary.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type authorization struct {
+	ClientId      string
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	Subject       string
+	Name          string
+	Email         string
+	Groups        []string
+}
+
+type server struct {
+	issuer   string
+	clientId string
+	secret   string
+	auto     bool
+	subject  string
+	name     string
+	email    string
+	groups   string
+	key      *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><title>Mock OIDC Sign In</title></head>
+<body>
+  <h3>Mock OIDC Sign In</h3>
+  <form method="post">
+    {{range $k, $v := .Query}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}"/>{{end}}
+    <p><label>Subject <input name="login_sub" value="{{.Subject}}"/></label></p>
+    <p><label>Name <input name="login_name" value="{{.Name}}"/></label></p>
+    <p><label>Email <input name="login_email" value="{{.Email}}"/></label></p>
+    <p><label>Groups <input name="login_groups" value="{{.Groups}}"/></label></p>
+    <button type="submit">Sign In</button>
+  </form>
+</body>
+</html>`))
+
+func main() {
+	s := &server{codes: map[string]authorization{}}
+	addr := flag.String("addr", "localhost:9999", "address to listen on")
+	flag.StringVar(&s.issuer, "issuer", "http://localhost:9999", "issuer url as seen by the browser and the app")
+	flag.StringVar(&s.clientId, "client-id", "mlibrary", "client id to accept")
+	flag.StringVar(&s.secret, "client-secret", "", "client secret to require, if any")
+	flag.BoolVar(&s.auto, "auto", false, "sign in as the default identity without showing a form")
+	flag.StringVar(&s.subject, "sub", "mock-user", "default subject")
+	flag.StringVar(&s.name, "name", "Mock User", "default name")
+	flag.StringVar(&s.email, "email", "mock@example.com", "default email")
+	flag.StringVar(&s.groups, "groups", "", "default comma separated groups")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.key = key
+
+	http.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	http.HandleFunc("/authorize", s.authorize)
+	http.HandleFunc("/token", s.token)
+	http.HandleFunc("/jwks", s.jwks)
+	http.HandleFunc("/logout", s.logout)
+
+	log.Printf("mock oidc provider %s listening on %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func (s *server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"end_session_endpoint":                  s.issuer + "/logout",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *server) authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != s.clientId {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("response_type") != "code" || r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		http.Error(w, "authorization code flow with S256 PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet && !s.auto {
+		loginForm.Execute(w, map[string]interface{}{
+			"Query":   r.URL.Query(),
+			"Subject": s.subject,
+			"Name":    s.name,
+			"Email":   s.email,
+			"Groups":  s.groups,
+		})
+		return
+	}
+
+	auth := authorization{
+		ClientId:      r.Form.Get("client_id"),
+		RedirectURI:   r.Form.Get("redirect_uri"),
+		Nonce:         r.Form.Get("nonce"),
+		CodeChallenge: r.Form.Get("code_challenge"),
+		Subject:       valueOr(r.Form.Get("login_sub"), s.subject),
+		Name:          valueOr(r.Form.Get("login_name"), s.name),
+		Email:         valueOr(r.Form.Get("login_email"), s.email),
+		Groups:        splitGroups(valueOr(r.Form.Get("login_groups"), s.groups)),
+	}
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = auth
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(auth.RedirectURI)
+	if err != nil {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *server) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	if s.secret != "" {
+		_, secret, ok := r.BasicAuth()
+		if !ok || secret != s.secret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+	}
+
+	code := r.Form.Get("code")
+	s.mu.Lock()
+	auth, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if !ok || auth.ClientId != r.Form.Get("client_id") || auth.RedirectURI != r.Form.Get("redirect_uri") || auth.CodeChallenge != challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := s.sign(map[string]interface{}{
+		"iss":    s.issuer,
+		"sub":    auth.Subject,
+		"aud":    auth.ClientId,
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"nonce":  auth.Nonce,
+		"name":   auth.Name,
+		"email":  auth.Email,
+		"groups": auth.Groups,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": "mock",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *server) logout(w http.ResponseWriter, r *http.Request) {
+	if redirect := r.URL.Query().Get("post_logout_redirect_uri"); redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+	w.Write([]byte("Signed out of the mock provider."))
+}
+
+func (s *server) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "mock"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func splitGroups(groups string) []string {
+	var result []string
+	for _, g := range strings.Split(groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			result = append(result, g)
+		}
+	}
+	return result
+}
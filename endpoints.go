@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
 
+	"mlibrary-htmx/pkg/catalogs"
+	"mlibrary-htmx/pkg/citation"
+	"mlibrary-htmx/pkg/covers"
 	"mlibrary-htmx/pkg/database"
 
 	"github.com/labstack/echo/v4"
@@ -29,7 +31,75 @@ type NewBookPage struct {
 	Book     *database.Book
 	Message  string
 	Existing bool
+	HasCover bool
 	Errors   map[string]string
+	Conflict *BookConflict
+}
+
+// BookConflict describes an update that lost the race against another save.
+// Left holds the submitted value of each field and Right the stored one.
+type BookConflict struct {
+	Stored *database.Book
+	Fields []FieldDiff
+}
+
+type BookVersionsPage struct {
+	BookId   int
+	Versions []database.BookVersion
+}
+
+type CompareVersionsPage struct {
+	Header Header
+	BookId int
+	Left   *database.BookVersion
+	Right  *database.BookVersion
+	Fields []FieldDiff
+}
+
+type FieldDiff struct {
+	Name    string
+	Label   string
+	Left    string
+	Right   string
+	Changed bool
+}
+
+// bookField is a single editable field of a book, named as in the book form.
+type bookField struct {
+	Name  string
+	Label string
+	Value string
+}
+
+func bookFields(b *database.Book) []bookField {
+	return []bookField{
+		{"isbn", "Isbn", b.Isbn},
+		{"lccn", "Lccn", b.Lccn},
+		{"title", "Title", b.Title},
+		{"author-first", "Author First Name", b.AuthorFirst},
+		{"author-last", "Author Last Name", b.AuthorLast},
+		{"publisher", "Publisher", b.Publisher},
+		{"location", "Publishing Location", b.Location},
+		{"genre", "Genre", b.Genre},
+		{"pages", "Pages", b.Pages},
+		{"copyright-date", "Copyright Date", b.CopyrightDate.String()},
+	}
+}
+
+func diffBooks(left *database.Book, right *database.Book) []FieldDiff {
+	leftFields := bookFields(left)
+	rightFields := bookFields(right)
+	diffs := make([]FieldDiff, len(leftFields))
+	for i := range leftFields {
+		diffs[i] = FieldDiff{
+			Name:    leftFields[i].Name,
+			Label:   leftFields[i].Label,
+			Left:    leftFields[i].Value,
+			Right:   rightFields[i].Value,
+			Changed: leftFields[i].Value != rightFields[i].Value,
+		}
+	}
+	return diffs
 }
 
 func RedirectToBase(c echo.Context) error {
@@ -37,22 +107,22 @@ func RedirectToBase(c echo.Context) error {
 	return c.Redirect(http.StatusFound, basePath)
 }
 
-func sort_and_paginate_books(page int, sortBy string) ([]database.Book, error) {
+func sort_and_paginate_books(libraryId int, page int, sortBy string) ([]database.Book, error) {
 	maxsize := 25
 	iteration := maxsize * page
 	initial := iteration - maxsize
-	books, err := database.SortAndPaginateBooks(initial, sortBy)
+	books, err := database.SortAndPaginateBooks(libraryId, initial, sortBy)
 	if err != nil {
 		return nil, err
 	}
 	return books, nil
 }
 
-func paginate_books(page int) ([]database.Book, error) {
+func paginate_books(libraryId int, page int) ([]database.Book, error) {
 	maxsize := 25
 	iteration := maxsize * page
 	initial := iteration - maxsize
-	books, err := database.PaginateBooks(initial)
+	books, err := database.PaginateBooks(libraryId, initial)
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +130,7 @@ func paginate_books(page int) ([]database.Book, error) {
 }
 
 func GetAllBooks(c echo.Context) error {
+	library := currentLibrary(c)
 	searchParam := c.QueryParam("q")
 	page, err := strconv.Atoi(c.QueryParam("page"))
 	if err != nil {
@@ -79,7 +150,7 @@ func GetAllBooks(c echo.Context) error {
 	if c.Request().Header.Get("HX-Trigger") == "sort-by" {
 		if sortParam != "" {
 			fmt.Println("sort param", sortParam)
-			books, err := sort_and_paginate_books(page, sortParam)
+			books, err := sort_and_paginate_books(library.Id, page, sortParam)
 			if err != nil {
 				c.Logger().Error(err)
 				return err
@@ -94,7 +165,7 @@ func GetAllBooks(c echo.Context) error {
 			})
 		}
 
-		books, err := paginate_books(page)
+		books, err := paginate_books(library.Id, page)
 		if err != nil {
 			return c.NoContent(http.StatusInternalServerError)
 		}
@@ -110,7 +181,7 @@ func GetAllBooks(c echo.Context) error {
 
 	if searchParam != "" {
 		if c.Request().Header.Get("HX-Trigger") == "search" {
-			books, err := database.FilterBook(searchParam)
+			books, err := database.FilterBook(library.Id, searchParam)
 			if err != nil {
 				return c.NoContent(http.StatusInternalServerError)
 			}
@@ -126,7 +197,7 @@ func GetAllBooks(c echo.Context) error {
 	}
 
 	if c.Request().Header.Get("HX-Trigger") == "search" {
-		books, err := paginate_books(page)
+		books, err := paginate_books(library.Id, page)
 		if err != nil {
 			c.Logger().Error(err)
 			return err
@@ -141,7 +212,7 @@ func GetAllBooks(c echo.Context) error {
 		})
 	}
 
-	books, err := paginate_books(page)
+	books, err := paginate_books(library.Id, page)
 	if err != nil {
 		c.Logger().Error(err)
 		return err
@@ -168,12 +239,13 @@ func HandleNewBook(c echo.Context) error {
 }
 
 func HandleExistingBook(c echo.Context) error {
+	library := currentLibrary(c)
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
-	book, err := database.GetBookById(id)
+	book, err := database.GetBookById(library.Id, id)
 	if err != nil {
 		c.Logger().Error(err)
 		return err
@@ -189,6 +261,7 @@ func HandleExistingBook(c echo.Context) error {
 }
 
 func CreateNewBook(c echo.Context) error {
+	library := currentLibrary(c)
 	newBook := database.Book{
 		Isbn:        c.FormValue("isbn"),
 		Lccn:        c.FormValue("lccn"),
@@ -200,25 +273,18 @@ func CreateNewBook(c echo.Context) error {
 		Genre:       c.FormValue("genre"),
 		Pages:       c.FormValue("pages"),
 		Id:          -1,
+		LibraryId:   library.Id,
 	}
 
-	if c.FormValue("copyright-date") == "" {
+	if message := newBook.SetCopyrightDate(c.FormValue("copyright-date")); message != "" {
 		errors := make(database.ErrorMap)
-		errors["publish_date"] = "Copyright Date Required"
+		errors["publish_date"] = message
 		return c.Render(http.StatusOK, "new-book-template", NewBookPage{
 			Book:     &newBook,
 			Existing: false,
 			Errors:   errors,
 		})
 	}
-	layout := "2006-01-02"
-	publish_date, err := time.Parse(layout, c.FormValue("copyright-date"))
-	if err != nil {
-		return fmt.Errorf("error parsing date string: %v", err)
-	}
-
-	newBook.CopyrightDate = publish_date
-	newBook.CopyrightDateString = publish_date.Format("2006-01-02")
 	newBook.Id = -1
 
 	errorMap, err := newBook.Save()
@@ -249,6 +315,7 @@ func CreateNewBook(c echo.Context) error {
 }
 
 func UpdateExistingBook(c echo.Context) error {
+	library := currentLibrary(c)
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error(err)
@@ -265,10 +332,15 @@ func UpdateExistingBook(c echo.Context) error {
 		Genre:       c.FormValue("genre"),
 		Pages:       c.FormValue("pages"),
 		Id:          id,
+		LibraryId:   library.Id,
+	}
+	newBook.Version, err = strconv.Atoi(c.FormValue("version"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing book version")
 	}
-	if c.FormValue("copyright-date") == "" {
+	if message := newBook.SetCopyrightDate(c.FormValue("copyright-date")); message != "" {
 		errorMap := make(map[string]string)
-		errorMap["publish_date"] = "Copyright Date Required"
+		errorMap["publish_date"] = message
 		return c.Render(http.StatusOK, "new-book-template", NewBookPage{
 			Message:  "",
 			Book:     &newBook,
@@ -277,15 +349,24 @@ func UpdateExistingBook(c echo.Context) error {
 		})
 	}
 
-	layout := "2006-01-02"
-	publish_date, err := time.Parse(layout, c.FormValue("copyright-date"))
-	if err != nil {
-		return fmt.Errorf("error parsing date string: %v", err)
-	}
-
-	newBook.CopyrightDate = publish_date
-
 	errorMap, err := newBook.Save()
+	if errors.Is(err, database.ErrVersionConflict) {
+		stored, err := database.GetBookById(library.Id, id)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		return c.Render(http.StatusOK, "new-book-template", NewBookPage{
+			Message:  "This book was changed by someone else",
+			Book:     &newBook,
+			Existing: true,
+			Errors:   map[string]string{},
+			Conflict: &BookConflict{
+				Stored: stored,
+				Fields: diffBooks(&newBook, stored),
+			},
+		})
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return c.Render(http.StatusOK, "new-book-template", NewBookPage{
@@ -305,6 +386,7 @@ func UpdateExistingBook(c echo.Context) error {
 		})
 	}
 
+	c.Response().Header().Set("HX-Trigger", "versions-changed")
 	return c.Render(http.StatusOK, "new-book-template", NewBookPage{
 		Message:  "Book Updated",
 		Book:     &newBook,
@@ -314,12 +396,13 @@ func UpdateExistingBook(c echo.Context) error {
 }
 
 func HandleShowBook(c echo.Context) error {
+	library := currentLibrary(c)
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
-	book, err := database.GetBookById(id)
+	book, err := database.GetBookById(library.Id, id)
 	if err != nil {
 		c.Logger().Error(err)
 		return err
@@ -330,18 +413,39 @@ func HandleShowBook(c echo.Context) error {
 		},
 		Book:     book,
 		Existing: true,
+		HasCover: book.Id != 0 && covers.Exists(book.Id),
 		Errors:   map[string]string{},
 	})
 }
 
+// GetBookCover sends the cover image kept for a book.
+func GetBookCover(c echo.Context) error {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	book, err := database.GetBookById(library.Id, id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if book.Id == 0 || !covers.Exists(book.Id) {
+		return echo.NewHTTPError(http.StatusNotFound, "book has no cover")
+	}
+	return c.File(covers.Path(book.Id))
+}
+
 func HandleDeleteBook(c echo.Context) error {
+	library := currentLibrary(c)
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
 
-	err = database.DeleteBook(id)
+	err = database.DeleteBook(library.Id, id)
 	if err != nil {
 		c.Logger().Error(err)
 		return err
@@ -369,101 +473,127 @@ func Upload(c echo.Context) error {
 		return err
 	}
 
+	// Exports of other catalogs are chosen on the form, or recognized by
+	// their header or extension
+	format := c.FormValue("format")
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	switch {
+	case format != "":
+	case ext == ".tsv" || ext == ".json":
+		format = catalogs.LibraryThing
+	case ext == ".csv":
+		format = detectCatalog(file)
+	}
+	switch format {
+	case "":
+	case catalogs.Goodreads, catalogs.LibraryThing, catalogs.Libib:
+		return uploadRecords(c, file, catalogSource(format))
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%q is not a catalog that can be imported", format))
+	}
+
+	switch ext {
+	case ".mrc", ".marc":
+		return uploadRecords(c, file, marcSource(false))
+	case ".xml":
+		return uploadRecords(c, file, marcSource(true))
+	case ".bib":
+		return uploadRecords(c, file, citationSource(citation.ReadBibTeX))
+	case ".ris":
+		return uploadRecords(c, file, citationSource(citation.ReadRIS))
+	case ".csv", ".xlsx", ".ods":
+		return uploadCsv(c, file)
+	case ".db":
+		return uploadRecords(c, file, calibreSource)
+	}
+
 	contentType := file.Header.Get("Content-Type")
 	if contentType != "text/csv" {
-		return c.HTML(http.StatusOK, fmt.Sprintf("<p>File is not correct type %s. Please use a .csv</p>", contentType))
+		return c.HTML(http.StatusOK, fmt.Sprintf("<p>File is not correct type %s. Please use a .csv, .xlsx, .ods, .mrc, .xml, .bib, .ris, .tsv, .json or a Calibre metadata.db</p>", contentType))
 	}
+	return uploadCsv(c, file)
+}
 
-	src, err := file.Open()
+func GetBookVersions(c echo.Context) error {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
-	defer src.Close()
-
-	dst, err := os.Create(file.Filename)
+	versions, err := database.GetBookVersions(library.Id, id)
 	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, src); err != nil {
+	return c.Render(http.StatusOK, "book-versions", BookVersionsPage{
+		BookId:   id,
+		Versions: versions,
+	})
+}
+
+func CompareBookVersions(c echo.Context) error {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
 		c.Logger().Error(err)
 		return err
 	}
+	a, err := strconv.Atoi(c.QueryParam("a"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "choose two versions to compare")
+	}
+	b, err := strconv.Atoi(c.QueryParam("b"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "choose two versions to compare")
+	}
 
-	err = handleBookUpload(dst.Name())
+	left, err := database.GetBookVersion(library.Id, id, a)
 	if err != nil {
 		c.Logger().Error(err)
-		return err
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	right, err := database.GetBookVersion(library.Id, id, b)
+	if err != nil {
+		c.Logger().Error(err)
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
 	}
 
-	return c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s uploaded</p>", file.Filename))
+	return c.Render(http.StatusOK, "compare-versions", CompareVersionsPage{
+		Header: Header{
+			Title: "Compare Versions",
+		},
+		BookId: id,
+		Left:   left,
+		Right:  right,
+		Fields: diffBooks(&left.Book, &right.Book),
+	})
 }
 
-func handleBookUpload(filename string) error {
-	dst, err := os.Open(filename)
+func RevertBookVersion(c echo.Context) error {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
+		c.Logger().Error(err)
 		return err
 	}
-
-	csvReader := csv.NewReader(dst)
-	data, err := csvReader.ReadAll()
+	version, err := strconv.Atoi(c.Param("version"))
 	if err != nil {
+		c.Logger().Error(err)
 		return err
 	}
 
-	var bookRows []database.BookCsv
-	for i, line := range data {
-		if i > 0 {
-			var bookRow database.BookCsv
-			for j, field := range line {
-				if j == 0 {
-					bookRow.Isbn = field
-				}
-				if j == 1 {
-					bookRow.Lccn = field
-				}
-				if j == 2 {
-					bookRow.Title = field
-				}
-				if j == 3 {
-					bookRow.AuthorFirst = field
-				}
-				if j == 4 {
-					bookRow.AuthorLast = field
-				}
-				if j == 5 {
-					if field != "" {
-						layout := "01-02-2006"
-						publish_date, err := time.Parse(layout, field)
-						if err != nil {
-							fmt.Println(err)
-							bookRow.CopyrightDate = time.Time{}
-						} else {
-							bookRow.CopyrightDate = publish_date
-						}
-					}
-				}
-				if j == 6 {
-					bookRow.Publisher = field
-				}
-				if j == 7 {
-					bookRow.Location = field
-				}
-				if j == 8 {
-					bookRow.Genre = field
-				}
-				if j == 9 {
-					bookRow.Pages = field
-				}
-			}
-			bookRows = append(bookRows, bookRow)
-		}
-	}
-	err = database.BulkInsert(bookRows)
+	_, err = database.RevertBook(library.Id, id, version)
 	if err != nil {
+		c.Logger().Error(err)
 		return err
 	}
-	return nil
+	// htmx follows a redirect without changing the address bar, so it is
+	// told where to go instead and loads the book as a new page
+	target := fmt.Sprintf("/books/%d", id)
+	if c.Request().Header.Get("HX-Request") != "" {
+		c.Response().Header().Set("HX-Location", target)
+		return c.NoContent(http.StatusOK)
+	}
+	return c.Redirect(http.StatusSeeOther, target)
 }
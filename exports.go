@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"mlibrary-htmx/pkg/citation"
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/importer"
+	"mlibrary-htmx/pkg/marc"
+	"mlibrary-htmx/pkg/metadata"
+	"mlibrary-htmx/pkg/spreadsheet"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BookEncoder writes books in one export format. Begin and End are called
+// once around the books, even when there are none.
+type BookEncoder interface {
+	Begin() error
+	Encode(book database.Book) error
+	End() error
+}
+
+// ExportFormat describes a format books can be downloaded in.
+type ExportFormat struct {
+	Name        string
+	Label       string
+	ContentType string
+	Extension   string
+	NewEncoder  func(w io.Writer, options ExportOptions) BookEncoder
+}
+
+type ExportOptions struct {
+	// Single is set when exactly one book is exported, for formats that
+	// write a lone record differently from a collection
+	Single bool
+	// BaseURL is the scheme and host the books are served from
+	BaseURL string
+}
+
+var exportFormats = []ExportFormat{
+	{
+		Name:        "marcxml",
+		Label:       "MARCXML",
+		ContentType: "application/marcxml+xml",
+		Extension:   ".xml",
+		NewEncoder:  newMarcXmlEncoder,
+	},
+	{
+		Name:        "mods",
+		Label:       "MODS",
+		ContentType: "application/mods+xml",
+		Extension:   ".xml",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &xmlEncoder{w: w, options: options, root: "modsCollection", namespace: metadata.ModsNamespace, element: func(book database.Book) interface{} {
+				return metadata.NewMods(book, bookURL(options, book))
+			}}
+		},
+	},
+	{
+		Name:        "oai_dc",
+		Label:       "Dublin Core",
+		ContentType: "application/xml",
+		Extension:   ".xml",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &xmlEncoder{w: w, options: options, root: "records", element: func(book database.Book) interface{} {
+				return metadata.NewDublinCore(book, bookURL(options, book)).OaiDc()
+			}}
+		},
+	},
+	{
+		Name:        "jsonld",
+		Label:       "JSON-LD",
+		ContentType: "application/ld+json",
+		Extension:   ".jsonld",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &jsonLdEncoder{w: w, options: options}
+		},
+	},
+	{
+		Name:        "bibtex",
+		Label:       "BibTeX",
+		ContentType: "application/x-bibtex; charset=utf-8",
+		Extension:   ".bib",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &citationEncoder{w: w, options: options, write: citation.WriteBibTeX}
+		},
+	},
+	{
+		Name:        "ris",
+		Label:       "RIS",
+		ContentType: "application/x-research-info-systems; charset=utf-8",
+		Extension:   ".ris",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &citationEncoder{w: w, options: options, write: citation.WriteRIS}
+		},
+	},
+	{
+		Name:        "csv",
+		Label:       "CSV",
+		ContentType: "text/csv; charset=utf-8",
+		Extension:   ".csv",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &csvEncoder{w: csv.NewWriter(w)}
+		},
+	},
+	{
+		Name:        "xlsx",
+		Label:       "Excel",
+		ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		Extension:   ".xlsx",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &xlsxEncoder{w: w}
+		},
+	},
+	{
+		Name:        "jsonl",
+		Label:       "JSON Lines",
+		ContentType: "application/jsonl; charset=utf-8",
+		Extension:   ".jsonl",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &jsonLinesEncoder{encoder: json.NewEncoder(w)}
+		},
+	},
+	{
+		Name:        "csl",
+		Label:       "CSL-JSON",
+		ContentType: "application/vnd.citationstyles.csl+json",
+		Extension:   ".json",
+		NewEncoder: func(w io.Writer, options ExportOptions) BookEncoder {
+			return &cslEncoder{w: w, options: options}
+		},
+	},
+}
+
+func getExportFormat(name string) (ExportFormat, bool) {
+	for _, f := range exportFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ExportFormat{}, false
+}
+
+type marcXmlEncoder struct {
+	w      io.Writer
+	writer *marc.XMLWriter
+	single bool
+}
+
+func newMarcXmlEncoder(w io.Writer, options ExportOptions) BookEncoder {
+	return &marcXmlEncoder{w: w, writer: marc.NewXMLWriter(w), single: options.Single}
+}
+
+func (e *marcXmlEncoder) Begin() error {
+	return nil
+}
+
+func (e *marcXmlEncoder) Encode(book database.Book) error {
+	if e.single {
+		return marc.WriteXMLRecord(e.w, marc.FromBook(book))
+	}
+	return e.writer.Write(marc.FromBook(book))
+}
+
+func (e *marcXmlEncoder) End() error {
+	if e.single {
+		return nil
+	}
+	return e.writer.Close()
+}
+
+// bookURL is where book is shown, which linked data formats identify it by.
+func bookURL(options ExportOptions, book database.Book) string {
+	return fmt.Sprintf("%s/books/show/%d", options.BaseURL, book.Id)
+}
+
+// xmlEncoder writes one element per book, inside a root element unless a
+// single book is exported.
+type xmlEncoder struct {
+	w         io.Writer
+	options   ExportOptions
+	root      string
+	namespace string
+	element   func(book database.Book) interface{}
+	encoder   *xml.Encoder
+}
+
+func (e *xmlEncoder) Begin() error {
+	_, err := io.WriteString(e.w, xml.Header)
+	if err != nil || e.options.Single {
+		return err
+	}
+	if e.namespace != "" {
+		_, err = fmt.Fprintf(e.w, "<%s xmlns=\"%s\">\n", e.root, e.namespace)
+	} else {
+		_, err = fmt.Fprintf(e.w, "<%s>\n", e.root)
+	}
+	return err
+}
+
+func (e *xmlEncoder) Encode(book database.Book) error {
+	if e.encoder == nil {
+		e.encoder = xml.NewEncoder(e.w)
+		if e.options.Single {
+			e.encoder.Indent("", "  ")
+		} else {
+			e.encoder.Indent("  ", "  ")
+		}
+	}
+	return e.encoder.Encode(e.element(book))
+}
+
+func (e *xmlEncoder) End() error {
+	if e.options.Single {
+		_, err := io.WriteString(e.w, "\n")
+		return err
+	}
+	if e.encoder == nil {
+		_, err := fmt.Fprintf(e.w, "</%s>\n", e.root)
+		return err
+	}
+	_, err := fmt.Fprintf(e.w, "\n</%s>\n", e.root)
+	return err
+}
+
+// jsonLdEncoder writes a single node, or a @graph of nodes sharing one
+// @context.
+type jsonLdEncoder struct {
+	w       io.Writer
+	options ExportOptions
+	count   int
+}
+
+func (e *jsonLdEncoder) Begin() error {
+	if e.options.Single {
+		return nil
+	}
+	context, err := json.Marshal(metadata.JSONLDContext)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "{\"@context\":%s,\"@graph\":[\n", context)
+	return err
+}
+
+func (e *jsonLdEncoder) Encode(book database.Book) error {
+	node := metadata.NewDublinCore(book, bookURL(e.options, book)).JSONLD()
+	if e.options.Single {
+		node["@context"] = metadata.JSONLDContext
+		return json.NewEncoder(e.w).Encode(node)
+	}
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		_, err = io.WriteString(e.w, ",\n")
+		if err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonLdEncoder) End() error {
+	if e.options.Single {
+		return nil
+	}
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
+
+// citationEncoder writes citations one after another.
+type citationEncoder struct {
+	w       io.Writer
+	options ExportOptions
+	write   func(w io.Writer, key string, book database.Book, url string) error
+}
+
+func (e *citationEncoder) Begin() error {
+	return nil
+}
+
+func (e *citationEncoder) Encode(book database.Book) error {
+	return e.write(e.w, citation.Key(book), book, bookURL(e.options, book))
+}
+
+func (e *citationEncoder) End() error {
+	return nil
+}
+
+// cslEncoder writes a CSL-JSON array, which holds even a single item.
+type cslEncoder struct {
+	w       io.Writer
+	options ExportOptions
+	count   int
+}
+
+func (e *cslEncoder) Begin() error {
+	_, err := io.WriteString(e.w, "[\n")
+	return err
+}
+
+func (e *cslEncoder) Encode(book database.Book) error {
+	b, err := json.Marshal(citation.NewCslItem(citation.Key(book), book, bookURL(e.options, book)))
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		_, err = io.WriteString(e.w, ",\n")
+		if err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *cslEncoder) End() error {
+	_, err := io.WriteString(e.w, "\n]\n")
+	return err
+}
+
+// templateRow is a book as a row of the import template, with a cell for
+// each of importer.Fields.
+func templateRow(book database.Book) []string {
+	date := importer.FormatDate(book.CopyrightDate)
+	return []string{book.Lccn, book.Isbn, book.Title, book.AuthorLast, book.AuthorFirst, date, book.Publisher, book.Location, book.Genre, book.Pages}
+}
+
+func templateHeader() []string {
+	var header []string
+	for _, field := range importer.Fields {
+		header = append(header, field.Name)
+	}
+	return header
+}
+
+// csvEncoder writes the columns of the import template, so an export can
+// be edited and uploaded again. Values a spreadsheet would run as formulas
+// are quoted.
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.w.Write(templateHeader())
+}
+
+func (e *csvEncoder) Encode(book database.Book) error {
+	row := templateRow(book)
+	for i := range row {
+		row[i] = importer.EscapeFormula(row[i])
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// xlsxEncoder writes the columns of the import template as a sheet, with
+// the copyright date as a date cell.
+type xlsxEncoder struct {
+	w      io.Writer
+	writer *spreadsheet.XLSXWriter
+}
+
+func (e *xlsxEncoder) Begin() error {
+	var err error
+	e.writer, err = spreadsheet.NewXLSXWriter(e.w, "Books")
+	if err != nil {
+		return err
+	}
+	var cells []interface{}
+	for _, name := range templateHeader() {
+		cells = append(cells, name)
+	}
+	return e.writer.WriteRow(cells...)
+}
+
+func (e *xlsxEncoder) Encode(book database.Book) error {
+	var cells []interface{}
+	for _, value := range templateRow(book) {
+		cells = append(cells, value)
+	}
+	// the copyright column, as a date when it is a day and as text otherwise
+	if book.CopyrightDate.IsDay() {
+		cells[5] = book.CopyrightDate.Start()
+	}
+	return e.writer.WriteRow(cells...)
+}
+
+func (e *xlsxEncoder) End() error {
+	return e.writer.Close()
+}
+
+// jsonLinesEncoder writes each book as a line of JSON, the way the API
+// shows it.
+type jsonLinesEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonLinesEncoder) Begin() error {
+	return nil
+}
+
+func (e *jsonLinesEncoder) Encode(book database.Book) error {
+	return e.encoder.Encode(book)
+}
+
+func (e *jsonLinesEncoder) End() error {
+	return nil
+}
+
+func baseURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+func startExport(c echo.Context, format ExportFormat, filename string) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, format.ContentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s%s"`, filename, format.Extension))
+	c.Response().WriteHeader(http.StatusOK)
+}
+
+// ExportBook downloads a single book.
+func ExportBook(c echo.Context) error {
+	format, ok := getExportFormat(c.Param("format"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown export format")
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+	book, err := database.GetBookById(currentLibrary(c).Id, id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if book.Id == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+
+	startExport(c, format, fmt.Sprintf("book-%d", book.Id))
+	encoder := format.NewEncoder(c.Response(), ExportOptions{Single: true, BaseURL: baseURL(c)})
+	err = encoder.Begin()
+	if err == nil {
+		err = encoder.Encode(*book)
+	}
+	if err == nil {
+		err = encoder.End()
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+	return nil
+}
+
+// ExportBooks downloads every book matching the same q and sort-by
+// parameters as the book list, streaming them as they are read. Repeated
+// id parameters export only the books selected in the list.
+func ExportBooks(c echo.Context) error {
+	format, ok := getExportFormat(c.Param("format"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown export format")
+	}
+	query := database.BookQuery{
+		Search: c.QueryParam("q"),
+		Sort:   c.QueryParam("sort-by"),
+	}
+	if query.Sort != "" && !database.IsSortableColumn(query.Sort) {
+		return echo.NewHTTPError(http.StatusBadRequest, "cannot sort by "+query.Sort)
+	}
+	for _, value := range c.QueryParams()["id"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "book id must be a number")
+		}
+		query.Ids = append(query.Ids, id)
+	}
+
+	library := currentLibrary(c)
+	startExport(c, format, "books")
+	encoder := format.NewEncoder(c.Response(), ExportOptions{BaseURL: baseURL(c)})
+	err := encoder.Begin()
+	if err == nil {
+		err = database.EachBook(library.Id, query, encoder.Encode)
+	}
+	if err == nil {
+		err = encoder.End()
+	}
+	if err != nil {
+		// The response has started, so all we can do is stop writing
+		c.Logger().Error(err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ module mlibrary-htmx
 go 1.21.0
 
 require (
+	github.com/graphql-go/graphql v0.8.1
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/mattn/go-sqlite3 v1.14.17
 )
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/labstack/echo/v4"
+)
+
+const graphqlPath = "/graphql"
+
+// Queries are refused before they run when they could fetch too much. Each
+// field costs one, and list fields multiply the cost of what is selected
+// inside them by the number of items they may return.
+const (
+	MAX_GRAPHQL_COMPLEXITY = 5000
+	MAX_GRAPHQL_DEPTH      = 10
+	DEFAULT_GRAPHQL_FIRST  = 25
+)
+
+// graphqlListSizes are the multipliers for list fields that take no first
+// argument.
+var graphqlListSizes = map[string]int{
+	"authors":  2,
+	"versions": 10,
+	"errors":   2,
+}
+
+type GraphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// Author is a name shared by books. There is no authors table, so an author
+// is identified by the first and last name stored on each book.
+type Author struct {
+	FirstName string
+	LastName  string
+}
+
+type BookPayload struct {
+	Book   *database.Book
+	Errors []FieldError
+}
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+type graphqlContextKey struct{}
+
+func echoContext(ctx context.Context) echo.Context {
+	return ctx.Value(graphqlContextKey{}).(echo.Context)
+}
+
+var graphqlSchema = sync.OnceValues(buildGraphqlSchema)
+
+func PostGraphql(c echo.Context) error {
+	var request GraphqlRequest
+	err := c.Bind(&request)
+	if err != nil || request.Query == "" {
+		return graphqlError(c, http.StatusBadRequest, "request body must be JSON with a query")
+	}
+
+	schema, err := graphqlSchema()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	err = checkGraphqlComplexity(request)
+	if err != nil {
+		return graphqlError(c, http.StatusBadRequest, err.Error())
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  request.Query,
+		VariableValues: request.Variables,
+		OperationName:  request.OperationName,
+		Context:        context.WithValue(c.Request().Context(), graphqlContextKey{}, c),
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+func graphqlError(c echo.Context, status int, message string) error {
+	return c.JSON(status, map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
+
+// graphqlBookField resolves a scalar field of a book.
+func graphqlBookField(t graphql.Output, value func(b *database.Book) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return value(p.Source.(*database.Book)), nil
+		},
+	}
+}
+
+// bookConnectionArgs mirror the search and sort options of /books.
+var bookConnectionArgs = graphql.FieldConfigArgument{
+	"q":      &graphql.ArgumentConfig{Type: graphql.String, Description: "Text to search for in any field"},
+	"genre":  &graphql.ArgumentConfig{Type: graphql.String, Description: "Only books in this genre"},
+	"sortBy": &graphql.ArgumentConfig{Type: graphql.String, Description: "Column to sort by"},
+	"desc":   &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+	"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: DEFAULT_GRAPHQL_FIRST},
+	"after":  &graphql.ArgumentConfig{Type: graphql.String, Description: "endCursor of the previous page"},
+}
+
+// listBooks runs a books query, narrowed to one author when one is given.
+func listBooks(p graphql.ResolveParams, author *Author) (interface{}, error) {
+	first, _ := p.Args["first"].(int)
+	if first < 1 || first > database.MAX_BOOK_QUERY_LIMIT {
+		return nil, fmt.Errorf("first must be between 1 and %d", database.MAX_BOOK_QUERY_LIMIT)
+	}
+	query := database.BookQuery{Limit: first}
+	query.Search, _ = p.Args["q"].(string)
+	query.Genre, _ = p.Args["genre"].(string)
+	query.Sort, _ = p.Args["sortBy"].(string)
+	query.Desc, _ = p.Args["desc"].(bool)
+	query.Cursor, _ = p.Args["after"].(string)
+	if author != nil {
+		query.AuthorFirst = author.FirstName
+		query.AuthorLast = author.LastName
+	}
+
+	page, err := database.ListBooks(currentLibrary(echoContext(p.Context)).Id, query)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func bookInputFields() graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{}
+	for _, name := range []string{"isbn", "lccn", "title", "authorFirst", "authorLast", "copyrightDate", "publisher", "location", "genre", "pages"} {
+		fields[name] = &graphql.InputObjectFieldConfig{Type: graphql.String}
+	}
+	return fields
+}
+
+// applyBookInput copies the fields present in input onto book.
+func applyBookInput(book *database.Book, input map[string]interface{}) {
+	fields := map[string]*string{
+		"isbn":          &book.Isbn,
+		"lccn":          &book.Lccn,
+		"title":         &book.Title,
+		"authorFirst":   &book.AuthorFirst,
+		"authorLast":    &book.AuthorLast,
+		"copyrightDate": &book.CopyrightDateString,
+		"publisher":     &book.Publisher,
+		"location":      &book.Location,
+		"genre":         &book.Genre,
+		"pages":         &book.Pages,
+	}
+	for name, value := range input {
+		if field, ok := fields[name]; ok {
+			*field, _ = value.(string)
+		}
+	}
+}
+
+// graphqlFieldNames maps the keys Book.Validate reports to schema names.
+var graphqlFieldNames = map[string]string{
+	"author_last":    "authorLast",
+	"author_first":   "authorFirst",
+	"copyright_date": "copyrightDate",
+}
+
+// saveGraphqlBook saves book, reporting validation problems in the payload
+// rather than as errors so clients can show them next to their inputs.
+func saveGraphqlBook(c echo.Context, book *database.Book) (*BookPayload, error) {
+	errorMap := database.ErrorMap{}
+	if message := book.SetCopyrightDate(book.CopyrightDateString); message != "" {
+		errorMap["copyright_date"] = message
+	} else {
+		var err error
+		errorMap, err = book.Save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(errorMap) > 0 {
+		payload := &BookPayload{}
+		for field, message := range errorMap {
+			if name, ok := graphqlFieldNames[field]; ok {
+				field = name
+			}
+			payload.Errors = append(payload.Errors, FieldError{Field: field, Message: message})
+		}
+		return payload, nil
+	}
+
+	saved, err := database.GetBookById(book.LibraryId, book.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &BookPayload{Book: saved}, nil
+}
+
+func requireLibrarian(c echo.Context) error {
+	if !hasRole(c, database.RoleLibrarian) {
+		return errors.New("your role does not allow changes")
+	}
+	return nil
+}
+
+func getGraphqlBook(c echo.Context, id int) (*database.Book, error) {
+	book, err := database.GetBookById(currentLibrary(c).Id, id)
+	if err != nil {
+		return nil, err
+	}
+	if book.Id == 0 {
+		return nil, database.ErrBookNotFound
+	}
+	return book, nil
+}
+
+func buildGraphqlSchema() (graphql.Schema, error) {
+	library := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Library",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*database.Library).Id, nil
+			}},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*database.Library).Name, nil
+			}},
+		},
+	})
+
+	pageInfo := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*database.BookPage).NextCursor != "", nil
+			}},
+			"endCursor": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if cursor := p.Source.(*database.BookPage).NextCursor; cursor != "" {
+					return cursor, nil
+				}
+				return nil, nil
+			}},
+		},
+	})
+
+	var book *graphql.Object
+	var author *graphql.Object
+	var bookConnection *graphql.Object
+
+	bookVersion := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookVersion",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"version": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(database.BookVersion).Version, nil
+				}},
+				"createdAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(database.BookVersion).CreatedAt, nil
+				}},
+				"note": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(database.BookVersion).Note, nil
+				}},
+				"book": &graphql.Field{
+					Type:        graphql.NewNonNull(book),
+					Description: "The book as it was saved in this version",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						b := p.Source.(database.BookVersion).Book
+						return &b, nil
+					},
+				},
+			}
+		}),
+	})
+
+	author = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"firstName": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Author).FirstName, nil
+				}},
+				"lastName": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(Author).LastName, nil
+				}},
+				"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					a := p.Source.(Author)
+					if a.FirstName == "" {
+						return a.LastName, nil
+					}
+					return a.FirstName + " " + a.LastName, nil
+				}},
+				"books": &graphql.Field{
+					Type: graphql.NewNonNull(bookConnection),
+					Args: bookConnectionArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						a := p.Source.(Author)
+						return listBooks(p, &a)
+					},
+				},
+			}
+		}),
+	})
+
+	book = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        graphqlBookField(graphql.NewNonNull(graphql.Int), func(b *database.Book) interface{} { return b.Id }),
+				"createdAt": graphqlBookField(graphql.DateTime, func(b *database.Book) interface{} { return b.CreatedDate }),
+				"updatedAt": graphqlBookField(graphql.DateTime, func(b *database.Book) interface{} { return b.UpdatedDate }),
+				"isbn":      graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Isbn }),
+				"lccn":      graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Lccn }),
+				"title":     graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Title }),
+				"authorFirst": graphqlBookField(graphql.String, func(b *database.Book) interface{} {
+					return b.AuthorFirst
+				}),
+				"authorLast": graphqlBookField(graphql.String, func(b *database.Book) interface{} {
+					return b.AuthorLast
+				}),
+				"copyrightDate": graphqlBookField(graphql.String, func(b *database.Book) interface{} {
+					return b.CopyrightDateString
+				}),
+				"publisher": graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Publisher }),
+				"location":  graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Location }),
+				"genre":     graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Genre }),
+				"pages":     graphqlBookField(graphql.String, func(b *database.Book) interface{} { return b.Pages }),
+				"version":   graphqlBookField(graphql.NewNonNull(graphql.Int), func(b *database.Book) interface{} { return b.Version }),
+				"authors": graphqlBookField(graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(author))), func(b *database.Book) interface{} {
+					if b.AuthorLast == "" {
+						return []Author{}
+					}
+					return []Author{{FirstName: b.AuthorFirst, LastName: b.AuthorLast}}
+				}),
+				"versions": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookVersion))),
+					Description: "Saved versions of the book, newest first",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						b := p.Source.(*database.Book)
+						return database.GetBookVersions(b.LibraryId, b.Id)
+					},
+				},
+				"library": &graphql.Field{
+					Type: graphql.NewNonNull(library),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return database.GetLibraryById(p.Source.(*database.Book).LibraryId)
+					},
+				},
+			}
+		}),
+	})
+
+	bookConnection = graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookConnection",
+		Fields: graphql.Fields{
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(book))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var books []*database.Book
+					for i := range p.Source.(*database.BookPage).Books {
+						books = append(books, &p.Source.(*database.BookPage).Books[i])
+					}
+					return books, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfo),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	fieldError := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FieldError",
+		Fields: graphql.Fields{
+			"field": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(FieldError).Field, nil
+			}},
+			"message": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(FieldError).Message, nil
+			}},
+		},
+	})
+
+	bookPayload := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BookPayload",
+		Fields: graphql.Fields{
+			"book": &graphql.Field{
+				Type:        book,
+				Description: "The saved book, absent when there are errors",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if b := p.Source.(*BookPayload).Book; b != nil {
+						return b, nil
+					}
+					return nil, nil
+				},
+			},
+			"errors": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(fieldError))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if errs := p.Source.(*BookPayload).Errors; errs != nil {
+						return errs, nil
+					}
+					return []FieldError{}, nil
+				},
+			},
+		},
+	})
+
+	bookInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:   "BookInput",
+		Fields: bookInputFields(),
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"books": &graphql.Field{
+				Type: graphql.NewNonNull(bookConnection),
+				Args: bookConnectionArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return listBooks(p, nil)
+				},
+			},
+			"book": &graphql.Field{
+				Type: book,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					b, err := getGraphqlBook(echoContext(p.Context), p.Args["id"].(int))
+					if errors.Is(err, database.ErrBookNotFound) {
+						return nil, nil
+					}
+					return b, err
+				},
+			},
+			"library": &graphql.Field{
+				Type:        graphql.NewNonNull(library),
+				Description: "The library selected by the library cookie",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return currentLibrary(echoContext(p.Context)), nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createBook": &graphql.Field{
+				Type: graphql.NewNonNull(bookPayload),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := echoContext(p.Context)
+					if err := requireLibrarian(c); err != nil {
+						return nil, err
+					}
+					b := database.Book{Id: -1, LibraryId: currentLibrary(c).Id}
+					applyBookInput(&b, p.Args["input"].(map[string]interface{}))
+					return saveGraphqlBook(c, &b)
+				},
+			},
+			"updateBook": &graphql.Field{
+				Type:        graphql.NewNonNull(bookPayload),
+				Description: "Changes the fields present in input. Fails when version is not the stored version.",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := echoContext(p.Context)
+					if err := requireLibrarian(c); err != nil {
+						return nil, err
+					}
+					b, err := getGraphqlBook(c, p.Args["id"].(int))
+					if err != nil {
+						return nil, err
+					}
+					b.Version = p.Args["version"].(int)
+					applyBookInput(b, p.Args["input"].(map[string]interface{}))
+					return saveGraphqlBook(c, b)
+				},
+			},
+			"deleteBook": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Deletes a book and returns its id. Fails when version is not the stored version.",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := echoContext(p.Context)
+					if err := requireLibrarian(c); err != nil {
+						return nil, err
+					}
+					b, err := getGraphqlBook(c, p.Args["id"].(int))
+					if err != nil {
+						return nil, err
+					}
+					if b.Version != p.Args["version"].(int) {
+						return nil, database.ErrVersionConflict
+					}
+					err = database.DeleteBook(b.LibraryId, b.Id)
+					if err != nil {
+						return nil, err
+					}
+					return b.Id, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+// checkGraphqlComplexity estimates the cost and depth of the operation that
+// will run, following fragments, and refuses it when either is too large.
+func checkGraphqlComplexity(request GraphqlRequest) error {
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(request.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		// Let the executor report syntax errors with their locations
+		return nil
+	}
+
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch d := definition.(type) {
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		case *ast.OperationDefinition:
+			if request.OperationName == "" || (d.Name != nil && d.Name.Value == request.OperationName) {
+				operations = append(operations, d)
+			}
+		}
+	}
+
+	estimator := &complexityEstimator{fragments: fragments, variables: request.Variables, visiting: map[string]bool{}}
+	for _, operation := range operations {
+		cost, depth := estimator.selectionSet(operation.SelectionSet)
+		if depth > MAX_GRAPHQL_DEPTH {
+			return fmt.Errorf("query is nested %d levels deep, the limit is %d", depth, MAX_GRAPHQL_DEPTH)
+		}
+		if cost > MAX_GRAPHQL_COMPLEXITY {
+			return fmt.Errorf("query complexity is %d, the limit is %d; ask for fewer items with first", cost, MAX_GRAPHQL_COMPLEXITY)
+		}
+	}
+	return nil
+}
+
+type complexityEstimator struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+// selectionSet returns the cost and depth of a selection set.
+func (e *complexityEstimator) selectionSet(set *ast.SelectionSet) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+	cost, depth := 0, 0
+	for _, selection := range set.Selections {
+		var c, d int
+		switch s := selection.(type) {
+		case *ast.Field:
+			c, d = e.selectionSet(s.SelectionSet)
+			c = 1 + e.listSize(s)*c
+			d++
+		case *ast.InlineFragment:
+			c, d = e.selectionSet(s.SelectionSet)
+		case *ast.FragmentSpread:
+			fragment, ok := e.fragments[s.Name.Value]
+			if !ok || e.visiting[s.Name.Value] {
+				continue
+			}
+			e.visiting[s.Name.Value] = true
+			c, d = e.selectionSet(fragment.SelectionSet)
+			delete(e.visiting, s.Name.Value)
+		}
+		cost += c
+		depth = max(depth, d)
+	}
+	return cost, depth
+}
+
+// listSize is how many items a field may return.
+func (e *complexityEstimator) listSize(field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+		switch v := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil {
+				return clampListSize(n)
+			}
+		case *ast.Variable:
+			if n, ok := e.variables[v.Name.Value].(float64); ok {
+				return clampListSize(int(n))
+			}
+		}
+		return DEFAULT_GRAPHQL_FIRST
+	}
+	if field.Name.Value == "books" {
+		return DEFAULT_GRAPHQL_FIRST
+	}
+	if size, ok := graphqlListSizes[field.Name.Value]; ok {
+		return size
+	}
+	return 1
+}
+
+func clampListSize(n int) int {
+	return min(max(n, 1), database.MAX_BOOK_QUERY_LIMIT)
+}
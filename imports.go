@@ -0,0 +1,891 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/calibre"
+	"mlibrary-htmx/pkg/catalogs"
+	"mlibrary-htmx/pkg/citation"
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+	"mlibrary-htmx/pkg/importer"
+	"mlibrary-htmx/pkg/marc"
+	"mlibrary-htmx/pkg/spreadsheet"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RecordImportPage struct {
+	BatchId  int
+	Filename string
+	Imported int
+	Updated  int
+	Skipped  int
+	Waiting  int
+	Records  []RecordReport
+	Pending  []PendingDuplicate
+}
+
+// Results of importing a record.
+const (
+	resultCreated = "created"
+	resultUpdated = "updated"
+	resultSkipped = "skipped"
+	resultWaiting = "waiting for a decision"
+)
+
+// RecordReport says what happened to one record and lists what could not
+// be carried over from it. BookId is the stored book it matched, if any.
+type RecordReport struct {
+	Number     int
+	Identifier string
+	Title      string
+	Result     string
+	BookId     int
+	Warnings   []string
+}
+
+// PendingDuplicate is a record matching a stored book, held back until
+// someone decides what to do with it. Incoming is the record's book as
+// JSON, to be posted back with the decision.
+type PendingDuplicate struct {
+	Number   int
+	Book     database.Book
+	Incoming string
+	Existing database.Book
+	By       string
+}
+
+// importedRecord is one record of an uploaded file mapped onto a book.
+type importedRecord struct {
+	Identifier string
+	Book       database.Book
+	Warnings   []string
+	Ok         bool
+}
+
+// errSkipRecord marks a record that could not be read when the rest of the
+// file still can.
+var errSkipRecord = errors.New("record skipped")
+
+// recordImport collects what an import will write, deciding what to do
+// with records that match books already in the library.
+type recordImport struct {
+	libraryId int
+	batchId   int
+	page      RecordImportPage
+	creates   []database.Book
+	updates   []database.Book
+	decider   *importer.Decider
+}
+
+func newRecordImport(libraryId int, filename string, policy importer.Policy) *recordImport {
+	return &recordImport{
+		libraryId: libraryId,
+		page:      RecordImportPage{Filename: filename},
+		decider:   importer.NewDecider(libraryId, policy),
+	}
+}
+
+// add decides what to do with a record that mapped onto a book.
+func (r *recordImport) add(report RecordReport, book database.Book) error {
+	decision, err := r.decider.Decide(report.Number, book)
+	if err != nil {
+		return err
+	}
+	if decision.Match != nil {
+		report.BookId = decision.Match.Book.Id
+	}
+	report.Warnings = append(report.Warnings, decision.Warnings...)
+	switch decision.Action {
+	case importer.Create:
+		r.create(report, decision.Book)
+	case importer.Update:
+		r.update(report, decision.Book)
+	case importer.Ask:
+		incoming, err := json.Marshal(book)
+		if err != nil {
+			return err
+		}
+		r.page.Pending = append(r.page.Pending, PendingDuplicate{
+			Number:   report.Number,
+			Book:     book,
+			Incoming: string(incoming),
+			Existing: decision.Match.Book,
+			By:       decision.Match.By,
+		})
+		report.Result = resultWaiting
+		r.page.Waiting++
+		r.page.Records = append(r.page.Records, report)
+	default:
+		r.skip(report)
+	}
+	return nil
+}
+
+func (r *recordImport) create(report RecordReport, book database.Book) {
+	r.creates = append(r.creates, book)
+	report.Result = resultCreated
+	r.page.Imported++
+	r.page.Records = append(r.page.Records, report)
+}
+
+// update saves changes to a stored book.
+func (r *recordImport) update(report RecordReport, book database.Book) {
+	r.updates = append(r.updates, book)
+	report.Result = resultUpdated
+	r.page.Updated++
+	r.page.Records = append(r.page.Records, report)
+}
+
+func (r *recordImport) skip(report RecordReport) {
+	report.Result = resultSkipped
+	r.page.Skipped++
+	r.page.Records = append(r.page.Records, report)
+}
+
+// begin records the import as a batch, so that what it writes can be
+// undone.
+func (r *recordImport) begin(userId int) error {
+	id, err := database.CreateImportBatch(r.libraryId, userId, r.page.Filename)
+	if err != nil {
+		return err
+	}
+	r.batchId = id
+	r.page.BatchId = id
+	return nil
+}
+
+// finish records how the import ended and the records it skipped.
+func (r *recordImport) finish(state string, failure string) error {
+	var importErrors []database.ImportError
+	for _, record := range r.page.Records {
+		if record.Result != resultSkipped {
+			continue
+		}
+		importErrors = append(importErrors, database.ImportError{
+			Number:     record.Number,
+			Identifier: record.Identifier,
+			Title:      record.Title,
+			Message:    strings.Join(record.Warnings, "; "),
+		})
+	}
+	return database.FinishImportBatch(r.batchId, state, r.page.Skipped, r.page.Waiting, failure, importErrors)
+}
+
+// flush writes the books collected since the last flush.
+func (r *recordImport) flush() error {
+	err := database.ImportBooks(r.libraryId, r.batchId, r.creates, r.updates)
+	if err != nil {
+		return err
+	}
+	r.creates, r.updates = nil, nil
+	return nil
+}
+
+// pending is how many records are waiting to be written.
+func (r *recordImport) pending() int {
+	return len(r.creates) + len(r.updates)
+}
+
+// take adds a record read by a recordReader, returning false once there
+// are no more. Records that map onto books are added, and the others
+// skipped.
+func (r *recordImport) take(number int, record *importedRecord, err error) (bool, error) {
+	if err == io.EOF {
+		return false, nil
+	}
+	if errors.Is(err, errSkipRecord) {
+		r.skip(RecordReport{Number: number, Warnings: []string{err.Error()}})
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("file %s could not be read: %v", r.page.Filename, err)
+	}
+
+	report := RecordReport{
+		Number:     number,
+		Identifier: record.Identifier,
+		Title:      record.Book.Title,
+		Warnings:   record.Warnings,
+	}
+	if !record.Ok {
+		r.skip(report)
+		return true, nil
+	}
+	return true, r.add(report, record.Book)
+}
+
+// ResolveDuplicates imports the records an import held back, as decided
+// for each one.
+func ResolveDuplicates(c echo.Context) error {
+	library := currentLibrary(c)
+	params, err := c.FormParams()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	numbers, incoming, existing, decisions := params["number"], params["incoming"], params["existing"], params["decision"]
+	if len(incoming) != len(numbers) || len(existing) != len(numbers) || len(decisions) != len(numbers) {
+		return echo.NewHTTPError(http.StatusBadRequest, "every held back record needs a decision")
+	}
+
+	// Every decision is read before the import begins, so that a bad one
+	// leaves no import behind
+	type decision struct {
+		report   RecordReport
+		book     database.Book
+		policy   importer.Policy
+		existing int
+	}
+	decided := make([]decision, len(numbers))
+	for i := range numbers {
+		var book database.Book
+		err := json.Unmarshal([]byte(incoming[i]), &book)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "a held back record could not be read")
+		}
+		if book.CopyrightDateString != "" {
+			book.CopyrightDate, _ = dates.Parse(book.CopyrightDateString)
+		}
+		book.Id = -1
+		policy, err := importer.ParsePolicy(decisions[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		number, _ := strconv.Atoi(numbers[i])
+		existingId, _ := strconv.Atoi(existing[i])
+		decided[i] = decision{
+			report:   RecordReport{Number: number, Title: book.Title},
+			book:     book,
+			policy:   policy,
+			existing: existingId,
+		}
+	}
+
+	batch := newRecordImport(library.Id, params.Get("filename"), importer.PolicySkip)
+	err = batch.begin(currentUserId(c))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	for _, d := range decided {
+		switch d.policy {
+		case importer.PolicyCreate:
+			batch.create(d.report, d.book)
+		case importer.PolicyUpdate:
+			err = batch.updateExisting(d.report, d.book, d.existing)
+		default:
+			batch.skip(d.report)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = batch.flush()
+	}
+	if err != nil {
+		batch.finish(jobFailed, err.Error())
+		c.Logger().Error(err)
+		return err
+	}
+	err = batch.finish(jobDone, "")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, "record-import", batch.page)
+}
+
+// updateExisting merges a held back record into the book it was held back
+// for, unless that book has been deleted since.
+func (r *recordImport) updateExisting(report RecordReport, book database.Book, id int) error {
+	stored, err := database.GetBookById(r.libraryId, id)
+	if err != nil {
+		return err
+	}
+	if stored.Id == 0 {
+		report.Warnings = append(report.Warnings, "the matching book no longer exists")
+		r.skip(report)
+		return nil
+	}
+	report.BookId = stored.Id
+	if !r.decider.ClaimUpdate(stored.Id) {
+		report.Warnings = append(report.Warnings, "the book was already updated by an earlier record")
+		r.skip(report)
+		return nil
+	}
+	r.update(report, importer.Merge(*stored, book))
+	return nil
+}
+
+// recordReader returns the next record of a file, or io.EOF after the
+// last one.
+type recordReader func() (*importedRecord, error)
+
+// recordSource reads a file in one of the formats that can be uploaded.
+type recordSource func(r *stagedReader) (recordReader, error)
+
+type marcRecordReader interface {
+	Next() (*marc.Record, error)
+}
+
+// marcSource reads binary MARC 21 records, or MARCXML when xml is set.
+func marcSource(xml bool) recordSource {
+	return func(r *stagedReader) (recordReader, error) {
+		var reader marcRecordReader = marc.NewReader(r)
+		if xml {
+			reader = marc.NewXMLReader(r)
+		}
+		return func() (*importedRecord, error) {
+			record, err := reader.Next()
+			if errors.Is(err, marc.ErrMalformed) {
+				return nil, fmt.Errorf("%w: %v", errSkipRecord, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			book, warnings, ok := marc.ToBook(record)
+			return &importedRecord{Identifier: record.Identifier(), Book: book, Warnings: warnings, Ok: ok}, nil
+		}, nil
+	}
+}
+
+// citationSource reads the references in a BibTeX or RIS file.
+func citationSource(read func(io.Reader) ([]citation.Entry, error)) recordSource {
+	return func(r *stagedReader) (recordReader, error) {
+		entries, err := read(r)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*importedRecord, error) {
+			if len(entries) == 0 {
+				return nil, io.EOF
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return &importedRecord{Identifier: entry.Key, Book: entry.Book, Warnings: entry.Warnings, Ok: entry.Ok}, nil
+		}, nil
+	}
+}
+
+// catalogSource reads the export of another book catalog.
+func catalogSource(format string) recordSource {
+	return func(r *stagedReader) (recordReader, error) {
+		reader, err := catalogs.NewReader(format, r)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*importedRecord, error) {
+			entry, err := reader.Next()
+			if err != nil {
+				return nil, err
+			}
+			return &importedRecord{Identifier: entry.Id, Book: entry.Book, Warnings: entry.Warnings, Ok: entry.Ok}, nil
+		}, nil
+	}
+}
+
+// calibreSource reads the books of a Calibre metadata.db. Covers are not
+// uploaded with it, so they are only copied by cmd/calibre-import.
+func calibreSource(r *stagedReader) (recordReader, error) {
+	reader, err := calibre.Open(r.f.Name())
+	if err != nil {
+		return nil, err
+	}
+	r.closers = append(r.closers, reader)
+	return func() (*importedRecord, error) {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		return &importedRecord{Identifier: entry.Id, Book: entry.Book, Warnings: entry.Warnings, Ok: entry.Ok}, nil
+	}, nil
+}
+
+// detectCatalog names the catalog an uploaded CSV file was exported from,
+// or returns "" for CSV files to map by hand.
+func detectCatalog(file *multipart.FileHeader) string {
+	src, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer src.Close()
+	return catalogs.Detect(src)
+}
+
+// csvSource reads the rows of a CSV file or workbook sheet after its header,
+// with the columns mapped to fields by the staged mapping.
+func csvSource(staged stagedCsv) recordSource {
+	return func(r *stagedReader) (recordReader, error) {
+		rows, err := newCsvRows(r, r.size, staged)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*importedRecord, error) {
+			row, err := rows.Next()
+			if err != nil {
+				return nil, err
+			}
+			return &importedRecord{Book: row.Book, Warnings: row.Messages(), Ok: row.Valid()}, nil
+		}, nil
+	}
+}
+
+// uploadRecords stages an uploaded file and imports it in the background.
+func uploadRecords(c echo.Context, file *multipart.FileHeader, source recordSource) error {
+	src, err := file.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	defer src.Close()
+
+	id, err := importer.Stage(src)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return startImport(c, id, file.Filename, source)
+}
+
+// CSV_SAMPLE_ROWS is how many rows the mapping screen shows under each
+// column.
+const CSV_SAMPLE_ROWS = 3
+
+type CsvMappingPage struct {
+	Staged  stagedCsv
+	Sheets  []string
+	Columns []CsvColumn
+	Fields  []importer.Field
+	Error   string
+}
+
+// CsvColumn is a column of an uploaded CSV file or sheet, the field it is mapped to
+// and its first few values.
+type CsvColumn struct {
+	Name    string
+	Field   string
+	Samples []string
+}
+
+// uploadCsv stages a CSV file or workbook and asks which book field each of
+// its columns holds, guessing from the header row.
+func uploadCsv(c echo.Context, file *multipart.FileHeader) error {
+	src, err := file.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	defer src.Close()
+
+	id, err := importer.Stage(src)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	page, err := csvMappingPage(stagedCsv{Upload: id, Filename: file.Filename, Duplicates: c.FormValue("duplicates")})
+	if err != nil {
+		importer.RemoveStaged(id)
+		return c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s could not be read: %s</p>", html.EscapeString(file.Filename), html.EscapeString(err.Error())))
+	}
+	return c.Render(http.StatusOK, "csv-mapping", page)
+}
+
+// csvMappingPage reads the header and first rows of a staged CSV file or
+// workbook sheet. The columns are mapped by the staged mapping, or matched
+// by name when there is none.
+func csvMappingPage(staged stagedCsv) (CsvMappingPage, error) {
+	page := CsvMappingPage{Staged: staged, Fields: importer.Fields}
+	mapping := staged.Mapping
+	rows, err := staged.open()
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	page.Sheets = rows.Sheets
+	if rows.Header == nil {
+		return page, errors.New("the file is empty")
+	}
+	if mapping == nil {
+		mapping = importer.MatchColumns(rows.Header)
+	}
+	for i, name := range rows.Header {
+		column := CsvColumn{Name: name}
+		if i < len(mapping) {
+			column.Field = mapping[i]
+		}
+		page.Columns = append(page.Columns, column)
+	}
+	for n := 0; n < CSV_SAMPLE_ROWS; n++ {
+		row, err := rows.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return page, err
+		}
+		for i := range page.Columns {
+			value := ""
+			if i < len(row) {
+				value = row[i]
+			}
+			page.Columns[i].Samples = append(page.Columns[i].Samples, value)
+		}
+	}
+	return page, nil
+}
+
+// stagedCsv is a staged CSV upload or workbook, the sheet to read from a
+// workbook and the column mapping confirmed for it, as posted back by the
+// mapping and preview screens.
+type stagedCsv struct {
+	Upload     string
+	Filename   string
+	Sheet      string
+	Mapping    importer.Mapping
+	Duplicates string
+}
+
+// isWorkbook is true for the uploads read as XLSX or ODS workbooks.
+func (s stagedCsv) isWorkbook() bool {
+	switch strings.ToLower(filepath.Ext(s.Filename)) {
+	case ".xlsx", ".ods":
+		return true
+	}
+	return false
+}
+
+func readStagedCsv(c echo.Context) (stagedCsv, error) {
+	params, err := c.FormParams()
+	if err != nil {
+		return stagedCsv{}, err
+	}
+	return stagedCsv{
+		Upload:     params.Get("upload"),
+		Filename:   params.Get("filename"),
+		Sheet:      params.Get("sheet"),
+		Mapping:    importer.Mapping(params["field"]),
+		Duplicates: params.Get("duplicates"),
+	}, nil
+}
+
+// Values encodes the upload for a link back to it.
+func (s stagedCsv) Values() url.Values {
+	values := url.Values{"upload": {s.Upload}, "filename": {s.Filename}, "sheet": {s.Sheet}, "duplicates": {s.Duplicates}}
+	for _, field := range s.Mapping {
+		values.Add("field", field)
+	}
+	return values
+}
+
+// csvRows reads the rows of a CSV file or workbook sheet after its header
+// and checks each one. Sheets names the sheets of a workbook.
+type csvRows struct {
+	file    *os.File
+	sheet   *spreadsheet.Rows
+	reader  importer.RowReader
+	mapping importer.Mapping
+	Header  []string
+	Sheets  []string
+	number  int
+}
+
+// stagedFile is a staged upload, read in order for CSV files or at random
+// for workbooks.
+type stagedFile interface {
+	io.Reader
+	io.ReaderAt
+}
+
+func newCsvRows(f stagedFile, size int64, staged stagedCsv) (*csvRows, error) {
+	rows := &csvRows{mapping: staged.Mapping}
+	var reader importer.RowReader = importer.NewCSVReader(f)
+	if staged.isWorkbook() {
+		workbook, err := spreadsheet.Open(f, size)
+		if err != nil {
+			return nil, err
+		}
+		rows.Sheets = workbook.Sheets()
+		rows.sheet, err = workbook.Rows(staged.Sheet)
+		if err != nil {
+			return nil, err
+		}
+		reader = rows.sheet
+	}
+
+	var err error
+	rows.Header, rows.reader, err = importer.ReadHeader(reader)
+	if err != nil && err != io.EOF {
+		rows.Close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (s stagedCsv) open() (*csvRows, error) {
+	f, err := importer.OpenStaged(s.Upload)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rows, err := newCsvRows(f, info.Size(), s)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rows.file = f
+	return rows, nil
+}
+
+// Next returns the next row, or io.EOF after the last one.
+func (r *csvRows) Next() (importer.Row, error) {
+	cells, err := r.reader.Read()
+	if err != nil {
+		return importer.Row{}, err
+	}
+	r.number++
+	return r.mapping.Check(r.number, cells), nil
+}
+
+func (r *csvRows) Close() error {
+	if r.sheet != nil {
+		r.sheet.Close()
+	}
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// openStagedCsv opens the rows of the posted upload. When the upload is
+// gone, or its mapping is not usable, it renders what to do instead and
+// returns nil rows.
+func openStagedCsv(c echo.Context) (stagedCsv, *csvRows, error) {
+	staged, err := readStagedCsv(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return staged, nil, err
+	}
+	if err := staged.Mapping.Validate(); err != nil {
+		page, readErr := csvMappingPage(staged)
+		if errors.Is(readErr, importer.ErrUploadNotFound) {
+			return staged, nil, c.HTML(http.StatusOK, "<p>The uploaded file is no longer available. Please upload it again.</p>")
+		}
+		if readErr != nil {
+			c.Logger().Error(readErr)
+			return staged, nil, readErr
+		}
+		page.Error = err.Error()
+		return staged, nil, c.Render(http.StatusOK, "csv-mapping", page)
+	}
+	rows, err := staged.open()
+	if errors.Is(err, importer.ErrUploadNotFound) {
+		return staged, nil, c.HTML(http.StatusOK, "<p>The uploaded file is no longer available. Please upload it again.</p>")
+	}
+	if err != nil {
+		return staged, nil, c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s could not be read: %s</p>", html.EscapeString(staged.Filename), html.EscapeString(err.Error())))
+	}
+	return staged, rows, nil
+}
+
+// EditCsvMapping goes back from the preview to the mapping screen. Choosing
+// another sheet of a workbook matches its columns again.
+func EditCsvMapping(c echo.Context) error {
+	staged, err := readStagedCsv(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if c.FormValue("rematch") != "" {
+		staged.Mapping = nil
+	}
+	page, err := csvMappingPage(staged)
+	if errors.Is(err, importer.ErrUploadNotFound) {
+		return c.HTML(http.StatusOK, "<p>The uploaded file is no longer available. Please upload it again.</p>")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, "csv-mapping", page)
+}
+
+// CSV_PREVIEW_ROWS is how many rows the preview table shows. Every row is
+// still checked and counted.
+const CSV_PREVIEW_ROWS = 100
+
+type CsvPreviewPage struct {
+	Staged  stagedCsv
+	Columns []string
+	Rows    []CsvPreviewRow
+	Total   int
+	Valid   int
+	Warned  int
+	Invalid int
+	// Duplicates counts the valid rows matching a stored book or an
+	// earlier row
+	Duplicates int
+	ErrorsURL  string
+}
+
+type CsvPreviewRow struct {
+	Number    int
+	Valid     bool
+	Cells     []CsvPreviewCell
+	Messages  []string
+	Duplicate string
+	BookId    int
+}
+
+type CsvPreviewCell struct {
+	Value string
+	Issue *importer.Issue
+}
+
+// PreviewCsv checks every row of a staged CSV file or sheet without importing it,
+// showing which rows are ready, which are already in the library and what
+// is wrong with the others.
+func PreviewCsv(c echo.Context) error {
+	library := currentLibrary(c)
+	staged, rows, err := openStagedCsv(c)
+	if rows == nil {
+		return err
+	}
+	defer rows.Close()
+
+	page := CsvPreviewPage{Staged: staged, ErrorsURL: "/upload/csv/errors?" + staged.Values().Encode()}
+	var columns []int
+	for i, field := range staged.Mapping {
+		if field != "" && i < len(rows.Header) {
+			columns = append(columns, i)
+			page.Columns = append(page.Columns, rows.Header[i])
+		}
+	}
+	seen := map[string]int{}
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s could not be read: %s</p>", html.EscapeString(staged.Filename), html.EscapeString(err.Error())))
+		}
+
+		page.Total++
+		duplicate, bookId := "", 0
+		if row.Valid() {
+			keys := database.DuplicateKeys(row.Book)
+			for _, key := range keys {
+				if earlier, ok := seen[key]; ok && duplicate == "" {
+					duplicate = fmt.Sprintf("same book as row %d", earlier)
+				}
+			}
+			if duplicate == "" {
+				match, err := database.FindDuplicate(library.Id, row.Book)
+				if err != nil {
+					c.Logger().Error(err)
+					return err
+				}
+				if match != nil {
+					duplicate, bookId = fmt.Sprintf("same %s as %q", match.By, match.Book.Title), match.Book.Id
+				}
+			}
+			for _, key := range keys {
+				if _, ok := seen[key]; !ok {
+					seen[key] = row.Number
+				}
+			}
+			if duplicate != "" {
+				page.Duplicates++
+			}
+		}
+		if !row.Valid() {
+			page.Invalid++
+		} else {
+			page.Valid++
+			if len(row.Issues) > 0 {
+				page.Warned++
+			}
+		}
+		if len(page.Rows) < CSV_PREVIEW_ROWS {
+			preview := CsvPreviewRow{Number: row.Number, Valid: row.Valid(), Messages: row.Messages(), Duplicate: duplicate, BookId: bookId}
+			for _, i := range columns {
+				cell := CsvPreviewCell{Issue: row.Issue(staged.Mapping[i])}
+				if i < len(row.Cells) {
+					cell.Value = row.Cells[i]
+				}
+				preview.Cells = append(preview.Cells, cell)
+			}
+			page.Rows = append(page.Rows, preview)
+		}
+	}
+	return c.Render(http.StatusOK, "csv-preview", page)
+}
+
+// DownloadCsvErrors sends the rows of a staged CSV file or sheet that cannot
+// be imported, with a column explaining why, so they can be fixed and uploaded
+// again.
+func DownloadCsvErrors(c echo.Context) error {
+	staged, rows, err := openStagedCsv(c)
+	if rows == nil {
+		return err
+	}
+	defer rows.Close()
+
+	name := strings.TrimSuffix(filepath.Base(staged.Filename), filepath.Ext(staged.Filename)) + "-errors.csv"
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	w.Write(append(append([]string{}, rows.Header...), "errors"))
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			break
+		}
+		if row.Valid() {
+			continue
+		}
+		cells := make([]string, len(rows.Header))
+		copy(cells, row.Cells)
+		w.Write(append(cells, strings.Join(row.Messages(), "; ")))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ImportCsv imports the rows of a staged CSV file or sheet that passed their
+// checks in the background.
+func ImportCsv(c echo.Context) error {
+	staged, rows, err := openStagedCsv(c)
+	if rows == nil {
+		return err
+	}
+	rows.Close()
+	return startImport(c, staged.Upload, staged.Filename, csvSource(staged))
+}
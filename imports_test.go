@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/importer"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A decision that cannot be read fails the request before an import is
+// recorded for it.
+func TestResolveDuplicatesRejectsBeforeImporting(t *testing.T) {
+	useLiveDatabase(t)
+	form := url.Values{
+		"filename": {"books.csv"},
+		"number":   {"2", "3"},
+		"incoming": {`{"title": "The Hobbit", "author_last": "Tolkien"}`, `{"title": "Beowulf", "author_last": "Heaney"}`},
+		"existing": {"1", "1"},
+		"decision": {"create", "overwrite everything"},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/upload/duplicates", strings.NewReader(form.Encode()))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	c := echo.New().NewContext(request, httptest.NewRecorder())
+	c.Set("library", &database.Library{Id: 1})
+
+	err := ResolveDuplicates(c)
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusBadRequest {
+		t.Fatalf("got %v, want a bad request", err)
+	}
+	batches, err := database.GetImportBatches(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 0 {
+		t.Errorf("imports = %+v, want none", batches)
+	}
+	if titles := liveTitles(t); len(titles) != 1 {
+		t.Errorf("books = %q, want only the live book", titles)
+	}
+}
+
+// A record repeating an earlier one is skipped and listed with the import's
+// errors, while the earlier one still updates the book it matches.
+func TestRecordImportSkipsRepeatedRecords(t *testing.T) {
+	useLiveDatabase(t)
+	batch := newRecordImport(1, "books.csv", importer.PolicyUpdate)
+	if err := batch.begin(0); err != nil {
+		t.Fatal(err)
+	}
+	books := []database.Book{
+		{Id: -1, Title: "Live", AuthorLast: "Tolkien", Publisher: "Allen & Unwin"},
+		{Id: -1, Title: "Live", AuthorLast: "Tolkien", Publisher: "Houghton Mifflin"},
+		{Id: -1, Title: "Beowulf", AuthorLast: "Heaney"},
+	}
+	for i, book := range books {
+		if err := batch.add(RecordReport{Number: i + 1, Title: book.Title}, book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.finish(jobDone, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []string
+	for _, record := range batch.page.Records {
+		results = append(results, record.Result)
+	}
+	if want := []string{resultUpdated, resultSkipped, resultCreated}; !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %q, want %q", results, want)
+	}
+	stored, err := database.GetBookById(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Publisher != "Allen & Unwin" {
+		t.Errorf("publisher = %q, want the first record's", stored.Publisher)
+	}
+	imported, err := database.GetImportBatch(1, batch.batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Errors) != 1 || imported.Errors[0].Number != 2 || !strings.Contains(imported.Errors[0].Message, "same book as record 1") {
+		t.Errorf("errors = %+v, want record 2 as the same book as record 1", imported.Errors)
+	}
+}
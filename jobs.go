@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"mlibrary-htmx/pkg/importer"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IMPORT_BATCH_SIZE is how many books an import writes per transaction.
+const IMPORT_BATCH_SIZE = 200
+
+// IMPORT_JOB_LIFETIME is how long a finished import's report is kept.
+const IMPORT_JOB_LIFETIME = time.Hour
+
+const (
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// importJob is an import running in the background. It is named by the id
+// of the staged upload it reads.
+type importJob struct {
+	Id        string
+	LibraryId int
+
+	mu         sync.Mutex
+	state      string
+	cancelling bool
+	progress   int
+	records    int
+	err        string
+	finished   time.Time
+	batch      *recordImport
+	cancel     context.CancelFunc
+}
+
+type ImportJobPage struct {
+	Id         string
+	Filename   string
+	State      string
+	Running    bool
+	Cancelling bool
+	Progress   int
+	Records    int
+	Error      string
+	Report     RecordImportPage
+}
+
+var importJobs = struct {
+	sync.Mutex
+	jobs map[string]*importJob
+}{jobs: map[string]*importJob{}}
+
+// startImport reads a staged upload in the background and shows its
+// progress. The duplicates policy comes from the request.
+func startImport(c echo.Context, id string, filename string, source recordSource) error {
+	policy, policyErr := importer.ParsePolicy(c.FormValue("duplicates"))
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &importJob{
+		Id:        id,
+		LibraryId: currentLibrary(c).Id,
+		state:     jobRunning,
+		batch:     newRecordImport(currentLibrary(c).Id, filename, policy),
+		cancel:    cancel,
+	}
+
+	// A second submit of the same upload, such as a double click, shows the
+	// job that claimed it instead of importing every record again. The job
+	// stays locked until its import has begun, so that it is only shown
+	// once it has.
+	job.mu.Lock()
+	claimed := claimImport(job)
+	if claimed != job {
+		job.mu.Unlock()
+		cancel()
+		if claimed.LibraryId != job.LibraryId {
+			return echo.NewHTTPError(http.StatusNotFound, errImportJobNotFound.Error())
+		}
+		return c.Render(http.StatusOK, "import-job", claimed.page())
+	}
+	err := policyErr
+	if err == nil {
+		err = job.batch.begin(currentUserId(c))
+	}
+	if err != nil {
+		job.state = jobFailed
+		job.err = err.Error()
+		job.finished = time.Now()
+		job.mu.Unlock()
+		cancel()
+		importer.RemoveStaged(id)
+		if policyErr != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		c.Logger().Error(err)
+		return err
+	}
+	job.mu.Unlock()
+
+	logger := c.Logger()
+	go func() {
+		err := job.run(ctx, source)
+		if err != nil {
+			logger.Error(err)
+		}
+	}()
+	return c.Render(http.StatusOK, "import-job", job.page())
+}
+
+// claimImport registers job under the id of its upload unless another job
+// has it already, and returns the job that has the upload. The reports of
+// imports that finished long ago are dropped.
+func claimImport(job *importJob) *importJob {
+	importJobs.Lock()
+	defer importJobs.Unlock()
+	for key, old := range importJobs.jobs {
+		old.mu.Lock()
+		expired := old.state != jobRunning && time.Since(old.finished) > IMPORT_JOB_LIFETIME
+		old.mu.Unlock()
+		if expired {
+			delete(importJobs.jobs, key)
+		}
+	}
+	if claimed, ok := importJobs.jobs[job.Id]; ok {
+		return claimed
+	}
+	importJobs.jobs[job.Id] = job
+	return job
+}
+
+// stagedReader reads a staged upload, counting the bytes read so the
+// progress of an import can be shown. Workbooks are read at random, so
+// their progress is only roughly how far through the sheet the import is.
+// Sources that open the file another way add what they open to closers.
+type stagedReader struct {
+	f       *os.File
+	size    int64
+	n       int64
+	closers []io.Closer
+}
+
+func (r *stagedReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *stagedReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.f.ReadAt(p, off)
+	r.n += int64(n)
+	return n, err
+}
+
+// closeSources closes what sources opened besides the file.
+func (r *stagedReader) closeSources() {
+	for _, c := range r.closers {
+		c.Close()
+	}
+}
+
+// progress is the percentage of the file read so far.
+func (r *stagedReader) progress() int {
+	if r.size <= 0 {
+		return 0
+	}
+	return int(min(r.n*100/r.size, 100))
+}
+
+// run imports the records of the staged upload, writing them in batches.
+// Batches written before the job fails or is cancelled are kept.
+func (j *importJob) run(ctx context.Context, source recordSource) error {
+	defer j.cancel()
+	defer importer.RemoveStaged(j.Id)
+
+	err := j.importRecords(ctx, source)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = time.Now()
+	switch {
+	case err != nil:
+		j.state = jobFailed
+		j.err = err.Error()
+	case ctx.Err() != nil:
+		j.state = jobCancelled
+	default:
+		j.state = jobDone
+		j.progress = 100
+	}
+	finishErr := j.batch.finish(j.state, j.err)
+	if err == nil {
+		err = finishErr
+	}
+	return err
+}
+
+// importRecords reads the records and writes the last of them. A panic
+// while reading a file fails the job rather than the server.
+func (j *importJob) importRecords(ctx context.Context, source recordSource) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("file %s could not be read: %v", j.batch.page.Filename, p)
+		}
+	}()
+	err = j.read(ctx, source)
+	if err == nil {
+		err = j.batch.flush()
+	}
+	return err
+}
+
+func (j *importJob) read(ctx context.Context, source recordSource) error {
+	f, err := importer.OpenStaged(j.Id)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	reader := &stagedReader{f: f, size: info.Size()}
+	defer reader.closeSources()
+	next, err := source(reader)
+	if err != nil {
+		return fmt.Errorf("file %s could not be read: %v", j.batch.page.Filename, err)
+	}
+
+	for number := 1; ctx.Err() == nil; number++ {
+		// Parsing the record is left outside the lock, so that showing the
+		// progress never waits on it
+		record, err := next()
+		more, err := j.take(number, record, err, reader.progress())
+		if err != nil || !more {
+			return err
+		}
+
+		if j.batch.pending() >= IMPORT_BATCH_SIZE {
+			err = j.batch.flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// take adds a record to the import and notes the progress.
+func (j *importJob) take(number int, record *importedRecord, err error, progress int) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	more, err := j.batch.take(number, record, err)
+	if more {
+		j.records = number
+	}
+	j.progress = progress
+	return more, err
+}
+
+// page copies the job's state for showing.
+func (j *importJob) page() ImportJobPage {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	page := ImportJobPage{
+		Id:         j.Id,
+		Filename:   j.batch.page.Filename,
+		State:      j.state,
+		Running:    j.state == jobRunning,
+		Cancelling: j.cancelling,
+		Progress:   j.progress,
+		Records:    j.records,
+		Error:      j.err,
+		Report:     j.batch.page,
+	}
+	page.Report.Records = append([]RecordReport(nil), j.batch.page.Records...)
+	page.Report.Pending = append([]PendingDuplicate(nil), j.batch.page.Pending...)
+	return page
+}
+
+var errImportJobNotFound = errors.New("import not found")
+
+// findImportJob finds a job of the current library.
+func findImportJob(c echo.Context) (*importJob, error) {
+	importJobs.Lock()
+	job, ok := importJobs.jobs[c.Param("id")]
+	importJobs.Unlock()
+	if !ok || job.LibraryId != currentLibrary(c).Id {
+		return nil, echo.NewHTTPError(http.StatusNotFound, errImportJobNotFound.Error())
+	}
+	return job, nil
+}
+
+// GetImportJob shows the progress of an import, or its report once it has
+// finished.
+func GetImportJob(c echo.Context) error {
+	job, err := findImportJob(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(http.StatusOK, "import-job", job.page())
+}
+
+// CancelImportJob stops an import after the record it is reading.
+func CancelImportJob(c echo.Context) error {
+	job, err := findImportJob(c)
+	if err != nil {
+		return err
+	}
+	job.mu.Lock()
+	if job.state == jobRunning {
+		job.cancelling = true
+	}
+	job.mu.Unlock()
+	job.cancel()
+	return c.Render(http.StatusOK, "import-job", job.page())
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimImport(t *testing.T) {
+	first := &importJob{Id: "upload", state: jobRunning}
+	second := &importJob{Id: "upload", state: jobRunning}
+	expired := &importJob{Id: "old", state: jobDone, finished: time.Now().Add(-2 * IMPORT_JOB_LIFETIME)}
+	importJobs.Lock()
+	importJobs.jobs["old"] = expired
+	importJobs.Unlock()
+	t.Cleanup(func() {
+		importJobs.Lock()
+		delete(importJobs.jobs, "upload")
+		importJobs.Unlock()
+	})
+
+	if claimed := claimImport(first); claimed != first {
+		t.Fatal("the first job did not claim the upload")
+	}
+	if claimed := claimImport(second); claimed != first {
+		t.Error("a second job claimed an upload that is being imported")
+	}
+	importJobs.Lock()
+	_, kept := importJobs.jobs["old"]
+	importJobs.Unlock()
+	if kept {
+		t.Error("an import that finished long ago is still kept")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+const libraryCookie = "library"
+
+type LibrariesPage struct {
+	Header           Header
+	User             *database.User
+	IsAdmin          bool
+	Library          *database.Library
+	Libraries        []database.Library
+	Settings         []database.Setting
+	InstanceSettings []database.Setting
+	Errors           map[string]string
+}
+
+// LibraryMiddleware resolves the library a request works in from the library
+// cookie, falling back to the first library available, so that handlers can
+// scope every query with currentLibrary.
+func LibraryMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isPublicPath(c.Request().URL.Path) {
+			return next(c)
+		}
+		libraries, err := availableLibraries(c)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		if len(libraries) == 0 {
+			return echo.NewHTTPError(http.StatusForbidden, "no library available")
+		}
+
+		library := libraries[0]
+		if cookie, err := c.Cookie(libraryCookie); err == nil {
+			if id, err := strconv.Atoi(cookie.Value); err == nil {
+				for _, l := range libraries {
+					if l.Id == id {
+						library = l
+					}
+				}
+			}
+		}
+
+		c.Set("library", &library)
+		return next(c)
+	}
+}
+
+// libraryFromPath resolves the library of a public catalog service from
+// the library parameter of its path. The services are harvested without a
+// session, so the library cookie cannot choose it. notFound answers for a
+// library that does not exist.
+func libraryFromPath(notFound func(c echo.Context) error) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := strconv.Atoi(c.Param("library"))
+			if err != nil {
+				return notFound(c)
+			}
+			library, err := database.GetLibraryById(id)
+			if errors.Is(err, database.ErrLibraryNotFound) {
+				return notFound(c)
+			}
+			if err != nil {
+				c.Logger().Error(err)
+				return err
+			}
+			c.Set("library", library)
+			return next(c)
+		}
+	}
+}
+
+func libraryNotFound(c echo.Context) error {
+	return echo.NewHTTPError(http.StatusNotFound, "library not found")
+}
+
+func currentLibrary(c echo.Context) *database.Library {
+	return c.Get("library").(*database.Library)
+}
+
+// availableLibraries lists the libraries the current request may switch to.
+// Signed in users only see the libraries they belong to, unless they are an
+// admin.
+func availableLibraries(c echo.Context) ([]database.Library, error) {
+	user := currentUser(c)
+	if user == nil || user.Role == database.RoleAdmin {
+		return database.GetLibraries()
+	}
+	return database.GetUserLibraries(user.Id)
+}
+
+func setLibraryCookie(c echo.Context, libraryId int) {
+	c.SetCookie(&http.Cookie{
+		Name:     libraryCookie,
+		Value:    strconv.Itoa(libraryId),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func GetLibrariesPage(c echo.Context) error {
+	library := currentLibrary(c)
+	libraries, err := availableLibraries(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	settings, err := database.GetSettings(library.Id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	var instanceSettings []database.Setting
+	if hasRole(c, database.RoleAdmin) {
+		instanceSettings, err = database.GetSettings(database.InstanceLibraryId)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+	}
+	return c.Render(http.StatusOK, "libraries", LibrariesPage{
+		Header: Header{
+			Title: "Libraries",
+		},
+		User:             currentUser(c),
+		IsAdmin:          hasRole(c, database.RoleAdmin),
+		Library:          library,
+		Libraries:        libraries,
+		Settings:         settings,
+		InstanceSettings: instanceSettings,
+		Errors:           map[string]string{},
+	})
+}
+
+func GetLibrarySwitcher(c echo.Context) error {
+	libraries, err := availableLibraries(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, "library-switcher", LibrariesPage{
+		User:      currentUser(c),
+		Library:   currentLibrary(c),
+		Libraries: libraries,
+	})
+}
+
+func SwitchLibrary(c echo.Context) error {
+	id, err := strconv.Atoi(c.FormValue("library"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown library")
+	}
+	if !canUseLibrary(c, id) {
+		return echo.NewHTTPError(http.StatusForbidden, "unknown library")
+	}
+
+	setLibraryCookie(c, id)
+	c.Response().Header().Set("HX-Redirect", "/books")
+	return c.NoContent(http.StatusOK)
+}
+
+func CreateLibrary(c echo.Context) error {
+	if !hasRole(c, database.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can create libraries")
+	}
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		errors := make(database.ErrorMap)
+		errors["name"] = "Name Required"
+		libraries, err := availableLibraries(c)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+		return c.Render(http.StatusOK, "library-form", LibrariesPage{
+			Library:   currentLibrary(c),
+			Libraries: libraries,
+			Errors:    errors,
+		})
+	}
+
+	library, err := database.CreateLibrary(name)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if user := currentUser(c); user != nil {
+		err = database.AddLibraryUser(library.Id, user.Id)
+		if err != nil {
+			c.Logger().Error(err)
+			return err
+		}
+	}
+
+	setLibraryCookie(c, library.Id)
+	c.Response().Header().Set("HX-Redirect", "/libraries")
+	return c.NoContent(http.StatusOK)
+}
+
+func SaveLibrarySetting(c echo.Context) error {
+	library := currentLibrary(c)
+	key := strings.TrimSpace(c.FormValue("key"))
+	if key == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "setting name required")
+	}
+	if !hasRole(c, database.RoleAdmin) {
+		return echo.NewHTTPError(http.StatusForbidden, "only admins can change settings")
+	}
+
+	libraryId := library.Id
+	template := "library-settings"
+	if c.FormValue("scope") == "instance" {
+		libraryId = database.InstanceLibraryId
+		template = "instance-settings"
+	}
+
+	err := database.SetSetting(libraryId, key, strings.TrimSpace(c.FormValue("value")))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	settings, err := database.GetSettings(libraryId)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Render(http.StatusOK, template, LibrariesPage{
+		Library:          library,
+		Settings:         settings,
+		InstanceSettings: settings,
+	})
+}
+
+func ExportLibrary(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if !canUseLibrary(c, id) {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown library")
+	}
+
+	export, err := database.ExportLibrary(id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=library-%d.json", id))
+	return c.JSONPretty(http.StatusOK, export, "  ")
+}
+
+func canUseLibrary(c echo.Context, libraryId int) bool {
+	libraries, err := availableLibraries(c)
+	if err != nil {
+		c.Logger().Error(err)
+		return false
+	}
+	for _, l := range libraries {
+		if l.Id == libraryId {
+			return true
+		}
+	}
+	return false
+}
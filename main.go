@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 
 	"mlibrary-htmx/pkg/database"
 
@@ -38,13 +39,28 @@ func main() {
 	if err != nil {
 		log.Fatalf("Shit: %v", err)
 	}
+	// Imports run in the server, so none survive it stopping
+	err = database.InterruptImportBatches()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	e := echo.New()
 	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
+	e.Use(AuthMiddleware)
+	e.Use(LibraryMiddleware)
 	e.Static("/css", "css")
 
 	t := &Template{
-		template: template.Must(template.ParseGlob("views/*.html")),
+		template: template.Must(template.New("views").Funcs(template.FuncMap{
+			"secret": func(key string) bool {
+				return strings.Contains(key, "secret")
+			},
+			"exportFormats": func() []ExportFormat {
+				return exportFormats
+			},
+		}).ParseGlob("views/*.html")),
 	}
 
 	e.Renderer = t
@@ -59,12 +75,77 @@ func main() {
 	e.GET("/books/:id", HandleExistingBook)
 	e.DELETE("/books/:id", HandleDeleteBook)
 	e.GET("/books/show/:id", HandleShowBook)
+	e.GET("/books/show/:id/cover", GetBookCover)
+	e.GET("/books/show/:id/export/:format", ExportBook)
+	e.GET("/books/export/:format", ExportBooks)
+
+	e.GET("/books/:id/versions", GetBookVersions)
+	e.GET("/books/:id/versions/compare", CompareBookVersions)
+	e.POST("/books/:id/versions/:version/revert", RevertBookVersion)
 
 	e.GET("/upload", GetUploadPage)
 
 	e.GET("/download", Download)
 	e.POST("/upload", Upload)
+	e.POST("/upload/csv", ImportCsv)
+	e.POST("/upload/duplicates", ResolveDuplicates)
+	e.GET("/upload/jobs/:id", GetImportJob)
+	e.POST("/upload/jobs/:id/cancel", CancelImportJob)
+	e.POST("/upload/csv/mapping", EditCsvMapping)
+	e.POST("/upload/csv/preview", PreviewCsv)
+	e.GET("/upload/csv/errors", DownloadCsvErrors)
+
+	e.GET("/imports", GetImportBatches)
+	e.GET("/imports/:id", GetImportBatch)
+	e.POST("/imports/:id/undo", UndoImportBatch)
+
+	e.GET("/auth/login", Login)
+	e.GET("/auth/callback", LoginCallback)
+	e.GET("/auth/logout", Logout)
+	e.GET("/auth/signed-out", SignedOut)
+
+	e.GET("/api/openapi.json", GetOpenApiDocument)
+	e.StaticFS("/api/docs", echo.MustSubFS(apiDocs, "apidocs"))
+	e.GET("/api/docs", func(c echo.Context) error {
+		return c.Redirect(http.StatusMovedPermanently, "/api/docs/")
+	})
+
+	api := e.Group("/api/v1")
+	api.GET("/books", ApiListBooks)
+	api.POST("/books", ApiCreateBook)
+	api.GET("/books/:id", ApiGetBook)
+	api.PUT("/books/:id", ApiUpdateBook)
+	api.PATCH("/books/:id", ApiPatchBook)
+	api.DELETE("/books/:id", ApiDeleteBook)
+
+	e.POST(graphqlPath, PostGraphql)
+
+	e.GET(opdsPath, RedirectToOpds)
+	opdsCatalog := e.Group(opdsPath+"/:library", libraryFromPath(libraryNotFound))
+	opdsCatalog.GET("", GetOpdsCatalog)
+	opdsCatalog.GET("/search.xml", GetOpenSearchDescription)
+	opdsCatalog.GET("/recent", GetOpdsRecent)
+	opdsCatalog.GET("/genres", GetOpdsGenres)
+	opdsCatalog.GET("/authors", GetOpdsAuthors)
+	opdsCatalog.GET("/books", GetOpdsBooks)
+	opdsCatalog.GET("/books/:id", GetOpdsBook)
+	opdsCatalog.GET("/books/:id/export/:format", ExportBook)
+
+	oaiLibrary := libraryFromPath(libraryNotFound)
+	e.GET(oaiPath+"/:library", HandleOai, oaiLibrary)
+	e.POST(oaiPath+"/:library", HandleOai, oaiLibrary)
+	e.GET(sruPath+"/:library", HandleSru, libraryFromPath(sruUnknownDatabase))
+
+	e.GET("/libraries", GetLibrariesPage)
+	e.POST("/libraries", CreateLibrary)
+	e.GET("/libraries/switcher", GetLibrarySwitcher)
+	e.POST("/libraries/switch", SwitchLibrary)
+	e.POST("/libraries/settings", SaveLibrarySetting)
+	e.GET("/libraries/:id/export", ExportLibrary)
+	e.GET("/backup", GetBackup)
+	e.POST("/backup/restore", RestoreBackup)
 
 	// e.HTTPErrorHandler = customHTTPErrorHandler
+	e.HTTPErrorHandler = httpErrorHandler(e)
 	e.Logger.Fatal(e.Start(":4444"))
 }
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/marc"
+	"mlibrary-htmx/pkg/metadata"
+	"mlibrary-htmx/pkg/oai"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Each library is a repository of its own at oaiPath/<library id>, which
+// harvesters reach without signing in.
+const oaiPath = "/oai"
+
+const OAI_PAGE_SIZE = 100
+
+// Library setting with the address harvesters can write to. Identify
+// falls back to webmaster at the host name.
+const settingOaiAdminEmail = "oai.admin_email"
+
+// Library setting with the domain name item identifiers are built from, so
+// they stay the same whichever host name harvesters reach the server by.
+// Without it the request's host name is used.
+const settingOaiRepositoryIdentifier = "oai.repository_identifier"
+
+// Books with a genre are in the genre set and in a set of their own genre.
+const oaiGenreSet = "genre"
+
+type oaiFormat struct {
+	oai.MetadataFormat
+	Metadata func(book database.Book, uri string) interface{}
+}
+
+var oaiFormats = []oaiFormat{
+	{
+		MetadataFormat: oai.MetadataFormat{
+			Prefix:    "oai_dc",
+			Schema:    "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+			Namespace: metadata.OaiDcNamespace,
+		},
+		Metadata: func(book database.Book, uri string) interface{} {
+			return metadata.NewDublinCore(book, uri).OaiDc()
+		},
+	},
+	{
+		MetadataFormat: oai.MetadataFormat{
+			Prefix:    "marc21",
+			Schema:    "http://www.loc.gov/standards/marcxml/schema/MARC21slim.xsd",
+			Namespace: marc.XMLNamespace,
+		},
+		Metadata: func(book database.Book, uri string) interface{} {
+			return marc.FromBook(book).XML()
+		},
+	},
+	{
+		MetadataFormat: oai.MetadataFormat{
+			Prefix:    "mods",
+			Schema:    "http://www.loc.gov/standards/mods/v3/mods-3-7.xsd",
+			Namespace: metadata.ModsNamespace,
+		},
+		Metadata: func(book database.Book, uri string) interface{} {
+			return metadata.NewMods(book, uri)
+		},
+	},
+}
+
+// oaiArguments lists the arguments each verb requires and allows. A
+// resumptionToken, where allowed, must be the only argument.
+var oaiArguments = map[string]struct {
+	required []string
+	optional []string
+}{
+	"Identify":            {},
+	"ListMetadataFormats": {optional: []string{"identifier"}},
+	"ListSets":            {optional: []string{"resumptionToken"}},
+	"GetRecord":           {required: []string{"identifier", "metadataPrefix"}},
+	"ListIdentifiers":     {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+	"ListRecords":         {required: []string{"metadataPrefix"}, optional: []string{"from", "until", "set", "resumptionToken"}},
+}
+
+// oaiToken carries a list request to its next page. The list continues
+// after the change to book AfterId at After.
+type oaiToken struct {
+	Verb    string `json:"v"`
+	Prefix  string `json:"m"`
+	From    string `json:"f,omitempty"`
+	Until   string `json:"u,omitempty"`
+	Set     string `json:"s,omitempty"`
+	After   string `json:"a"`
+	AfterId int    `json:"i"`
+	Cursor  int    `json:"c"`
+}
+
+// oaiList is a ListIdentifiers or ListRecords request once its arguments,
+// or those of its resumption token, are read.
+type oaiList struct {
+	token  oaiToken
+	format oaiFormat
+	query  database.ChangeQuery
+}
+
+// HandleOai answers OAI-PMH requests sent with GET or as a POSTed form.
+func HandleOai(c echo.Context) error {
+	library := currentLibrary(c)
+	repository, err := database.GetSetting(library.Id, settingOaiRepositoryIdentifier)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	c.Set("oaiRepository", repository)
+	err = c.Request().ParseForm()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	form := c.Request().Form
+
+	args := map[string]string{}
+	request := oai.Request{URL: oaiBaseURL(c, library)}
+	response := oai.NewResponse(request, time.Now())
+	for key, values := range form {
+		if len(values) > 1 {
+			response.AddError(oai.BadArgument, fmt.Sprintf("%s is repeated", key))
+		}
+		args[key] = values[0]
+	}
+	if len(response.Errors) > 0 {
+		return renderOai(c, response)
+	}
+
+	verb := args["verb"]
+	delete(args, "verb")
+	allowed, ok := oaiArguments[verb]
+	if !ok {
+		message := fmt.Sprintf("%s is not an OAI-PMH verb", verb)
+		if verb == "" {
+			message = "the verb argument is missing"
+		}
+		response.AddError(oai.BadVerb, message)
+		return renderOai(c, response)
+	}
+	response.Request = oai.Request{
+		URL:             request.URL,
+		Verb:            verb,
+		Identifier:      args["identifier"],
+		MetadataPrefix:  args["metadataPrefix"],
+		From:            args["from"],
+		Until:           args["until"],
+		Set:             args["set"],
+		ResumptionToken: args["resumptionToken"],
+	}
+	for key := range args {
+		if !containsString(allowed.required, key) && !containsString(allowed.optional, key) {
+			response.AddError(oai.BadArgument, fmt.Sprintf("%s does not take %s", verb, key))
+		}
+	}
+	if _, ok := args["resumptionToken"]; ok {
+		if len(args) > 1 {
+			response.AddError(oai.BadArgument, "resumptionToken must be the only argument")
+		}
+	} else {
+		for _, key := range allowed.required {
+			if args[key] == "" {
+				response.AddError(oai.BadArgument, fmt.Sprintf("%s requires %s", verb, key))
+			}
+		}
+	}
+	if len(response.Errors) > 0 {
+		return renderOai(c, response)
+	}
+
+	switch verb {
+	case "Identify":
+		err = oaiIdentify(c, library, response)
+	case "ListMetadataFormats":
+		err = oaiListMetadataFormats(c, library, response, args["identifier"])
+	case "ListSets":
+		err = oaiListSets(library, response, args["resumptionToken"])
+	case "GetRecord":
+		err = oaiGetRecord(c, library, response, args["identifier"], args["metadataPrefix"])
+	default:
+		err = oaiListChanges(c, library, response, verb, args)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return renderOai(c, response)
+}
+
+func renderOai(c echo.Context, response *oai.Response) error {
+	b, err := xml.MarshalIndent(response, "", "  ")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Blob(http.StatusOK, "text/xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// oaiBaseURL is the address of the repository of a library.
+func oaiBaseURL(c echo.Context, library *database.Library) string {
+	return fmt.Sprintf("%s%s/%d", baseURL(c), oaiPath, library.Id)
+}
+
+// oaiRepository is the domain name items are identified by: the library's
+// repository identifier setting, or else the host name without a port.
+func oaiRepository(c echo.Context) string {
+	if repository, _ := c.Get("oaiRepository").(string); repository != "" {
+		return repository
+	}
+	host, _, err := net.SplitHostPort(c.Request().Host)
+	if err != nil {
+		return c.Request().Host
+	}
+	return host
+}
+
+func oaiIdentifier(c echo.Context, bookId int) string {
+	return fmt.Sprintf("oai:%s:%d", oaiRepository(c), bookId)
+}
+
+// parseOaiIdentifier returns the book id of an identifier from this
+// repository, or 0.
+func parseOaiIdentifier(c echo.Context, identifier string) int {
+	id, err := strconv.Atoi(strings.TrimPrefix(identifier, fmt.Sprintf("oai:%s:", oaiRepository(c))))
+	if err != nil || id < 1 {
+		return 0
+	}
+	return id
+}
+
+func getOaiFormat(prefix string) (oaiFormat, bool) {
+	for _, format := range oaiFormats {
+		if format.Prefix == prefix {
+			return format, true
+		}
+	}
+	return oaiFormat{}, false
+}
+
+// oaiSetSpec turns a genre into a set spec, which may only hold letters,
+// digits and a few marks.
+func oaiSetSpec(genre string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(genre) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return oaiGenreSet + ":" + sb.String()
+}
+
+func oaiHeader(c echo.Context, change database.BookChange) oai.Header {
+	header := oai.Header{
+		Identifier: oaiIdentifier(c, change.BookId),
+		Datestamp:  oai.Datestamp(change.ChangedAt),
+	}
+	if change.Deleted {
+		header.Status = "deleted"
+	}
+	if spec := oaiSetSpec(change.Genre); spec != "" {
+		header.SetSpecs = []string{oaiGenreSet, spec}
+	}
+	return header
+}
+
+// oaiRecord adds the metadata of a book that has not been deleted. It
+// returns false when the book is gone.
+func oaiRecord(c echo.Context, library *database.Library, change database.BookChange, format oaiFormat) (oai.Record, bool, error) {
+	record := oai.Record{Header: oaiHeader(c, change)}
+	if change.Deleted {
+		return record, true, nil
+	}
+	book, err := database.GetBookById(library.Id, change.BookId)
+	if err != nil {
+		return record, false, err
+	}
+	if book.Id == 0 {
+		return record, false, nil
+	}
+	uri := bookURL(ExportOptions{BaseURL: baseURL(c)}, *book)
+	record.Metadata = &oai.Metadata{Value: format.Metadata(*book, uri)}
+	return record, true, nil
+}
+
+func oaiIdentify(c echo.Context, library *database.Library, response *oai.Response) error {
+	email, err := database.GetSetting(library.Id, settingOaiAdminEmail)
+	if err != nil {
+		return err
+	}
+	if email == "" {
+		email = "webmaster@" + oaiRepository(c)
+	}
+	earliest := library.CreatedDate
+	changes, err := database.ListBookChanges(library.Id, database.ChangeQuery{Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(changes) > 0 && (earliest.IsZero() || changes[0].ChangedAt.Before(earliest)) {
+		earliest = changes[0].ChangedAt
+	}
+
+	response.Identify = &oai.Identify{
+		RepositoryName:    library.Name,
+		BaseURL:           oaiBaseURL(c, library),
+		ProtocolVersion:   oai.ProtocolVersion,
+		AdminEmails:       []string{email},
+		EarliestDatestamp: oai.Datestamp(earliest),
+		DeletedRecord:     "persistent",
+		Granularity:       oai.Granularity,
+		Descriptions: []oai.Description{
+			{Value: oai.NewRepositoryIdentifier(oaiRepository(c), oaiIdentifier(c, 1))},
+		},
+	}
+	return nil
+}
+
+func oaiListMetadataFormats(c echo.Context, library *database.Library, response *oai.Response, identifier string) error {
+	if identifier != "" {
+		changes, err := oaiChangesTo(c, library, identifier)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			response.AddError(oai.IdDoesNotExist, fmt.Sprintf("%s is not in this repository", identifier))
+			return nil
+		}
+	}
+	response.ListMetadataFormats = &oai.ListMetadataFormats{}
+	for _, format := range oaiFormats {
+		response.ListMetadataFormats.Formats = append(response.ListMetadataFormats.Formats, format.MetadataFormat)
+	}
+	return nil
+}
+
+// ListSets answers with every set at once, so it never hands out a
+// resumption token.
+func oaiListSets(library *database.Library, response *oai.Response, resumptionToken string) error {
+	if resumptionToken != "" {
+		response.AddError(oai.BadResumptionToken, "the list of sets is never split")
+		return nil
+	}
+	genres, err := database.ListChangeGenres(library.Id)
+	if err != nil {
+		return err
+	}
+	sets := []oai.Set{{Spec: oaiGenreSet, Name: "Books with a genre"}}
+	seen := map[string]bool{}
+	for _, genre := range genres {
+		spec := oaiSetSpec(genre)
+		if spec == "" || seen[spec] {
+			continue
+		}
+		seen[spec] = true
+		sets = append(sets, oai.Set{Spec: spec, Name: genre})
+	}
+	response.ListSets = &oai.ListSets{Sets: sets}
+	return nil
+}
+
+func oaiChangesTo(c echo.Context, library *database.Library, identifier string) ([]database.BookChange, error) {
+	id := parseOaiIdentifier(c, identifier)
+	if id == 0 {
+		return nil, nil
+	}
+	return database.ListBookChanges(library.Id, database.ChangeQuery{BookId: id, Limit: 1})
+}
+
+func oaiGetRecord(c echo.Context, library *database.Library, response *oai.Response, identifier string, prefix string) error {
+	format, ok := getOaiFormat(prefix)
+	if !ok {
+		response.AddError(oai.CannotDisseminateFormat, fmt.Sprintf("%s is not a metadata format of this repository", prefix))
+		return nil
+	}
+	changes, err := oaiChangesTo(c, library, identifier)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		response.AddError(oai.IdDoesNotExist, fmt.Sprintf("%s is not in this repository", identifier))
+		return nil
+	}
+	record, found, err := oaiRecord(c, library, changes[0], format)
+	if err != nil {
+		return err
+	}
+	if !found {
+		response.AddError(oai.IdDoesNotExist, fmt.Sprintf("%s is not in this repository", identifier))
+		return nil
+	}
+	response.GetRecord = &oai.GetRecord{Record: record}
+	return nil
+}
+
+// oaiListChanges answers ListIdentifiers and ListRecords, which page
+// through the same changes.
+func oaiListChanges(c echo.Context, library *database.Library, response *oai.Response, verb string, args map[string]string) error {
+	var list *oaiList
+	var code, message string
+	if encoded := args["resumptionToken"]; encoded != "" {
+		list, code, message = readOaiToken(verb, encoded)
+	} else {
+		token := oaiToken{Verb: verb, Prefix: args["metadataPrefix"], From: args["from"], Until: args["until"], Set: args["set"]}
+		list, code, message = readOaiList(token)
+	}
+	if code != "" {
+		response.AddError(code, message)
+		return nil
+	}
+	if list.token.Set != "" {
+		err := selectOaiSet(library, list)
+		if err != nil {
+			return err
+		}
+	}
+
+	list.query.Limit = OAI_PAGE_SIZE + 1
+	changes, err := database.ListBookChanges(library.Id, list.query)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		response.AddError(oai.NoRecordsMatch, "no records match the request")
+		return nil
+	}
+
+	var resumptionToken *oai.ResumptionToken
+	if len(changes) > OAI_PAGE_SIZE {
+		changes = changes[:OAI_PAGE_SIZE]
+		last := changes[len(changes)-1]
+		next := list.token
+		next.After = oai.Datestamp(last.ChangedAt)
+		next.AfterId = last.BookId
+		next.Cursor += OAI_PAGE_SIZE
+		resumptionToken = &oai.ResumptionToken{Cursor: list.token.Cursor, Value: encodeOaiToken(next)}
+	} else if list.token.Cursor > 0 {
+		// The last page of a split list ends it with an empty token
+		resumptionToken = &oai.ResumptionToken{Cursor: list.token.Cursor}
+	}
+
+	if verb == "ListIdentifiers" {
+		response.ListIdentifiers = &oai.ListIdentifiers{ResumptionToken: resumptionToken}
+		for _, change := range changes {
+			response.ListIdentifiers.Headers = append(response.ListIdentifiers.Headers, oaiHeader(c, change))
+		}
+		return nil
+	}
+
+	response.ListRecords = &oai.ListRecords{ResumptionToken: resumptionToken}
+	for _, change := range changes {
+		record, found, err := oaiRecord(c, library, change, list.format)
+		if err != nil {
+			return err
+		}
+		if found {
+			response.ListRecords.Records = append(response.ListRecords.Records, record)
+		}
+	}
+	return nil
+}
+
+// readOaiList checks the arguments of a list request, returning an error
+// code and message when they are not acceptable.
+func readOaiList(token oaiToken) (*oaiList, string, string) {
+	list := &oaiList{token: token}
+	format, ok := getOaiFormat(token.Prefix)
+	if !ok {
+		return nil, oai.CannotDisseminateFormat, fmt.Sprintf("%s is not a metadata format of this repository", token.Prefix)
+	}
+	list.format = format
+
+	var fromDay, untilDay bool
+	var err error
+	if token.From != "" {
+		list.query.From, fromDay, err = parseOaiDatestamp(token.From)
+		if err != nil {
+			return nil, oai.BadArgument, fmt.Sprintf("from %q is not a date", token.From)
+		}
+	}
+	if token.Until != "" {
+		list.query.Until, untilDay, err = parseOaiDatestamp(token.Until)
+		if err != nil {
+			return nil, oai.BadArgument, fmt.Sprintf("until %q is not a date", token.Until)
+		}
+		if untilDay {
+			list.query.Until = list.query.Until.Add(24*time.Hour - time.Second)
+		}
+	}
+	if token.From != "" && token.Until != "" {
+		if fromDay != untilDay {
+			return nil, oai.BadArgument, "from and until have different granularities"
+		}
+		if list.query.From.After(list.query.Until) {
+			return nil, oai.BadArgument, "from is later than until"
+		}
+	}
+
+	if token.After != "" {
+		list.query.After, err = time.Parse(oai.TimeLayout, token.After)
+		if err != nil {
+			return nil, oai.BadResumptionToken, "the resumption token is not valid"
+		}
+		list.query.AfterId = token.AfterId
+	}
+	return list, "", ""
+}
+
+// parseOaiDatestamp reads a day or a second in UTC, reporting which.
+func parseOaiDatestamp(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(oai.DayLayout, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(oai.TimeLayout, value)
+	return t, false, err
+}
+
+// selectOaiSet narrows the list to the books of a set. Genres are matched
+// by their spec, so genres that only differ in case or punctuation share a
+// set. The genres of deleted books count too, so that harvesters of a set
+// learn of its deletions.
+func selectOaiSet(library *database.Library, list *oaiList) error {
+	if list.token.Set == oaiGenreSet {
+		list.query.AnyGenre = true
+		return nil
+	}
+	genres, err := database.ListChangeGenres(library.Id)
+	if err != nil {
+		return err
+	}
+	list.query.Genres = []string{}
+	for _, genre := range genres {
+		if oaiSetSpec(genre) == list.token.Set {
+			list.query.Genres = append(list.query.Genres, genre)
+		}
+	}
+	return nil
+}
+
+func encodeOaiToken(token oaiToken) string {
+	b, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func readOaiToken(verb string, encoded string) (*oaiList, string, string) {
+	var token oaiToken
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil {
+		err = json.Unmarshal(b, &token)
+	}
+	if err != nil || token.Verb != verb || token.After == "" {
+		return nil, oai.BadResumptionToken, "the resumption token is not valid"
+	}
+	list, code, _ := readOaiList(token)
+	if code != "" {
+		return nil, oai.BadResumptionToken, "the resumption token is not valid"
+	}
+	return list, "", ""
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/metadata"
+	"mlibrary-htmx/pkg/opds"
+
+	"github.com/labstack/echo/v4"
+)
+
+const OPDS_PAGE_SIZE = 25
+
+// Each library has a catalog of its own at /opds/<library id>, which
+// readers reach without signing in.
+const opdsPath = "/opds"
+
+// opdsHome is the address of the current library's catalog. path leads to
+// a feed within it.
+func opdsHome(c echo.Context, path string) string {
+	return fmt.Sprintf("%s/%d%s", opdsPath, currentLibrary(c).Id, path)
+}
+
+// opdsPage is the 1 based page requested with the page parameter.
+func opdsPage(c echo.Context) int {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// newOpdsFeed starts a feed with the links every page of the catalog has.
+// path and params locate the feed without its page; path is "" at the root.
+func newOpdsFeed(c echo.Context, kind string, title string, path string, params url.Values) *opds.Feed {
+	path = opdsHome(c, path)
+	base := baseURL(c)
+	feed := opds.NewFeed(base+opdsHref(path, params, 0), title, time.Now())
+	feed.Author = &opds.Person{Name: currentLibrary(c).Name, URI: base}
+	feed.Links = []opds.Link{
+		{Rel: "self", Href: opdsHref(path, params, opdsPage(c)), Type: kind},
+		{Rel: "start", Href: opdsHome(c, ""), Type: opds.NavigationType},
+		{Rel: "search", Href: opdsHome(c, "/search.xml"), Type: opds.OpenSearchType},
+	}
+	if path != opdsHome(c, "") {
+		feed.Links = append(feed.Links, opds.Link{Rel: "up", Href: opdsHome(c, ""), Type: opds.NavigationType})
+	}
+	return feed
+}
+
+// addOpdsPageLinks links the neighbouring pages of a paginated feed.
+func addOpdsPageLinks(c echo.Context, feed *opds.Feed, kind string, path string, params url.Values, more bool) {
+	path = opdsHome(c, path)
+	page := opdsPage(c)
+	feed.ItemsPerPage = OPDS_PAGE_SIZE
+	feed.StartIndex = (page-1)*OPDS_PAGE_SIZE + 1
+	if page > 1 {
+		feed.Links = append(feed.Links,
+			opds.Link{Rel: "first", Href: opdsHref(path, params, 1), Type: kind},
+			opds.Link{Rel: "previous", Href: opdsHref(path, params, page-1), Type: kind},
+		)
+	}
+	if more {
+		feed.Links = append(feed.Links, opds.Link{Rel: "next", Href: opdsHref(path, params, page+1), Type: kind})
+	}
+}
+
+// opdsHref adds params and, past the first page, the page number to path.
+func opdsHref(path string, params url.Values, page int) string {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+func renderOpds(c echo.Context, contentType string, document interface{}) error {
+	b, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Blob(http.StatusOK, contentType, append([]byte(xml.Header), b...))
+}
+
+// opdsBookEntry describes a book with links to the formats it can be
+// downloaded in. The downloads are part of the catalog, so they need no
+// session either.
+func opdsBookEntry(c echo.Context, book database.Book) opds.Entry {
+	bookPath := fmt.Sprintf("/books/show/%d", book.Id)
+	entry := opds.Entry{
+		Id:          baseURL(c) + bookPath,
+		Title:       book.Title,
+		Updated:     book.UpdatedDate.UTC(),
+		Publisher:   book.Publisher,
+		Identifiers: metadata.Identifiers(book),
+	}
+	if book.AuthorLast != "" {
+		entry.Authors = []opds.Person{{Name: metadata.CreatorName(book)}}
+	}
+	if !book.CopyrightDate.IsZero() {
+		entry.Issued = book.CopyrightDate.String()
+	}
+	if book.Genre != "" {
+		entry.Categories = []opds.Category{{Term: book.Genre, Label: book.Genre}}
+	}
+
+	var summary []string
+	if book.Pages != "" {
+		summary = append(summary, metadata.Extent(book))
+	}
+	if book.Location != "" {
+		summary = append(summary, "Published in "+book.Location)
+	}
+	if len(summary) > 0 {
+		entry.Content = &opds.Content{Type: "text", Value: strings.Join(summary, ". ")}
+	}
+
+	entry.Links = []opds.Link{
+		{Rel: "alternate", Href: opdsHome(c, fmt.Sprintf("/books/%d", book.Id)), Type: opds.EntryType},
+		{Rel: "alternate", Href: bookPath, Type: "text/html"},
+	}
+	for _, format := range exportFormats {
+		contentType, _, _ := strings.Cut(format.ContentType, ";")
+		entry.Links = append(entry.Links, opds.Link{
+			Rel:   opds.RelAcquisition,
+			Href:  opdsHome(c, fmt.Sprintf("/books/%d/export/%s", book.Id, format.Name)),
+			Type:  contentType,
+			Title: format.Label,
+		})
+	}
+	return entry
+}
+
+// navigationEntry is an entry of a navigation feed that leads to href, a
+// path within the catalog.
+func navigationEntry(c echo.Context, title string, content string, rel string, href string, kind string) opds.Entry {
+	href = opdsHome(c, href)
+	entry := opds.Entry{
+		Id:      baseURL(c) + href,
+		Title:   title,
+		Updated: time.Now().UTC().Truncate(time.Second),
+		Links:   []opds.Link{{Rel: rel, Href: href, Type: kind}},
+	}
+	if content != "" {
+		entry.Content = &opds.Content{Type: "text", Value: content}
+	}
+	return entry
+}
+
+func bookCount(n int) string {
+	if n == 1 {
+		return "1 book"
+	}
+	return fmt.Sprintf("%d books", n)
+}
+
+// GetOpdsCatalog serves the root of the catalog.
+func GetOpdsCatalog(c echo.Context) error {
+	library := currentLibrary(c)
+	feed := newOpdsFeed(c, opds.NavigationType, library.Name, "", nil)
+	feed.Links = append(feed.Links, opds.Link{Rel: opds.RelSortNew, Href: opdsHome(c, "/recent"), Type: opds.AcquisitionType, Title: "Recently added"})
+	feed.Entries = []opds.Entry{
+		navigationEntry(c, "Recently added", "The newest books in the library", opds.RelSortNew, "/recent", opds.AcquisitionType),
+		navigationEntry(c, "By genre", "Books grouped by genre", opds.RelSubsection, "/genres", opds.NavigationType),
+		navigationEntry(c, "By author", "Books grouped by author", opds.RelSubsection, "/authors", opds.NavigationType),
+		navigationEntry(c, "All books", "Every book by title", opds.RelSubsection, "/books", opds.AcquisitionType),
+	}
+	return renderOpds(c, opds.NavigationType, feed)
+}
+
+func GetOpdsGenres(c echo.Context) error {
+	library := currentLibrary(c)
+	page := opdsPage(c)
+	genres, err := database.ListGenres(library.Id, (page-1)*OPDS_PAGE_SIZE, OPDS_PAGE_SIZE+1)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	more := len(genres) > OPDS_PAGE_SIZE
+	if more {
+		genres = genres[:OPDS_PAGE_SIZE]
+	}
+
+	feed := newOpdsFeed(c, opds.NavigationType, "By genre", "/genres", nil)
+	addOpdsPageLinks(c, feed, opds.NavigationType, "/genres", nil, more)
+	for _, genre := range genres {
+		href := opdsHref("/books", url.Values{"genre": {genre.Genre}}, 0)
+		entry := navigationEntry(c, genre.Genre, bookCount(genre.Books), opds.RelSubsection, href, opds.AcquisitionType)
+		entry.Links[0].Count = genre.Books
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return renderOpds(c, opds.NavigationType, feed)
+}
+
+func GetOpdsAuthors(c echo.Context) error {
+	library := currentLibrary(c)
+	page := opdsPage(c)
+	authors, err := database.ListAuthors(library.Id, (page-1)*OPDS_PAGE_SIZE, OPDS_PAGE_SIZE+1)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	more := len(authors) > OPDS_PAGE_SIZE
+	if more {
+		authors = authors[:OPDS_PAGE_SIZE]
+	}
+
+	feed := newOpdsFeed(c, opds.NavigationType, "By author", "/authors", nil)
+	addOpdsPageLinks(c, feed, opds.NavigationType, "/authors", nil, more)
+	for _, author := range authors {
+		name := metadata.CreatorName(database.Book{AuthorLast: author.AuthorLast, AuthorFirst: author.AuthorFirst})
+		href := opdsHref("/books", url.Values{"author_last": {author.AuthorLast}, "author_first": {author.AuthorFirst}}, 0)
+		entry := navigationEntry(c, name, bookCount(author.Books), opds.RelSubsection, href, opds.AcquisitionType)
+		entry.Links[0].Count = author.Books
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return renderOpds(c, opds.NavigationType, feed)
+}
+
+// GetOpdsBooks serves books by title, narrowed by the genre and author
+// parameters the navigation feeds link to and the q of a search.
+func GetOpdsBooks(c echo.Context) error {
+	query := database.BookQuery{
+		Search:      c.QueryParam("q"),
+		Genre:       c.QueryParam("genre"),
+		AuthorLast:  c.QueryParam("author_last"),
+		AuthorFirst: c.QueryParam("author_first"),
+		Sort:        "title",
+	}
+	params := url.Values{}
+	var titles []string
+	if query.Genre != "" {
+		params.Set("genre", query.Genre)
+		titles = append(titles, query.Genre)
+	}
+	if query.AuthorLast != "" {
+		params.Set("author_last", query.AuthorLast)
+		params.Set("author_first", query.AuthorFirst)
+		titles = append(titles, metadata.CreatorName(database.Book{AuthorLast: query.AuthorLast, AuthorFirst: query.AuthorFirst}))
+	}
+	if query.Search != "" {
+		params.Set("q", query.Search)
+		titles = append(titles, "Search results for "+query.Search)
+	}
+	title := strings.Join(titles, ", ")
+	if title == "" {
+		title = "All books"
+	}
+	return opdsAcquisitionFeed(c, query, title, "/books", params)
+}
+
+// GetOpdsRecent serves the newest books first.
+func GetOpdsRecent(c echo.Context) error {
+	query := database.BookQuery{Sort: "created_at", Desc: true}
+	return opdsAcquisitionFeed(c, query, "Recently added", "/recent", nil)
+}
+
+func opdsAcquisitionFeed(c echo.Context, query database.BookQuery, title string, path string, params url.Values) error {
+	library := currentLibrary(c)
+	query.Offset = (opdsPage(c) - 1) * OPDS_PAGE_SIZE
+	query.Limit = OPDS_PAGE_SIZE
+	page, err := database.ListBooks(library.Id, query)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+
+	feed := newOpdsFeed(c, opds.AcquisitionType, title, path, params)
+	addOpdsPageLinks(c, feed, opds.AcquisitionType, path, params, page.NextCursor != "")
+	for _, book := range page.Books {
+		feed.Entries = append(feed.Entries, opdsBookEntry(c, book))
+	}
+	return renderOpds(c, opds.AcquisitionType, feed)
+}
+
+// GetOpdsBook serves the complete entry of one book.
+func GetOpdsBook(c echo.Context) error {
+	library := currentLibrary(c)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+	book, err := database.GetBookById(library.Id, id)
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	if book.Id == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "book not found")
+	}
+	return renderOpds(c, opds.EntryType, opdsBookEntry(c, *book).Standalone())
+}
+
+// GetOpenSearchDescription tells OPDS clients how to search the catalog.
+func GetOpenSearchDescription(c echo.Context) error {
+	name := currentLibrary(c).Name
+	// ShortName may be at most 16 characters
+	shortName := []rune(name)
+	if len(shortName) > 16 {
+		shortName = shortName[:16]
+	}
+	description := opds.NewOpenSearchDescription(string(shortName), "Search the books of "+name, baseURL(c)+opdsHome(c, "/books?q={searchTerms}&page={startPage?}"))
+	return renderOpds(c, opds.OpenSearchType, description)
+}
+
+// RedirectToOpds sends a signed in reader to the catalog of the library
+// they work in, whose address does not need the library cookie.
+func RedirectToOpds(c echo.Context) error {
+	return c.Redirect(http.StatusFound, opdsHome(c, ""))
+}
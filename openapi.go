@@ -0,0 +1,222 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"mlibrary-htmx/pkg/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed apidocs
+var apiDocs embed.FS
+
+type object = map[string]interface{}
+
+// requiredBookFields mirrors the checks in Book.Validate and
+// Book.SetCopyrightDate.
+var requiredBookFields = []string{"title", "author_last", "copyright_date"}
+
+var openApiDocument = sync.OnceValue(buildOpenApiDocument)
+
+func GetOpenApiDocument(c echo.Context) error {
+	return c.JSONPretty(http.StatusOK, openApiDocument(), "  ")
+}
+
+// schemaFor describes a struct from its json tags so the documented schema
+// always matches what the API encodes. A format tag refines string fields.
+func schemaFor(t reflect.Type) object {
+	properties := object{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := object{}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int64:
+			property["type"] = "integer"
+		case reflect.Bool:
+			property["type"] = "boolean"
+		default:
+			property["type"] = "string"
+		}
+		if format := field.Tag.Get("format"); format != "" {
+			property["format"] = format
+		}
+		if readOnlyBookFields[name] {
+			property["readOnly"] = true
+		}
+		properties[name] = property
+	}
+	return object{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+func buildOpenApiDocument() object {
+	book := schemaFor(reflect.TypeOf(database.Book{}))
+	book["required"] = requiredBookFields
+
+	var sorts []string
+	for _, column := range database.SortableColumns {
+		sorts = append(sorts, column, "-"+column)
+	}
+
+	ref := func(name string) object {
+		return object{"$ref": "#/components/schemas/" + name}
+	}
+	jsonContent := func(schema object) object {
+		return object{"application/json": object{"schema": schema}}
+	}
+	errorResponse := func(description string) object {
+		return object{"description": description, "content": jsonContent(ref("Error"))}
+	}
+	bookResponse := func(description string) object {
+		return object{
+			"description": description,
+			"headers": object{
+				"ETag": object{"description": "Version of the book", "schema": object{"type": "string"}},
+			},
+			"content": jsonContent(ref("Book")),
+		}
+	}
+	idParameter := object{"name": "id", "in": "path", "required": true, "schema": object{"type": "integer"}}
+	ifMatchParameter := object{
+		"name":        "If-Match",
+		"in":          "header",
+		"description": "Version being replaced, as returned in the ETag header",
+		"schema":      object{"type": "string"},
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "mlibrary Books API",
+			"version":     "1.0.0",
+			"description": "Books in the library selected by the library cookie. Writes are checked against the book version to catch concurrent edits.",
+		},
+		"servers": []object{{"url": "/api/v1"}},
+		"paths": object{
+			"/books": object{
+				"get": object{
+					"operationId": "listBooks",
+					"summary":     "List books",
+					"parameters": []object{
+						{"name": "q", "in": "query", "description": "Text to search for in any field", "schema": object{"type": "string"}},
+						{"name": "genre", "in": "query", "description": "Only books in this genre", "schema": object{"type": "string"}},
+						{"name": "author_last", "in": "query", "description": "Only books by authors with this last name", "schema": object{"type": "string"}},
+						{"name": "author_first", "in": "query", "description": "With author_last, only books by the author with this first name", "schema": object{"type": "string"}},
+						{"name": "sort", "in": "query", "description": "Field to sort by, prefixed with - for descending order", "schema": object{"type": "string", "enum": sorts}},
+						{"name": "cursor", "in": "query", "description": "next_cursor from the previous page", "schema": object{"type": "string"}},
+						{"name": "limit", "in": "query", "schema": object{"type": "integer", "minimum": 1, "maximum": database.MAX_BOOK_QUERY_LIMIT, "default": 25}},
+					},
+					"responses": object{
+						"200": object{"description": "A page of books", "content": jsonContent(ref("BookList"))},
+						"400": errorResponse("Invalid sort, cursor or limit"),
+					},
+				},
+				"post": object{
+					"operationId": "createBook",
+					"summary":     "Create a book",
+					"requestBody": object{"required": true, "content": jsonContent(ref("Book"))},
+					"responses": object{
+						"201": bookResponse("The created book"),
+						"400": errorResponse("Malformed body"),
+						"422": errorResponse("Validation failed"),
+					},
+				},
+			},
+			"/books/{id}": object{
+				"parameters": []object{idParameter},
+				"get": object{
+					"operationId": "getBook",
+					"summary":     "Get a book",
+					"responses": object{
+						"200": bookResponse("The book"),
+						"404": errorResponse("No such book"),
+					},
+				},
+				"put": object{
+					"operationId": "updateBook",
+					"summary":     "Replace a book",
+					"description": "Send the version being replaced in the body or an If-Match header.",
+					"parameters":  []object{ifMatchParameter},
+					"requestBody": object{"required": true, "content": jsonContent(ref("Book"))},
+					"responses": object{
+						"200": bookResponse("The updated book"),
+						"404": errorResponse("No such book"),
+						"409": errorResponse("The book was changed by someone else"),
+						"422": errorResponse("Validation failed"),
+						"428": errorResponse("No version was sent"),
+					},
+				},
+				"patch": object{
+					"operationId": "patchBook",
+					"summary":     "Update some fields of a book",
+					"description": "A JSON merge patch. Only the fields present change.",
+					"parameters":  []object{ifMatchParameter},
+					"requestBody": object{"required": true, "content": object{
+						"application/merge-patch+json": object{"schema": ref("Book")},
+						"application/json":             object{"schema": ref("Book")},
+					}},
+					"responses": object{
+						"200": bookResponse("The updated book"),
+						"404": errorResponse("No such book"),
+						"409": errorResponse("The book was changed by someone else"),
+						"422": errorResponse("Validation failed"),
+					},
+				},
+				"delete": object{
+					"operationId": "deleteBook",
+					"summary":     "Delete a book",
+					"parameters":  []object{ifMatchParameter},
+					"responses": object{
+						"204": object{"description": "Deleted"},
+						"404": errorResponse("No such book"),
+						"409": errorResponse("The book was changed by someone else"),
+					},
+				},
+			},
+		},
+		"components": object{
+			"schemas": object{
+				"Book": book,
+				"BookList": object{
+					"type": "object",
+					"properties": object{
+						"data":        object{"type": "array", "items": ref("Book")},
+						"next_cursor": object{"type": "string", "description": "Absent on the last page"},
+					},
+				},
+				"Error": object{
+					"type": "object",
+					"properties": object{
+						"error": object{
+							"type": "object",
+							"properties": object{
+								"status":  object{"type": "integer"},
+								"message": object{"type": "string"},
+								"fields": object{
+									"type":                 "object",
+									"description":          "Validation message per book field",
+									"additionalProperties": object{"type": "string"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
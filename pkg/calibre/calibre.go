@@ -0,0 +1,275 @@
+// Package calibre reads the books of a Calibre library from its metadata.db
+// database.
+package calibre
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+	"mlibrary-htmx/pkg/importer"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ErrNotCalibre is returned for a database that is not a Calibre library.
+var ErrNotCalibre = errors.New("not a Calibre metadata.db")
+
+const BOOKS_QUERY = `SELECT id, title, pubdate, path, has_cover FROM books ORDER BY id`
+
+const AUTHORS_QUERY = `SELECT authors.name, authors.sort FROM books_authors_link
+JOIN authors ON authors.id = books_authors_link.author
+WHERE books_authors_link.book = ?
+ORDER BY books_authors_link.id`
+
+const PUBLISHERS_QUERY = `SELECT publishers.name FROM books_publishers_link
+JOIN publishers ON publishers.id = books_publishers_link.publisher
+WHERE books_publishers_link.book = ?
+ORDER BY books_publishers_link.id`
+
+const TAGS_QUERY = `SELECT tags.name FROM books_tags_link
+JOIN tags ON tags.id = books_tags_link.tag
+WHERE books_tags_link.book = ?
+ORDER BY books_tags_link.id`
+
+const SERIES_QUERY = `SELECT series.name FROM books_series_link
+JOIN series ON series.id = books_series_link.series
+WHERE books_series_link.book = ?`
+
+const IDENTIFIERS_QUERY = `SELECT type, val FROM identifiers WHERE book = ?`
+
+// Entry is a book of the library, with anything that could not be carried
+// over described in Warnings. Id is the book's id in Calibre and Cover the
+// path of its cover image, if it has one on disk. Ok is false when the
+// entry lacks the title or author every book needs.
+type Entry struct {
+	Id       string
+	Book     database.Book
+	Cover    string
+	Warnings []string
+	Ok       bool
+}
+
+// Reader reads the books of a library one at a time.
+type Reader struct {
+	db   *sql.DB
+	rows *sql.Rows
+	dir  string
+}
+
+// Open reads the metadata.db at path, or in the library directory path
+// names. Covers are looked for in the book directories next to it.
+func Open(path string) (*Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, "metadata.db")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	}
+	rows, err := db.Query(BOOKS_QUERY)
+	if err != nil {
+		db.Close()
+		return nil, ErrNotCalibre
+	}
+	return &Reader{db: db, rows: rows, dir: filepath.Dir(path)}, nil
+}
+
+func (r *Reader) Close() error {
+	r.rows.Close()
+	return r.db.Close()
+}
+
+// Next returns the next book, or io.EOF after the last one.
+func (r *Reader) Next() (*Entry, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read books: %v", err)
+		}
+		return nil, io.EOF
+	}
+	var id int
+	var title, path string
+	var pubdate interface{}
+	var hasCover bool
+	err := r.rows.Scan(&id, &title, &pubdate, &path, &hasCover)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read books: %v", err)
+	}
+
+	e := &Entry{Id: fmt.Sprint(id)}
+	e.Book.Title = strings.TrimSpace(title)
+	err = r.authors(e, id)
+	if err == nil {
+		err = r.details(e, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.setDate(pubdate)
+	if hasCover {
+		cover := filepath.Join(r.dir, filepath.FromSlash(path), "cover.jpg")
+		if _, err := os.Stat(cover); err == nil {
+			e.Cover = cover
+		}
+	}
+
+	if e.Book.Title == "" {
+		e.warn("no title, skipped")
+	}
+	if e.Book.AuthorLast == "" {
+		e.warn("no author, skipped")
+	}
+	e.Book.Id = -1
+	e.Ok = e.Book.Title != "" && e.Book.AuthorLast != ""
+	return e, nil
+}
+
+func (e *Entry) warn(format string, args ...interface{}) {
+	e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+}
+
+// authors keeps the first author, split by the sort name Calibre keeps as
+// "Last, First".
+func (r *Reader) authors(e *Entry, id int) error {
+	rows, err := r.db.Query(AUTHORS_QUERY, id)
+	if err != nil {
+		return fmt.Errorf("unable to read authors: %v", err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var name, sort string
+		err = rows.Scan(&name, &sort)
+		if err != nil {
+			return fmt.Errorf("unable to read authors: %v", err)
+		}
+		count++
+		if count > 1 {
+			continue
+		}
+		if last, first, found := strings.Cut(sort, ","); found {
+			e.Book.AuthorLast, e.Book.AuthorFirst = strings.TrimSpace(last), strings.TrimSpace(first)
+		} else if i := strings.LastIndex(name, " "); i > 0 {
+			e.Book.AuthorLast, e.Book.AuthorFirst = name[i+1:], strings.TrimSpace(name[:i])
+		} else {
+			e.Book.AuthorLast = strings.TrimSpace(name)
+		}
+	}
+	if count > 1 {
+		e.warn("only one author is kept, %d more dropped", count-1)
+	}
+	return rows.Err()
+}
+
+// details reads the publisher, identifiers, tags and series of a book.
+// Books have no series, so it is reported as dropped.
+func (r *Reader) details(e *Entry, id int) error {
+	publishers, err := r.names(PUBLISHERS_QUERY, id)
+	if err != nil {
+		return err
+	}
+	if len(publishers) > 0 {
+		e.Book.Publisher = publishers[0]
+	}
+
+	tags, err := r.names(TAGS_QUERY, id)
+	if err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		e.Book.Genre = tags[0]
+		if len(tags) > 1 {
+			e.warn("only the first tag is kept as the genre, %d more dropped", len(tags)-1)
+		}
+	}
+
+	series, err := r.names(SERIES_QUERY, id)
+	if err != nil {
+		return err
+	}
+	for _, name := range series {
+		e.warn("series %q is not kept", name)
+	}
+
+	rows, err := r.db.Query(IDENTIFIERS_QUERY, id)
+	if err != nil {
+		return fmt.Errorf("unable to read identifiers: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, value string
+		err = rows.Scan(&kind, &value)
+		if err != nil {
+			return fmt.Errorf("unable to read identifiers: %v", err)
+		}
+		switch strings.ToLower(kind) {
+		case "isbn":
+			e.Book.Isbn = strings.NewReplacer("-", "", " ", "").Replace(value)
+			if err := importer.CheckIsbn(e.Book.Isbn); err != nil {
+				e.warn("%v", err)
+			}
+		case "lccn":
+			e.Book.Lccn = strings.TrimSpace(value)
+		}
+	}
+	return rows.Err()
+}
+
+func (r *Reader) names(query string, id int) ([]string, error) {
+	rows, err := r.db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read book details: %v", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read book details: %v", err)
+		}
+		names = append(names, strings.TrimSpace(name))
+	}
+	return names, rows.Err()
+}
+
+// undefinedYear is the year Calibre writes for an unknown date.
+const undefinedYear = 101
+
+func (e *Entry) setDate(value interface{}) {
+	var date time.Time
+	switch v := value.(type) {
+	case time.Time:
+		date = v
+	case string:
+		if v == "" {
+			return
+		}
+		day, _, _ := strings.Cut(v, " ")
+		parsed, err := time.Parse("2006-01-02", strings.SplitN(day, "T", 2)[0])
+		if err != nil {
+			e.warn("date %q is not understood", v)
+			return
+		}
+		date = parsed
+	default:
+		return
+	}
+	if date.Year() <= undefinedYear {
+		return
+	}
+	e.Book.CopyrightDate = dates.Day(date)
+	e.Book.CopyrightDateString = e.Book.CopyrightDate.String()
+}
@@ -0,0 +1,140 @@
+package calibre
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// CALIBRE_SCHEMA is the part of a Calibre metadata.db the reader uses.
+const CALIBRE_SCHEMA = `CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT NOT NULL DEFAULT 'Unknown', sort TEXT, pubdate TIMESTAMP DEFAULT CURRENT_TIMESTAMP, path TEXT NOT NULL DEFAULT '', has_cover BOOL DEFAULT 0);
+CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL, sort TEXT);
+CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, author INTEGER NOT NULL);
+CREATE TABLE publishers (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE books_publishers_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, publisher INTEGER NOT NULL);
+CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, tag INTEGER NOT NULL);
+CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, series INTEGER NOT NULL);
+CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, type TEXT NOT NULL, val TEXT NOT NULL);`
+
+const CALIBRE_BOOKS = `INSERT INTO books (id, title, pubdate, path, has_cover) VALUES
+ (1, 'The Fellowship of the Ring', '1954-07-29 00:00:00+00:00', 'J. R. R. Tolkien/The Fellowship of the Ring (1)', 1),
+ (2, 'Good Omens', '0101-01-01 00:00:00+00:00', 'Terry Pratchett/Good Omens (2)', 1),
+ (3, 'Untitled', '2001-01-01 00:00:00+00:00', 'Unknown/Untitled (3)', 0);
+INSERT INTO authors (id, name, sort) VALUES
+ (1, 'J. R. R. Tolkien', 'Tolkien, J. R. R.'),
+ (2, 'Terry Pratchett', 'Pratchett, Terry'),
+ (3, 'Neil Gaiman', 'Gaiman, Neil');
+INSERT INTO books_authors_link (book, author) VALUES (1, 1), (2, 2), (2, 3);
+INSERT INTO publishers (id, name) VALUES (1, 'Allen & Unwin');
+INSERT INTO books_publishers_link (book, publisher) VALUES (1, 1);
+INSERT INTO tags (id, name) VALUES (1, 'Fantasy'), (2, 'Classics');
+INSERT INTO books_tags_link (book, tag) VALUES (1, 1), (1, 2);
+INSERT INTO series (id, name) VALUES (1, 'The Lord of the Rings');
+INSERT INTO books_series_link (book, series) VALUES (1, 1);
+INSERT INTO identifiers (book, type, val) VALUES
+ (1, 'isbn', '978-0-261-10235-4'), (1, 'lccn', '54007621'), (1, 'goodreads', '34');`
+
+// writeLibrary creates a Calibre library directory with a cover for the
+// first book only, even though the second claims one too.
+func writeLibrary(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range []string{CALIBRE_SCHEMA, CALIBRE_BOOKS} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bookDir := filepath.Join(dir, "J. R. R. Tolkien", "The Fellowship of the Ring (1)")
+	if err := os.MkdirAll(bookDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bookDir, "cover.jpg"), []byte("jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReader(t *testing.T) {
+	dir := writeLibrary(t)
+	reader, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var entries []*Entry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	fellowship := entries[0]
+	book := fellowship.Book
+	if !fellowship.Ok || fellowship.Id != "1" || book.Id != -1 {
+		t.Errorf("first entry: ok %v, id %q, book id %d", fellowship.Ok, fellowship.Id, book.Id)
+	}
+	if book.Title != "The Fellowship of the Ring" || book.AuthorLast != "Tolkien" || book.AuthorFirst != "J. R. R." {
+		t.Errorf("title %q, author %q, %q", book.Title, book.AuthorLast, book.AuthorFirst)
+	}
+	if book.Publisher != "Allen & Unwin" || book.Isbn != "9780261102354" || book.Lccn != "54007621" || book.Genre != "Fantasy" {
+		t.Errorf("publisher %q, isbn %q, lccn %q, genre %q", book.Publisher, book.Isbn, book.Lccn, book.Genre)
+	}
+	if book.CopyrightDateString != "1954-07-29" {
+		t.Errorf("date = %q, want 1954-07-29", book.CopyrightDateString)
+	}
+	// the second tag and the series
+	if len(fellowship.Warnings) != 2 {
+		t.Errorf("warnings = %v, want 2", fellowship.Warnings)
+	}
+	if want := filepath.Join(dir, "J. R. R. Tolkien", "The Fellowship of the Ring (1)", "cover.jpg"); fellowship.Cover != want {
+		t.Errorf("cover = %q, want %q", fellowship.Cover, want)
+	}
+
+	// Calibre writes year 101 for an unknown date
+	omens := entries[1]
+	if !omens.Ok || omens.Book.AuthorLast != "Pratchett" || omens.Book.CopyrightDateString != "" || omens.Cover != "" {
+		t.Errorf("second entry = %+v", omens)
+	}
+	if len(omens.Warnings) != 1 {
+		t.Errorf("second entry warnings = %v, want the dropped author", omens.Warnings)
+	}
+
+	if untitled := entries[2]; untitled.Ok {
+		t.Errorf("entry without an author is ok")
+	}
+}
+
+func TestOpenNotCalibre(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "other.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE master_books (id INTEGER PRIMARY KEY)")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrNotCalibre) {
+		t.Errorf("got %v, want ErrNotCalibre", err)
+	}
+}
@@ -0,0 +1,269 @@
+// Package catalogs reads the exports of other book catalogs, Goodreads,
+// LibraryThing and Libib, into books.
+package catalogs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/importer"
+)
+
+// The catalogs whose exports can be read.
+const (
+	Goodreads    = "goodreads"
+	LibraryThing = "librarything"
+	Libib        = "libib"
+)
+
+// Entry is a book read from an export, with anything that could not be
+// carried over described in Warnings. Id is the book's id in the other
+// catalog. Ok is false when the entry lacks the title or author every book
+// needs.
+type Entry struct {
+	Id       string
+	Book     database.Book
+	Warnings []string
+	Ok       bool
+}
+
+// Reader reads the books of an export one at a time.
+type Reader interface {
+	// Next returns the next book, or io.EOF after the last one
+	Next() (*Entry, error)
+}
+
+// NewReader reads an export of the named catalog.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case Goodreads:
+		return NewGoodreadsReader(r)
+	case LibraryThing:
+		return NewLibraryThingReader(r)
+	case Libib:
+		return NewLibibReader(r)
+	}
+	return nil, fmt.Errorf("%q is not a catalog that can be imported", format)
+}
+
+// Detect names the catalog a CSV file was exported from by its header, or
+// returns "" for any other CSV file.
+func Detect(r io.Reader) string {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return ""
+	}
+	columns := columnIndexes(header)
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has("book id", "exclusive shelf"):
+		return Goodreads
+	case has("item_type", "ean_isbn13"):
+		return Libib
+	case has("book id", "primary author"):
+		return LibraryThing
+	}
+	return ""
+}
+
+func (e *Entry) warn(format string, args ...interface{}) {
+	e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+}
+
+// setAuthors keeps the first author, written "Last, First" when lastFirst
+// is set or "First Last" otherwise.
+func (e *Entry) setAuthors(names []string, lastFirst bool) {
+	var authors []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) == 0 {
+		return
+	}
+	name := authors[0]
+	if last, first, found := strings.Cut(name, ","); found && lastFirst {
+		e.Book.AuthorLast, e.Book.AuthorFirst = strings.TrimSpace(last), strings.TrimSpace(first)
+	} else if i := strings.LastIndex(name, " "); i > 0 {
+		e.Book.AuthorLast, e.Book.AuthorFirst = name[i+1:], strings.TrimSpace(name[:i])
+	} else {
+		e.Book.AuthorLast = name
+	}
+	if len(authors) > 1 {
+		e.warn("only one author is kept, %d more dropped", len(authors)-1)
+	}
+}
+
+// setIsbn keeps the first of the ISBNs given. Exports wrap them in quotes,
+// brackets or an ="..." formula to keep spreadsheets from reading them as
+// numbers.
+func (e *Entry) setIsbn(values ...string) {
+	clean := strings.NewReplacer("=", "", `"`, "", "[", "", "]", "", "-", "", " ", "")
+	for _, value := range values {
+		isbn := clean.Replace(value)
+		if isbn == "" {
+			continue
+		}
+		if err := importer.CheckIsbn(isbn); err != nil {
+			e.warn("%v", err)
+		}
+		e.Book.Isbn = isbn
+		return
+	}
+}
+
+func (e *Entry) setDate(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	date, err := importer.ParseDate(value)
+	if err != nil {
+		e.warn("date %q is not understood", value)
+		return
+	}
+	e.Book.CopyrightDate = date
+	e.Book.CopyrightDateString = date.String()
+}
+
+func (e *Entry) setPages(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if strings.Trim(value, "0123456789") != "" {
+		e.warn("pages %q is not a page count", value)
+		return
+	}
+	e.Book.Pages = value
+}
+
+// setGenre keeps the first of the shelves or tags a book was filed under.
+func (e *Entry) setGenre(tags []string) {
+	var kept []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			kept = append(kept, tag)
+		}
+	}
+	if len(kept) == 0 {
+		return
+	}
+	e.Book.Genre = kept[0]
+	if len(kept) > 1 {
+		e.warn("only the first tag is kept as the genre, %d more dropped", len(kept)-1)
+	}
+}
+
+// finish sets the outcome every importer reports the same way.
+func (e *Entry) finish() {
+	if e.Book.Title == "" {
+		e.Warnings = append(e.Warnings, "no title, skipped")
+	}
+	if e.Book.AuthorLast == "" {
+		e.Warnings = append(e.Warnings, "no author, skipped")
+	}
+	e.Book.Id = -1
+	e.Ok = e.Book.Title != "" && e.Book.AuthorLast != ""
+}
+
+func splitList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// table reads the rows of a delimited export by the names in its header.
+type table struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newTable(r io.Reader, comma rune) (*table, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &table{reader: reader, columns: columnIndexes(header)}, nil
+}
+
+func columnIndexes(header []string) map[string]int {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+	return columns
+}
+
+// row is a row of a table.
+type row struct {
+	cells   []string
+	columns map[string]int
+}
+
+// next returns the next row, or io.EOF after the last one.
+func (t *table) next() (row, error) {
+	cells, err := t.reader.Read()
+	if err != nil {
+		return row{}, err
+	}
+	return row{cells: cells, columns: t.columns}, nil
+}
+
+// get returns the cell under the named column, or "" when there is none.
+func (r row) get(name string) string {
+	i, ok := r.columns[strings.ToLower(name)]
+	if !ok || i >= len(r.cells) {
+		return ""
+	}
+	return strings.TrimSpace(r.cells[i])
+}
+
+// decodeText reads UTF-8 text, converting UTF-16 text as some exports are
+// written, which starts with a byte order mark.
+func decodeText(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	bom, _ := buffered.Peek(2)
+	littleEndian := bytes.Equal(bom, []byte{0xff, 0xfe})
+	if !littleEndian && !bytes.Equal(bom, []byte{0xfe, 0xff}) {
+		return buffered, nil
+	}
+
+	data, err := io.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		if littleEndian {
+			units = append(units, uint16(data[i])|uint16(data[i+1])<<8)
+		} else {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+	}
+	return strings.NewReader(string(utf16.Decode(units))), nil
+}
@@ -0,0 +1,217 @@
+package catalogs
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+// readAll reads every entry of an export.
+func readAll(t *testing.T, format string, r io.Reader) []*Entry {
+	t.Helper()
+	reader, err := NewReader(format, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*Entry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func checkBook(t *testing.T, got database.Book, want database.Book) {
+	t.Helper()
+	want.Id = -1
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+const goodreadsExport = `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Exclusive Shelf
+5907,"The Hobbit, or There and Back Again",J.R.R. Tolkien,"Tolkien, J.R.R.",Douglas A. Anderson,"=""0261102214""","=""9780261102217""",5,Allen & Unwin,Paperback,310,1999,1937,,2020/01/01,"fantasy, classics, to-read",to-read
+12,Untitled Draft,,,,"=""""","=""""",0,,,,,,,2020/01/01,,read
+`
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{strings.SplitN(goodreadsExport, "\n", 2)[0], Goodreads},
+		{"item_type,title,creators,first_name,last_name,ean_isbn13,upc_isbn10", Libib},
+		{"\uFEFFBook Id,Title,Primary Author,ISBN", LibraryThing},
+		{"title,author_last,author_first", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := Detect(strings.NewReader(test.header + "\n")); got != test.want {
+			t.Errorf("Detect(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestGoodreads(t *testing.T) {
+	entries := readAll(t, Goodreads, strings.NewReader(goodreadsExport))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	hobbit := entries[0]
+	if !hobbit.Ok || hobbit.Id != "5907" {
+		t.Errorf("first entry: ok %v, id %q", hobbit.Ok, hobbit.Id)
+	}
+	checkBook(t, hobbit.Book, database.Book{
+		Title:               "The Hobbit, or There and Back Again",
+		AuthorLast:          "Tolkien",
+		AuthorFirst:         "J.R.R.",
+		Isbn:                "9780261102217",
+		Publisher:           "Allen & Unwin",
+		Pages:               "310",
+		CopyrightDate:       hobbit.Book.CopyrightDate,
+		CopyrightDateString: "1999",
+		Genre:               "fantasy",
+	})
+	// the second author and the classics shelf; to-read says nothing of
+	// the genre
+	if len(hobbit.Warnings) != 2 {
+		t.Errorf("warnings = %v, want 2", hobbit.Warnings)
+	}
+
+	if draft := entries[1]; draft.Ok || draft.Book.Isbn != "" {
+		t.Errorf("entry without an author: ok %v, isbn %q", draft.Ok, draft.Book.Isbn)
+	}
+}
+
+func TestLibib(t *testing.T) {
+	export := "item_type,title,creators,first_name,last_name,ean_isbn13,upc_isbn10,description,publisher,publish_date,group,tags,notes,length\n" +
+		"book,Good Omens,\"Terry Pratchett, Neil Gaiman\",Terry,Pratchett,9780060853983,0060853980,,William Morrow,2006-11-28,,\"fantasy,humor\",,432\n" +
+		"music,Abbey Road,The Beatles,,,,,,Apple,1969,,,,\n"
+	entries := readAll(t, Libib, strings.NewReader(export))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	omens := entries[0]
+	checkBook(t, omens.Book, database.Book{
+		Title:               "Good Omens",
+		AuthorLast:          "Pratchett",
+		AuthorFirst:         "Terry",
+		Isbn:                "9780060853983",
+		Publisher:           "William Morrow",
+		CopyrightDate:       omens.Book.CopyrightDate,
+		CopyrightDateString: "2006-11-28",
+		Pages:               "432",
+		Genre:               "fantasy",
+	})
+	if !omens.Ok || omens.Id != "9780060853983" || len(omens.Warnings) != 2 {
+		t.Errorf("first entry: ok %v, id %q, warnings %v", omens.Ok, omens.Id, omens.Warnings)
+	}
+
+	// creators is read when Libib could not split the name
+	road := entries[1]
+	if !road.Ok || road.Book.AuthorLast != "Beatles" || road.Book.AuthorFirst != "The" {
+		t.Errorf("second entry = %+v", road)
+	}
+	if len(road.Warnings) != 1 || !strings.Contains(road.Warnings[0], "music") {
+		t.Errorf("second entry warnings = %v, want the item type", road.Warnings)
+	}
+}
+
+// encodeUTF16 writes s as little endian UTF-16 with a byte order mark, as
+// LibraryThing's tab-delimited export is written.
+func encodeUTF16(s string) string {
+	b := []byte{0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(unit), byte(unit>>8))
+	}
+	return string(b)
+}
+
+func TestLibraryThingText(t *testing.T) {
+	export := "Book Id\tTitle\tPrimary Author\tSecondary Author\tPublication\tDate\tISBNs\tISBN\tLCCN\tPage Count\tTags\r\n" +
+		"114\tDer Hobbit\tTolkien, J. R. R.\tKrege, Wolfgang|\tStuttgart : Klett-Cotta (1998), Gebundene Ausgabe, 384 Seiten\t1998\t3608938001, 9783608938005\t[3608938001]\t\t384\tFantasy, Übersetzung\r\n"
+	for _, encoded := range []string{export, encodeUTF16(export)} {
+		entries := readAll(t, LibraryThing, strings.NewReader(encoded))
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		hobbit := entries[0]
+		checkBook(t, hobbit.Book, database.Book{
+			Title:               "Der Hobbit",
+			AuthorLast:          "Tolkien",
+			AuthorFirst:         "J. R. R.",
+			Isbn:                "3608938001",
+			Location:            "Stuttgart",
+			Publisher:           "Klett-Cotta",
+			CopyrightDate:       hobbit.Book.CopyrightDate,
+			CopyrightDateString: "1998",
+			Pages:               "384",
+			Genre:               "Fantasy",
+		})
+		// the translator and the second tag
+		if !hobbit.Ok || hobbit.Id != "114" || len(hobbit.Warnings) != 2 {
+			t.Errorf("ok %v, id %q, warnings %v", hobbit.Ok, hobbit.Id, hobbit.Warnings)
+		}
+	}
+}
+
+func TestLibraryThingJSON(t *testing.T) {
+	export := `{
+  "114": {
+    "books_id": "114",
+    "title": "The Hobbit",
+    "primaryauthor": "J. R. R. Tolkien",
+    "authors": [{"lf": "Tolkien, J. R. R.", "fl": "J. R. R. Tolkien"}],
+    "publication": "Boston : Houghton Mifflin, 1966.",
+    "date": "1966",
+    "isbn": {"0": "0395071224", "2": "9780395071229"},
+    "lccn": "66004290",
+    "pages": 317,
+    "tags": ["fantasy"]
+  },
+  "115": {"books_id": "115", "title": "Notes", "date": "someday"}
+}`
+	entries := readAll(t, LibraryThing, strings.NewReader(export))
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	hobbit := entries[0]
+	checkBook(t, hobbit.Book, database.Book{
+		Title:               "The Hobbit",
+		AuthorLast:          "Tolkien",
+		AuthorFirst:         "J. R. R.",
+		Isbn:                "9780395071229",
+		Lccn:                "66004290",
+		Location:            "Boston",
+		Publisher:           "Houghton Mifflin",
+		CopyrightDate:       hobbit.Book.CopyrightDate,
+		CopyrightDateString: "1966",
+		Pages:               "317",
+		Genre:               "fantasy",
+	})
+	if !hobbit.Ok || hobbit.Id != "114" || len(hobbit.Warnings) != 0 {
+		t.Errorf("first entry: ok %v, id %q, warnings %v", hobbit.Ok, hobbit.Id, hobbit.Warnings)
+	}
+
+	notes := entries[1]
+	if notes.Ok || !strings.Contains(strings.Join(notes.Warnings, "\n"), `date "someday"`) {
+		t.Errorf("second entry: ok %v, warnings %v", notes.Ok, notes.Warnings)
+	}
+}
+
+func TestUnknownCatalog(t *testing.T) {
+	if _, err := NewReader("delicious", strings.NewReader("")); err == nil {
+		t.Error("unknown catalog was read")
+	}
+}
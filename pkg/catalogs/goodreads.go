@@ -0,0 +1,56 @@
+package catalogs
+
+import (
+	"io"
+	"strings"
+)
+
+// goodreadsStatusShelves are the shelves Goodreads files every book under
+// by reading status, which say nothing about its genre.
+var goodreadsStatusShelves = map[string]bool{"read": true, "to-read": true, "currently-reading": true}
+
+type goodreadsReader struct {
+	table *table
+}
+
+// NewGoodreadsReader reads the CSV file Goodreads exports from My Books.
+func NewGoodreadsReader(r io.Reader) (Reader, error) {
+	t, err := newTable(r, ',')
+	if err != nil {
+		return nil, err
+	}
+	return &goodreadsReader{table: t}, nil
+}
+
+func (g *goodreadsReader) Next() (*Entry, error) {
+	row, err := g.table.next()
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Id: row.get("Book Id")}
+	e.Book.Title = row.get("Title")
+	// Author l-f has the name split the way books store it
+	if author := row.get("Author l-f"); author != "" {
+		e.setAuthors(append([]string{author}, splitList(row.get("Additional Authors"))...), true)
+	} else {
+		e.setAuthors(append([]string{row.get("Author")}, splitList(row.get("Additional Authors"))...), false)
+	}
+	e.setIsbn(row.get("ISBN13"), row.get("ISBN"))
+	e.Book.Publisher = row.get("Publisher")
+	e.setPages(row.get("Number of Pages"))
+	if year := row.get("Year Published"); year != "" {
+		e.setDate(year)
+	} else {
+		e.setDate(row.get("Original Publication Year"))
+	}
+
+	var shelves []string
+	for _, shelf := range splitList(row.get("Bookshelves")) {
+		if !goodreadsStatusShelves[strings.TrimSpace(shelf)] {
+			shelves = append(shelves, shelf)
+		}
+	}
+	e.setGenre(shelves)
+	e.finish()
+	return e, nil
+}
@@ -0,0 +1,48 @@
+package catalogs
+
+import (
+	"io"
+)
+
+type libibReader struct {
+	table *table
+}
+
+// NewLibibReader reads the CSV file Libib exports for a library.
+func NewLibibReader(r io.Reader) (Reader, error) {
+	t, err := newTable(r, ',')
+	if err != nil {
+		return nil, err
+	}
+	return &libibReader{table: t}, nil
+}
+
+func (l *libibReader) Next() (*Entry, error) {
+	row, err := l.table.next()
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Id: row.get("ean_isbn13")}
+	if kind := row.get("item_type"); kind != "" && kind != "book" {
+		e.warn("%s item imported as a book", kind)
+	}
+	e.Book.Title = row.get("title")
+	// creators lists every author first name first, while first_name and
+	// last_name only hold the first author, when Libib could split the name
+	creators := splitList(row.get("creators"))
+	if last := row.get("last_name"); last != "" {
+		e.Book.AuthorLast, e.Book.AuthorFirst = last, row.get("first_name")
+		if len(creators) > 1 {
+			e.warn("only one author is kept, %d more dropped", len(creators)-1)
+		}
+	} else {
+		e.setAuthors(creators, false)
+	}
+	e.setIsbn(row.get("ean_isbn13"), row.get("upc_isbn10"))
+	e.Book.Publisher = row.get("publisher")
+	e.setDate(row.get("publish_date"))
+	e.setPages(row.get("length"))
+	e.setGenre(splitList(row.get("tags")))
+	e.finish()
+	return e, nil
+}
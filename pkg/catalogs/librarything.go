@@ -0,0 +1,181 @@
+package catalogs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// NewLibraryThingReader reads the tab-delimited text or JSON file
+// LibraryThing exports, telling them apart by the first character.
+func NewLibraryThingReader(r io.Reader) (Reader, error) {
+	text, err := decodeText(r)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(text)
+	for {
+		c, _, err := buffered.ReadRune()
+		if err != nil {
+			return nil, err
+		}
+		if strings.ContainsRune(" \t\r\n\ufeff", c) {
+			continue
+		}
+		buffered.UnreadRune()
+		if c == '{' || c == '[' {
+			return newLibraryThingJSONReader(buffered)
+		}
+		t, err := newTable(buffered, '\t')
+		if err != nil {
+			return nil, err
+		}
+		return &libraryThingReader{table: t}, nil
+	}
+}
+
+type libraryThingReader struct {
+	table *table
+}
+
+func (l *libraryThingReader) Next() (*Entry, error) {
+	row, err := l.table.next()
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Id: row.get("Book Id")}
+	e.Book.Title = row.get("Title")
+	authors := []string{row.get("Primary Author")}
+	e.setAuthors(append(authors, strings.Split(row.get("Secondary Author"), "|")...), true)
+	e.setIsbn(append(splitList(row.get("ISBNs")), row.get("ISBN"))...)
+	e.Book.Lccn = row.get("LCCN")
+	e.Book.Location, e.Book.Publisher = splitPublication(row.get("Publication"))
+	e.setDate(row.get("Date"))
+	e.setPages(row.get("Page Count"))
+	e.setGenre(splitList(row.get("Tags")))
+	e.finish()
+	return e, nil
+}
+
+// splitPublication reads the place and publisher from LibraryThing's
+// publication line, such as "New York : Del Rey (1997), Paperback, 464
+// pages".
+func splitPublication(s string) (string, string) {
+	if i := strings.IndexAny(s, "(,"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimRight(strings.TrimSpace(s), ".;")
+	if place, publisher, found := strings.Cut(s, ":"); found {
+		return strings.TrimSpace(place), strings.TrimSpace(publisher)
+	}
+	return "", s
+}
+
+// libraryThingJSONReader reads the JSON export, an object of books keyed by
+// their id, one book at a time.
+type libraryThingJSONReader struct {
+	decoder *json.Decoder
+	keyed   bool
+}
+
+func newLibraryThingJSONReader(r io.Reader) (Reader, error) {
+	decoder := json.NewDecoder(r)
+	t, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	return &libraryThingJSONReader{decoder: decoder, keyed: t == json.Delim('{')}, nil
+}
+
+func (l *libraryThingJSONReader) Next() (*Entry, error) {
+	if !l.decoder.More() {
+		return nil, io.EOF
+	}
+	if l.keyed {
+		// the key is the id again, which the book also holds
+		if _, err := l.decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+	var book map[string]json.RawMessage
+	err := l.decoder.Decode(&book)
+	if err != nil {
+		return nil, fmt.Errorf("a book could not be read: %v", err)
+	}
+	first := func(name string) string {
+		values := jsonStrings(book[name])
+		if len(values) == 0 {
+			return ""
+		}
+		return strings.TrimSpace(values[0])
+	}
+
+	e := &Entry{Id: first("books_id")}
+	e.Book.Title = first("title")
+	var authors []string
+	var list []struct {
+		Lf string `json:"lf"`
+	}
+	if json.Unmarshal(book["authors"], &list) == nil {
+		for _, author := range list {
+			authors = append(authors, author.Lf)
+		}
+	}
+	if len(authors) == 0 {
+		authors = []string{first("primaryauthor")}
+	}
+	e.setAuthors(authors, true)
+	isbns := jsonStrings(book["isbn"])
+	// ISBN-13s are kept over ISBN-10s
+	sort.SliceStable(isbns, func(i, j int) bool { return len(isbns[i]) > len(isbns[j]) })
+	e.setIsbn(isbns...)
+	e.Book.Lccn = first("lccn")
+	e.Book.Location, e.Book.Publisher = splitPublication(first("publication"))
+	e.setDate(first("date"))
+	e.setPages(first("pages"))
+	e.setGenre(jsonStrings(book["tags"]))
+	e.finish()
+	return e, nil
+}
+
+// jsonStrings reads the strings and numbers of a value, which LibraryThing
+// writes as a single value, a list or an object keyed by position depending
+// on the field.
+func jsonStrings(raw json.RawMessage) []string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return []string{n.String()}
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var values []string
+		for _, item := range list {
+			values = append(values, jsonStrings(item)...)
+		}
+		return values
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(raw, &object) == nil {
+		var keys []string
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, key := range keys {
+			values = append(values, jsonStrings(object[key])...)
+		}
+		return values
+	}
+	return nil
+}
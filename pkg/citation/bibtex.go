@@ -0,0 +1,419 @@
+package citation
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`, "}", `\}`,
+	"&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+	"~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+)
+
+// bibtexAccents are the LaTeX accent commands that have a precomposed
+// Latin letter, keyed by command and then by base letter.
+var bibtexAccents = map[byte]map[byte]string{
+	'"':  {'a': "ä", 'e': "ë", 'i': "ï", 'o': "ö", 'u': "ü", 'y': "ÿ", 'A': "Ä", 'E': "Ë", 'I': "Ï", 'O': "Ö", 'U': "Ü"},
+	'\'': {'a': "á", 'e': "é", 'i': "í", 'o': "ó", 'u': "ú", 'y': "ý", 'c': "ć", 'n': "ń", 's': "ś", 'z': "ź", 'A': "Á", 'E': "É", 'I': "Í", 'O': "Ó", 'U': "Ú"},
+	'`':  {'a': "à", 'e': "è", 'i': "ì", 'o': "ò", 'u': "ù", 'A': "À", 'E': "È", 'I': "Ì", 'O': "Ò", 'U': "Ù"},
+	'^':  {'a': "â", 'e': "ê", 'i': "î", 'o': "ô", 'u': "û", 'A': "Â", 'E': "Ê", 'I': "Î", 'O': "Ô", 'U': "Û"},
+	'~':  {'a': "ã", 'n': "ñ", 'o': "õ", 'A': "Ã", 'N': "Ñ", 'O': "Õ"},
+	'c':  {'c': "ç", 'C': "Ç", 's': "ş", 'S': "Ş"},
+	'v':  {'c': "č", 's': "š", 'z': "ž", 'r': "ř", 'e': "ě", 'C': "Č", 'S': "Š", 'Z': "Ž", 'R': "Ř"},
+}
+
+var bibtexSymbols = map[string]string{
+	"ss": "ß", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ", "o": "ø", "O": "Ø", "l": "ł", "L": "Ł", "aa": "å", "AA": "Å",
+	"textbackslash": `\`, "textasciitilde": "~", "textasciicircum": "^",
+}
+
+var bibtexMonths = map[string]string{
+	"jan": "1", "feb": "2", "mar": "3", "apr": "4", "may": "5", "jun": "6",
+	"jul": "7", "aug": "8", "sep": "9", "oct": "10", "nov": "11", "dec": "12",
+}
+
+// WriteBibTeX writes book as a @book entry. url, when set, is where the
+// book can be viewed.
+func WriteBibTeX(w io.Writer, key string, b database.Book, url string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@book{%s,\n", key)
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "  %s = {%s},\n", name, bibtexEscaper.Replace(value))
+		}
+	}
+	field("author", authorName(b))
+	field("title", b.Title)
+	field("publisher", b.Publisher)
+	field("address", b.Location)
+	if !b.CopyrightDate.IsZero() {
+		field("year", strconv.Itoa(b.CopyrightDate.Year))
+		// biblatex reads EDTF, so circa dates and ranges carry over. EDTF
+		// has nothing to escape, and its ~ must stay as it is
+		fmt.Fprintf(&sb, "  date = {%s},\n", b.CopyrightDate.String())
+	}
+	field("isbn", b.Isbn)
+	field("lccn", b.Lccn)
+	field("pagetotal", b.Pages)
+	field("keywords", b.Genre)
+	field("url", url)
+	sb.WriteString("}\n\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type bibtexParser struct {
+	s       string
+	pos     int
+	strings map[string]string
+}
+
+// ReadBibTeX reads the entries of a .bib file. @string abbreviations and #
+// concatenation are understood. Entries that cannot be parsed are
+// returned with a warning instead of stopping the rest of the file.
+func ReadBibTeX(r io.Reader) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &bibtexParser{s: string(data), strings: map[string]string{}}
+	for month, number := range bibtexMonths {
+		p.strings[month] = number
+	}
+
+	var entries []Entry
+	for p.pos < len(p.s) {
+		at := strings.IndexByte(p.s[p.pos:], '@')
+		if at < 0 {
+			return entries, nil
+		}
+		p.pos += at + 1
+		kind := strings.ToLower(p.identifier())
+		p.space()
+		if p.pos >= len(p.s) || (p.s[p.pos] != '{' && p.s[p.pos] != '(') {
+			continue
+		}
+		closing := byte('}')
+		if p.s[p.pos] == '(' {
+			closing = ')'
+		}
+		p.pos++
+
+		switch kind {
+		case "comment", "preamble":
+			p.skipBalanced(closing)
+			continue
+		case "string":
+			name, value, err := p.field()
+			if err == nil {
+				p.strings[strings.ToLower(name)] = value
+			}
+			p.skipBalanced(closing)
+			continue
+		}
+
+		p.space()
+		start := p.pos
+		for p.pos < len(p.s) && p.s[p.pos] != ',' && p.s[p.pos] != closing {
+			p.pos++
+		}
+		entry := bibtexEntry{kind: kind, key: strings.TrimSpace(p.s[start:p.pos]), fields: map[string]string{}}
+		var parseErr error
+		for p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+			p.space()
+			if p.pos < len(p.s) && p.s[p.pos] == closing {
+				break
+			}
+			name, value, err := p.field()
+			if err != nil {
+				parseErr = err
+				break
+			}
+			entry.fields[strings.ToLower(name)] = value
+			p.space()
+		}
+		if parseErr != nil {
+			p.skipBalanced(closing)
+			entries = append(entries, Entry{Key: entry.key, Warnings: []string{parseErr.Error() + ", skipped"}})
+			continue
+		}
+		// Past the closing delimiter, unless the file ends first
+		p.pos = min(p.pos+1, len(p.s))
+		entries = append(entries, entry.toEntry())
+	}
+	return entries, nil
+}
+
+func (p *bibtexParser) space() {
+	for p.pos < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+func (p *bibtexParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '=' || c == ',' || c == '#' || c == '{' || c == '}' || c == '(' || c == ')' || c == '"' || unicode.IsSpace(rune(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// skipBalanced moves past the closing delimiter of the current entry.
+func (p *bibtexParser) skipBalanced(closing byte) {
+	depth := 0
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == closing && depth == 0:
+			return
+		}
+	}
+}
+
+// field reads name = value, where value is parts joined with #.
+func (p *bibtexParser) field() (string, string, error) {
+	p.space()
+	name := p.identifier()
+	p.space()
+	if name == "" || p.pos >= len(p.s) || p.s[p.pos] != '=' {
+		return "", "", fmt.Errorf("expected a field at offset %d", p.pos)
+	}
+	p.pos++
+
+	var value strings.Builder
+	for {
+		p.space()
+		if p.pos >= len(p.s) {
+			return "", "", fmt.Errorf("field %s is not finished", name)
+		}
+		switch c := p.s[p.pos]; {
+		case c == '{':
+			part, err := p.delimited('}')
+			if err != nil {
+				return "", "", fmt.Errorf("field %s: %v", name, err)
+			}
+			value.WriteString(part)
+		case c == '"':
+			part, err := p.delimited('"')
+			if err != nil {
+				return "", "", fmt.Errorf("field %s: %v", name, err)
+			}
+			value.WriteString(part)
+		default:
+			word := p.identifier()
+			if word == "" {
+				return "", "", fmt.Errorf("field %s has no value", name)
+			}
+			if abbreviation, ok := p.strings[strings.ToLower(word)]; ok {
+				word = abbreviation
+			}
+			value.WriteString(word)
+		}
+		p.space()
+		if p.pos < len(p.s) && p.s[p.pos] == '#' {
+			p.pos++
+			continue
+		}
+		return name, value.String(), nil
+	}
+}
+
+// delimited reads a braced or quoted value, keeping nested braces.
+func (p *bibtexParser) delimited(close byte) (string, error) {
+	p.pos++
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s):
+			p.pos++
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == close && depth == 0:
+			value := p.s[start:p.pos]
+			p.pos++
+			return value, nil
+		}
+		p.pos++
+	}
+	return "", fmt.Errorf("missing closing %c", close)
+}
+
+type bibtexEntry struct {
+	kind   string
+	key    string
+	fields map[string]string
+}
+
+func (be bibtexEntry) toEntry() Entry {
+	e := Entry{Key: be.key}
+	warn := func(format string, args ...interface{}) {
+		e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+	}
+	field := func(name string) string {
+		return strings.Join(strings.Fields(unlatex(be.fields[name])), " ")
+	}
+
+	if be.kind != "book" {
+		warn("@%s entry imported as a book", be.kind)
+	}
+
+	authors := splitBibtexNames(be.fields["author"])
+	if len(authors) == 0 {
+		authors = splitBibtexNames(be.fields["editor"])
+		if len(authors) > 0 {
+			warn("no author, used the editor")
+		}
+	}
+	if len(authors) > 0 {
+		e.Book.AuthorLast, e.Book.AuthorFirst = splitAuthor(unlatex(authors[0]))
+		if len(authors) > 1 {
+			warn("only one author is kept, %d more dropped", len(authors)-1)
+		}
+	}
+
+	e.Book.Title = field("title")
+	if subtitle := field("subtitle"); subtitle != "" {
+		e.Book.Title += ": " + subtitle
+	}
+	e.Book.Publisher = field("publisher")
+	e.Book.Location = field("address")
+	if e.Book.Location == "" {
+		e.Book.Location = field("location")
+	}
+	e.Book.Isbn = strings.ReplaceAll(field("isbn"), "-", "")
+	e.Book.Lccn = field("lccn")
+
+	// The date is EDTF, in which ~ means circa rather than a space
+	date := strings.TrimSpace(strings.NewReplacer("{", "", "}", "").Replace(be.fields["date"]))
+	if date == "" && field("year") != "" {
+		date = field("year")
+		if month := field("month"); month != "" {
+			date += "-" + month
+		}
+	}
+	if date != "" {
+		if parsed, ok := parseDate(date); ok {
+			e.Book.CopyrightDate = parsed
+			e.Book.CopyrightDateString = parsed.String()
+		} else {
+			warn("date %q is not understood", date)
+		}
+	}
+
+	pages := field("pagetotal")
+	if pages == "" && strings.Trim(field("pages"), "0123456789") == "" {
+		pages = field("pages")
+	}
+	e.Book.Pages = pages
+
+	if keywords := strings.Split(field("keywords"), ","); keywords[0] != "" {
+		e.Book.Genre = strings.TrimSpace(keywords[0])
+		if len(keywords) > 1 {
+			warn("only the first keyword is kept as the genre")
+		}
+	}
+
+	e.finish()
+	return e
+}
+
+// splitBibtexNames splits a name list on "and" outside braces.
+func splitBibtexNames(s string) []string {
+	var names []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(s[i:], " and ") {
+			names = append(names, strings.TrimSpace(s[start:i]))
+			start = i + len(" and ")
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		names = append(names, last)
+	}
+	return names
+}
+
+// unlatex turns the LaTeX escapes and accents found in .bib files into
+// plain text and drops the braces used to protect capitalization.
+func unlatex(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' || c == '}':
+			continue
+		case c == '~':
+			sb.WriteByte(' ')
+		case c == '\\' && i+1 < len(s):
+			next := s[i+1]
+			if accents, ok := bibtexAccents[next]; ok {
+				// \"u, \"{u} and \c{c}
+				j := i + 2
+				for j < len(s) && (s[j] == '{' || s[j] == ' ') {
+					j++
+				}
+				if j < len(s) {
+					if letter, ok := accents[s[j]]; ok {
+						sb.WriteString(letter)
+						i = j
+						continue
+					}
+				}
+			}
+			if !unicode.IsLetter(rune(next)) {
+				// \& \% \$ \# \_ \{ \}
+				sb.WriteByte(next)
+				i++
+				continue
+			}
+			j := i + 1
+			for j < len(s) && unicode.IsLetter(rune(s[j])) {
+				j++
+			}
+			if symbol, ok := bibtexSymbols[s[i+1:j]]; ok {
+				sb.WriteString(symbol)
+			}
+			// A space after a command word only ends it, as in Gro\ss e
+			if j < len(s) && s[j] == ' ' {
+				j++
+			}
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
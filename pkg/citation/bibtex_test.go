@@ -0,0 +1,94 @@
+package citation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadBibTeX(t *testing.T) {
+	input := `@string{au = "Allen \& Unwin"}
+@comment{ignored @book{not, title = {read}} }
+@book{tolkien1937hobbit,
+  author = {Tolkien, J. R. R.},
+  title = {The {Hobbit}},
+  subtitle = "There and Back Again",
+  publisher = au,
+  address = {London},
+  year = 1937,
+  month = sep,
+  isbn = {978-0-261-10221-7},
+}
+@article(short, title = {Only a title})`
+	entries, err := ReadBibTeX(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+
+	hobbit := entries[0]
+	if !hobbit.Ok || hobbit.Key != "tolkien1937hobbit" {
+		t.Errorf("first entry: ok %v key %q", hobbit.Ok, hobbit.Key)
+	}
+	book := hobbit.Book
+	if book.Title != "The Hobbit: There and Back Again" {
+		t.Errorf("title = %q", book.Title)
+	}
+	if book.AuthorLast != "Tolkien" || book.AuthorFirst != "J. R. R." {
+		t.Errorf("author = %q, %q", book.AuthorLast, book.AuthorFirst)
+	}
+	if book.Publisher != "Allen & Unwin" || book.Location != "London" {
+		t.Errorf("publisher = %q, location = %q", book.Publisher, book.Location)
+	}
+	if book.Isbn != "9780261102217" {
+		t.Errorf("isbn = %q", book.Isbn)
+	}
+	if got := book.CopyrightDate.String(); got != "1937-09" {
+		t.Errorf("date = %q, want 1937-09", got)
+	}
+
+	if entries[1].Ok {
+		t.Errorf("entry without an author is ok")
+	}
+}
+
+// Malformed files must come back as warnings or nothing at all, never as a
+// panic.
+func TestReadBibTeXMalformed(t *testing.T) {
+	inputs := []string{
+		"@",
+		"@(",
+		"@{",
+		"@book",
+		"@book{",
+		"@book{key",
+		"@book{key,",
+		"@book{key, title",
+		"@book{key, title =",
+		"@book{key, title = {The Hobbit",
+		`@book{key, title = "The Hobbit`,
+		`@book{key, title = {The Hobbit\`,
+		`@book{key, title = "The Hobbit\`,
+		"@book{key, title = {a} # ",
+		"@string{",
+		"@string{name = ",
+		"@comment{",
+		"@preamble(",
+		"@book(key, title = {x}",
+		"@@@",
+	}
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			entries, err := ReadBibTeX(strings.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if entry.Ok {
+					t.Errorf("malformed entry %q is ok", entry.Key)
+				}
+			}
+		})
+	}
+}
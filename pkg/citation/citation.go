@@ -0,0 +1,138 @@
+// Package citation writes books in the BibTeX, RIS and CSL-JSON formats
+// reference managers use, and reads BibTeX and RIS back into books.
+package citation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+// Entry is a book read from a citation file, with anything that could not
+// be carried over described in Warnings. Ok is false when the entry lacks
+// the title or author every book needs.
+type Entry struct {
+	Key      string
+	Book     database.Book
+	Warnings []string
+	Ok       bool
+}
+
+var keyStopWords = map[string]bool{"a": true, "an": true, "the": true, "of": true, "on": true, "in": true, "and": true, "le": true, "la": true, "les": true, "der": true, "die": true, "das": true}
+
+var asciiFold = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i",
+	"î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o",
+	"ö", "o", "ø", "o", "œ", "oe", "ß", "ss", "ù", "u", "ú", "u", "û", "u",
+	"ü", "u", "ý", "y", "ÿ", "y", "ł", "l",
+)
+
+// Key builds a citation key from the author, year and first significant
+// title word and the id of the book, such as tolkien1937hobbit_12. The id
+// keeps keys unique within the library, so a book gets the same key in
+// every export whatever else is exported with it. Books not yet stored
+// get no id.
+func Key(book database.Book) string {
+	key := keyWord(book.AuthorLast)
+	if key == "" {
+		key = "anon"
+	}
+	if !book.CopyrightDate.IsZero() {
+		key += strconv.Itoa(book.CopyrightDate.Year)
+	}
+	for _, word := range strings.Fields(book.Title) {
+		if word = keyWord(word); word != "" && !keyStopWords[word] {
+			key += word
+			break
+		}
+	}
+	if book.Id > 0 {
+		key += "_" + strconv.Itoa(book.Id)
+	}
+	return key
+}
+
+func keyWord(s string) string {
+	s = asciiFold.Replace(strings.ToLower(s))
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// splitAuthor reads a name written "Last, First" or "First Last".
+func splitAuthor(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if last, first, found := strings.Cut(name, ","); found {
+		return strings.TrimSpace(last), strings.TrimSpace(first)
+	}
+	if i := strings.LastIndex(name, " "); i > 0 {
+		return name[i+1:], strings.TrimSpace(name[:i])
+	}
+	return name, ""
+}
+
+func authorName(book database.Book) string {
+	if book.AuthorFirst == "" {
+		return book.AuthorLast
+	}
+	return book.AuthorLast + ", " + book.AuthorFirst
+}
+
+// finish sets the outcome every importer reports the same way.
+func (e *Entry) finish() {
+	if e.Book.Title == "" {
+		e.Warnings = append(e.Warnings, "no title, skipped")
+	}
+	if e.Book.AuthorLast == "" {
+		e.Warnings = append(e.Warnings, "no author, skipped")
+	}
+	e.Book.Id = -1
+	e.Ok = e.Book.Title != "" && e.Book.AuthorLast != ""
+}
+
+// parseDate reads a year or a full date written with dashes or slashes,
+// as in 1937, 1937-09-21 and the RIS 1937/09/21/, or any date dates.Parse
+// reads, such as c1937 and 1937/1939. The RIS other-info part after the
+// third slash may say more about the date than the first three.
+func parseDate(s string) (dates.PartialDate, bool) {
+	if date, err := dates.Parse(s); err == nil {
+		return date, !date.IsZero()
+	}
+	if parts := strings.SplitN(s, "/", 4); len(parts) == 4 {
+		if date, err := dates.Parse(parts[3]); err == nil && !date.IsZero() {
+			return date, true
+		}
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '/' })
+	if len(parts) == 0 {
+		return dates.PartialDate{}, false
+	}
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	var numbers []string
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return dates.PartialDate{}, false
+		}
+		if i == 0 {
+			numbers = append(numbers, fmt.Sprintf("%04d", n))
+		} else {
+			numbers = append(numbers, fmt.Sprintf("%02d", n))
+		}
+	}
+	date, err := dates.Parse(strings.Join(numbers, "-"))
+	if err != nil || date.IsZero() {
+		return dates.PartialDate{}, false
+	}
+	return date, true
+}
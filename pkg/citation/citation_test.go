@@ -0,0 +1,114 @@
+package citation
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		book database.Book
+		want string
+	}{
+		{database.Book{Id: 12, AuthorLast: "Tolkien", Title: "The Hobbit", CopyrightDate: dates.Year(1937)}, "tolkien1937hobbit_12"},
+		{database.Book{Id: 13, AuthorLast: "Tolkien", Title: "The Hobbit", CopyrightDate: dates.Year(1937)}, "tolkien1937hobbit_13"},
+		{database.Book{Id: 3, AuthorLast: "Brontë", Title: "Jane Eyre"}, "brontejane_3"},
+		{database.Book{Id: 4, Title: "Beowulf", CopyrightDate: dates.PartialDate{Year: 1000, EndYear: 1099, Circa: true}}, "anon1000beowulf_4"},
+		{database.Book{Id: -1, AuthorLast: "Tolkien", Title: "The Hobbit"}, "tolkienhobbit"},
+	}
+	for _, test := range tests {
+		if got := Key(test.book); got != test.want {
+			t.Errorf("Key(%q) = %q, want %q", test.book.Title, got, test.want)
+		}
+	}
+}
+
+// What the writers export, the readers import as the same book, whatever is
+// known of its date.
+func TestRoundTrip(t *testing.T) {
+	book := database.Book{
+		Lccn:        "37019245",
+		Isbn:        "9780261102217",
+		Title:       "The Hobbit: There & Back Again",
+		AuthorLast:  "Tolkien",
+		AuthorFirst: "J. R. R.",
+		Publisher:   "Allen & Unwin",
+		Location:    "London",
+		Genre:       "Fantasy",
+		Pages:       "310",
+		Id:          -1,
+	}
+	formats := []struct {
+		name  string
+		write func(io.Writer, string, database.Book, string) error
+		read  func(io.Reader) ([]Entry, error)
+	}{
+		{"BibTeX", WriteBibTeX, ReadBibTeX},
+		{"RIS", WriteRIS, ReadRIS},
+	}
+	for _, format := range formats {
+		for _, date := range []string{"", "1937", "1937-09", "1937-09-21", "1937~", "1937-09~", "1930/1939", "1930~/1939~"} {
+			t.Run(format.name+" "+date, func(t *testing.T) {
+				book.CopyrightDate, _ = dates.Parse(date)
+				book.CopyrightDateString = book.CopyrightDate.String()
+
+				var b bytes.Buffer
+				if err := format.write(&b, Key(book), book, "http://localhost/books/1"); err != nil {
+					t.Fatal(err)
+				}
+				entries, err := format.read(&b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 1 {
+					t.Fatalf("got %d entries, want 1", len(entries))
+				}
+				entry := entries[0]
+				if !entry.Ok || len(entry.Warnings) > 0 {
+					t.Errorf("ok %v, warnings %v", entry.Ok, entry.Warnings)
+				}
+				if entry.Key != Key(book) {
+					t.Errorf("key = %q, want %q", entry.Key, Key(book))
+				}
+				if entry.Book != book {
+					t.Errorf("got %+v\nwant %+v", entry.Book, book)
+				}
+			})
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"1937", "1937"},
+		{"c1937", "1937"},
+		{"ca. 1937", "1937~"},
+		{"1937-09-21", "1937-09-21"},
+		{"1937/09/21/", "1937-09-21"},
+		{"1937/09//", "1937-09"},
+		{"1937///", "1937"},
+		{"1937/9/1", "1937-09-01"},
+		{"1937///c. 1937", "1937~"},
+		{"1930///1930–1939", "1930/1939"},
+		{"1937-9", "1937-09"},
+		{"1937/1939", "1937/1939"},
+	}
+	for _, test := range tests {
+		got, ok := parseDate(test.value)
+		if !ok || got.String() != test.want {
+			t.Errorf("parseDate(%q) = %q, %v, want %q", test.value, got, ok, test.want)
+		}
+	}
+	for _, value := range []string{"", "fall", "1937/13//", "sometime/in/the/thirties"} {
+		if got, ok := parseDate(value); ok {
+			t.Errorf("parseDate(%q) = %q, want none", value, got)
+		}
+	}
+}
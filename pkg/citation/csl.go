@@ -0,0 +1,75 @@
+package citation
+
+import (
+	"strconv"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+type CslName struct {
+	Family string `json:"family"`
+	Given  string `json:"given,omitempty"`
+}
+
+type CslDate struct {
+	DateParts [][]int `json:"date-parts"`
+	Circa     bool    `json:"circa,omitempty"`
+}
+
+// CslItem is a book as a Citation Style Language data item.
+type CslItem struct {
+	Id             string    `json:"id"`
+	Type           string    `json:"type"`
+	Title          string    `json:"title,omitempty"`
+	Author         []CslName `json:"author,omitempty"`
+	Issued         *CslDate  `json:"issued,omitempty"`
+	Publisher      string    `json:"publisher,omitempty"`
+	PublisherPlace string    `json:"publisher-place,omitempty"`
+	ISBN           string    `json:"ISBN,omitempty"`
+	NumberOfPages  string    `json:"number-of-pages,omitempty"`
+	Genre          string    `json:"genre,omitempty"`
+	URL            string    `json:"URL,omitempty"`
+}
+
+// NewCslItem describes book for citation processors. CSL has no variable
+// for the LCCN, so it is left out.
+func NewCslItem(key string, b database.Book, url string) CslItem {
+	item := CslItem{
+		Id:             key,
+		Type:           "book",
+		Title:          b.Title,
+		Publisher:      b.Publisher,
+		PublisherPlace: b.Location,
+		ISBN:           b.Isbn,
+		Genre:          b.Genre,
+		URL:            url,
+	}
+	if b.AuthorLast != "" {
+		item.Author = []CslName{{Family: b.AuthorLast, Given: b.AuthorFirst}}
+	}
+	if !b.CopyrightDate.IsZero() {
+		item.Issued = cslDate(b.CopyrightDate)
+	}
+	if _, err := strconv.Atoi(b.Pages); err == nil {
+		item.NumberOfPages = b.Pages
+	}
+	return item
+}
+
+// cslDate gives only the parts of a date that are known, and a range as its
+// first and last years.
+func cslDate(date dates.PartialDate) *CslDate {
+	parts := []int{date.Year}
+	if date.Month != 0 {
+		parts = append(parts, date.Month)
+	}
+	if date.Day != 0 {
+		parts = append(parts, date.Day)
+	}
+	issued := &CslDate{DateParts: [][]int{parts}, Circa: date.Circa}
+	if date.IsRange() {
+		issued.DateParts = append(issued.DateParts, []int{date.EndYear})
+	}
+	return issued
+}
@@ -0,0 +1,178 @@
+package citation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+// WriteRIS writes book as a BOOK reference. The LCCN has no RIS tag of
+// its own, so it goes in AN, the accession number.
+func WriteRIS(w io.Writer, key string, b database.Book, url string) error {
+	var sb strings.Builder
+	tag := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s  - %s\r\n", name, strings.Join(strings.Fields(value), " "))
+		}
+	}
+	tag("TY", "BOOK")
+	tag("ID", key)
+	tag("AU", authorName(b))
+	tag("TI", b.Title)
+	if !b.CopyrightDate.IsZero() {
+		tag("PY", strconv.Itoa(b.CopyrightDate.Year))
+		tag("DA", risDate(b.CopyrightDate))
+	}
+	tag("PB", b.Publisher)
+	tag("CY", b.Location)
+	tag("SN", b.Isbn)
+	tag("AN", b.Lccn)
+	tag("SP", b.Pages)
+	tag("KW", b.Genre)
+	tag("UR", url)
+	sb.WriteString("ER  - \r\n\r\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ReadRIS reads the references of a .ris file.
+func ReadRIS(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var tags map[string][]string
+	var lastTag string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(strings.TrimPrefix(scanner.Text(), "\uFEFF"), "\r ")
+		if len(line) < 5 || line[2:5] != "  -" {
+			// Long values sometimes continue on the next line
+			if tags != nil && lastTag != "" && strings.TrimSpace(line) != "" {
+				values := tags[lastTag]
+				values[len(values)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		tag := line[:2]
+		value := strings.TrimSpace(line[5:])
+		switch tag {
+		case "TY":
+			tags = map[string][]string{"TY": {value}}
+		case "ER":
+			if tags != nil {
+				entries = append(entries, risEntry(tags))
+			}
+			tags = nil
+		default:
+			if tags != nil {
+				tags[tag] = append(tags[tag], value)
+			}
+		}
+		lastTag = tag
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if tags != nil {
+		entry := risEntry(tags)
+		entry.Warnings = append(entry.Warnings, "reference has no ER line")
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func risEntry(tags map[string][]string) Entry {
+	e := Entry{}
+	warn := func(format string, args ...interface{}) {
+		e.Warnings = append(e.Warnings, fmt.Sprintf(format, args...))
+	}
+	// first returns the first value of the first tag that has one
+	first := func(names ...string) string {
+		for _, name := range names {
+			if values := tags[name]; len(values) > 0 {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	e.Key = first("ID")
+	if kind := first("TY"); kind != "BOOK" {
+		warn("%s reference imported as a book", kind)
+	}
+
+	var authors []string
+	for _, name := range []string{"AU", "A1", "A2", "ED"} {
+		authors = append(authors, tags[name]...)
+		if len(authors) > 0 {
+			if name == "A2" || name == "ED" {
+				warn("no author, used the editor")
+			}
+			break
+		}
+	}
+	if len(authors) > 0 {
+		e.Book.AuthorLast, e.Book.AuthorFirst = splitAuthor(authors[0])
+		if len(authors) > 1 {
+			warn("only one author is kept, %d more dropped", len(authors)-1)
+		}
+	}
+
+	e.Book.Title = first("TI", "T1", "BT")
+	e.Book.Publisher = first("PB")
+	e.Book.Location = first("CY", "PP")
+	// SN may hold several numbers or a qualifier such as (pbk.)
+	if isbn := strings.Fields(first("SN")); len(isbn) > 0 {
+		e.Book.Isbn = strings.ReplaceAll(isbn[0], "-", "")
+	}
+	e.Book.Lccn = first("AN")
+
+	date := first("DA", "PY", "Y1")
+	if date != "" {
+		if parsed, ok := parseDate(date); ok {
+			e.Book.CopyrightDate = parsed
+			e.Book.CopyrightDateString = parsed.String()
+		} else {
+			warn("date %q is not understood", date)
+		}
+	}
+
+	pages := first("SP")
+	if strings.Trim(pages, "0123456789") == "" {
+		e.Book.Pages = pages
+	} else {
+		warn("pages %q is not a page count", pages)
+	}
+
+	if keywords := tags["KW"]; len(keywords) > 0 {
+		e.Book.Genre = keywords[0]
+		if len(keywords) > 1 {
+			warn("only the first keyword is kept as the genre")
+		}
+	}
+
+	e.finish()
+	return e
+}
+
+// risDate writes a date as year/month/day/other, leaving out the parts that
+// are not known and describing a circa date or range in the other part.
+func risDate(date dates.PartialDate) string {
+	s := fmt.Sprintf("%04d/", date.Year)
+	if date.Month != 0 {
+		s += fmt.Sprintf("%02d", date.Month)
+	}
+	s += "/"
+	if date.Day != 0 {
+		s += fmt.Sprintf("%02d", date.Day)
+	}
+	s += "/"
+	if date.Circa || date.IsRange() {
+		s += date.Display()
+	}
+	return s
+}
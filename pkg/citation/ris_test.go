@@ -0,0 +1,83 @@
+package citation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadRIS(t *testing.T) {
+	input := "\uFEFFTY  - BOOK\r\n" +
+		"ID  - hobbit\r\n" +
+		"AU  - Tolkien, J. R. R.\r\n" +
+		"AU  - Anderson, Douglas A.\r\n" +
+		"TI  - The Hobbit, or There and\r\n" +
+		"      Back Again\r\n" +
+		"PY  - 1937\r\n" +
+		"PB  - Allen & Unwin\r\n" +
+		"PP  - London\r\n" +
+		"SN  - 978-0-261-10221-7 (pbk.)\r\n" +
+		"SP  - 310\r\n" +
+		"KW  - Fantasy\r\n" +
+		"KW  - Dragons\r\n" +
+		"ER  - \r\n" +
+		"\r\n" +
+		"TY  - CHAP\r\n" +
+		"ED  - Carpenter, Humphrey\r\n" +
+		"T1  - Letters\r\n" +
+		"DA  - 1981/08/20/\r\n" +
+		"SP  - 1-20\r\n" +
+		"ER  - \r\n" +
+		"TY  - BOOK\r\n" +
+		"TI  - Unfinished\r\n"
+	entries, err := ReadRIS(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	hobbit := entries[0]
+	if !hobbit.Ok || hobbit.Key != "hobbit" {
+		t.Errorf("first entry: ok %v key %q", hobbit.Ok, hobbit.Key)
+	}
+	book := hobbit.Book
+	if book.Title != "The Hobbit, or There and Back Again" {
+		t.Errorf("title = %q", book.Title)
+	}
+	if book.AuthorLast != "Tolkien" || book.AuthorFirst != "J. R. R." {
+		t.Errorf("author = %q, %q", book.AuthorLast, book.AuthorFirst)
+	}
+	if book.Location != "London" || book.Isbn != "9780261102217" || book.Pages != "310" || book.Genre != "Fantasy" {
+		t.Errorf("location %q, isbn %q, pages %q, genre %q", book.Location, book.Isbn, book.Pages, book.Genre)
+	}
+	if book.CopyrightDateString != "1937" {
+		t.Errorf("date = %q", book.CopyrightDateString)
+	}
+	if len(hobbit.Warnings) != 2 {
+		t.Errorf("warnings = %v, want the dropped author and keyword", hobbit.Warnings)
+	}
+
+	letters := entries[1]
+	if !letters.Ok || letters.Book.AuthorLast != "Carpenter" || letters.Book.CopyrightDateString != "1981-08-20" {
+		t.Errorf("second entry = %+v", letters)
+	}
+	if letters.Book.Pages != "" || len(letters.Warnings) != 3 {
+		t.Errorf("second entry warnings = %v, want the type, editor and pages", letters.Warnings)
+	}
+
+	unfinished := entries[2]
+	if unfinished.Ok || !strings.Contains(strings.Join(unfinished.Warnings, "\n"), "no ER line") {
+		t.Errorf("third entry: ok %v, warnings %v", unfinished.Ok, unfinished.Warnings)
+	}
+}
+
+func TestReadRISIgnoresTextOutsideReferences(t *testing.T) {
+	entries, err := ReadRIS(strings.NewReader("exported by a reference manager\nER  - \nnot a tag\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries, want none", len(entries))
+	}
+}
@@ -0,0 +1,87 @@
+// Package covers keeps the cover images of books on disk, one JPEG per book
+// named by its id.
+package covers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dir is where covers are kept, set by COVERS_DIR.
+func Dir() string {
+	dir := os.Getenv("COVERS_DIR")
+	// Dev
+	if dir == "" {
+		dir = "./covers"
+	}
+	return dir
+}
+
+// Path is where the cover of the book with id is kept.
+func Path(bookId int) string {
+	return filepath.Join(Dir(), strconv.Itoa(bookId)+".jpg")
+}
+
+// Exists is true when the book with id has a cover.
+func Exists(bookId int) bool {
+	_, err := os.Stat(Path(bookId))
+	return err == nil
+}
+
+// Copy keeps the image at src as the cover of the book with id, replacing
+// any it had.
+func Copy(bookId int, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("unable to read cover: %v", err)
+	}
+	defer in.Close()
+	err = os.MkdirAll(Dir(), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to save cover: %v", err)
+	}
+
+	// Written beside the cover and renamed, so a cover is never half written
+	out, err := os.CreateTemp(Dir(), "cover-*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to save cover: %v", err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), Path(bookId))
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return fmt.Errorf("unable to save cover: %v", err)
+	}
+	return nil
+}
+
+// Ids lists the books that have a cover.
+func Ids() ([]int, error) {
+	entries, err := os.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list covers: %v", err)
+	}
+	var ids []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jpg")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if id, err := strconv.Atoi(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,374 @@
+// Package cql parses the Contextual Query Language used by SRU, such as
+//
+//	dc.title any "rings" and dc.creator = tolkien sortBy dc.date/sort.descending
+//
+// into a tree of clauses joined by booleans.
+package cql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a Clause or a Boolean.
+type Node interface {
+	node()
+}
+
+// Clause tests the value of an index. A bare term is a clause on
+// cql.serverChoice with the = relation.
+type Clause struct {
+	Index    string
+	Relation Relation
+	// Term keeps backslash escapes, so an escaped * or ? can be told apart
+	// from a masking character
+	Term string
+	// Prefixes are the context set prefixes assigned where the clause is,
+	// with "" for the default set
+	Prefixes map[string]string
+}
+
+type Relation struct {
+	Name      string
+	Modifiers []Modifier
+}
+
+// Modifier is written /name, or /name=value with any comparison symbol.
+type Modifier struct {
+	Name       string
+	Comparison string
+	Value      string
+}
+
+// Boolean joins two nodes with and, or, not or prox. Booleans of the same
+// level are left associative.
+type Boolean struct {
+	Operator  string
+	Modifiers []Modifier
+	Left      Node
+	Right     Node
+}
+
+func (*Clause) node()  {}
+func (*Boolean) node() {}
+
+type SortKey struct {
+	Index     string
+	Modifiers []Modifier
+}
+
+// Query is a parsed query. Prefixes maps the context set prefixes assigned
+// at the start of the query to their identifiers.
+type Query struct {
+	Root     Node
+	Prefixes map[string]string
+	SortKeys []SortKey
+}
+
+// SyntaxError describes where a query stopped making sense.
+type SyntaxError struct {
+	Offset  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("CQL syntax error at %d: %s", e.Offset, e.Message)
+}
+
+const ServerChoice = "cql.serverChoice"
+
+var booleans = map[string]bool{"and": true, "or": true, "not": true, "prox": true}
+
+var comparisons = map[string]bool{"=": true, "==": true, "<>": true, "<": true, ">": true, "<=": true, ">=": true}
+
+// Parse reads a CQL query.
+func Parse(s string) (*Query, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, end: len(s)}
+	query := &Query{Prefixes: map[string]string{}}
+
+	for p.peek().is(">") {
+		err = p.prefixAssignment(query.Prefixes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	query.Root, err = p.query(query.Prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().isWord("sortby") {
+		p.next()
+		query.SortKeys, err = p.sortKeys()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return query, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenQuoted
+	tokenSymbol
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func (t token) is(symbol string) bool {
+	return t.kind == tokenSymbol && t.text == symbol
+}
+
+// isWord matches an unquoted word, ignoring case as CQL does for its
+// keywords.
+func (t token) isWord(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+// isTerm is true for anything that can be an index, term or relation name.
+func (t token) isTerm() bool {
+	return t.kind == tokenWord || t.kind == tokenQuoted
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '/':
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(c), offset: i})
+			i++
+		case c == '=' || c == '<' || c == '>':
+			symbol := string(c)
+			if i+1 < len(s) && comparisons[s[i:i+2]] {
+				symbol = s[i : i+2]
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: symbol, offset: i})
+			i += len(symbol)
+		case c == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &SyntaxError{Offset: start, Message: "unterminated quoted string"}
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				// A backslash keeps the next character, masking characters
+				// included, which stay escaped for the caller
+				if s[i] == '\\' && i+1 < len(s) {
+					if s[i+1] != '"' {
+						sb.WriteByte('\\')
+					}
+					i++
+				}
+				sb.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenQuoted, text: sb.String(), offset: start})
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r()/=<>\"", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[start:i], offset: start})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	end    int
+}
+
+func (p *parser) peek() token {
+	return p.peekAt(0)
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return token{kind: tokenEnd, offset: p.end}
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	if t.kind == tokenEnd {
+		return &SyntaxError{Offset: t.offset, Message: "query ends too early"}
+	}
+	return &SyntaxError{Offset: t.offset, Message: fmt.Sprintf(format, args...)}
+}
+
+// prefixAssignment reads >prefix="identifier" or >"identifier", the latter
+// setting the default context set.
+func (p *parser) prefixAssignment(prefixes map[string]string) error {
+	p.next()
+	name := p.next()
+	if !name.isTerm() {
+		return p.errorf(name, "expected a context set after >")
+	}
+	if !p.peek().is("=") {
+		prefixes[""] = name.text
+		return nil
+	}
+	p.next()
+	identifier := p.next()
+	if !identifier.isTerm() {
+		return p.errorf(identifier, "expected a context set identifier")
+	}
+	prefixes[name.text] = identifier.text
+	return nil
+}
+
+// query reads clauses joined by booleans, up to a closing parenthesis,
+// sortBy or the end.
+func (p *parser) query(prefixes map[string]string) (Node, error) {
+	left, err := p.searchClause(prefixes)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenWord || !booleans[strings.ToLower(t.text)] {
+			return left, nil
+		}
+		p.next()
+		b := &Boolean{Operator: strings.ToLower(t.text), Left: left}
+		b.Modifiers, err = p.modifiers()
+		if err != nil {
+			return nil, err
+		}
+		b.Right, err = p.searchClause(prefixes)
+		if err != nil {
+			return nil, err
+		}
+		left = b
+	}
+}
+
+func (p *parser) searchClause(prefixes map[string]string) (Node, error) {
+	t := p.peek()
+	if t.is("(") {
+		p.next()
+		node, err := p.query(prefixes)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); !closing.is(")") {
+			return nil, p.errorf(closing, "expected ) instead of %q", closing.text)
+		}
+		return node, nil
+	}
+	if t.is(">") {
+		// A prefix assignment scoped to this clause
+		inner := map[string]string{}
+		for key, value := range prefixes {
+			inner[key] = value
+		}
+		err := p.prefixAssignment(inner)
+		if err != nil {
+			return nil, err
+		}
+		return p.searchClause(inner)
+	}
+	if !t.isTerm() {
+		return nil, p.errorf(t, "expected a search term instead of %q", t.text)
+	}
+	p.next()
+
+	relation := p.peek()
+	isRelation := relation.kind == tokenSymbol && comparisons[relation.text]
+	// A word is a named relation such as any when a term follows it
+	if relation.kind == tokenWord && !booleans[strings.ToLower(relation.text)] && !relation.isWord("sortby") {
+		isRelation = p.peekAt(1).isTerm() || p.peekAt(1).is("/")
+	}
+	if !isRelation {
+		return &Clause{Index: ServerChoice, Relation: Relation{Name: "="}, Term: t.text}, nil
+	}
+	p.next()
+
+	clause := &Clause{Index: t.text, Relation: Relation{Name: strings.ToLower(relation.text)}}
+	if len(prefixes) > 0 {
+		clause.Prefixes = prefixes
+	}
+	var err error
+	clause.Relation.Modifiers, err = p.modifiers()
+	if err != nil {
+		return nil, err
+	}
+	term := p.next()
+	if !term.isTerm() {
+		return nil, p.errorf(term, "expected a search term instead of %q", term.text)
+	}
+	clause.Term = term.text
+	return clause, nil
+}
+
+func (p *parser) modifiers() ([]Modifier, error) {
+	var modifiers []Modifier
+	for p.peek().is("/") {
+		p.next()
+		name := p.next()
+		if !name.isTerm() {
+			return nil, p.errorf(name, "expected a modifier after /")
+		}
+		modifier := Modifier{Name: strings.ToLower(name.text)}
+		if t := p.peek(); t.kind == tokenSymbol && comparisons[t.text] {
+			p.next()
+			value := p.next()
+			if !value.isTerm() {
+				return nil, p.errorf(value, "expected a modifier value")
+			}
+			modifier.Comparison = t.text
+			modifier.Value = value.text
+		}
+		modifiers = append(modifiers, modifier)
+	}
+	return modifiers, nil
+}
+
+func (p *parser) sortKeys() ([]SortKey, error) {
+	var keys []SortKey
+	for p.peek().isTerm() {
+		key := SortKey{Index: p.next().text}
+		var err error
+		key.Modifiers, err = p.modifiers()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, p.errorf(p.peek(), "expected an index after sortBy")
+	}
+	return keys, nil
+}
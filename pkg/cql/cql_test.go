@@ -0,0 +1,145 @@
+package cql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// format writes a node with its booleans in parentheses and its terms in
+// brackets, so the tree Parse made can be compared as text.
+func format(node Node) string {
+	switch n := node.(type) {
+	case *Clause:
+		return fmt.Sprintf("%s %s%s [%s]", n.Index, n.Relation.Name, formatModifiers(n.Relation.Modifiers), n.Term)
+	case *Boolean:
+		return fmt.Sprintf("(%s %s%s %s)", format(n.Left), n.Operator, formatModifiers(n.Modifiers), format(n.Right))
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func formatModifiers(modifiers []Modifier) string {
+	var sb strings.Builder
+	for _, m := range modifiers {
+		sb.WriteString("/" + m.Name + m.Comparison + m.Value)
+	}
+	return sb.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"rings", "cql.serverChoice = [rings]"},
+		{`"lord of the rings"`, "cql.serverChoice = [lord of the rings]"},
+		{"dc.title any rings", "dc.title any [rings]"},
+		{"dc.title ANY rings", "dc.title any [rings]"},
+		{`dc.title = "the hobbit"`, "dc.title = [the hobbit]"},
+		{"dc.title==hobbit", "dc.title == [hobbit]"},
+		{"dc.date >= 1950", "dc.date >= [1950]"},
+		{"dc.date<>1950", "dc.date <> [1950]"},
+		{`dc.title = "say \"hi\""`, `dc.title = [say "hi"]`},
+		{`dc.title = "hob\*"`, `dc.title = [hob\*]`},
+		{"dc.title = hob*", "dc.title = [hob*]"},
+		{"dc.title =/relevant/cql.unmasked hobbit", "dc.title =/relevant/cql.unmasked [hobbit]"},
+		{"a and b", "(cql.serverChoice = [a] and cql.serverChoice = [b])"},
+		{"a AND b OR c", "((cql.serverChoice = [a] and cql.serverChoice = [b]) or cql.serverChoice = [c])"},
+		{"a and (b or c)", "(cql.serverChoice = [a] and (cql.serverChoice = [b] or cql.serverChoice = [c]))"},
+		{"((a))", "cql.serverChoice = [a]"},
+		{"a not b", "(cql.serverChoice = [a] not cql.serverChoice = [b])"},
+		{
+			"dc.title = hobbit prox/unit=word/distance<=2 dc.creator = tolkien",
+			"(dc.title = [hobbit] prox/unit=word/distance<=2 dc.creator = [tolkien])",
+		},
+		{"dc.title any rings sortBy dc.date", "dc.title any [rings]"},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := Parse(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(query.Root); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSortKeys(t *testing.T) {
+	query, err := Parse("dc.title any rings sortBy dc.date/sort.descending dc.title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(query.SortKeys) != 2 {
+		t.Fatalf("got %d sort keys, want 2", len(query.SortKeys))
+	}
+	date := query.SortKeys[0]
+	if date.Index != "dc.date" || len(date.Modifiers) != 1 || date.Modifiers[0].Name != "sort.descending" {
+		t.Errorf("first sort key = %+v", date)
+	}
+	if title := query.SortKeys[1]; title.Index != "dc.title" || len(title.Modifiers) != 0 {
+		t.Errorf("second sort key = %+v", title)
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	query, err := Parse(`>dc="info:srw/cql-context-set/1/dc-v1.1" >"info:default" dc.title = a and >x="info:x" x.title = b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Prefixes["dc"] != "info:srw/cql-context-set/1/dc-v1.1" || query.Prefixes[""] != "info:default" {
+		t.Errorf("query prefixes = %v", query.Prefixes)
+	}
+	if _, ok := query.Prefixes["x"]; ok {
+		t.Errorf("prefix scoped to a clause leaked into the query: %v", query.Prefixes)
+	}
+	b, ok := query.Root.(*Boolean)
+	if !ok {
+		t.Fatalf("root is %s", format(query.Root))
+	}
+	left, right := b.Left.(*Clause), b.Right.(*Clause)
+	if left.Prefixes["dc"] == "" || left.Prefixes["x"] != "" {
+		t.Errorf("left clause prefixes = %v", left.Prefixes)
+	}
+	if right.Prefixes["x"] != "info:x" || right.Prefixes["dc"] == "" {
+		t.Errorf("right clause prefixes = %v", right.Prefixes)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"", 0},
+		{`"unterminated`, 0},
+		{"dc.title = ", 11},
+		{"(a", 2},
+		{"a)", 1},
+		{"a and", 5},
+		{"cat dog", 4},
+		{"a sortBy", 8},
+		{"dc.title =/ hobbit", 18},
+		{"dc.title =/x= hobbit", 20},
+		{"dc.title =/) hobbit", 11},
+		{">", 1},
+		{">dc= a", 6},
+		{">dc=) a", 4},
+		{"= a", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := Parse(test.query)
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Fatalf("got %+v, %v, want a SyntaxError", query, err)
+			}
+			if syntax.Offset != test.offset {
+				t.Errorf("error at %d, want %d: %v", syntax.Offset, test.offset, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Snapshot writes a consistent copy of the database to the file at path
+// with SQLite's online backup, while other connections keep using it.
+func Snapshot(path string) error {
+	dst, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot: %v", err)
+	}
+	defer dst.Close()
+	err = copyDatabase(dst, Db)
+	if err != nil {
+		return fmt.Errorf("unable to create snapshot: %v", err)
+	}
+	return nil
+}
+
+// Restore replaces everything in the database with the database at path.
+// Every connection sees the restored data once it returns.
+func Restore(path string) error {
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("unable to restore database: %v", err)
+	}
+	defer src.Close()
+	err = copyDatabase(Db, src)
+	if err != nil {
+		return fmt.Errorf("unable to restore database: %v", err)
+	}
+	return nil
+}
+
+// copyDatabase copies all of src over dst in one backup step.
+func copyDatabase(dst *sql.DB, src *sql.DB) error {
+	ctx := context.Background()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			to, ok := dstDriver.(*sqlite3.SQLiteConn)
+			from, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("not a SQLite connection")
+			}
+			backup, err := to.Backup("main", from, "main")
+			if err != nil {
+				return err
+			}
+			done, err := backup.Step(-1)
+			finishErr := backup.Finish()
+			if err != nil {
+				return err
+			}
+			if !done {
+				return errors.New("backup did not finish")
+			}
+			return finishErr
+		})
+	})
+}
+
+const INTEGRITY_CHECK_QUERY = `PRAGMA integrity_check`
+
+// CheckIntegrity runs SQLite's integrity check on db.
+func CheckIntegrity(db *sql.DB) error {
+	var result string
+	err := db.QueryRow(INTEGRITY_CHECK_QUERY).Scan(&result)
+	if err != nil {
+		return fmt.Errorf("unable to check database: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database is damaged: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	hobbit := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := hobbit.Save(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := Snapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	giles := Book{Id: -1, LibraryId: 1, Title: "Farmer Giles of Ham", AuthorLast: "Tolkien"}
+	if _, err := giles.Save(); err != nil {
+		t.Fatal(err)
+	}
+	hobbit.Title = "The Hobbit, or There and Back Again"
+	if _, err := hobbit.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+	if err := CheckIntegrity(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(path); err != nil {
+		t.Fatal(err)
+	}
+	books, err := GetBooksList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].Title != "The Hobbit" || books[0].Version != 1 {
+		t.Errorf("books after restoring = %+v", books)
+	}
+}
@@ -2,24 +2,30 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
+
+	"mlibrary-htmx/pkg/dates"
 )
 
 type Book struct {
-	Id                  int
-	CreatedDate         time.Time
-	Lccn                string
-	Isbn                string
-	Title               string
-	AuthorLast          string
-	AuthorFirst         string
-	CopyrightDate       time.Time
-	CopyrightDateString string
-	Publisher           string
-	Location            string
-	Genre               string
-	Pages               string
+	Id                  int               `json:"id"`
+	CreatedDate         time.Time         `json:"created_at" format:"date-time"`
+	UpdatedDate         time.Time         `json:"updated_at" format:"date-time"`
+	Lccn                string            `json:"lccn"`
+	Isbn                string            `json:"isbn"`
+	Title               string            `json:"title"`
+	AuthorLast          string            `json:"author_last"`
+	AuthorFirst         string            `json:"author_first"`
+	CopyrightDate       dates.PartialDate `json:"-"`
+	CopyrightDateString string            `json:"copyright_date" format:"edtf"`
+	Publisher           string            `json:"publisher"`
+	Location            string            `json:"location"`
+	Genre               string            `json:"genre"`
+	Pages               string            `json:"pages"`
+	Version             int               `json:"version"`
+	LibraryId           int               `json:"-"`
 }
 
 type BookCsv struct {
@@ -28,7 +34,7 @@ type BookCsv struct {
 	Title         string
 	AuthorLast    string
 	AuthorFirst   string
-	CopyrightDate time.Time
+	CopyrightDate dates.PartialDate
 	Publisher     string
 	Location      string
 	Genre         string
@@ -37,237 +43,83 @@ type BookCsv struct {
 
 type ErrorMap = map[string]string
 
-const GET_BOOK_LIST_QUERY = "SELECT * FROM master_books"
-const PAGINATE_BOOK_LIST_QUERY = "SELECT * FROM master_books WHERE id > $1 ORDER BY id LIMIT 25;"
-const PAGINATE_BOOK_LIST_SORT_BY_QUERY = "SELECT * FROM master_books WHERE id > $1 ORDER BY %s LIMIT 25;"
-const GET_BOOK_BY_ID_QUERY = "SELECT * FROM master_books WHERE id = $1"
-const DELETE_BOOK_BY_ID_QUERY = "DELETE FROM master_books WHERE id = $1"
-const FILTER_BOOKS_QUERY = `SELECT * FROM master_books
-WHERE (
- lccn like $1 or
- isbn like $1 or 
- title like $1 or 
- author_first like $1 or  
- author_last like $1 or  
- copyright_date like $1 or
- publisher like $1 or
- location like $1 or
- genre like $1
+// ErrVersionConflict is returned by Save when the stored book has been
+// changed since the copy being saved was read.
+var ErrVersionConflict = errors.New("book was changed since it was loaded")
+
+var ErrBookNotFound = errors.New("book not found")
+
+// Copyright dates are read as text, as the driver turns DATE columns it
+// cannot read as a time into year 1
+const BOOK_COLUMNS = "id, created_at, lccn, isbn, title, author_first, author_last, CAST(copyright_date AS TEXT), publisher, location, genre, pages, version, library_id, updated_at"
+
+// Every query is scoped to a single library, always passed as the first parameter.
+const GET_BOOK_LIST_QUERY = "SELECT " + BOOK_COLUMNS + " FROM master_books WHERE library_id = $1"
+const PAGINATE_BOOK_LIST_QUERY = "SELECT " + BOOK_COLUMNS + " FROM master_books WHERE library_id = $1 AND id > $2 ORDER BY id LIMIT 25;"
+const PAGINATE_BOOK_LIST_SORT_BY_QUERY = "SELECT " + BOOK_COLUMNS + " FROM master_books WHERE library_id = $1 AND id > $2 ORDER BY %s LIMIT 25;"
+const GET_BOOK_BY_ID_QUERY = "SELECT " + BOOK_COLUMNS + " FROM master_books WHERE library_id = $1 AND id = $2"
+const DELETE_BOOK_BY_ID_QUERY = "DELETE FROM master_books WHERE library_id = $1 AND id = $2"
+const INSERT_DELETED_BOOK_QUERY = `INSERT OR REPLACE INTO deleted_books (book_id, library_id, genre)
+SELECT id, library_id, genre FROM master_books WHERE library_id = ? AND id = ?`
+const FILTER_BOOKS_QUERY = `SELECT ` + BOOK_COLUMNS + ` FROM master_books
+WHERE library_id = $1 AND (
+ lccn like $2 or
+ isbn like $2 or
+ title like $2 or
+ author_first like $2 or
+ author_last like $2 or
+ copyright_date like $2 or
+ publisher like $2 or
+ location like $2 or
+ genre like $2
 )`
 
-// 9 values
-const INSERT_BOOK_QUERY = `INSERT INTO master_books (lccn, isbn, title, author_first, author_last, copyright_date, publisher, location, genre, pages) values (?,?,?,?,?,?,?,?,?,?)`
+// 11 values
+const INSERT_BOOK_QUERY = `INSERT INTO master_books (lccn, isbn, title, author_first, author_last, copyright_date, publisher, location, genre, pages, library_id, updated_at) values (?,?,?,?,?,?,?,?,?,?,?,CURRENT_TIMESTAMP)`
+
+// 13 Values. Ending with id, library and the version being replaced
+const UPDATE_BOOK_QUERY = `UPDATE master_books SET lccn = ?, isbn = ?, title = ?, author_first = ?, author_last = ?, copyright_date = ?, publisher = ?, location = ?, genre = ?, pages = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND library_id = ? AND version = ?`
 
-// 6 Values. Ending with id
-const UPDATE_BOOK_QUERY = `UPDATE master_books SET lccn = ?, isbn = ?, title = ?, author_first = ?, author_last = ?, copyright_date = ?, publisher = ?, location = ?, genre = ?, pages = ? WHERE id = ?`
+const BOOK_EXISTS_QUERY = "SELECT COUNT(*) FROM master_books WHERE id = ? AND library_id = ?"
 
-func GetBooksList() ([]Book, error) {
-	res, err := Db.Query(GET_BOOK_LIST_QUERY)
+func GetBooksList(libraryId int) ([]Book, error) {
+	res, err := Db.Query(GET_BOOK_LIST_QUERY, libraryId)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Close()
 
-	var books []Book
-
-	for res.Next() {
-		var lccn sql.NullString
-		var isbn sql.NullString
-		var title sql.NullString
-		var author_first sql.NullString
-		var author_last sql.NullString
-		var copyright_date_string sql.NullString
-		var publisher sql.NullString
-		var location sql.NullString
-		var genre sql.NullString
-		var pages sql.NullString
-		var _created_at string
-		var _id int
-
-		err = res.Scan(
-			&_id,
-			&_created_at,
-			&lccn,
-			&isbn,
-			&title,
-			&author_first,
-			&author_last,
-			&copyright_date_string,
-			&publisher,
-			&location,
-			&genre,
-			&pages,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("unable to scan db row: %v", err)
-		}
-
-		layout := "2006-01-02T15:04:05Z"
-		copyright_date, err := time.Parse(layout, getValidNullStr(copyright_date_string))
-		if err != nil {
-			return nil, fmt.Errorf("error parsing date string: %v", err)
-		}
-
-		book := Book{
-			Lccn:                getValidNullStr(lccn),
-			Isbn:                getValidNullStr(isbn),
-			Title:               getValidNullStr(title),
-			AuthorFirst:         getValidNullStr(author_first),
-			AuthorLast:          getValidNullStr(author_last),
-			CopyrightDate:       copyright_date,
-			CopyrightDateString: copyright_date.Format("2006-01-02"),
-			Publisher:           getValidNullStr(publisher),
-			Location:            getValidNullStr(location),
-			Genre:               getValidNullStr(genre),
-			Pages:               getValidNullStr(pages),
-			Id:                  _id,
-		}
-
-		books = append(books, book)
-	}
-
-	return books, nil
+	return scanBooks(res)
 }
 
-func PaginateBooks(lastId int) ([]Book, error) {
-	res, err := Db.Query(PAGINATE_BOOK_LIST_QUERY, lastId)
+func PaginateBooks(libraryId int, lastId int) ([]Book, error) {
+	res, err := Db.Query(PAGINATE_BOOK_LIST_QUERY, libraryId, lastId)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Close()
 
-	var books []Book
-
-	for res.Next() {
-		var lccn sql.NullString
-		var isbn sql.NullString
-		var title sql.NullString
-		var author_last sql.NullString
-		var author_first sql.NullString
-		var copyright_date_string sql.NullString
-		var publisher sql.NullString
-		var location sql.NullString
-		var genre sql.NullString
-		var pages sql.NullString
-		var _created_at string
-		var _id int
-
-		err = res.Scan(
-			&_id,
-			&_created_at,
-			&lccn,
-			&isbn,
-			&title,
-			&author_first,
-			&author_last,
-			&copyright_date_string,
-			&publisher,
-			&location,
-			&genre,
-			&pages,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("unable to scan db row: %v", err)
-		}
-
-		layout := "2006-01-02T15:04:05Z"
-		copyright_date, err := time.Parse(layout, getValidNullStr(copyright_date_string))
-		if err != nil {
-			return nil, fmt.Errorf("error parsing date string: %v", err)
-		}
-
-		book := Book{
-			Lccn:                getValidNullStr(lccn),
-			Isbn:                getValidNullStr(isbn),
-			Title:               getValidNullStr(title),
-			AuthorFirst:         getValidNullStr(author_first),
-			AuthorLast:          getValidNullStr(author_last),
-			CopyrightDate:       copyright_date,
-			CopyrightDateString: copyright_date.Format("2006-01-02"),
-			Publisher:           getValidNullStr(publisher),
-			Location:            getValidNullStr(location),
-			Genre:               getValidNullStr(genre),
-			Pages:               getValidNullStr(pages),
-			Id:                  _id,
-		}
-
-		books = append(books, book)
-	}
-
-	return books, nil
+	return scanBooks(res)
 }
 
-func SortAndPaginateBooks(lastId int, sortBy string) ([]Book, error) {
+func SortAndPaginateBooks(libraryId int, lastId int, sortBy string) ([]Book, error) {
+	// A date that is only a year is stored as a number, which would sort
+	// before every other date
+	if sortBy == "copyright_date" {
+		sortBy = "CAST(copyright_date AS TEXT)"
+	}
 	queryString := fmt.Sprintf(PAGINATE_BOOK_LIST_SORT_BY_QUERY, sortBy)
-	res, err := Db.Query(queryString, lastId)
+	res, err := Db.Query(queryString, libraryId, lastId)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Close()
 
-	var books []Book
-
-	for res.Next() {
-		var lccn sql.NullString
-		var isbn sql.NullString
-		var title sql.NullString
-		var author_first sql.NullString
-		var author_last sql.NullString
-		var copyright_date_string sql.NullString
-		var publisher sql.NullString
-		var location sql.NullString
-		var genre sql.NullString
-		var pages sql.NullString
-		var _created_at string
-		var _id int
-
-		err = res.Scan(
-			&_id,
-			&_created_at,
-			&lccn,
-			&isbn,
-			&title,
-			&author_first,
-			&author_last,
-			&copyright_date_string,
-			&publisher,
-			&location,
-			&genre,
-			&pages,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("unable to scan db row: %v", err)
-		}
-
-		layout := "2006-01-02T15:04:05Z"
-		copyright_date, err := time.Parse(layout, getValidNullStr(copyright_date_string))
-		if err != nil {
-			return nil, fmt.Errorf("error parsing date string: %v", err)
-		}
-
-		book := Book{
-			Lccn:                getValidNullStr(lccn),
-			Isbn:                getValidNullStr(isbn),
-			Title:               getValidNullStr(title),
-			AuthorFirst:         getValidNullStr(author_first),
-			AuthorLast:          getValidNullStr(author_last),
-			CopyrightDate:       copyright_date,
-			CopyrightDateString: copyright_date.Format("2006-01-02"),
-			Publisher:           getValidNullStr(publisher),
-			Location:            getValidNullStr(location),
-			Genre:               getValidNullStr(genre),
-			Pages:               getValidNullStr(pages),
-			Id:                  _id,
-		}
-
-		books = append(books, book)
-	}
-
-	return books, nil
+	return scanBooks(res)
 }
 
-func GetBookById(id int) (*Book, error) {
-	res, err := Db.Query(GET_BOOK_BY_ID_QUERY, id)
+func GetBookById(libraryId int, id int) (*Book, error) {
+	res, err := Db.Query(GET_BOOK_BY_ID_QUERY, libraryId, id)
 	if err != nil {
 		return nil, err
 	}
@@ -276,140 +128,147 @@ func GetBookById(id int) (*Book, error) {
 	var book Book
 
 	if res.Next() {
-		var lccn sql.NullString
-		var isbn sql.NullString
-		var title sql.NullString
-		var author_first sql.NullString
-		var author_last sql.NullString
-		var copyright_date_string sql.NullString
-		var publisher sql.NullString
-		var location sql.NullString
-		var genre sql.NullString
-		var pages sql.NullString
-		var _created_at string
-		var _id int
-
-		err = res.Scan(
-			&_id,
-			&_created_at,
-			&lccn,
-			&isbn,
-			&title,
-			&author_first,
-			&author_last,
-			&copyright_date_string,
-			&publisher,
-			&location,
-			&genre,
-			&pages,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("unable to scan db row: %v", err)
-		}
-
-		layout := "2006-01-02T15:04:05Z"
-		copyright_date, err := time.Parse(layout, getValidNullStr(copyright_date_string))
+		scanned, err := scanBook(res)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing date string: %v", err)
-		}
-
-		book = Book{
-			Lccn:                getValidNullStr(lccn),
-			Isbn:                getValidNullStr(isbn),
-			Title:               getValidNullStr(title),
-			AuthorFirst:         getValidNullStr(author_first),
-			AuthorLast:          getValidNullStr(author_last),
-			CopyrightDate:       copyright_date,
-			CopyrightDateString: copyright_date.Format("2006-01-02"),
-			Publisher:           getValidNullStr(publisher),
-			Location:            getValidNullStr(location),
-			Genre:               getValidNullStr(genre),
-			Pages:               getValidNullStr(pages),
-			Id:                  _id,
+			return nil, err
 		}
+		book = *scanned
 	}
 
 	return &book, nil
 }
 
-func DeleteBook(id int) error {
-	_, err := Db.Exec(DELETE_BOOK_BY_ID_QUERY, id)
+// DeleteBook removes a book, leaving a tombstone in deleted_books so the
+// deletion can be harvested.
+func DeleteBook(libraryId int, id int) error {
+	tx, err := Db.Begin()
 	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(INSERT_DELETED_BOOK_QUERY, libraryId, id)
+	if err == nil {
+		_, err = tx.Exec(DELETE_BOOK_BY_ID_QUERY, libraryId, id)
+	}
+	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("unable to delete contact from db: %v", err)
 	}
 
-	return nil
+	return tx.Commit()
 }
 
-func FilterBook(q string) ([]Book, error) {
-	res, err := Db.Query(FILTER_BOOKS_QUERY, "%"+q+"%")
+func FilterBook(libraryId int, q string) ([]Book, error) {
+	res, err := Db.Query(FILTER_BOOKS_QUERY, libraryId, "%"+q+"%")
 
 	if err != nil {
 		return nil, fmt.Errorf("unable to query db: %v", err)
 	}
 	defer res.Close()
 
+	return scanBooks(res)
+}
+
+func scanBooks(res *sql.Rows) ([]Book, error) {
 	var books []Book
 	for res.Next() {
-		var lccn sql.NullString
-		var isbn sql.NullString
-		var title sql.NullString
-		var author_first sql.NullString
-		var author_last sql.NullString
-		var copyright_date_string sql.NullString
-		var publisher sql.NullString
-		var location sql.NullString
-		var genre sql.NullString
-		var pages sql.NullString
-		var _created_at string
-		var _id int
-
-		err = res.Scan(
-			&_id,
-			&_created_at,
-			&lccn,
-			&isbn,
-			&title,
-			&author_first,
-			&author_last,
-			&copyright_date_string,
-			&publisher,
-			&location,
-			&genre,
-			&pages,
-		)
+		book, err := scanBook(res)
 		if err != nil {
-			return nil, fmt.Errorf("unable to scan db row: %v", err)
+			return nil, err
 		}
+		books = append(books, *book)
+	}
 
-		layout := "2006-01-02T15:04:05Z"
-		copyright_date, err := time.Parse(layout, getValidNullStr(copyright_date_string))
-		if err != nil {
-			return nil, fmt.Errorf("error parsing date string: %v", err)
-		}
+	return books, nil
+}
 
-		book := Book{
-			Lccn:                getValidNullStr(lccn),
-			Isbn:                getValidNullStr(isbn),
-			Title:               getValidNullStr(title),
-			AuthorFirst:         getValidNullStr(author_first),
-			AuthorLast:          getValidNullStr(author_last),
-			CopyrightDate:       copyright_date,
-			CopyrightDateString: copyright_date.Format("2006-01-02"),
-			Publisher:           getValidNullStr(publisher),
-			Location:            getValidNullStr(location),
-			Genre:               getValidNullStr(genre),
-			Pages:               getValidNullStr(pages),
-			Id:                  _id,
-		}
+// scanBook reads a single row selected with BOOK_COLUMNS, followed by any
+// extra columns the query selected into extra.
+func scanBook(res *sql.Rows, extra ...interface{}) (*Book, error) {
+	var lccn sql.NullString
+	var isbn sql.NullString
+	var title sql.NullString
+	var author_first sql.NullString
+	var author_last sql.NullString
+	var copyright_date dates.PartialDate
+	var publisher sql.NullString
+	var location sql.NullString
+	var genre sql.NullString
+	var pages sql.NullString
+	var _created_at string
+	var _updated_at sql.NullString
+	var _id int
+	var _version int
+	var _library_id int
+
+	dest := []interface{}{
+		&_id,
+		&_created_at,
+		&lccn,
+		&isbn,
+		&title,
+		&author_first,
+		&author_last,
+		&copyright_date,
+		&publisher,
+		&location,
+		&genre,
+		&pages,
+		&_version,
+		&_library_id,
+		&_updated_at,
+	}
+	err := res.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan db row: %v", err)
+	}
 
-		books = append(books, book)
+	layout := "2006-01-02T15:04:05Z"
+	created_at, _ := time.Parse(layout, _created_at)
+	updated_at, err := time.Parse(layout, _updated_at.String)
+	if err != nil {
+		updated_at = created_at
 	}
 
-	return books, nil
+	return &Book{
+		Lccn:                getValidNullStr(lccn),
+		Isbn:                getValidNullStr(isbn),
+		Title:               getValidNullStr(title),
+		AuthorFirst:         getValidNullStr(author_first),
+		AuthorLast:          getValidNullStr(author_last),
+		CopyrightDate:       copyright_date,
+		CopyrightDateString: copyright_date.String(),
+		Publisher:           getValidNullStr(publisher),
+		Location:            getValidNullStr(location),
+		Genre:               getValidNullStr(genre),
+		Pages:               getValidNullStr(pages),
+		Id:                  _id,
+		CreatedDate:         created_at,
+		UpdatedDate:         updated_at,
+		Version:             _version,
+		LibraryId:           _library_id,
+	}, nil
 }
 
-func (b *Book) validate() ErrorMap {
+// SetCopyrightDate reads value as the copyright date of a book someone is
+// editing, returning a message when there is none or it cannot be read.
+// Once read, CopyrightDateString is rewritten in EDTF.
+func (b *Book) SetCopyrightDate(value string) string {
+	b.CopyrightDateString = value
+	date, err := dates.Parse(value)
+	if err != nil {
+		return "Copyright Date must be a date such as 1954, 03/12/1954, c1954 or 1950-1955"
+	}
+	if date.IsZero() {
+		return "Copyright Date Required"
+	}
+	b.CopyrightDate = date
+	b.CopyrightDateString = date.String()
+	return ""
+}
+
+// Validate reports the fields that keep the book from being saved, keyed by
+// column name.
+func (b *Book) Validate() ErrorMap {
 	errors := make(ErrorMap)
 	if b.AuthorLast == "" {
 		errors["author_last"] = "Author Last Name Required"
@@ -421,38 +280,151 @@ func (b *Book) validate() ErrorMap {
 	return errors
 }
 
+// Save creates the book when its Id is -1 and updates it otherwise. Updates
+// only succeed while b.Version still matches the stored version; if someone
+// else saved the book in the meantime ErrVersionConflict is returned and
+// nothing is written.
 func (b *Book) Save() (ErrorMap, error) {
-	errors := b.validate()
+	if b.Id == -1 {
+		return b.save("Created")
+	}
+	return b.save("Updated")
+}
+
+// save writes the book and snapshots the result as a new version, noting
+// what caused the change.
+func (b *Book) save(note string) (ErrorMap, error) {
+	errors := b.Validate()
 	if len(errors) > 0 {
 		return errors, nil
 	}
 
-	var err error
+	tx, err := Db.Begin()
+	if err != nil {
+		return errors, err
+	}
+
 	if b.Id == -1 {
-		_, err = Db.Exec(INSERT_BOOK_QUERY, b.Lccn, b.Isbn, b.Title, b.AuthorFirst, b.AuthorLast, b.CopyrightDate.Format("2006-01-02"), b.Publisher, b.Location, b.Genre, b.Pages)
+		err = b.insert(tx)
 	} else {
-		_, err = Db.Exec(UPDATE_BOOK_QUERY, b.Lccn, b.Isbn, b.Title, b.AuthorFirst, b.AuthorLast, b.CopyrightDate.Format("2006-01-02"), b.Publisher, b.Location, b.Genre, b.Pages, b.Id)
+		err = b.update(tx)
+	}
+	if err == nil {
+		err = snapshotBook(tx, b, note)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors, err
 	}
 
-	return errors, err
+	return errors, tx.Commit()
 }
 
-func BulkInsert(bookCsv []BookCsv) error {
+func (b *Book) insert(tx *sql.Tx) error {
+	res, err := tx.Exec(INSERT_BOOK_QUERY, b.Lccn, b.Isbn, b.Title, b.AuthorFirst, b.AuthorLast, b.CopyrightDate, b.Publisher, b.Location, b.Genre, b.Pages, b.LibraryId)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	b.Id = int(id)
+	b.Version = 1
+	return nil
+}
+
+func (b *Book) update(tx *sql.Tx) error {
+	res, err := tx.Exec(UPDATE_BOOK_QUERY, b.Lccn, b.Isbn, b.Title, b.AuthorFirst, b.AuthorLast, b.CopyrightDate, b.Publisher, b.Location, b.Genre, b.Pages, b.Id, b.LibraryId, b.Version)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var count int
+		err = tx.QueryRow(BOOK_EXISTS_QUERY, b.Id, b.LibraryId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrBookNotFound
+		}
+		return ErrVersionConflict
+	}
+	b.Version++
+	return nil
+}
+
+func BulkInsert(libraryId int, bookCsv []BookCsv) error {
+	var books []Book
+	for _, line := range bookCsv {
+		books = append(books, line.book())
+	}
+	return InsertBooks(libraryId, books)
+}
+
+// InsertBooks adds books to a library in one transaction, recording each
+// as an imported first version.
+func InsertBooks(libraryId int, books []Book) error {
+	return ImportBooks(libraryId, 0, books, nil)
+}
+
+// ImportBooks adds the books in creates and saves the changes to the books
+// in updates in one transaction, recording a version of each. Updates are
+// checked against the version they were read at, like Save. The books in
+// creates are given the ids they were stored under. The books and versions
+// are tagged with batchId unless it is 0.
+func ImportBooks(libraryId int, batchId int, creates []Book, updates []Book) error {
 	tx, err := Db.Begin()
 	if err != nil {
 		return err
 	}
 	stmt, err := tx.Prepare(INSERT_BOOK_QUERY)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
-	for _, line := range bookCsv {
-		_, err = stmt.Exec(line.Lccn, line.Isbn, line.Title, line.AuthorFirst, line.AuthorLast, line.CopyrightDate, line.Publisher, line.Location, line.Genre, line.Pages)
+	for i := range creates {
+		book := &creates[i]
+		res, err := stmt.Exec(book.Lccn, book.Isbn, book.Title, book.AuthorFirst, book.AuthorLast, book.CopyrightDate, book.Publisher, book.Location, book.Genre, book.Pages, libraryId)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		book.Id = int(id)
+		book.Version = 1
+		book.LibraryId = libraryId
+		err = snapshotBook(tx, book, "Imported")
+		if err == nil && batchId != 0 {
+			err = tagImport(tx, batchId, book)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, book := range updates {
+		book.LibraryId = libraryId
+		err = book.update(tx)
+		if err == nil {
+			err = snapshotBook(tx, &book, "Updated by import")
+		}
+		if err == nil && batchId != 0 {
+			err = tagImport(tx, batchId, &book)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to update book %d: %w", book.Id, err)
+		}
 	}
 	err = tx.Commit()
 	if err != nil {
@@ -461,6 +433,23 @@ func BulkInsert(bookCsv []BookCsv) error {
 	return nil
 }
 
+func (line BookCsv) book() Book {
+	return Book{
+		Lccn:                line.Lccn,
+		Isbn:                line.Isbn,
+		Title:               line.Title,
+		AuthorFirst:         line.AuthorFirst,
+		AuthorLast:          line.AuthorLast,
+		CopyrightDate:       line.CopyrightDate,
+		CopyrightDateString: line.CopyrightDate.String(),
+		Publisher:           line.Publisher,
+		Location:            line.Location,
+		Genre:               line.Genre,
+		Pages:               line.Pages,
+		Id:                  -1,
+	}
+}
+
 func getValidNullStr(nullString sql.NullString) string {
 	if nullString.Valid {
 		return nullString.String
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BASE_SCHEMA is master_books as it was before the first migration.
+const BASE_SCHEMA = `CREATE TABLE master_books (
+  id INTEGER PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  lccn TEXT DEFAULT NULL,
+  isbn TEXT DEFAULT NULL,
+  title TEXT DEFAULT NULL,
+  author_first TEXT DEFAULT NULL,
+  author_last TEXT DEFAULT NULL,
+  copyright_date DATE DEFAULT NULL,
+  publisher TEXT DEFAULT NULL,
+  location TEXT DEFAULT NULL,
+  genre TEXT DEFAULT NULL,
+  pages TEXT DEFAULT NULL
+)`
+
+// openTestDb creates a database with the base schema in a temporary
+// directory, applies the migrations up to version and makes it Db.
+func openTestDb(t *testing.T, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(BASE_SCHEMA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations := Migrations
+	Migrations = Migrations[:version]
+	_, err = Migrate(db, os.DirFS("../../sql"))
+	Migrations = migrations
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := Db
+	Db = db
+	t.Cleanup(func() { Db = previous })
+	return db
+}
+
+func TestSaveDetectsConflicts(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if book.Version != 1 {
+		t.Errorf("new book has version %d, want 1", book.Version)
+	}
+
+	// Two editors load the same version
+	first, second := book, book
+	first.Title = "The Hobbit, or There and Back Again"
+	if _, err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("saved book has version %d, want 2", first.Version)
+	}
+	second.Title = "Farmer Giles of Ham"
+	if _, err := second.Save(); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("saving a stale copy: got %v, want ErrVersionConflict", err)
+	}
+
+	// The losing save changes nothing
+	stored, err := GetBookById(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != first.Title || stored.Version != 2 {
+		t.Errorf("stored book is %q version %d", stored.Title, stored.Version)
+	}
+	versions, err := GetBookVersions(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("got %d versions, want 2", len(versions))
+	}
+
+	// Reloading gives the copy that can be saved
+	stored.Title = "Farmer Giles of Ham"
+	if _, err := stored.Save(); err != nil {
+		t.Errorf("saving the reloaded book: %v", err)
+	}
+}
+
+func TestSaveDeletedBook(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBook(1, book.Id); err != nil {
+		t.Fatal(err)
+	}
+	book.Title = "Farmer Giles of Ham"
+	if _, err := book.Save(); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("saving a deleted book: got %v, want ErrBookNotFound", err)
+	}
+}
+
+func TestSaveAfterDeletingNewestBook(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	first := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBook(1, first.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	second := Book{Id: -1, LibraryId: 1, Title: "Farmer Giles of Ham", AuthorLast: "Tolkien"}
+	if _, err := second.Save(); err != nil {
+		t.Fatalf("saving after a delete: %v", err)
+	}
+	if second.Id == first.Id {
+		t.Errorf("new book reused id %d of the deleted book", first.Id)
+	}
+	versions, err := GetBookVersions(1, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("new book has %d versions, want 1", len(versions))
+	}
+}
+
+func TestMigrationKeepsFreedIds(t *testing.T) {
+	db := openTestDb(t, SchemaVersion-1)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBook(1, book.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(db, os.DirFS("../../sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	next := Book{Id: -1, LibraryId: 1, Title: "Farmer Giles of Ham", AuthorLast: "Tolkien"}
+	if _, err := next.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if next.Id <= book.Id {
+		t.Errorf("new book got id %d, want more than the deleted %d", next.Id, book.Id)
+	}
+}
+
+func TestSetCopyrightDate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		message string
+	}{
+		{"c1954", "1954", ""},
+		{"03/12/1954", "1954-03-12", ""},
+		{"1950/1959", "1950/1959", ""},
+		{"", "", "Copyright Date Required"},
+		{"someday", "someday", "Copyright Date must be a date such as 1954, 03/12/1954, c1954 or 1950-1955"},
+	}
+	for _, test := range tests {
+		var book Book
+		message := book.SetCopyrightDate(test.value)
+		if message != test.message || book.CopyrightDateString != test.want {
+			t.Errorf("SetCopyrightDate(%q) = %q, %q, want %q, %q", test.value, message, book.CopyrightDateString, test.message, test.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BookChange is the latest change to a book: the save that last touched it,
+// or its deletion.
+type BookChange struct {
+	BookId    int
+	ChangedAt time.Time
+	Genre     string
+	Deleted   bool
+}
+
+// ChangeQuery selects the changes to a library's books in the order they
+// were made. Zero values leave a condition out.
+type ChangeQuery struct {
+	BookId int
+	// From and Until are inclusive, to the second
+	From  time.Time
+	Until time.Time
+	// Genres selects books in any of the genres; AnyGenre selects every
+	// book that has a genre at all
+	Genres   []string
+	AnyGenre bool
+	// After continues a listing past the change to book AfterId at After
+	After   time.Time
+	AfterId int
+	Limit   int
+}
+
+const changeTimeLayout = "2006-01-02T15:04:05Z"
+
+const LIST_BOOK_CHANGES_QUERY = `SELECT id, changed_at, genre, deleted FROM (
+ SELECT id, strftime('%Y-%m-%dT%H:%M:%SZ', updated_at) AS changed_at, COALESCE(genre, '') AS genre, 0 AS deleted
+ FROM master_books WHERE library_id = ?
+ UNION ALL
+ SELECT book_id, strftime('%Y-%m-%dT%H:%M:%SZ', deleted_at), COALESCE(genre, ''), 1
+ FROM deleted_books WHERE library_id = ?
+) WHERE changed_at IS NOT NULL`
+
+const LIST_CHANGE_GENRES_QUERY = `SELECT genre FROM master_books WHERE library_id = ? AND COALESCE(genre, '') <> ''
+UNION
+SELECT genre FROM deleted_books WHERE library_id = ? AND COALESCE(genre, '') <> ''
+ORDER BY genre`
+
+// ListBookChanges runs a ChangeQuery.
+func ListBookChanges(libraryId int, query ChangeQuery) ([]BookChange, error) {
+	var sb strings.Builder
+	args := []interface{}{libraryId, libraryId}
+	sb.WriteString(LIST_BOOK_CHANGES_QUERY)
+
+	if query.BookId != 0 {
+		sb.WriteString(" AND id = ?")
+		args = append(args, query.BookId)
+	}
+	if !query.From.IsZero() {
+		sb.WriteString(" AND changed_at >= ?")
+		args = append(args, query.From.UTC().Format(changeTimeLayout))
+	}
+	if !query.Until.IsZero() {
+		sb.WriteString(" AND changed_at <= ?")
+		args = append(args, query.Until.UTC().Format(changeTimeLayout))
+	}
+	if query.AnyGenre {
+		sb.WriteString(" AND genre <> ''")
+	} else if query.Genres != nil {
+		// SQLite takes an empty list, which matches nothing
+		fmt.Fprintf(&sb, " AND genre IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(query.Genres)), ","))
+		for _, genre := range query.Genres {
+			args = append(args, genre)
+		}
+	}
+	if !query.After.IsZero() {
+		after := query.After.UTC().Format(changeTimeLayout)
+		sb.WriteString(" AND (changed_at > ? OR (changed_at = ? AND id > ?))")
+		args = append(args, after, after, query.AfterId)
+	}
+	sb.WriteString(" ORDER BY changed_at, id")
+	if query.Limit > 0 {
+		sb.WriteString(" LIMIT ?")
+		args = append(args, query.Limit)
+	}
+
+	res, err := Db.Query(sb.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	changes := []BookChange{}
+	for res.Next() {
+		var change BookChange
+		var changedAt string
+		err = res.Scan(&change.BookId, &changedAt, &change.Genre, &change.Deleted)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan book change: %v", err)
+		}
+		change.ChangedAt, err = time.Parse(changeTimeLayout, changedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing change time: %v", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, res.Err()
+}
+
+// ListChangeGenres returns the genres of the books in a library and of
+// those deleted from it, which are every genre a change can have.
+func ListChangeGenres(libraryId int) ([]string, error) {
+	res, err := Db.Query(LIST_CHANGE_GENRES_QUERY, libraryId, libraryId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	genres := []string{}
+	for res.Next() {
+		var genre string
+		err = res.Scan(&genre)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan genre: %v", err)
+		}
+		genres = append(genres, genre)
+	}
+	return genres, res.Err()
+}
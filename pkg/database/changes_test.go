@@ -0,0 +1,41 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListChangeGenres(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	books := []Book{
+		{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien", Genre: "Fantasy"},
+		{Id: -1, LibraryId: 1, Title: "Beowulf", AuthorLast: "Heaney", Genre: "Poetry"},
+		{Id: -1, LibraryId: 1, Title: "Notes", AuthorLast: "Tolkien"},
+		{Id: -1, LibraryId: 2, Title: "Dune", AuthorLast: "Herbert", Genre: "Science fiction"},
+	}
+	for i := range books {
+		if _, err := books[i].Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The genre of a deleted book stays, so its deletion can be harvested
+	// from its set
+	if err := DeleteBook(1, books[1].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	genres, err := ListChangeGenres(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Fantasy", "Poetry"}; !reflect.DeepEqual(genres, want) {
+		t.Errorf("genres = %q, want %q", genres, want)
+	}
+	changes, err := ListBookChanges(1, ChangeQuery{Genres: genres})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || !changes[1].Deleted || changes[1].Genre != "Poetry" {
+		t.Errorf("changes = %+v, want the hobbit and the deleted poem", changes)
+	}
+}
@@ -10,6 +10,9 @@ import (
 
 var Db *sql.DB
 
+// FilePath is the file Db was opened from.
+var FilePath string
+
 func InitDb() error {
 	dbFilePath := os.Getenv("DB_FILE")
 	// Dev
@@ -21,5 +24,6 @@ func InitDb() error {
 		log.Fatal(err)
 	}
 	Db = db
+	FilePath = dbFilePath
 	return nil
 }
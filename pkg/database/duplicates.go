@@ -0,0 +1,166 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const FIND_BOOK_BY_ISBN_QUERY = "SELECT " + BOOK_COLUMNS + ` FROM master_books
+WHERE library_id = ? AND REPLACE(REPLACE(UPPER(isbn), '-', ''), ' ', '') IN (?, ?) ORDER BY id LIMIT 1`
+const FIND_BOOK_BY_LCCN_QUERY = "SELECT " + BOOK_COLUMNS + ` FROM master_books
+WHERE library_id = ? AND REPLACE(lccn, ' ', '') = ? ORDER BY id LIMIT 1`
+const FIND_BOOKS_BY_AUTHOR_QUERY = "SELECT " + BOOK_COLUMNS + ` FROM master_books
+WHERE library_id = ? AND TRIM(author_last) = ? COLLATE NOCASE ORDER BY id`
+
+// Match is a stored book an incoming one duplicates, and what they share.
+type Match struct {
+	Book Book
+	By   string
+}
+
+// FindDuplicate looks for a stored book that is the same as book, by ISBN,
+// then LCCN, then title and author. It returns nil when there is none.
+func FindDuplicate(libraryId int, book Book) (*Match, error) {
+	if isbn := NormalizeIsbn(book.Isbn); isbn != "" {
+		other := otherIsbn(isbn)
+		if other == "" {
+			other = isbn
+		}
+		match, err := findDuplicate(FIND_BOOK_BY_ISBN_QUERY, libraryId, isbn, other)
+		if match != nil || err != nil {
+			return withMatch(match, "ISBN", err)
+		}
+	}
+	if lccn := NormalizeLccn(book.Lccn); lccn != "" {
+		match, err := findDuplicate(FIND_BOOK_BY_LCCN_QUERY, libraryId, lccn)
+		if match != nil || err != nil {
+			return withMatch(match, "LCCN", err)
+		}
+	}
+
+	title := NormalizeTitle(book.Title)
+	author := strings.TrimSpace(book.AuthorLast)
+	if title == "" || author == "" {
+		return nil, nil
+	}
+	res, err := Db.Query(FIND_BOOKS_BY_AUTHOR_QUERY, libraryId, author)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find duplicates: %v", err)
+	}
+	defer res.Close()
+	books, err := scanBooks(res)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find duplicates: %v", err)
+	}
+	for _, stored := range books {
+		if NormalizeTitle(stored.Title) == title {
+			return &Match{Book: stored, By: "title and author"}, nil
+		}
+	}
+	return nil, nil
+}
+
+func findDuplicate(query string, libraryId int, args ...interface{}) (*Book, error) {
+	res, err := Db.Query(query, append([]interface{}{libraryId}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find duplicates: %v", err)
+	}
+	defer res.Close()
+	if !res.Next() {
+		return nil, res.Err()
+	}
+	return scanBook(res)
+}
+
+func withMatch(book *Book, by string, err error) (*Match, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Match{Book: *book, By: by}, nil
+}
+
+// DuplicateKeys are the values two books share when FindDuplicate would
+// match them, for spotting the same book twice in one import.
+func DuplicateKeys(book Book) []string {
+	var keys []string
+	if isbn := NormalizeIsbn(book.Isbn); isbn != "" {
+		keys = append(keys, "isbn:"+isbn)
+		if other := otherIsbn(isbn); other != "" {
+			keys = append(keys, "isbn:"+other)
+		}
+	}
+	if lccn := NormalizeLccn(book.Lccn); lccn != "" {
+		keys = append(keys, "lccn:"+lccn)
+	}
+	if title, author := NormalizeTitle(book.Title), strings.ToLower(strings.TrimSpace(book.AuthorLast)); title != "" && author != "" {
+		keys = append(keys, "title:"+author+"|"+title)
+	}
+	return keys
+}
+
+// NormalizeIsbn drops hyphens and spaces from an ISBN.
+func NormalizeIsbn(isbn string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+}
+
+func NormalizeLccn(lccn string) string {
+	return strings.ReplaceAll(strings.TrimSpace(lccn), " ", "")
+}
+
+// otherIsbn converts between the ISBN-10 and ISBN-13 forms of a book
+// number, returning "" when isbn has no other form.
+func otherIsbn(isbn string) string {
+	if isbn == "" || strings.Trim(isbn[:len(isbn)-1], "0123456789") != "" {
+		return ""
+	}
+	switch {
+	case len(isbn) == 10:
+		digits := "978" + isbn[:9]
+		return digits + isbn13CheckDigit(digits)
+	case len(isbn) == 13 && strings.HasPrefix(isbn, "978"):
+		digits := isbn[3:12]
+		sum := 0
+		for i, r := range digits {
+			sum += (10 - i) * int(r-'0')
+		}
+		check := (11 - sum%11) % 11
+		if check == 10 {
+			return digits + "X"
+		}
+		return digits + fmt.Sprint(check)
+	}
+	return ""
+}
+
+func isbn13CheckDigit(digits string) string {
+	sum := 0
+	for i, r := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return fmt.Sprint((10 - sum%10) % 10)
+}
+
+var leadingArticles = []string{"the ", "a ", "an "}
+
+// NormalizeTitle folds case and punctuation out of a title and drops a
+// leading article, so "The Hobbit." and "hobbit" compare equal.
+func NormalizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, title)
+	title = strings.Join(strings.Fields(title), " ")
+	for _, article := range leadingArticles {
+		if strings.HasPrefix(title, article) {
+			return strings.TrimPrefix(title, article)
+		}
+	}
+	return title
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BookFilter is a condition on master_books built from tests on single
+// columns, for searches BookQuery has no field for. Set it as the Filter of
+// a BookQuery.
+type BookFilter struct {
+	sql  string
+	args []interface{}
+	err  error
+}
+
+// AllBooks is a filter every book passes.
+var AllBooks = BookFilter{sql: "1 = 1"}
+
+var filterComparisons = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// filterColumn is the expression a filter tests for column, which must be
+// one of the SortableColumns. Missing values test as empty text.
+func filterColumn(column string) (string, error) {
+	if !IsSortableColumn(column) {
+		return "", fmt.Errorf("%w: cannot filter on %q", ErrInvalidQuery, column)
+	}
+	if column == "id" {
+		return "id", nil
+	}
+	return fmt.Sprintf("COALESCE(CAST(%s AS TEXT), '')", column), nil
+}
+
+// FieldLike matches column against a LIKE pattern, ignoring case. % and _
+// are the wildcards and a backslash escapes them.
+func FieldLike(column string, pattern string) BookFilter {
+	expression, err := filterColumn(column)
+	if err != nil {
+		return BookFilter{err: err}
+	}
+	return BookFilter{sql: expression + ` LIKE ? ESCAPE '\'`, args: []interface{}{pattern}}
+}
+
+// FieldCompare compares column with value using =, <>, <, <=, > or >=.
+// Text compares by its characters, so dates written as 2006-01-02 order
+// correctly. Dates compare only as far as value goes: 1937 is equal to
+// every date in 1937 and later than none of them.
+func FieldCompare(column string, comparison string, value interface{}) BookFilter {
+	expression, err := filterColumn(column)
+	if err != nil {
+		return BookFilter{err: err}
+	}
+	if !filterComparisons[comparison] {
+		return BookFilter{err: fmt.Errorf("%w: cannot compare with %q", ErrInvalidQuery, comparison)}
+	}
+	if text, ok := value.(string); ok && (column == "copyright_date" || column == "created_at" || column == "updated_at") {
+		expression = fmt.Sprintf("substr(%s, 1, %d)", expression, len(text))
+	}
+	return BookFilter{sql: fmt.Sprintf("%s %s ?", expression, comparison), args: []interface{}{value}}
+}
+
+// AndFilters passes books that pass every filter.
+func AndFilters(filters ...BookFilter) BookFilter {
+	return joinFilters(" AND ", filters)
+}
+
+// OrFilters passes books that pass any of the filters.
+func OrFilters(filters ...BookFilter) BookFilter {
+	return joinFilters(" OR ", filters)
+}
+
+// NotFilter passes books that do not pass filter.
+func NotFilter(filter BookFilter) BookFilter {
+	if filter.err != nil {
+		return filter
+	}
+	return BookFilter{sql: "NOT (" + filter.sql + ")", args: filter.args}
+}
+
+func joinFilters(operator string, filters []BookFilter) BookFilter {
+	if len(filters) == 0 {
+		return BookFilter{err: fmt.Errorf("%w: no filters to join", ErrInvalidQuery)}
+	}
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	var parts []string
+	var args []interface{}
+	for _, filter := range filters {
+		if filter.err != nil {
+			return filter
+		}
+		parts = append(parts, "("+filter.sql+")")
+		args = append(args, filter.args...)
+	}
+	return BookFilter{sql: strings.Join(parts, operator), args: args}
+}
+
+// Err reports a filter that tests a column or comparison that does not
+// exist.
+func (f BookFilter) Err() error {
+	return f.err
+}
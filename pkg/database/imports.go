@@ -0,0 +1,351 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ImportBatch is one run of an import: a file and every book it added or
+// changed.
+type ImportBatch struct {
+	Id         int
+	LibraryId  int
+	UserName   string
+	Filename   string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+	State      string
+	Created    int
+	Updated    int
+	Skipped    int
+	Held       int
+	Error      string
+	UndoneAt   time.Time
+	Errors     []ImportError
+}
+
+// ImportError is a record an import skipped.
+type ImportError struct {
+	Number     int
+	Identifier string
+	Title      string
+	Message    string
+}
+
+// ImportUndo is what undoing an import did. Kept lists the books left as
+// they are because they were changed after the import.
+type ImportUndo struct {
+	Deleted  int
+	Restored int
+	Kept     []Book
+}
+
+// The state of a batch whose import is still writing it.
+const ImportRunning = "running"
+
+// The state of batches left running when the server stopped.
+const ImportInterrupted = "interrupted"
+
+var ErrImportNotFound = errors.New("import not found")
+var ErrImportRunning = errors.New("import is still running")
+var ErrImportUndone = errors.New("import was already undone")
+
+const IMPORT_BATCH_COLUMNS = `b.id, b.library_id, COALESCE(u.name, u.email, ''), b.filename, b.created_at, b.finished_at, b.state, b.created, b.updated, b.skipped, b.held, COALESCE(b.error, ''), b.undone_at`
+const GET_IMPORT_BATCHES_QUERY = `SELECT ` + IMPORT_BATCH_COLUMNS + ` FROM import_batches b
+LEFT JOIN users u ON u.id = b.user_id
+WHERE b.library_id = ? ORDER BY b.id DESC`
+const GET_IMPORT_BATCH_QUERY = `SELECT ` + IMPORT_BATCH_COLUMNS + ` FROM import_batches b
+LEFT JOIN users u ON u.id = b.user_id
+WHERE b.library_id = ? AND b.id = ?`
+const GET_IMPORT_ERRORS_QUERY = `SELECT record_number, COALESCE(identifier, ''), COALESCE(title, ''), COALESCE(message, '')
+FROM import_batch_errors WHERE import_batch_id = ? ORDER BY record_number`
+const INSERT_IMPORT_BATCH_QUERY = `INSERT INTO import_batches (library_id, user_id, filename) values (?,?,?)`
+const INSERT_IMPORT_ERROR_QUERY = `INSERT INTO import_batch_errors (import_batch_id, record_number, identifier, title, message) values (?,?,?,?,?)`
+
+// Created and updated are counted from the versions the batch wrote, so
+// they only count what was saved
+const FINISH_IMPORT_BATCH_QUERY = `UPDATE import_batches SET
+finished_at = CURRENT_TIMESTAMP,
+state = ?,
+skipped = ?,
+held = ?,
+error = NULLIF(?, ''),
+created = (SELECT COUNT(*) FROM book_versions WHERE import_batch_id = import_batches.id AND version = 1),
+updated = (SELECT COUNT(*) FROM book_versions WHERE import_batch_id = import_batches.id AND version > 1)
+WHERE id = ?`
+const INTERRUPT_IMPORT_BATCHES_QUERY = `UPDATE import_batches SET state = ?, finished_at = CURRENT_TIMESTAMP WHERE state = ?`
+const UNDO_IMPORT_BATCH_QUERY = `UPDATE import_batches SET undone_at = CURRENT_TIMESTAMP WHERE id = ?`
+
+const TAG_IMPORTED_BOOK_QUERY = `UPDATE master_books SET import_batch_id = ? WHERE id = ?`
+const TAG_IMPORTED_VERSION_QUERY = `UPDATE book_versions SET import_batch_id = ? WHERE book_id = ? AND version = ?`
+
+// The versions a batch wrote, with the version each book is at now, or
+// NULL once it is deleted
+const GET_IMPORTED_VERSIONS_QUERY = `SELECT v.book_id, v.version, b.version FROM book_versions v
+LEFT JOIN master_books b ON b.id = v.book_id AND b.library_id = ?
+WHERE v.import_batch_id = ? ORDER BY v.book_id`
+
+// A restored book is tagged with the batch that wrote the version it goes
+// back to, if any
+const RESTORE_IMPORTED_BOOK_QUERY = `UPDATE master_books SET import_batch_id =
+(SELECT import_batch_id FROM book_versions WHERE book_id = master_books.id AND version = ?)
+WHERE id = ?`
+
+// CreateImportBatch starts the record of an import of filename by the user
+// with userId, 0 when nobody is signed in.
+func CreateImportBatch(libraryId int, userId int, filename string) (int, error) {
+	var user sql.NullInt64
+	if userId != 0 {
+		user = sql.NullInt64{Int64: int64(userId), Valid: true}
+	}
+	res, err := Db.Exec(INSERT_IMPORT_BATCH_QUERY, libraryId, user, filename)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert import batch: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// FinishImportBatch records how an import ended, with the records it
+// skipped.
+func FinishImportBatch(batchId int, state string, skipped int, held int, failure string, importErrors []ImportError) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(FINISH_IMPORT_BATCH_QUERY, state, skipped, held, failure, batchId)
+	for _, e := range importErrors {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(INSERT_IMPORT_ERROR_QUERY, batchId, e.Number, e.Identifier, e.Title, e.Message)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("unable to update import batch: %v", err)
+	}
+	return tx.Commit()
+}
+
+// InterruptImportBatches marks the imports that were running when the
+// server stopped, as nothing will finish them.
+func InterruptImportBatches() error {
+	_, err := Db.Exec(INTERRUPT_IMPORT_BATCHES_QUERY, ImportInterrupted, ImportRunning)
+	if err != nil {
+		return fmt.Errorf("unable to update import batches: %v", err)
+	}
+	return nil
+}
+
+func GetImportBatches(libraryId int) ([]ImportBatch, error) {
+	res, err := Db.Query(GET_IMPORT_BATCHES_QUERY, libraryId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	var batches []ImportBatch
+	for res.Next() {
+		batch, err := scanImportBatch(res)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, *batch)
+	}
+	return batches, nil
+}
+
+// GetImportBatch returns a batch with the records it skipped.
+func GetImportBatch(libraryId int, id int) (*ImportBatch, error) {
+	res, err := Db.Query(GET_IMPORT_BATCH_QUERY, libraryId, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+	if !res.Next() {
+		return nil, ErrImportNotFound
+	}
+	batch, err := scanImportBatch(res)
+	if err != nil {
+		return nil, err
+	}
+	res.Close()
+
+	errorRows, err := Db.Query(GET_IMPORT_ERRORS_QUERY, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer errorRows.Close()
+	for errorRows.Next() {
+		var e ImportError
+		err := errorRows.Scan(&e.Number, &e.Identifier, &e.Title, &e.Message)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan db row: %v", err)
+		}
+		batch.Errors = append(batch.Errors, e)
+	}
+	return batch, nil
+}
+
+func scanImportBatch(res *sql.Rows) (*ImportBatch, error) {
+	var batch ImportBatch
+	var finished_at sql.NullTime
+	var undone_at sql.NullTime
+	err := res.Scan(
+		&batch.Id,
+		&batch.LibraryId,
+		&batch.UserName,
+		&batch.Filename,
+		&batch.CreatedAt,
+		&finished_at,
+		&batch.State,
+		&batch.Created,
+		&batch.Updated,
+		&batch.Skipped,
+		&batch.Held,
+		&batch.Error,
+		&undone_at,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan db row: %v", err)
+	}
+	batch.FinishedAt = finished_at.Time
+	batch.UndoneAt = undone_at.Time
+	return &batch, nil
+}
+
+// tagImport marks a book and the version of it just written as the work
+// of an import batch.
+func tagImport(tx *sql.Tx, batchId int, b *Book) error {
+	_, err := tx.Exec(TAG_IMPORTED_BOOK_QUERY, batchId, b.Id)
+	if err == nil {
+		_, err = tx.Exec(TAG_IMPORTED_VERSION_QUERY, batchId, b.Id, b.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to tag imported book: %v", err)
+	}
+	return nil
+}
+
+// UndoImportBatch deletes the books an import added and puts the books it
+// updated back to the version before, in one transaction. Books changed
+// since the import are kept as they are.
+func UndoImportBatch(libraryId int, batchId int) (*ImportUndo, error) {
+	batch, err := GetImportBatch(libraryId, batchId)
+	if err != nil {
+		return nil, err
+	}
+	if batch.State == ImportRunning {
+		return nil, ErrImportRunning
+	}
+	if !batch.UndoneAt.IsZero() {
+		return nil, ErrImportUndone
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	undo, err := undoImport(tx, libraryId, batchId)
+	if err == nil {
+		_, err = tx.Exec(UNDO_IMPORT_BATCH_QUERY, batchId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("unable to undo import %d: %v", batchId, err)
+	}
+	return undo, tx.Commit()
+}
+
+func undoImport(tx *sql.Tx, libraryId int, batchId int) (*ImportUndo, error) {
+	type imported struct {
+		bookId  int
+		version int
+		current sql.NullInt64
+	}
+	res, err := tx.Query(GET_IMPORTED_VERSIONS_QUERY, libraryId, batchId)
+	if err != nil {
+		return nil, err
+	}
+	var books []imported
+	for res.Next() {
+		var book imported
+		err := res.Scan(&book.bookId, &book.version, &book.current)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	res.Close()
+
+	undo := &ImportUndo{}
+	for _, book := range books {
+		switch {
+		case !book.current.Valid:
+			// Deleted since, so there is nothing left to undo
+		case int(book.current.Int64) != book.version:
+			res, err := tx.Query(GET_BOOK_BY_ID_QUERY, libraryId, book.bookId)
+			if err != nil {
+				return nil, err
+			}
+			res.Next()
+			kept, err := scanBook(res)
+			res.Close()
+			if err != nil {
+				return nil, err
+			}
+			undo.Kept = append(undo.Kept, *kept)
+		case book.version == 1:
+			_, err = tx.Exec(INSERT_DELETED_BOOK_QUERY, libraryId, book.bookId)
+			if err == nil {
+				_, err = tx.Exec(DELETE_BOOK_BY_ID_QUERY, libraryId, book.bookId)
+			}
+			if err != nil {
+				return nil, err
+			}
+			undo.Deleted++
+		default:
+			previous, err := getBookVersion(tx, libraryId, book.bookId, book.version-1)
+			if err != nil {
+				return nil, err
+			}
+			previous.Version = book.version
+			previous.LibraryId = libraryId
+			err = previous.update(tx)
+			if err == nil {
+				err = snapshotBook(tx, previous, fmt.Sprintf("Undid import %d", batchId))
+			}
+			if err == nil {
+				_, err = tx.Exec(RESTORE_IMPORTED_BOOK_QUERY, book.version-1, book.bookId)
+			}
+			if err != nil {
+				return nil, err
+			}
+			undo.Restored++
+		}
+	}
+	return undo, nil
+}
+
+// getBookVersion reads one version of a book within tx.
+func getBookVersion(tx *sql.Tx, libraryId int, bookId int, version int) (*Book, error) {
+	res, err := tx.Query(GET_BOOK_VERSION_QUERY, bookId, version, libraryId)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	if !res.Next() {
+		return nil, fmt.Errorf("book %d has no version %d", bookId, version)
+	}
+	bookVersion, err := scanBookVersion(res)
+	if err != nil {
+		return nil, err
+	}
+	return &bookVersion.Book, nil
+}
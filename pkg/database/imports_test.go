@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+// saveBook saves a new book in the first library.
+func saveBook(t *testing.T, title string) Book {
+	t.Helper()
+	book := Book{Id: -1, LibraryId: 1, Title: title, AuthorLast: "Tolkien"}
+	if errorMap, err := book.Save(); err != nil || len(errorMap) > 0 {
+		t.Fatalf("saving %s: %v %v", title, errorMap, err)
+	}
+	return book
+}
+
+// runImport records an import that adds the books titled creates and
+// changes the title of each of updates.
+func runImport(t *testing.T, creates []string, updates ...Book) int {
+	t.Helper()
+	batchId, err := CreateImportBatch(1, 0, "books.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var books []Book
+	for _, title := range creates {
+		books = append(books, Book{Title: title, AuthorLast: "Tolkien"})
+	}
+	for i := range updates {
+		updates[i].Title += " (imported)"
+	}
+	err = ImportBooks(1, batchId, books, updates)
+	if err == nil {
+		err = FinishImportBatch(batchId, "done", 1, 0, "", []ImportError{{Number: 3, Title: "Skipped", Message: "no author"}})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return batchId
+}
+
+func TestUndoImportBatch(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	updated := saveBook(t, "The Hobbit")
+	changedSince := saveBook(t, "The Silmarillion")
+	batchId := runImport(t, []string{"Farmer Giles of Ham", "Roverandom"}, updated, changedSince)
+
+	batch, err := GetImportBatch(1, batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.State != "done" || batch.Created != 2 || batch.Updated != 2 || batch.Skipped != 1 || len(batch.Errors) != 1 {
+		t.Errorf("batch = %+v", batch)
+	}
+
+	// An edit after the import keeps the book out of the undo
+	changed, err := GetBookById(1, changedSince.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed.Title = "The Silmarillion, edited"
+	if _, err := changed.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	undo, err := UndoImportBatch(1, batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undo.Deleted != 2 || undo.Restored != 1 || len(undo.Kept) != 1 || undo.Kept[0].Id != changedSince.Id {
+		t.Errorf("undo = %+v", undo)
+	}
+
+	books, err := GetBooksList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[string]bool{}
+	for _, book := range books {
+		titles[book.Title] = true
+	}
+	if len(books) != 2 || !titles["The Hobbit"] || !titles["The Silmarillion, edited"] {
+		t.Errorf("books after undoing = %v", titles)
+	}
+
+	// The restore is a new version on top of the import's
+	versions, err := GetBookVersions(1, updated.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Version != 3 || versions[0].Book.Title != "The Hobbit" {
+		t.Errorf("versions after undoing = %+v", versions)
+	}
+
+	batch, err = GetImportBatch(1, batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.UndoneAt.IsZero() {
+		t.Error("batch is not marked undone")
+	}
+	if _, err := UndoImportBatch(1, batchId); !errors.Is(err, ErrImportUndone) {
+		t.Errorf("undoing twice: got %v, want ErrImportUndone", err)
+	}
+}
+
+func TestUndoDeletedImport(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	batchId := runImport(t, []string{"Farmer Giles of Ham"})
+	books, err := GetBooksList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBook(1, books[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	undo, err := UndoImportBatch(1, batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undo.Deleted != 0 || undo.Restored != 0 || len(undo.Kept) != 0 {
+		t.Errorf("undo = %+v, want nothing left to undo", undo)
+	}
+}
+
+func TestUndoImportRefused(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	running, err := CreateImportBatch(1, 0, "books.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UndoImportBatch(1, running); !errors.Is(err, ErrImportRunning) {
+		t.Errorf("running import: got %v, want ErrImportRunning", err)
+	}
+	if _, err := UndoImportBatch(2, running); !errors.Is(err, ErrImportNotFound) {
+		t.Errorf("import of another library: got %v, want ErrImportNotFound", err)
+	}
+
+	if err := InterruptImportBatches(); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := GetImportBatch(1, running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.State != ImportInterrupted {
+		t.Errorf("state after a restart = %q, want %q", batch.State, ImportInterrupted)
+	}
+}
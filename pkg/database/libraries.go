@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Library struct {
+	Id          int
+	CreatedDate time.Time
+	Name        string
+}
+
+type Setting struct {
+	Key   string
+	Value string
+}
+
+// LibraryExport is everything that belongs to a single library.
+type LibraryExport struct {
+	Library  Library
+	Settings []Setting
+	Books    []Book
+	Versions []BookVersion
+}
+
+var ErrLibraryNotFound = errors.New("library not found")
+
+// Settings stored under InstanceLibraryId apply to the whole instance rather
+// than to a single library. Their library_id is NULL.
+const InstanceLibraryId = 0
+
+const GET_LIBRARIES_QUERY = "SELECT id, created_at, name FROM libraries ORDER BY name"
+const GET_LIBRARY_BY_ID_QUERY = "SELECT id, created_at, name FROM libraries WHERE id = ?"
+const GET_USER_LIBRARIES_QUERY = `SELECT l.id, l.created_at, l.name FROM libraries l
+JOIN library_users lu ON lu.library_id = l.id
+WHERE lu.user_id = ?
+ORDER BY l.name`
+const INSERT_LIBRARY_QUERY = "INSERT INTO libraries (name) values (?)"
+const INSERT_LIBRARY_USER_QUERY = "INSERT OR IGNORE INTO library_users (library_id, user_id) values (?,?)"
+const IS_LIBRARY_USER_QUERY = "SELECT COUNT(*) FROM library_users WHERE library_id = ? AND user_id = ?"
+
+const GET_SETTINGS_QUERY = "SELECT key, value FROM settings WHERE library_id IS ? ORDER BY key"
+const GET_SETTING_QUERY = "SELECT value FROM settings WHERE library_id IS ? AND key = ?"
+const UPSERT_SETTING_QUERY = `INSERT INTO settings (library_id, key, value) values (?,?,?)
+ON CONFLICT (IFNULL(library_id, 0), key) DO UPDATE SET value = excluded.value`
+const DELETE_SETTING_QUERY = "DELETE FROM settings WHERE library_id IS ? AND key = ?"
+
+func GetLibraries() ([]Library, error) {
+	res, err := Db.Query(GET_LIBRARIES_QUERY)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	return scanLibraries(res)
+}
+
+func GetUserLibraries(userId int) ([]Library, error) {
+	res, err := Db.Query(GET_USER_LIBRARIES_QUERY, userId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	return scanLibraries(res)
+}
+
+func GetLibraryById(id int) (*Library, error) {
+	var library Library
+	err := Db.QueryRow(GET_LIBRARY_BY_ID_QUERY, id).Scan(&library.Id, &library.CreatedDate, &library.Name)
+	if err == sql.ErrNoRows {
+		return nil, ErrLibraryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	return &library, nil
+}
+
+func CreateLibrary(name string) (*Library, error) {
+	res, err := Db.Exec(INSERT_LIBRARY_QUERY, name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to insert library: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetLibraryById(int(id))
+}
+
+func AddLibraryUser(libraryId int, userId int) error {
+	_, err := Db.Exec(INSERT_LIBRARY_USER_QUERY, libraryId, userId)
+	if err != nil {
+		return fmt.Errorf("unable to add user to library: %v", err)
+	}
+	return nil
+}
+
+func IsLibraryUser(libraryId int, userId int) (bool, error) {
+	var count int
+	err := Db.QueryRow(IS_LIBRARY_USER_QUERY, libraryId, userId).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("unable to query db: %v", err)
+	}
+	return count > 0, nil
+}
+
+// settingsLibrary is the library_id the settings of libraryId are stored
+// under.
+func settingsLibrary(libraryId int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(libraryId), Valid: libraryId != InstanceLibraryId}
+}
+
+func GetSettings(libraryId int) ([]Setting, error) {
+	res, err := Db.Query(GET_SETTINGS_QUERY, settingsLibrary(libraryId))
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	var settings []Setting
+	for res.Next() {
+		var setting Setting
+		var value sql.NullString
+		err = res.Scan(&setting.Key, &value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan db row: %v", err)
+		}
+		setting.Value = getValidNullStr(value)
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// GetSetting returns the value stored for key in a library, or "" when the
+// setting has never been set.
+func GetSetting(libraryId int, key string) (string, error) {
+	var value sql.NullString
+	err := Db.QueryRow(GET_SETTING_QUERY, settingsLibrary(libraryId), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to query db: %v", err)
+	}
+	return getValidNullStr(value), nil
+}
+
+func SetSetting(libraryId int, key string, value string) error {
+	var err error
+	if value == "" {
+		_, err = Db.Exec(DELETE_SETTING_QUERY, settingsLibrary(libraryId), key)
+	} else {
+		_, err = Db.Exec(UPSERT_SETTING_QUERY, settingsLibrary(libraryId), key, value)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save setting: %v", err)
+	}
+	return nil
+}
+
+func ExportLibrary(libraryId int) (*LibraryExport, error) {
+	library, err := GetLibraryById(libraryId)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := GetSettings(libraryId)
+	if err != nil {
+		return nil, err
+	}
+	books, err := GetBooksList(libraryId)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := GetLibraryBookVersions(libraryId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LibraryExport{
+		Library:  *library,
+		Settings: settings,
+		Books:    books,
+		Versions: versions,
+	}, nil
+}
+
+func scanLibraries(res *sql.Rows) ([]Library, error) {
+	var libraries []Library
+	for res.Next() {
+		var library Library
+		err := res.Scan(&library.Id, &library.CreatedDate, &library.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan db row: %v", err)
+		}
+		libraries = append(libraries, library)
+	}
+	return libraries, nil
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+// twoLibraries stores The Hobbit in the Home library and a book of the
+// same title in a second library, returning the second library and both
+// books.
+func twoLibraries(t *testing.T) (*Library, Book, Book) {
+	t.Helper()
+	openTestDb(t, SchemaVersion)
+	branch, err := CreateLibrary("Branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := home.Save(); err != nil {
+		t.Fatal(err)
+	}
+	other := Book{Id: -1, LibraryId: branch.Id, Title: "The Hobbit", AuthorLast: "Tolkien", Publisher: "Houghton Mifflin"}
+	if _, err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return branch, home, other
+}
+
+func TestBookReadsStayInTheirLibrary(t *testing.T) {
+	branch, home, other := twoLibraries(t)
+
+	lists := map[string]func(libraryId int) ([]Book, error){
+		"GetBooksList": GetBooksList,
+		"PaginateBooks": func(libraryId int) ([]Book, error) {
+			return PaginateBooks(libraryId, 0)
+		},
+		"SortAndPaginateBooks": func(libraryId int) ([]Book, error) {
+			return SortAndPaginateBooks(libraryId, 0, "title")
+		},
+		"FilterBook": func(libraryId int) ([]Book, error) {
+			return FilterBook(libraryId, "Hobbit")
+		},
+	}
+	for name, list := range lists {
+		for _, want := range []Book{home, other} {
+			books, err := list(want.LibraryId)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if len(books) != 1 || books[0].Id != want.Id || books[0].LibraryId != want.LibraryId {
+				t.Errorf("%s(%d) = %+v, want only book %d", name, want.LibraryId, books, want.Id)
+			}
+		}
+	}
+
+	book, err := GetBookById(branch.Id, home.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Id != 0 {
+		t.Errorf("library %d read book %d of library 1", branch.Id, home.Id)
+	}
+	versions, err := GetBookVersions(branch.Id, home.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("library %d read %d versions of book %d of library 1", branch.Id, len(versions), home.Id)
+	}
+	if _, err := GetBookVersion(branch.Id, home.Id, 1); err == nil {
+		t.Errorf("library %d read version 1 of book %d of library 1", branch.Id, home.Id)
+	}
+}
+
+func TestBookWritesStayInTheirLibrary(t *testing.T) {
+	branch, home, _ := twoLibraries(t)
+
+	// Saving another library's book under this library's id finds nothing
+	stolen := home
+	stolen.LibraryId = branch.Id
+	stolen.Title = "Stolen"
+	if _, err := stolen.Save(); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("saving into another library: got %v, want ErrBookNotFound", err)
+	}
+	if _, err := RevertBook(branch.Id, home.Id, 1); err == nil {
+		t.Errorf("library %d reverted book %d of library 1", branch.Id, home.Id)
+	}
+	if err := DeleteBook(branch.Id, home.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := GetBookById(1, home.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Id != home.Id || book.Title != "The Hobbit" || book.Version != 1 {
+		t.Errorf("book of library 1 is now %+v", book)
+	}
+}
+
+func TestSettingsStayInTheirLibrary(t *testing.T) {
+	branch, _, _ := twoLibraries(t)
+
+	if err := SetSetting(1, "theme", "dark"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSetting(branch.Id, "theme", "light"); err != nil {
+		t.Fatal(err)
+	}
+	for libraryId, want := range map[int]string{1: "dark", branch.Id: "light"} {
+		value, err := GetSetting(libraryId, "theme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != want {
+			t.Errorf("library %d theme = %q, want %q", libraryId, value, want)
+		}
+	}
+
+	// An empty value removes the setting
+	if err := SetSetting(branch.Id, "theme", ""); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := GetSettings(branch.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 0 {
+		t.Errorf("library %d settings = %v, want none", branch.Id, settings)
+	}
+	if value, _ := GetSetting(1, "theme"); value != "dark" {
+		t.Errorf("library 1 theme = %q after clearing another library's", value)
+	}
+}
+
+func TestExportLibrary(t *testing.T) {
+	branch, _, other := twoLibraries(t)
+	if err := SetSetting(branch.Id, "theme", "light"); err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := ExportLibrary(branch.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Library.Name != "Branch" {
+		t.Errorf("exported library %q", export.Library.Name)
+	}
+	if len(export.Books) != 1 || export.Books[0].Id != other.Id {
+		t.Errorf("exported books %+v, want only book %d", export.Books, other.Id)
+	}
+	if len(export.Versions) != 1 || export.Versions[0].BookId != other.Id {
+		t.Errorf("exported versions %+v, want only those of book %d", export.Versions, other.Id)
+	}
+	if len(export.Settings) != 1 || export.Settings[0].Value != "light" {
+		t.Errorf("exported settings %+v", export.Settings)
+	}
+
+	if _, err := ExportLibrary(99); !errors.Is(err, ErrLibraryNotFound) {
+		t.Errorf("exporting a missing library: got %v, want ErrLibraryNotFound", err)
+	}
+}
+
+func TestLibraryUsers(t *testing.T) {
+	db := openTestDb(t, SchemaVersion)
+	branch, err := CreateLibrary("Branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO users (id, name) VALUES (7, 'Bilbo')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding a user twice is not an error
+	for i := 0; i < 2; i++ {
+		if err := AddLibraryUser(branch.Id, 7); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for libraryId, want := range map[int]bool{1: false, branch.Id: true} {
+		member, err := IsLibraryUser(libraryId, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if member != want {
+			t.Errorf("user 7 in library %d = %v, want %v", libraryId, member, want)
+		}
+	}
+	libraries, err := GetUserLibraries(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libraries) != 1 || libraries[0].Id != branch.Id {
+		t.Errorf("user 7 libraries = %+v", libraries)
+	}
+}
@@ -0,0 +1,312 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BookQuery selects a page of books from a library. Pages are either read
+// after a Cursor returned with the previous page or skipped with Offset.
+type BookQuery struct {
+	Search string
+	// Genre and AuthorLast, when set, match exactly. AuthorFirst is only
+	// matched along with AuthorLast so an author is always a full name.
+	Genre       string
+	AuthorLast  string
+	AuthorFirst string
+	// Ids, when set, selects only the books with those ids
+	Ids    []int
+	Filter *BookFilter
+	Sort   string
+	Desc   bool
+	Cursor string
+	Offset int
+	Limit  int
+}
+
+type BookPage struct {
+	Books      []Book
+	NextCursor string
+}
+
+// bookCursor remembers the sort value and id of the last book on a page.
+type bookCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    int    `json:"i"`
+}
+
+var ErrInvalidQuery = errors.New("invalid book query")
+
+// SortableColumns are the master_books columns books can be ordered by.
+var SortableColumns = []string{"id", "created_at", "updated_at", "isbn", "lccn", "title", "author_last", "author_first", "copyright_date", "publisher", "location", "genre", "pages"}
+
+const FILTER_BOOKS_CONDITION = `(
+ lccn like ? or
+ isbn like ? or
+ title like ? or
+ author_first like ? or
+ author_last like ? or
+ copyright_date like ? or
+ publisher like ? or
+ location like ? or
+ genre like ?
+)`
+
+const MAX_BOOK_QUERY_LIMIT = 100
+
+const LIST_GENRES_QUERY = `SELECT genre, COUNT(*) FROM master_books
+WHERE library_id = ? AND COALESCE(genre, '') <> ''
+GROUP BY genre ORDER BY genre LIMIT ? OFFSET ?`
+
+const LIST_AUTHORS_QUERY = `SELECT author_last, COALESCE(author_first, ''), COUNT(*) FROM master_books
+WHERE library_id = ? AND COALESCE(author_last, '') <> ''
+GROUP BY author_last, COALESCE(author_first, '') ORDER BY author_last, 2 LIMIT ? OFFSET ?`
+
+// GenreCount is a genre and the number of books in it.
+type GenreCount struct {
+	Genre string
+	Books int
+}
+
+// AuthorCount is an author and the number of books by them.
+type AuthorCount struct {
+	AuthorLast  string
+	AuthorFirst string
+	Books       int
+}
+
+func IsSortableColumn(column string) bool {
+	for _, c := range SortableColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// ListBooks runs a BookQuery. Sorting is stable because ties on the sort
+// column are broken by id, which also makes keyset cursors exact.
+func ListBooks(libraryId int, query BookQuery) (*BookPage, error) {
+	limit := query.Limit
+	if limit <= 0 || limit > MAX_BOOK_QUERY_LIMIT {
+		limit = MAX_BOOK_QUERY_LIMIT
+	}
+	sql, args, sortBy, err := buildBookQuery(libraryId, query)
+	if err != nil {
+		return nil, err
+	}
+	// One extra row tells us whether there is another page
+	sql += " LIMIT ?"
+	args = append(args, limit+1)
+	if query.Cursor == "" && query.Offset > 0 {
+		sql += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+
+	res, err := Db.Query(sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	page := &BookPage{}
+	var lastValue string
+	for res.Next() {
+		var value string
+		book, err := scanBook(res, &value)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Books) == limit {
+			last := page.Books[len(page.Books)-1]
+			page.NextCursor = encodeBookCursor(bookCursor{Sort: sortBy, Desc: query.Desc, Value: lastValue, Id: last.Id})
+			break
+		}
+		page.Books = append(page.Books, *book)
+		lastValue = value
+	}
+
+	return page, nil
+}
+
+// CountBooks returns how many books match query, ignoring its cursor and
+// paging.
+func CountBooks(libraryId int, query BookQuery) (int, error) {
+	query.Cursor = ""
+	sql, args, _, err := buildBookQuery(libraryId, query)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = Db.QueryRow("SELECT COUNT(*) FROM ("+sql+")", args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("unable to count books: %v", err)
+	}
+	return count, nil
+}
+
+// EachBook calls fn with every book matching query, in order, without
+// holding them all in memory. Limit and Offset are ignored. Iteration stops
+// at the first error fn returns. Books are read a page at a time, so no
+// read is left open while fn runs to block writers.
+func EachBook(libraryId int, query BookQuery, fn func(Book) error) error {
+	query.Cursor, query.Offset, query.Limit = "", 0, MAX_BOOK_QUERY_LIMIT
+	for {
+		page, err := ListBooks(libraryId, query)
+		if err != nil {
+			return err
+		}
+		for _, book := range page.Books {
+			err = fn(book)
+			if err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// buildBookQuery returns the ordered SELECT for query, which also selects the
+// sort value a cursor needs after BOOK_COLUMNS.
+func buildBookQuery(libraryId int, query BookQuery) (string, []interface{}, string, error) {
+	sortBy := query.Sort
+	if sortBy == "" {
+		sortBy = "id"
+	}
+	if !IsSortableColumn(sortBy) {
+		return "", nil, "", fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, sortBy)
+	}
+
+	sortKey := fmt.Sprintf("COALESCE(CAST(%s AS TEXT), '')", sortBy)
+	if sortBy == "id" {
+		sortKey = "id"
+	}
+	direction := "ASC"
+	comparison := ">"
+	if query.Desc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	var sb strings.Builder
+	args := []interface{}{libraryId}
+	fmt.Fprintf(&sb, "SELECT %s, CAST(%s AS TEXT) FROM master_books WHERE library_id = ?", BOOK_COLUMNS, sortKey)
+
+	if query.Search != "" {
+		sb.WriteString(" AND " + FILTER_BOOKS_CONDITION)
+		for i := 0; i < strings.Count(FILTER_BOOKS_CONDITION, "?"); i++ {
+			args = append(args, "%"+query.Search+"%")
+		}
+	}
+
+	if query.Genre != "" {
+		sb.WriteString(" AND genre = ?")
+		args = append(args, query.Genre)
+	}
+	if query.AuthorLast != "" {
+		sb.WriteString(" AND author_last = ? AND COALESCE(author_first, '') = ?")
+		args = append(args, query.AuthorLast, query.AuthorFirst)
+	}
+
+	if len(query.Ids) > 0 {
+		fmt.Fprintf(&sb, " AND id IN (%s)", strings.TrimSuffix(strings.Repeat("?,", len(query.Ids)), ","))
+		for _, id := range query.Ids {
+			args = append(args, id)
+		}
+	}
+
+	if query.Filter != nil {
+		if query.Filter.err != nil {
+			return "", nil, "", query.Filter.err
+		}
+		sb.WriteString(" AND (" + query.Filter.sql + ")")
+		args = append(args, query.Filter.args...)
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeBookCursor(query.Cursor)
+		if err != nil || cursor.Sort != sortBy || cursor.Desc != query.Desc {
+			return "", nil, "", fmt.Errorf("%w: cursor does not match this query", ErrInvalidQuery)
+		}
+		if sortBy == "id" {
+			fmt.Fprintf(&sb, " AND id %s ?", comparison)
+			args = append(args, cursor.Id)
+		} else {
+			fmt.Fprintf(&sb, " AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sortKey, comparison)
+			args = append(args, cursor.Value, cursor.Value, cursor.Id)
+		}
+	}
+
+	fmt.Fprintf(&sb, " ORDER BY %s %s", sortKey, direction)
+	if sortBy != "id" {
+		fmt.Fprintf(&sb, ", id %s", direction)
+	}
+	return sb.String(), args, sortBy, nil
+}
+
+// ListGenres returns the genres used in a library in alphabetical order.
+func ListGenres(libraryId int, offset int, limit int) ([]GenreCount, error) {
+	res, err := Db.Query(LIST_GENRES_QUERY, libraryId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	genres := []GenreCount{}
+	for res.Next() {
+		var genre GenreCount
+		err = res.Scan(&genre.Genre, &genre.Books)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan genre: %v", err)
+		}
+		genres = append(genres, genre)
+	}
+	return genres, res.Err()
+}
+
+// ListAuthors returns the authors in a library ordered by last and then
+// first name. The names match BookQuery's AuthorLast and AuthorFirst.
+func ListAuthors(libraryId int, offset int, limit int) ([]AuthorCount, error) {
+	res, err := Db.Query(LIST_AUTHORS_QUERY, libraryId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	authors := []AuthorCount{}
+	for res.Next() {
+		var author AuthorCount
+		err = res.Scan(&author.AuthorLast, &author.AuthorFirst, &author.Books)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan author: %v", err)
+		}
+		authors = append(authors, author)
+	}
+	return authors, res.Err()
+}
+
+func encodeBookCursor(cursor bookCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeBookCursor(encoded string) (*bookCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var cursor bookCursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEachBookPages(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	count := MAX_BOOK_QUERY_LIMIT*2 + 7
+	for i := 0; i < count; i++ {
+		book := Book{Id: -1, LibraryId: 1, Title: fmt.Sprintf("Book %03d", count-i), AuthorLast: "Author"}
+		if _, err := book.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var titles []string
+	err := EachBook(1, BookQuery{Sort: "title", Limit: 5, Offset: 3}, func(book Book) error {
+		titles = append(titles, book.Title)
+		// Writing while the export runs must not wait on its reads
+		if len(titles) == 1 {
+			extra := Book{Id: -1, LibraryId: 2, Title: "Elsewhere", AuthorLast: "Author"}
+			_, err := extra.Save()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != count {
+		t.Fatalf("got %d books, want %d", len(titles), count)
+	}
+	for i, title := range titles {
+		if want := fmt.Sprintf("Book %03d", i+1); title != want {
+			t.Fatalf("book %d is %q, want %q", i, title, want)
+		}
+	}
+}
+
+func TestListBooksByIds(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		book := Book{Id: -1, LibraryId: 1, Title: fmt.Sprintf("Book %d", i), AuthorLast: "Author"}
+		if _, err := book.Save(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, book.Id)
+	}
+
+	page, err := ListBooks(1, BookQuery{Ids: []int{ids[3], ids[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Books) != 2 || page.Books[0].Id != ids[1] || page.Books[1].Id != ids[3] {
+		t.Errorf("got %v, want books %d and %d", page.Books, ids[1], ids[3])
+	}
+}
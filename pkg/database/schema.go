@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+)
+
+// Migration is a file of the sql directory that changes the schema. Applied
+// counts what the migration creates, telling whether a database has it.
+type Migration struct {
+	File    string
+	Applied string
+}
+
+// Migrations are the changes to the schema since the master_books table,
+// in the order they are applied. The schema version of a database is how
+// many of them it has.
+var Migrations = []Migration{
+	{
+		File:    "10192026_create_book_versions.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'book_versions'`,
+	},
+	{
+		File:    "10192026_add_book_version.sql",
+		Applied: `SELECT COUNT(*) FROM pragma_table_info('master_books') WHERE name = 'version'`,
+	},
+	{
+		File:    "10192026_create_libraries.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'libraries'`,
+	},
+	{
+		File:    "10192026_create_sessions.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sessions'`,
+	},
+	{
+		File:    "10192026_track_book_changes.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'deleted_books'`,
+	},
+	{
+		File:    "10192026_create_import_batches.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'import_batches'`,
+	},
+	{
+		// Only changes data, so it counts as applied while no date needs it
+		File: "10192026_partial_copyright_dates.sql",
+		Applied: `SELECT COUNT(*) = 0 FROM master_books
+WHERE CAST(copyright_date AS TEXT) = '' OR CAST(copyright_date AS TEXT) LIKE '0001-01-01%' OR length(CAST(copyright_date AS TEXT)) > 11`,
+	},
+	{
+		File:    "10192026_never_reuse_book_ids.sql",
+		Applied: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'master_books' AND sql LIKE '%AUTOINCREMENT%'`,
+	},
+	{
+		File:    "10192026_instance_settings.sql",
+		Applied: `SELECT COUNT(*) FROM pragma_table_info('settings') WHERE name = 'library_id' AND "notnull" = 0`,
+	},
+}
+
+// SchemaVersion is the version of the newest schema.
+var SchemaVersion = len(Migrations)
+
+const HAS_BOOKS_TABLE_QUERY = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'master_books'`
+
+// GetSchemaVersion counts the migrations db has, stopping at the first one
+// it lacks.
+func GetSchemaVersion(db *sql.DB) (int, error) {
+	var books int
+	err := db.QueryRow(HAS_BOOKS_TABLE_QUERY).Scan(&books)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read schema: %v", err)
+	}
+	if books == 0 {
+		return 0, fmt.Errorf("database has no books table")
+	}
+	for i, migration := range Migrations {
+		var count int
+		err := db.QueryRow(migration.Applied).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read schema: %v", err)
+		}
+		if count == 0 {
+			return i, nil
+		}
+	}
+	return len(Migrations), nil
+}
+
+// Migrate applies the migrations db lacks, reading them from files, and
+// returns the schema version it had.
+func Migrate(db *sql.DB, files fs.FS) (int, error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	for _, migration := range Migrations[version:] {
+		script, err := fs.ReadFile(files, migration.File)
+		if err != nil {
+			return version, fmt.Errorf("unable to read migration %s: %v", migration.File, err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return version, err
+		}
+		_, err = tx.Exec(string(script))
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			return version, fmt.Errorf("unable to apply migration %s: %v", migration.File, err)
+		}
+	}
+	return version, nil
+}
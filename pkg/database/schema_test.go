@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// migrationIndex is the position of a migration file in Migrations.
+func migrationIndex(t *testing.T, file string) int {
+	t.Helper()
+	for i, migration := range Migrations {
+		if migration.File == file {
+			return i
+		}
+	}
+	t.Fatalf("no migration %s", file)
+	return 0
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openTestDb(t, SchemaVersion)
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("schema version %d, want %d", version, SchemaVersion)
+	}
+	// Applying the migrations again does nothing
+	version, err = Migrate(db, os.DirFS("../../sql"))
+	if err != nil || version != SchemaVersion {
+		t.Errorf("Migrate again = %d, %v", version, err)
+	}
+}
+
+// A database from before the first migration keeps its books, which land
+// in the first library with a first version.
+func TestMigrateOldDatabase(t *testing.T) {
+	db := openTestDb(t, 0)
+	_, err := db.Exec(`INSERT INTO master_books (title, author_last, copyright_date) VALUES ('The Hobbit', 'Tolkien', '1937-09-21')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := Migrate(db, os.DirFS("../../sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("Migrate = %d, want the old version 0", version)
+	}
+	version, err = GetSchemaVersion(db)
+	if err != nil || version != SchemaVersion {
+		t.Fatalf("schema version %d, %v after migrating, want %d", version, err, SchemaVersion)
+	}
+
+	books, err := GetBooksList(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].Title != "The Hobbit" || books[0].Version != 1 {
+		t.Fatalf("books after migrating = %+v", books)
+	}
+	versions, err := GetBookVersions(1, books[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("migrated book has %d versions, want 1", len(versions))
+	}
+}
+
+func TestPartialDatesMigration(t *testing.T) {
+	partial := migrationIndex(t, "10192026_partial_copyright_dates.sql")
+	db := openTestDb(t, partial)
+
+	dates := map[string]string{
+		"missing":   "0001-01-01 00:00:00+00:00",
+		"empty":     "",
+		"timestamp": "1954-03-12 00:00:00+00:00",
+		"year":      "1954",
+		"range":     "1950~/1959~",
+	}
+	for title, date := range dates {
+		_, err := db.Exec(`INSERT INTO master_books (title, copyright_date) VALUES (?, ?)`, title, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Old dates make the data migration count as missing
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != partial {
+		t.Fatalf("schema version %d, want %d", version, partial)
+	}
+	if _, err := Migrate(db, os.DirFS("../../sql")); err != nil {
+		t.Fatal(err)
+	}
+	version, err = GetSchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion {
+		t.Errorf("schema version %d after migrating, want %d", version, SchemaVersion)
+	}
+
+	want := map[string]sql.NullString{
+		"missing":   {},
+		"empty":     {},
+		"timestamp": {String: "1954-03-12", Valid: true},
+		"year":      {String: "1954", Valid: true},
+		"range":     {String: "1950~/1959~", Valid: true},
+	}
+	for title, date := range want {
+		var got sql.NullString
+		err := db.QueryRow(`SELECT CAST(copyright_date AS TEXT) FROM master_books WHERE title = ?`, title).Scan(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != date {
+			t.Errorf("%s: copyright date %+v, want %+v", title, got, date)
+		}
+	}
+}
+
+func TestInstanceSettingsMigration(t *testing.T) {
+	db := openTestDb(t, migrationIndex(t, "10192026_instance_settings.sql"))
+	_, err := db.Exec(`INSERT INTO settings (library_id, key, value) VALUES (0, 'oidc.issuer', 'https://id.example.com'), (1, 'theme', 'dark')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(db, os.DirFS("../../sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Instance settings no longer point at a library that does not exist
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		t.Fatal(err)
+	}
+	var violations int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check('settings')").Scan(&violations); err != nil {
+		t.Fatal(err)
+	}
+	if violations != 0 {
+		t.Errorf("%d settings reference missing libraries", violations)
+	}
+	if err := SetSetting(InstanceLibraryId, "oidc.issuer", "https://login.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for libraryId, want := range map[int]string{InstanceLibraryId: "https://login.example.com", 1: ""} {
+		value, err := GetSetting(libraryId, "oidc.issuer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != want {
+			t.Errorf("library %d oidc.issuer = %q, want %q", libraryId, value, want)
+		}
+	}
+	if settings, _ := GetSettings(InstanceLibraryId); len(settings) != 1 {
+		t.Errorf("instance settings = %v, want only oidc.issuer", settings)
+	}
+}
+
+func TestMigrateMissingFile(t *testing.T) {
+	db := openTestDb(t, 0)
+	if _, err := Migrate(db, os.DirFS(t.TempDir())); err == nil {
+		t.Fatal("migrated without the migration files")
+	}
+	version, err := GetSchemaVersion(db)
+	if err != nil || version != 0 {
+		t.Errorf("schema version %d, %v, want 0", version, err)
+	}
+}
+
+func TestSchemaVersionWithoutBooks(t *testing.T) {
+	db := openTestDb(t, 0)
+	if _, err := db.Exec(`DROP TABLE master_books`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSchemaVersion(db); err == nil {
+		t.Error("database without books has a schema version")
+	}
+}
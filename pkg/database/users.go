@@ -0,0 +1,137 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	RoleViewer    = "viewer"
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+)
+
+type User struct {
+	Id          int
+	CreatedDate time.Time
+	Name        string
+	Email       string
+	Issuer      string
+	Subject     string
+	Role        string
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+const USER_COLUMNS = "id, created_at, name, email, issuer, subject, role"
+
+const GET_USER_BY_ID_QUERY = "SELECT " + USER_COLUMNS + " FROM users WHERE id = ?"
+const GET_USER_BY_SUBJECT_QUERY = "SELECT " + USER_COLUMNS + " FROM users WHERE issuer = ? AND subject = ?"
+
+// 5 values
+const UPSERT_OIDC_USER_QUERY = `INSERT INTO users (issuer, subject, name, email, role) values (?,?,?,?,?)
+ON CONFLICT (issuer, subject) DO UPDATE SET name = excluded.name, email = excluded.email, role = excluded.role`
+
+const INSERT_SESSION_QUERY = "INSERT INTO sessions (id, user_id, expires_at, id_token) values (?,?,?,?)"
+const GET_SESSION_QUERY = "SELECT user_id, expires_at, id_token FROM sessions WHERE id = ?"
+const DELETE_SESSION_QUERY = "DELETE FROM sessions WHERE id = ?"
+const DELETE_EXPIRED_SESSIONS_QUERY = "DELETE FROM sessions WHERE expires_at < ?"
+
+func GetUserById(id int) (*User, error) {
+	return scanUser(Db.QueryRow(GET_USER_BY_ID_QUERY, id))
+}
+
+// UpsertOidcUser provisions a user the first time they sign in and refreshes
+// their profile and role from the identity provider on every later sign in.
+func UpsertOidcUser(issuer string, subject string, name string, email string, role string) (*User, error) {
+	_, err := Db.Exec(UPSERT_OIDC_USER_QUERY, issuer, subject, name, email, role)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save user: %v", err)
+	}
+	return scanUser(Db.QueryRow(GET_USER_BY_SUBJECT_QUERY, issuer, subject))
+}
+
+// CreateSession starts a session for userId and returns the token to hand to
+// the browser. Only a hash of the token is stored.
+func CreateSession(userId int, idToken string, expires time.Time) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	_, err = Db.Exec(DELETE_EXPIRED_SESSIONS_QUERY, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("unable to delete expired sessions: %v", err)
+	}
+	_, err = Db.Exec(INSERT_SESSION_QUERY, hashSessionToken(token), userId, expires.UTC(), idToken)
+	if err != nil {
+		return "", fmt.Errorf("unable to insert session: %v", err)
+	}
+	return token, nil
+}
+
+// GetSessionUser returns the user behind a session token along with the ID
+// token the session was started with.
+func GetSessionUser(token string) (*User, string, error) {
+	var userId int
+	var expires time.Time
+	var idToken sql.NullString
+	err := Db.QueryRow(GET_SESSION_QUERY, hashSessionToken(token)).Scan(&userId, &expires, &idToken)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to query db: %v", err)
+	}
+	if time.Now().After(expires) {
+		return nil, "", ErrSessionNotFound
+	}
+
+	user, err := GetUserById(userId)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, getValidNullStr(idToken), nil
+}
+
+func DeleteSession(token string) error {
+	_, err := Db.Exec(DELETE_SESSION_QUERY, hashSessionToken(token))
+	if err != nil {
+		return fmt.Errorf("unable to delete session: %v", err)
+	}
+	return nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+	var name sql.NullString
+	var email sql.NullString
+	var issuer sql.NullString
+	var subject sql.NullString
+	var user User
+
+	err := row.Scan(&user.Id, &user.CreatedDate, &name, &email, &issuer, &subject, &user.Role)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan db row: %v", err)
+	}
+
+	user.Name = getValidNullStr(name)
+	user.Email = getValidNullStr(email)
+	user.Issuer = getValidNullStr(issuer)
+	user.Subject = getValidNullStr(subject)
+	return &user, nil
+}
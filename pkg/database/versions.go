@@ -0,0 +1,168 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"mlibrary-htmx/pkg/dates"
+)
+
+type BookVersion struct {
+	Id        int
+	BookId    int
+	Version   int
+	CreatedAt time.Time
+	Note      string
+	Book      Book
+}
+
+const GET_BOOK_VERSIONS_QUERY = `SELECT id, book_id, version, created_at, note, lccn, isbn, title, author_first, author_last, CAST(copyright_date AS TEXT), publisher, location, genre, pages
+FROM book_versions WHERE book_id = ? AND book_id IN (SELECT id FROM master_books WHERE library_id = ?) ORDER BY version DESC`
+const GET_BOOK_VERSION_QUERY = `SELECT id, book_id, version, created_at, note, lccn, isbn, title, author_first, author_last, CAST(copyright_date AS TEXT), publisher, location, genre, pages
+FROM book_versions WHERE book_id = ? AND version = ? AND book_id IN (SELECT id FROM master_books WHERE library_id = ?)`
+const GET_LIBRARY_BOOK_VERSIONS_QUERY = `SELECT id, book_id, version, created_at, note, lccn, isbn, title, author_first, author_last, CAST(copyright_date AS TEXT), publisher, location, genre, pages
+FROM book_versions WHERE book_id IN (SELECT id FROM master_books WHERE library_id = ?) ORDER BY book_id, version`
+
+// 13 values
+const INSERT_BOOK_VERSION_QUERY = `INSERT INTO book_versions (book_id, version, note, lccn, isbn, title, author_first, author_last, copyright_date, publisher, location, genre, pages) values (?,?,?,?,?,?,?,?,?,?,?,?,?)`
+
+// snapshotBook stores the current state of b under b.Version. It runs inside
+// the same transaction as the write it records so the two never drift.
+func snapshotBook(tx *sql.Tx, b *Book, note string) error {
+	_, err := tx.Exec(INSERT_BOOK_VERSION_QUERY, b.Id, b.Version, note, b.Lccn, b.Isbn, b.Title, b.AuthorFirst, b.AuthorLast, b.CopyrightDate, b.Publisher, b.Location, b.Genre, b.Pages)
+	if err != nil {
+		return fmt.Errorf("unable to insert book version: %v", err)
+	}
+	return nil
+}
+
+func GetBookVersions(libraryId int, bookId int) ([]BookVersion, error) {
+	res, err := Db.Query(GET_BOOK_VERSIONS_QUERY, bookId, libraryId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	return scanBookVersions(res)
+}
+
+// GetLibraryBookVersions returns the history of every book in a library.
+func GetLibraryBookVersions(libraryId int) ([]BookVersion, error) {
+	res, err := Db.Query(GET_LIBRARY_BOOK_VERSIONS_QUERY, libraryId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	return scanBookVersions(res)
+}
+
+func GetBookVersion(libraryId int, bookId int, version int) (*BookVersion, error) {
+	res, err := Db.Query(GET_BOOK_VERSION_QUERY, bookId, version, libraryId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query db: %v", err)
+	}
+	defer res.Close()
+
+	if !res.Next() {
+		return nil, fmt.Errorf("book %d has no version %d", bookId, version)
+	}
+	return scanBookVersion(res)
+}
+
+// RevertBook restores a book to the state stored in one of its earlier
+// versions. The revert is saved as a new version rather than rewriting history.
+func RevertBook(libraryId int, bookId int, version int) (*Book, error) {
+	bookVersion, err := GetBookVersion(libraryId, bookId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := GetBookById(libraryId, bookId)
+	if err != nil {
+		return nil, err
+	}
+
+	book := bookVersion.Book
+	book.Version = current.Version
+	book.LibraryId = libraryId
+	errorMap, err := book.save(fmt.Sprintf("Reverted to version %d", version))
+	if err != nil {
+		return nil, err
+	}
+	if len(errorMap) > 0 {
+		return nil, fmt.Errorf("version %d of book %d is no longer valid", version, bookId)
+	}
+	return &book, nil
+}
+
+func scanBookVersions(res *sql.Rows) ([]BookVersion, error) {
+	var versions []BookVersion
+	for res.Next() {
+		version, err := scanBookVersion(res)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+
+	return versions, nil
+}
+
+func scanBookVersion(res *sql.Rows) (*BookVersion, error) {
+	var lccn sql.NullString
+	var isbn sql.NullString
+	var title sql.NullString
+	var author_first sql.NullString
+	var author_last sql.NullString
+	var copyright_date dates.PartialDate
+	var publisher sql.NullString
+	var location sql.NullString
+	var genre sql.NullString
+	var pages sql.NullString
+	var note sql.NullString
+	var created_at time.Time
+	var version BookVersion
+
+	err := res.Scan(
+		&version.Id,
+		&version.BookId,
+		&version.Version,
+		&created_at,
+		&note,
+		&lccn,
+		&isbn,
+		&title,
+		&author_first,
+		&author_last,
+		&copyright_date,
+		&publisher,
+		&location,
+		&genre,
+		&pages,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scan db row: %v", err)
+	}
+
+	version.CreatedAt = created_at
+	version.Note = getValidNullStr(note)
+	version.Book = Book{
+		Lccn:                getValidNullStr(lccn),
+		Isbn:                getValidNullStr(isbn),
+		Title:               getValidNullStr(title),
+		AuthorFirst:         getValidNullStr(author_first),
+		AuthorLast:          getValidNullStr(author_last),
+		CopyrightDate:       copyright_date,
+		CopyrightDateString: copyright_date.String(),
+		Publisher:           getValidNullStr(publisher),
+		Location:            getValidNullStr(location),
+		Genre:               getValidNullStr(genre),
+		Pages:               getValidNullStr(pages),
+		Id:                  version.BookId,
+		Version:             version.Version,
+	}
+
+	return &version, nil
+}
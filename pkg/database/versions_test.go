@@ -0,0 +1,118 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestSaveSnapshotsVersions(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	book.Title = "The Hobbit, or There and Back Again"
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := GetBookVersions(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want 2", len(versions))
+	}
+	// Newest first
+	if versions[0].Version != 2 || versions[0].Note != "Updated" || versions[0].Book.Title != book.Title {
+		t.Errorf("newest version = %d %q %q", versions[0].Version, versions[0].Note, versions[0].Book.Title)
+	}
+	if versions[1].Version != 1 || versions[1].Note != "Created" || versions[1].Book.Title != "The Hobbit" {
+		t.Errorf("first version = %d %q %q", versions[1].Version, versions[1].Note, versions[1].Book.Title)
+	}
+}
+
+func TestRevertBook(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien", Publisher: "Allen & Unwin"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	book.Title = "Farmer Giles of Ham"
+	book.Publisher = ""
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := RevertBook(1, book.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Title != "The Hobbit" || reverted.Publisher != "Allen & Unwin" {
+		t.Errorf("reverted to %q, %q", reverted.Title, reverted.Publisher)
+	}
+	stored, err := GetBookById(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "The Hobbit" || stored.Publisher != "Allen & Unwin" {
+		t.Errorf("stored book is %q, %q", stored.Title, stored.Publisher)
+	}
+
+	// The revert is a new version, and the versions it skipped stay
+	versions, err := GetBookVersions(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Note != "Reverted to version 1" {
+		t.Fatalf("got %d versions, newest %q", len(versions), versions[0].Note)
+	}
+	if versions[1].Book.Title != "Farmer Giles of Ham" {
+		t.Errorf("version 2 is %q", versions[1].Book.Title)
+	}
+}
+
+func TestRevertMissingVersion(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RevertBook(1, book.Id, 5); err == nil {
+		t.Errorf("reverted to a version that does not exist")
+	}
+	versions, err := GetBookVersions(1, book.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("failed revert left %d versions", len(versions))
+	}
+}
+
+// A revert applies on top of whatever version the book is at, so it never
+// conflicts.
+func TestRevertAfterAnotherEdit(t *testing.T) {
+	openTestDb(t, SchemaVersion)
+
+	book := Book{Id: -1, LibraryId: 1, Title: "The Hobbit", AuthorLast: "Tolkien"}
+	if _, err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"Farmer Giles of Ham", "Smith of Wootton Major"} {
+		book.Title = title
+		if _, err := book.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reverted, err := RevertBook(1, book.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Version != 4 || reverted.Title != "The Hobbit" {
+		t.Errorf("reverted book is %q version %d, want The Hobbit version 4", reverted.Title, reverted.Version)
+	}
+}
@@ -0,0 +1,300 @@
+// Package dates reads and writes the dates books are published on, which
+// are often known only to the year or month, only roughly, or only as a
+// range of years.
+//
+// A PartialDate is stored and exchanged as a Library of Congress Extended
+// Date/Time Format (EDTF) string: 1954, 1954-03, 1954-03-12, 1954~ for
+// about 1954 and 1950/1959 for a range. The text sorts in date order.
+package dates
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PartialDate is a year, a month of a year or a day, possibly only
+// roughly known, or a range of years. The zero PartialDate is no date.
+type PartialDate struct {
+	Year int
+	// Month is 0 when only the year is known, and Day when only the year
+	// and month are
+	Month int
+	Day   int
+	Circa bool
+	// EndYear is the last year of a range, 0 unless the date is a range
+	EndYear int
+}
+
+var ErrInvalid = errors.New("not a date")
+
+// Year returns the year y.
+func Year(y int) PartialDate {
+	return PartialDate{Year: y}
+}
+
+// Day returns the day of t.
+func Day(t time.Time) PartialDate {
+	return PartialDate{Year: t.Year(), Month: int(t.Month()), Day: t.Day()}
+}
+
+// IsZero is true when there is no date.
+func (d PartialDate) IsZero() bool {
+	return d.Year == 0
+}
+
+// IsRange is true when the date is a range of years.
+func (d PartialDate) IsRange() bool {
+	return d.EndYear != 0
+}
+
+// IsDay is true when the date is a single day known exactly.
+func (d PartialDate) IsDay() bool {
+	return d.Day != 0 && !d.Circa
+}
+
+// String is the date in EDTF, or empty when there is no date.
+func (d PartialDate) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	circa := ""
+	if d.Circa {
+		circa = "~"
+	}
+	switch {
+	case d.IsRange():
+		return fmt.Sprintf("%04d%s/%04d%s", d.Year, circa, d.EndYear, circa)
+	case d.Day != 0:
+		return fmt.Sprintf("%04d-%02d-%02d%s", d.Year, d.Month, d.Day, circa)
+	case d.Month != 0:
+		return fmt.Sprintf("%04d-%02d%s", d.Year, d.Month, circa)
+	}
+	return fmt.Sprintf("%04d%s", d.Year, circa)
+}
+
+// Display is the date as the pages show it: 03/12/1954, 03/1954, 1954,
+// c. 1954 or 1950–1959.
+func (d PartialDate) Display() string {
+	if d.IsZero() {
+		return ""
+	}
+	var s string
+	switch {
+	case d.IsRange():
+		s = fmt.Sprintf("%d–%d", d.Year, d.EndYear)
+	case d.Day != 0:
+		s = fmt.Sprintf("%02d/%02d/%d", d.Month, d.Day, d.Year)
+	case d.Month != 0:
+		s = fmt.Sprintf("%02d/%d", d.Month, d.Year)
+	default:
+		s = strconv.Itoa(d.Year)
+	}
+	if d.Circa {
+		s = "c. " + s
+	}
+	return s
+}
+
+// Start is the first day the date may be.
+func (d PartialDate) Start() time.Time {
+	if d.IsZero() {
+		return time.Time{}
+	}
+	month, day := max(d.Month, 1), max(d.Day, 1)
+	return time.Date(d.Year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// LastYear is the last year the date may be in.
+func (d PartialDate) LastYear() int {
+	if d.IsRange() {
+		return d.EndYear
+	}
+	return d.Year
+}
+
+// fullLayouts are the ways of writing a day or a month that Parse reads
+// besides EDTF.
+var fullLayouts = []struct {
+	layout string
+	month  bool
+	day    bool
+}{
+	{"01-02-2006", true, true},
+	{"01/02/2006", true, true},
+	{"2006/01/02", true, true},
+	{"2006.01.02", true, true},
+	{"January 2, 2006", true, true},
+	{"Jan 2, 2006", true, true},
+	{"Jan. 2, 2006", true, true},
+	{"2 January 2006", true, true},
+	{"2 Jan 2006", true, true},
+	{"2006-01-02T15:04:05Z07:00", true, true},
+	{"2006-01-02 15:04:05Z07:00", true, true},
+	{"2006-01-02 15:04:05", true, true},
+	{"January 2006", true, false},
+	{"Jan 2006", true, false},
+	{"Jan. 2006", true, false},
+	{"01/2006", true, false},
+	{"2006/01", true, false},
+}
+
+var (
+	edtfDate   = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2}))?)?([~?%]?)$`)
+	edtfRange  = regexp.MustCompile(`^(\d{4})[~?%]?/(\d{4})([~?%]?)$`)
+	yearRange  = regexp.MustCompile(`^(\d{4}) ?(?:-|–|—| to | or ) ?(\d{2,4})$`)
+	decade     = regexp.MustCompile(`^(\d{2,3})(-+|u+|x+|X+)$`)
+	circaWords = regexp.MustCompile(`^(?i:circa|ca\.?|c\.|c|approximately|approx\.?|about)\s*`)
+)
+
+// Parse reads a date in EDTF or in the ways dates are written in
+// catalogs: a full date such as 03/12/1954, March 1954, c1954, ©1954,
+// [1954?], 1950-1955 or [195-?] for some year in the 1950s. Empty text is
+// no date.
+func Parse(value string) (PartialDate, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return PartialDate{}, nil
+	}
+
+	var d PartialDate
+	// Square brackets mark a date the cataloger supplied, and a question
+	// mark one they were unsure of
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	s = strings.TrimSuffix(strings.TrimSpace(s), ".")
+	if strings.HasSuffix(s, "?") && !edtfDate.MatchString(s) {
+		d.Circa = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "?"))
+	}
+	// Copyright and phonogram marks. c1954 is copyright 1954, while c. 1954
+	// is about 1954
+	for _, mark := range []string{"©", "℗", "copyright ", "cop. ", "c", "p"} {
+		if rest, ok := strings.CutPrefix(s, mark); ok && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			s = rest
+		}
+	}
+	if m := circaWords.FindString(s); m != "" && len(m) < len(s) {
+		d.Circa = true
+		s = s[len(m):]
+	}
+	s = strings.TrimSuffix(s, "]")
+
+	parsed, err := parse(s)
+	if err != nil {
+		return PartialDate{}, fmt.Errorf("%w: %q", ErrInvalid, value)
+	}
+	parsed.Circa = parsed.Circa || d.Circa
+	return parsed, nil
+}
+
+func parse(s string) (PartialDate, error) {
+	var d PartialDate
+	if m := edtfDate.FindStringSubmatch(s); m != nil {
+		d.Year, _ = strconv.Atoi(m[1])
+		d.Month, _ = strconv.Atoi(m[2])
+		d.Day, _ = strconv.Atoi(m[3])
+		d.Circa = m[4] != ""
+		// 1954-58 is a range, read below
+		if d.check() == nil {
+			return d, nil
+		}
+		d = PartialDate{}
+	}
+	if m := edtfRange.FindStringSubmatch(s); m != nil {
+		d.Year, _ = strconv.Atoi(m[1])
+		d.EndYear, _ = strconv.Atoi(m[2])
+		d.Circa = m[3] != "" || strings.ContainsAny(s[:len(m[1])+1], "~?%")
+		return d, d.check()
+	}
+	if m := yearRange.FindStringSubmatch(s); m != nil {
+		d.Year, _ = strconv.Atoi(m[1])
+		d.EndYear, _ = strconv.Atoi(m[2])
+		// 1954-58 ends in 1958
+		if len(m[2]) < 4 {
+			d.EndYear, _ = strconv.Atoi(m[1][:4-len(m[2])] + m[2])
+		}
+		d.Circa = strings.Contains(s, " or ")
+		return d, d.check()
+	}
+	// 195- is some year of the 1950s, and 19-- of the twentieth century
+	if m := decade.FindStringSubmatch(s); m != nil && len(m[1])+len(m[2]) == 4 {
+		start, _ := strconv.Atoi(m[1])
+		span := 10
+		if len(m[1]) == 2 {
+			span = 100
+		}
+		d.Year = start * span
+		d.EndYear = d.Year + span - 1
+		return d, d.check()
+	}
+	for _, f := range fullLayouts {
+		t, err := time.Parse(f.layout, s)
+		if err != nil {
+			continue
+		}
+		d = PartialDate{Year: t.Year()}
+		if f.month {
+			d.Month = int(t.Month())
+		}
+		if f.day {
+			d.Day = t.Day()
+		}
+		return d, d.check()
+	}
+	return d, ErrInvalid
+}
+
+// check rejects dates with parts out of range, and year 1, which is how
+// a missing date used to be stored.
+func (d PartialDate) check() error {
+	if d.Year <= 1 || d.Year > 9999 || d.Month < 0 || d.Month > 12 {
+		return ErrInvalid
+	}
+	if d.Day != 0 {
+		if d.Month == 0 || d.Start().Day() != d.Day {
+			return ErrInvalid
+		}
+	}
+	if d.IsRange() && (d.EndYear <= d.Year || d.EndYear > 9999 || d.Month != 0) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Value stores the date as EDTF text, or NULL when there is no date.
+func (d PartialDate) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+// Scan reads a date stored by Value. Columns the driver reads as times
+// are taken as days.
+func (d *PartialDate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = PartialDate{}
+		return nil
+	case time.Time:
+		*d = PartialDate{}
+		if v.Year() > 1 {
+			*d = Day(v)
+		}
+		return nil
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	}
+	return fmt.Errorf("cannot read a date from %T", src)
+}
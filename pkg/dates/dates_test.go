@@ -0,0 +1,186 @@
+package dates
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  PartialDate
+	}{
+		{"", PartialDate{}},
+		{"1954", PartialDate{Year: 1954}},
+		{"1954-03", PartialDate{Year: 1954, Month: 3}},
+		{"1954-12", PartialDate{Year: 1954, Month: 12}},
+		{"1954-03-12", PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{"1954~", PartialDate{Year: 1954, Circa: true}},
+		{"1954-03?", PartialDate{Year: 1954, Month: 3, Circa: true}},
+		{"c1954", PartialDate{Year: 1954}},
+		{"c. 1954", PartialDate{Year: 1954, Circa: true}},
+		{"ca. 1954", PartialDate{Year: 1954, Circa: true}},
+		{"circa 1954", PartialDate{Year: 1954, Circa: true}},
+		{"©1954", PartialDate{Year: 1954}},
+		{"℗1954", PartialDate{Year: 1954}},
+		{"p1954", PartialDate{Year: 1954}},
+		{"copyright 1954", PartialDate{Year: 1954}},
+		{"1954.", PartialDate{Year: 1954}},
+		{"[1954]", PartialDate{Year: 1954}},
+		{"[1954?]", PartialDate{Year: 1954, Circa: true}},
+		{"[195-?]", PartialDate{Year: 1950, EndYear: 1959, Circa: true}},
+		{"195-", PartialDate{Year: 1950, EndYear: 1959}},
+		{"195u", PartialDate{Year: 1950, EndYear: 1959}},
+		{"19--", PartialDate{Year: 1900, EndYear: 1999}},
+		{"1950-1955", PartialDate{Year: 1950, EndYear: 1955}},
+		{"1950 to 1955", PartialDate{Year: 1950, EndYear: 1955}},
+		{"1954-58", PartialDate{Year: 1954, EndYear: 1958}},
+		{"1954 or 1955", PartialDate{Year: 1954, EndYear: 1955, Circa: true}},
+		{"1950/1959", PartialDate{Year: 1950, EndYear: 1959}},
+		{"1950~/1959~", PartialDate{Year: 1950, EndYear: 1959, Circa: true}},
+		{"March 1954", PartialDate{Year: 1954, Month: 3}},
+		{"March 12, 1954", PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{"12 Mar 1954", PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{"03/12/1954", PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{"03/1954", PartialDate{Year: 1954, Month: 3}},
+		{"1954-03-12T00:00:00Z", PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{"1954-03-12 00:00:00+00:00", PartialDate{Year: 1954, Month: 3, Day: 12}},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := Parse(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	values := []string{
+		"bogus",
+		"0001-01-01",
+		"0001-01-01 00:00:00+00:00",
+		"1954-13",
+		"1954-02-30",
+		"1954-00-12",
+		"1955-1950",
+		"1954-54",
+		"1950/1950",
+		"19",
+		"c",
+		"?",
+	}
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			got, err := Parse(value)
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("got %+v, %v, want ErrInvalid", got, err)
+			}
+		})
+	}
+}
+
+func TestStringAndDisplay(t *testing.T) {
+	tests := []struct {
+		date    PartialDate
+		edtf    string
+		display string
+	}{
+		{PartialDate{}, "", ""},
+		{Year(1954), "1954", "1954"},
+		{PartialDate{Year: 1954, Month: 3}, "1954-03", "03/1954"},
+		{PartialDate{Year: 1954, Month: 3, Day: 12}, "1954-03-12", "03/12/1954"},
+		{PartialDate{Year: 1954, Circa: true}, "1954~", "c. 1954"},
+		{PartialDate{Year: 1954, Month: 3, Circa: true}, "1954-03~", "c. 03/1954"},
+		{PartialDate{Year: 1950, EndYear: 1959}, "1950/1959", "1950–1959"},
+		{PartialDate{Year: 1950, EndYear: 1959, Circa: true}, "1950~/1959~", "c. 1950–1959"},
+		{Year(800), "0800", "800"},
+	}
+	for _, test := range tests {
+		t.Run(test.edtf, func(t *testing.T) {
+			if got := test.date.String(); got != test.edtf {
+				t.Errorf("String() = %q, want %q", got, test.edtf)
+			}
+			if got := test.date.Display(); got != test.display {
+				t.Errorf("Display() = %q, want %q", got, test.display)
+			}
+			// What String writes, Parse reads back
+			parsed, err := Parse(test.date.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed != test.date {
+				t.Errorf("Parse(String()) = %+v, want %+v", parsed, test.date)
+			}
+		})
+	}
+}
+
+func TestStartAndLastYear(t *testing.T) {
+	tests := []struct {
+		date  PartialDate
+		start time.Time
+		last  int
+	}{
+		{Year(1954), time.Date(1954, 1, 1, 0, 0, 0, 0, time.UTC), 1954},
+		{PartialDate{Year: 1954, Month: 3}, time.Date(1954, 3, 1, 0, 0, 0, 0, time.UTC), 1954},
+		{PartialDate{Year: 1954, Month: 3, Day: 12}, time.Date(1954, 3, 12, 0, 0, 0, 0, time.UTC), 1954},
+		{PartialDate{Year: 1950, EndYear: 1959}, time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC), 1959},
+	}
+	for _, test := range tests {
+		if got := test.date.Start(); !got.Equal(test.start) {
+			t.Errorf("%s: Start() = %v, want %v", test.date, got, test.start)
+		}
+		if got := test.date.LastYear(); got != test.last {
+			t.Errorf("%s: LastYear() = %d, want %d", test.date, got, test.last)
+		}
+	}
+	if !(PartialDate{}).Start().IsZero() {
+		t.Errorf("no date starts at %v", PartialDate{}.Start())
+	}
+}
+
+func TestValueAndScan(t *testing.T) {
+	value, err := PartialDate{}.Value()
+	if err != nil || value != nil {
+		t.Errorf("no date stored as %v, %v, want NULL", value, err)
+	}
+	value, err = PartialDate{Year: 1950, EndYear: 1959, Circa: true}.Value()
+	if err != nil || value != "1950~/1959~" {
+		t.Errorf("range stored as %v, %v", value, err)
+	}
+
+	tests := []struct {
+		src  interface{}
+		want PartialDate
+	}{
+		{nil, PartialDate{}},
+		{"1954-03", PartialDate{Year: 1954, Month: 3}},
+		{[]byte("1954~"), PartialDate{Year: 1954, Circa: true}},
+		{time.Date(1954, 3, 12, 0, 0, 0, 0, time.UTC), PartialDate{Year: 1954, Month: 3, Day: 12}},
+		{time.Time{}, PartialDate{}},
+	}
+	for _, test := range tests {
+		d := Year(2000)
+		if err := d.Scan(test.src); err != nil {
+			t.Errorf("Scan(%v): %v", test.src, err)
+			continue
+		}
+		if d != test.want {
+			t.Errorf("Scan(%v) = %+v, want %+v", test.src, d, test.want)
+		}
+	}
+
+	var d PartialDate
+	if err := d.Scan("bogus"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Scan(bogus) = %v, want ErrInvalid", err)
+	}
+	if err := d.Scan(1954); err == nil {
+		t.Errorf("Scan(1954) read an int")
+	}
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+// Issue is a problem with one field of a row. Rows with an error are not
+// imported, while warnings only point at values worth a second look.
+type Issue struct {
+	Field   string
+	Message string
+	Error   bool
+}
+
+// Row is a spreadsheet row read into a book. Number counts the data rows
+// from 1, not including the header.
+type Row struct {
+	Number int
+	Cells  []string
+	Book   database.Book
+	Issues []Issue
+}
+
+// Valid is true when the row has no errors and can be imported.
+func (r Row) Valid() bool {
+	for _, issue := range r.Issues {
+		if issue.Error {
+			return false
+		}
+	}
+	return true
+}
+
+// Issue returns the first issue with field, or nil.
+func (r Row) Issue(field string) *Issue {
+	for i := range r.Issues {
+		if r.Issues[i].Field == field {
+			return &r.Issues[i]
+		}
+	}
+	return nil
+}
+
+// Messages lists the issues of the row.
+func (r Row) Messages() []string {
+	var messages []string
+	for _, issue := range r.Issues {
+		messages = append(messages, issue.Message)
+	}
+	return messages
+}
+
+// Check reads cells into a book and checks it the way saving a book does,
+// along with the date, ISBN and page count.
+func (m Mapping) Check(number int, cells []string) Row {
+	row := Row{Number: number, Cells: cells}
+	book := &row.Book
+	book.Id = -1
+	for i, name := range m {
+		if name == "" || i >= len(cells) {
+			continue
+		}
+		value := strings.TrimSpace(unescapeFormula(cells[i]))
+		switch name {
+		case "lccn":
+			book.Lccn = value
+		case "isbn":
+			book.Isbn = value
+			if value == "" {
+				continue
+			}
+			err := CheckIsbn(value)
+			if err != nil {
+				row.warn(name, err.Error())
+			}
+		case "title":
+			book.Title = value
+		case "author_last":
+			book.AuthorLast = value
+		case "author_first":
+			book.AuthorFirst = value
+		case "copyright":
+			if value == "" {
+				continue
+			}
+			date, err := ParseDate(value)
+			if err != nil {
+				row.fail(name, err.Error())
+				continue
+			}
+			book.CopyrightDate = date
+			book.CopyrightDateString = date.String()
+		case "publisher":
+			book.Publisher = value
+		case "location":
+			book.Location = value
+		case "genre":
+			book.Genre = value
+		case "pages":
+			book.Pages = value
+			if value == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+				row.warn(name, fmt.Sprintf("pages %q is not a number of pages", value))
+			}
+		}
+	}
+	errors := book.Validate()
+	for _, field := range Fields {
+		if message, ok := errors[field.Name]; ok {
+			row.fail(field.Name, message)
+		}
+	}
+	return row
+}
+
+func (r *Row) warn(field string, message string) {
+	r.Issues = append(r.Issues, Issue{Field: field, Message: message})
+}
+
+func (r *Row) fail(field string, message string) {
+	r.Issues = append(r.Issues, Issue{Field: field, Message: message, Error: true})
+}
+
+// CheckIsbn checks an ISBN-10 or ISBN-13 has the right length and check
+// digit. Hyphens and spaces are ignored.
+func CheckIsbn(isbn string) error {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+	sum := 0
+	switch len(digits) {
+	case 10:
+		for i, r := range digits {
+			value := int(r - '0')
+			if r == 'X' && i == 9 {
+				value = 10
+			} else if r < '0' || r > '9' {
+				return fmt.Errorf("ISBN %q has a character that is not a digit", isbn)
+			}
+			sum += (10 - i) * value
+		}
+		if sum%11 != 0 {
+			return fmt.Errorf("ISBN %q has the wrong check digit", isbn)
+		}
+	case 13:
+		for i, r := range digits {
+			if r < '0' || r > '9' {
+				return fmt.Errorf("ISBN %q has a character that is not a digit", isbn)
+			}
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += weight * int(r-'0')
+		}
+		if sum%10 != 0 {
+			return fmt.Errorf("ISBN %q has the wrong check digit", isbn)
+		}
+	default:
+		return fmt.Errorf("ISBN %q is not 10 or 13 digits", isbn)
+	}
+	return nil
+}
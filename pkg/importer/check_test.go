@@ -0,0 +1,38 @@
+package importer
+
+import "testing"
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"The Hobbit", "The Hobbit"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tTab", "'\tTab"},
+		{"'quoted", "'quoted"},
+	}
+	for _, test := range tests {
+		if got := EscapeFormula(test.value); got != test.want {
+			t.Errorf("EscapeFormula(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCheckReadsEscapedFormulas(t *testing.T) {
+	mapping := Mapping{"title", "author_last", "publisher"}
+	row := mapping.Check(1, []string{EscapeFormula("=1+1"), EscapeFormula("-Smith"), "'Quoted"})
+	if row.Book.Title != "=1+1" {
+		t.Errorf("title = %q, want =1+1", row.Book.Title)
+	}
+	if row.Book.AuthorLast != "-Smith" {
+		t.Errorf("author = %q, want -Smith", row.Book.AuthorLast)
+	}
+	if row.Book.Publisher != "'Quoted" {
+		t.Errorf("publisher = %q, want 'Quoted", row.Book.Publisher)
+	}
+}
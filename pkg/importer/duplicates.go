@@ -0,0 +1,162 @@
+package importer
+
+import (
+	"fmt"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+// Policy decides what an import does with a record that matches a book
+// already in the library.
+type Policy string
+
+const (
+	// PolicySkip leaves the stored book alone and drops the record
+	PolicySkip Policy = "skip"
+	// PolicyUpdate fills the stored book in with the record's values
+	PolicyUpdate Policy = "update"
+	// PolicyCreate adds the record as another book
+	PolicyCreate Policy = "create"
+	// PolicyAsk holds the record back until someone decides
+	PolicyAsk Policy = "ask"
+)
+
+// ParsePolicy reads a policy, defaulting to PolicySkip.
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(s); policy {
+	case "":
+		return PolicySkip, nil
+	case PolicySkip, PolicyUpdate, PolicyCreate, PolicyAsk:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%q is not a duplicate policy", s)
+}
+
+// Merge copies the values incoming has onto a stored book. Fields incoming
+// leaves empty keep their stored values.
+func Merge(stored database.Book, incoming database.Book) database.Book {
+	merged := stored
+	set := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	set(&merged.Lccn, incoming.Lccn)
+	set(&merged.Isbn, incoming.Isbn)
+	set(&merged.Title, incoming.Title)
+	set(&merged.AuthorLast, incoming.AuthorLast)
+	set(&merged.AuthorFirst, incoming.AuthorFirst)
+	set(&merged.Publisher, incoming.Publisher)
+	set(&merged.Location, incoming.Location)
+	set(&merged.Genre, incoming.Genre)
+	set(&merged.Pages, incoming.Pages)
+	if !incoming.CopyrightDate.IsZero() {
+		merged.CopyrightDate = incoming.CopyrightDate
+		merged.CopyrightDateString = incoming.CopyrightDate.String()
+	}
+	return merged
+}
+
+// Action is what an import does with one record.
+type Action int
+
+const (
+	// Create adds the record as a book
+	Create Action = iota
+	// Update writes the record's values onto the book it matches
+	Update
+	// Ask holds the record back until someone decides
+	Ask
+	// Skip drops the record
+	Skip
+)
+
+// Decision is what an import does with a record. Book is what to write:
+// the record's book, or for an update the stored book with the record's
+// values merged in. Match is the stored book the record duplicates, if any.
+type Decision struct {
+	Action   Action
+	Book     database.Book
+	Match    *database.Match
+	Warnings []string
+}
+
+// Decider applies a policy to the records of one import, in order, so that
+// the upload page and the command line importers treat duplicates alike.
+type Decider struct {
+	libraryId int
+	policy    Policy
+	// seen maps the duplicate keys of the records decided so far to their
+	// numbers, and updated the stored books they update
+	seen    map[string]int
+	updated map[int]bool
+}
+
+// NewDecider decides for an import into libraryId.
+func NewDecider(libraryId int, policy Policy) *Decider {
+	return &Decider{
+		libraryId: libraryId,
+		policy:    policy,
+		seen:      map[string]int{},
+		updated:   map[int]bool{},
+	}
+}
+
+// Decide decides what to do with record number, which mapped onto book.
+func (d *Decider) Decide(number int, book database.Book) (Decision, error) {
+	// The same book twice in one import is only added twice when asked to
+	keys := database.DuplicateKeys(book)
+	if d.policy != PolicyCreate {
+		for _, key := range keys {
+			if earlier, ok := d.seen[key]; ok {
+				return Decision{
+					Action:   Skip,
+					Book:     book,
+					Warnings: []string{fmt.Sprintf("same book as record %d", earlier)},
+				}, nil
+			}
+		}
+	}
+
+	match, err := database.FindDuplicate(d.libraryId, book)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision := Decision{Action: Create, Book: book, Match: match}
+	if match != nil {
+		decision.Warnings = append(decision.Warnings, fmt.Sprintf("same %s as %q", match.By, match.Book.Title))
+		switch d.policy {
+		case PolicyCreate:
+			// added again, as decided
+		case PolicyUpdate:
+			if d.ClaimUpdate(match.Book.Id) {
+				decision.Action = Update
+				decision.Book = Merge(match.Book, book)
+			} else {
+				decision.Action = Skip
+				decision.Warnings = append(decision.Warnings, "the book was already updated by an earlier record")
+			}
+		case PolicyAsk:
+			decision.Action = Ask
+		default:
+			decision.Action = Skip
+		}
+	}
+	for _, key := range keys {
+		if _, ok := d.seen[key]; !ok {
+			d.seen[key] = number
+		}
+	}
+	return decision, nil
+}
+
+// ClaimUpdate reports whether the stored book id can still be updated,
+// marking it updated. A book is only updated once per import, as the
+// second update would be of a version that is gone.
+func (d *Decider) ClaimUpdate(id int) bool {
+	if d.updated[id] {
+		return false
+	}
+	d.updated[id] = true
+	return true
+}
@@ -0,0 +1,220 @@
+// Package importer reads spreadsheets of books whose columns are named by a
+// header row, matching the names to book fields.
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"mlibrary-htmx/pkg/dates"
+)
+
+// RowReader reads the rows of a spreadsheet, returning io.EOF after the last
+// one. *csv.Reader is a RowReader.
+type RowReader interface {
+	Read() ([]string, error)
+}
+
+// NewCSVReader reads comma separated rows, allowing rows to have fewer or
+// more cells than the header.
+func NewCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// Field is a book field a column can be mapped to. Aliases are other column
+// names the field goes by.
+type Field struct {
+	Name     string
+	Label    string
+	Aliases  []string
+	Required bool
+}
+
+// Fields are the book fields in the order of the import template.
+var Fields = []Field{
+	{Name: "lccn", Label: "LCCN", Aliases: []string{"lc control number", "library of congress control number", "lc number"}},
+	{Name: "isbn", Label: "ISBN", Aliases: []string{"isbn10", "isbn13", "isbn 10", "isbn 13", "isbn number"}},
+	{Name: "title", Label: "Title", Aliases: []string{"book title", "name"}, Required: true},
+	{Name: "author_last", Label: "Author last name", Aliases: []string{"last name", "author last", "author surname", "surname", "family name"}, Required: true},
+	{Name: "author_first", Label: "Author first name", Aliases: []string{"first name", "author first", "given name", "forename"}},
+	{Name: "copyright", Label: "Copyright date", Aliases: []string{"copyright date", "date", "year", "published", "publication date", "publication year", "year published", "pub date"}},
+	{Name: "publisher", Label: "Publisher", Aliases: []string{"publisher name", "imprint"}},
+	{Name: "location", Label: "Location", Aliases: []string{"place", "place of publication", "publication place", "city"}},
+	{Name: "genre", Label: "Genre", Aliases: []string{"subject", "category"}},
+	{Name: "pages", Label: "Pages", Aliases: []string{"page count", "number of pages", "num pages", "extent"}},
+}
+
+// DateLayout is how the import template writes a copyright date that is a
+// day.
+const DateLayout = "01-02-2006"
+
+// normalizeColumn folds case, punctuation and spacing out of a column name.
+func normalizeColumn(name string) string {
+	name = strings.TrimPrefix(name, "\ufeff")
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '_', '-', '.', ':', '/':
+			return ' '
+		}
+		return r
+	}, strings.ToLower(name))
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func findField(name string) (Field, bool) {
+	for _, field := range Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// Mapping names the field each column holds, with "" for columns that are
+// not imported.
+type Mapping []string
+
+// MatchColumns maps each header cell whose name or alias is known to its
+// field. A field is only mapped from the first column that matches it.
+func MatchColumns(header []string) Mapping {
+	mapping := make(Mapping, len(header))
+	used := map[string]bool{}
+	for i, column := range header {
+		column = normalizeColumn(column)
+		for _, field := range Fields {
+			if used[field.Name] {
+				continue
+			}
+			matches := column == normalizeColumn(field.Name) || column == normalizeColumn(field.Label)
+			for _, alias := range field.Aliases {
+				matches = matches || column == alias
+			}
+			if matches {
+				mapping[i] = field.Name
+				used[field.Name] = true
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// Validate checks every field is known and mapped at most once, and that
+// the fields a book needs are mapped.
+func (m Mapping) Validate() error {
+	used := map[string]bool{}
+	for _, name := range m {
+		if name == "" {
+			continue
+		}
+		field, ok := findField(name)
+		if !ok {
+			return fmt.Errorf("%q is not a book field", name)
+		}
+		if used[name] {
+			return fmt.Errorf("more than one column is mapped to %s", field.Label)
+		}
+		used[name] = true
+	}
+	for _, field := range Fields {
+		if field.Required && !used[field.Name] {
+			return fmt.Errorf("a column must be mapped to %s", field.Label)
+		}
+	}
+	return nil
+}
+
+// ParseDate reads a copyright date, which may be a year, a month, a day,
+// a rough date such as c1954 or a range of years.
+func ParseDate(value string) (dates.PartialDate, error) {
+	date, err := dates.Parse(value)
+	if err != nil {
+		return date, fmt.Errorf("copyright date %q is not a date like 1954, %s or c1954", value, DateLayout)
+	}
+	return date, nil
+}
+
+// FormatDate writes a copyright date the way the import template does,
+// and dates that are not a day in EDTF, which ParseDate also reads.
+func FormatDate(date dates.PartialDate) string {
+	if date.IsDay() {
+		return date.Start().Format(DateLayout)
+	}
+	return date.String()
+}
+
+// formulaStarts are the characters that make a spreadsheet read a cell as
+// a formula.
+const formulaStarts = "=+-@\t\r"
+
+// EscapeFormula puts a quote before a value that a spreadsheet opening a
+// CSV file would run as a formula. Check takes the quote off again, so
+// exports still import.
+func EscapeFormula(value string) string {
+	if value != "" && strings.ContainsRune(formulaStarts, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeFormula undoes EscapeFormula.
+func unescapeFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(formulaStarts, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+// HEADER_SEARCH_ROWS is how many rows ReadHeader looks through for the
+// header, as spreadsheets often start with a title or notes above it.
+const HEADER_SEARCH_ROWS = 10
+
+// ReadHeader finds the header row: the first row naming at least two book
+// fields, or the first row when none does. The returned reader reads the
+// rows after the header.
+func ReadHeader(r RowReader) ([]string, RowReader, error) {
+	var rows [][]string
+	for len(rows) < HEADER_SEARCH_ROWS {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		matched := 0
+		for _, field := range MatchColumns(row) {
+			if field != "" {
+				matched++
+			}
+		}
+		if matched >= 2 {
+			return row, r, nil
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, r, io.EOF
+	}
+	return rows[0], &bufferedRows{rows: rows[1:], r: r}, nil
+}
+
+// bufferedRows reads rows already taken from r before the rest of r.
+type bufferedRows struct {
+	rows [][]string
+	r    RowReader
+}
+
+func (b *bufferedRows) Read() ([]string, error) {
+	if len(b.rows) > 0 {
+		row := b.rows[0]
+		b.rows = b.rows[1:]
+		return row, nil
+	}
+	return b.r.Read()
+}
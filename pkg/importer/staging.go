@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ErrUploadNotFound is returned for a staged upload that does not exist,
+// such as one already imported.
+var ErrUploadNotFound = errors.New("staged upload not found")
+
+var stagedId = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// StagedLifetime is how long an upload waits for its import to be
+// confirmed before it is removed.
+const StagedLifetime = 24 * time.Hour
+
+// stagedPath is where the upload with id is kept. The id is checked first,
+// so it can come from a request.
+func stagedPath(id string) (string, error) {
+	if !stagedId.MatchString(id) {
+		return "", ErrUploadNotFound
+	}
+	return filepath.Join(os.TempDir(), "mlibrary-import-"+id), nil
+}
+
+// Stage copies an upload to the temporary directory under a random name,
+// keeping it until the import is confirmed, and returns the id to open it
+// by.
+func Stage(src io.Reader) (string, error) {
+	removeExpired()
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to name staged upload: %v", err)
+	}
+	id := hex.EncodeToString(b)
+	path, err := stagedPath(id)
+	if err != nil {
+		return "", err
+	}
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("unable to stage upload: %v", err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("unable to stage upload: %v", err)
+	}
+	return id, nil
+}
+
+// OpenStaged opens the staged upload with id.
+func OpenStaged(id string) (*os.File, error) {
+	path, err := stagedPath(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	return f, err
+}
+
+// RemoveStaged deletes the staged upload with id.
+func RemoveStaged(id string) error {
+	path, err := stagedPath(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// removeExpired deletes uploads that were staged but never imported.
+func removeExpired() {
+	paths, _ := filepath.Glob(filepath.Join(os.TempDir(), "mlibrary-import-*"))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err == nil && time.Since(info.ModTime()) > StagedLifetime {
+			os.Remove(path)
+		}
+	}
+}
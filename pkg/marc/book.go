@@ -0,0 +1,327 @@
+package marc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+var (
+	yearPattern  = regexp.MustCompile(`\d{4}`)
+	datePattern  = regexp.MustCompile(`^\d{2,3}[\du]{1,2}$`)
+	pagesPattern = regexp.MustCompile(`(\d+)\s*(p\b|p\.|pages)`)
+)
+
+// Identifier names a record in messages, preferring its control number.
+func (r *Record) Identifier() string {
+	return strings.TrimSpace(r.ControlField("001"))
+}
+
+// ToBook maps a bibliographic record onto a book. Warnings describe data
+// that could not be carried over. ok is false when the record lacks the
+// title or author every book needs.
+func ToBook(r *Record) (book database.Book, warnings []string, ok bool) {
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	if len(r.Leader) > 6 && r.Leader[6] != 'a' && r.Leader[6] != 't' {
+		warn("record type %q is not language material", r.Leader[6])
+	}
+	if r.Lossy {
+		warn("some MARC-8 characters could not be converted")
+	}
+
+	// 020 ISBN, skipping fields that only carry a cancelled $z
+	for _, f := range r.DataFields("020") {
+		if isbn := f.Subfield('a'); isbn != "" {
+			book.Isbn = cleanIsbn(isbn)
+			if len(book.Isbn) != 10 && len(book.Isbn) != 13 {
+				warn("020 ISBN %q is not 10 or 13 characters", isbn)
+			}
+			break
+		}
+	}
+
+	// 010 LCCN
+	for _, f := range r.DataFields("010") {
+		book.Lccn = strings.TrimSpace(f.Subfield('a'))
+		break
+	}
+
+	// 100 main entry, falling back to the first 700 added entry
+	authors := append(r.DataFields("100"), r.DataFields("700")...)
+	if len(authors) > 0 {
+		book.AuthorLast, book.AuthorFirst = splitName(authors[0])
+		if len(r.DataFields("100")) == 0 {
+			warn("no 100 main entry, used the first 700 author")
+		}
+		if extra := len(authors) - 1; extra > 0 {
+			warn("only one author is kept, %d more in 700 dropped", extra)
+		}
+	} else {
+		for _, tag := range []string{"110", "111"} {
+			if fields := r.DataFields(tag); len(fields) > 0 {
+				book.AuthorLast = trimPunctuation(fields[0].Subfield('a'))
+				warn("no personal author, used the %s corporate name", tag)
+				break
+			}
+		}
+	}
+
+	// 245 title with its remainder, part number and part name
+	for _, f := range r.DataFields("245") {
+		var parts []string
+		for _, s := range f.Subfields {
+			switch s.Code {
+			case 'a', 'b', 'n', 'p':
+				if part := trimPunctuation(s.Value); part != "" {
+					parts = append(parts, part)
+				}
+			}
+		}
+		book.Title = strings.Join(parts, ": ")
+		break
+	}
+
+	// 264 with second indicator 1 is the publication statement. Older records
+	// use 260 instead.
+	var imprint *Field
+	for _, f := range r.DataFields("264") {
+		if f.Indicator2 == '1' {
+			imprint = &f
+			break
+		}
+	}
+	if imprint == nil {
+		if fields := r.DataFields("260"); len(fields) > 0 {
+			imprint = &fields[0]
+		}
+	}
+	date := ""
+	if imprint != nil {
+		book.Location = trimPunctuation(imprint.Subfield('a'))
+		book.Publisher = trimPunctuation(imprint.Subfield('b'))
+		date = imprint.Subfield('c')
+	}
+	if date == "" {
+		// 264 with second indicator 4 holds the copyright date
+		for _, f := range r.DataFields("264") {
+			if f.Indicator2 == '4' {
+				date = f.Subfield('c')
+				break
+			}
+		}
+	}
+	// 046 holds the date as it was known when the record was exported
+	// from here
+	var exact dates.PartialDate
+	for _, f := range r.DataFields("046") {
+		exact = readDate046(f)
+		break
+	}
+	if !exact.IsZero() {
+		book.CopyrightDate = exact
+	} else if parsed, err := dates.Parse(trimPunctuation(date)); err == nil && !parsed.IsZero() {
+		book.CopyrightDate = parsed
+	} else if year := yearPattern.FindString(date); year != "" {
+		book.CopyrightDate, _ = dates.Parse(year)
+	} else if fixed := r.ControlField("008"); len(fixed) >= 11 && datePattern.MatchString(fixed[7:11]) {
+		// Date1 may leave unknown digits as u, as in 195u
+		book.CopyrightDate, _ = dates.Parse(fixed[7:11])
+		if date != "" {
+			warn("date %q has no year, used 008", date)
+		}
+	} else if date != "" {
+		warn("date %q has no year", date)
+	}
+	book.CopyrightDateString = book.CopyrightDate.String()
+
+	// 300 physical description
+	for _, f := range r.DataFields("300") {
+		extent := f.Subfield('a')
+		if match := pagesPattern.FindStringSubmatch(extent); match != nil {
+			book.Pages = match[1]
+		} else if extent != "" {
+			warn("extent %q is not a page count", trimPunctuation(extent))
+		}
+		break
+	}
+
+	// 655 genre/form, falling back to the first 650 topical subject
+	subjects := append(r.DataFields("655"), r.DataFields("650")...)
+	if len(subjects) > 0 {
+		book.Genre = trimPunctuation(subjects[0].Subfield('a'))
+		if extra := len(subjects) - 1; extra > 0 {
+			warn("only one genre is kept, %d more subject headings dropped", extra)
+		}
+	}
+
+	if book.Title == "" {
+		warn("no 245 title, skipped")
+	}
+	if book.AuthorLast == "" {
+		warn("no author in 100, 110, 111 or 700, skipped")
+	}
+	book.Id = -1
+	return book, warnings, book.Title != "" && book.AuthorLast != ""
+}
+
+// FromBook builds a record holding every field a book has. ISBD
+// punctuation is omitted, as the leader declares, and the full copyright
+// date is kept in 046 so ToBook reads back the same book.
+func FromBook(b database.Book) *Record {
+	r := &Record{Leader: "00000nam a2200000 c 4500"}
+	if b.Id > 0 {
+		r.Fields = append(r.Fields, Field{Tag: "001", Value: strconv.Itoa(b.Id)})
+	}
+
+	entered := "      "
+	if !b.CreatedDate.IsZero() {
+		entered = b.CreatedDate.Format("060102")
+	}
+	// A single known date, or a questionable one between Date1 and Date2
+	kind, date1, date2 := 's', "    ", "    "
+	if !b.CopyrightDate.IsZero() {
+		date1 = fmt.Sprintf("%04d", b.CopyrightDate.Year)
+		if b.CopyrightDate.Circa || b.CopyrightDate.IsRange() {
+			kind = 'q'
+			date2 = fmt.Sprintf("%04d", b.CopyrightDate.LastYear())
+		}
+	}
+	r.Fields = append(r.Fields, Field{Tag: "008", Value: fmt.Sprintf("%s%c%s%s%-25s", entered, kind, date1, date2, "")})
+
+	data := func(tag string, ind1 byte, ind2 byte, subfields ...Subfield) {
+		var present []Subfield
+		for _, s := range subfields {
+			if s.Value != "" {
+				present = append(present, s)
+			}
+		}
+		if len(present) > 0 {
+			r.Fields = append(r.Fields, Field{Tag: tag, Indicator1: ind1, Indicator2: ind2, Subfields: present})
+		}
+	}
+
+	data("010", ' ', ' ', Subfield{'a', b.Lccn})
+	data("020", ' ', ' ', Subfield{'a', b.Isbn})
+	if !b.CopyrightDate.IsZero() {
+		data("046", ' ', ' ', date046(b.CopyrightDate)...)
+	}
+
+	if b.AuthorFirst != "" {
+		data("100", '1', ' ', Subfield{'a', b.AuthorLast + ", " + b.AuthorFirst})
+	} else {
+		data("100", '0', ' ', Subfield{'a', b.AuthorLast})
+	}
+
+	titleIndicator := byte('0')
+	if b.AuthorLast != "" {
+		titleIndicator = '1'
+	}
+	title, remainder, _ := strings.Cut(b.Title, ": ")
+	data("245", titleIndicator, '0', Subfield{'a', title}, Subfield{'b', remainder})
+
+	data("264", ' ', '1', Subfield{'a', b.Location}, Subfield{'b', b.Publisher}, Subfield{'c', imprintDate(b.CopyrightDate)})
+
+	pages := b.Pages
+	if _, err := strconv.Atoi(pages); err == nil {
+		pages += " pages"
+	}
+	data("300", ' ', ' ', Subfield{'a', pages})
+	if b.Genre != "" {
+		data("655", ' ', '7', Subfield{'a', b.Genre}, Subfield{'2', "local"})
+	}
+	return r
+}
+
+// splitName reads "Last, First" from $a of a personal name field.
+func splitName(f Field) (string, string) {
+	name := trimPunctuation(f.Subfield('a'))
+	last, first, found := strings.Cut(name, ",")
+	if !found || f.Indicator1 == '0' {
+		// Forename only, as in "Homer"
+		return name, ""
+	}
+	return trimPunctuation(last), trimPunctuation(first)
+}
+
+func cleanIsbn(isbn string) string {
+	fields := strings.Fields(isbn)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(strings.ReplaceAll(fields[0], "-", ""))
+}
+
+// trimPunctuation removes the ISBD punctuation cataloguers end subfields
+// with. A final period is kept after an initial such as "J. R. R."
+// imprintDate writes the year of a date as catalogers do in 264 $c: 1954,
+// 1950-1959, or [1954?] when it is uncertain.
+func imprintDate(date dates.PartialDate) string {
+	if date.IsZero() {
+		return ""
+	}
+	year := strconv.Itoa(date.Year)
+	if date.IsRange() {
+		year += "-" + strconv.Itoa(date.EndYear)
+	}
+	if date.Circa {
+		year = "[" + year + "?]"
+	}
+	return year
+}
+
+// date046 writes a date as 046 subfields: $k the date, or the first year
+// of a range with $l the last. Rough dates are written in EDTF, named by
+// $2.
+func date046(date dates.PartialDate) []Subfield {
+	if date.Circa {
+		return []Subfield{{'k', date.String()}, {'2', "edtf"}}
+	}
+	if date.IsRange() {
+		return []Subfield{{'k', strconv.Itoa(date.Year)}, {'l', strconv.Itoa(date.EndYear)}}
+	}
+	return []Subfield{{'k', strings.ReplaceAll(date.String(), "-", "")}}
+}
+
+// readDate046 reads the date date046 writes. $k and $l are yyyy, yyyymm or
+// yyyymmdd unless $2 names EDTF.
+func readDate046(f Field) dates.PartialDate {
+	k := f.Subfield('k')
+	if f.Subfield('2') == "edtf" {
+		date, _ := dates.Parse(k)
+		return date
+	}
+	if len(k) > 4 {
+		k = k[:4] + "-" + k[4:]
+	}
+	if len(k) > 7 {
+		k = k[:7] + "-" + k[7:]
+	}
+	if l := f.Subfield('l'); l != "" {
+		k += "/" + l
+	}
+	date, _ := dates.Parse(k)
+	return date
+}
+
+func trimPunctuation(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), " ,:;/=")
+	if strings.HasSuffix(s, ".") {
+		trimmed := strings.TrimSuffix(s, ".")
+		last, _ := utf8.DecodeLastRuneInString(trimmed)
+		before := strings.TrimSuffix(trimmed, string(last))
+		isInitial := unicode.IsUpper(last) && (before == "" || strings.HasSuffix(before, " ") || strings.HasSuffix(before, "."))
+		if !isInitial {
+			s = trimmed
+		}
+	}
+	return strings.TrimSpace(s)
+}
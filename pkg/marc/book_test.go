@@ -0,0 +1,169 @@
+package marc
+
+import (
+	"bytes"
+	"testing"
+
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/dates"
+)
+
+// A book written as MARCXML reads back as the same book, whatever is known
+// of its date.
+func TestFromBookRoundTrip(t *testing.T) {
+	book := database.Book{
+		Lccn:        "37019245",
+		Isbn:        "9780261102217",
+		Title:       "The Hobbit: There and Back Again",
+		AuthorLast:  "Tolkien",
+		AuthorFirst: "J. R. R.",
+		Publisher:   "Allen & Unwin",
+		Location:    "London",
+		Genre:       "Fantasy",
+		Pages:       "310",
+	}
+	for _, date := range []string{"", "1937", "1937-09", "1937-09-21", "1937~", "1937-09~", "1930/1939", "1930~/1939~"} {
+		t.Run(date, func(t *testing.T) {
+			book.CopyrightDate, _ = dates.Parse(date)
+			book.CopyrightDateString = book.CopyrightDate.String()
+
+			var b bytes.Buffer
+			if err := WriteXMLRecord(&b, FromBook(book)); err != nil {
+				t.Fatal(err)
+			}
+			record, err := NewXMLReader(&b).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, warnings, ok := ToBook(record)
+			if !ok || len(warnings) > 0 {
+				t.Errorf("ok %v, warnings %v", ok, warnings)
+			}
+			got.Id = 0
+			if got != book {
+				t.Errorf("got %+v\nwant %+v", got, book)
+			}
+		})
+	}
+}
+
+func TestToBook(t *testing.T) {
+	record := &Record{Leader: "00000nam a2200000 i 4500", Fields: []Field{
+		{Tag: "001", Value: "12345"},
+		{Tag: "008", Value: "370921s1937    enk           000 1 eng d"},
+		{Tag: "010", Subfields: []Subfield{{'a', "   37019245 "}}},
+		{Tag: "020", Subfields: []Subfield{{'z', "0000000000"}}},
+		{Tag: "020", Subfields: []Subfield{{'a', "978-0-261-10221-7 (pbk.)"}}},
+		{Tag: "100", Indicator1: '1', Subfields: []Subfield{{'a', "Tolkien, J. R. R.,"}, {'e', "author."}}},
+		{Tag: "245", Indicator1: '1', Indicator2: '4', Subfields: []Subfield{{'a', "The hobbit :"}, {'b', "there and back again /"}, {'c', "J.R.R. Tolkien."}}},
+		{Tag: "264", Indicator2: '1', Subfields: []Subfield{{'a', "London :"}, {'b', "Allen & Unwin,"}, {'c', "1937."}}},
+		{Tag: "300", Subfields: []Subfield{{'a', "310 p. :"}, {'b', "ill."}}},
+		{Tag: "655", Indicator2: '7', Subfields: []Subfield{{'a', "Fantasy fiction."}}},
+	}}
+	book, warnings, ok := ToBook(record)
+	if !ok || len(warnings) > 0 {
+		t.Fatalf("ok %v, warnings %v", ok, warnings)
+	}
+	got := map[string]string{
+		"Isbn":          book.Isbn,
+		"Lccn":          book.Lccn,
+		"AuthorLast":    book.AuthorLast,
+		"AuthorFirst":   book.AuthorFirst,
+		"Title":         book.Title,
+		"Location":      book.Location,
+		"Publisher":     book.Publisher,
+		"CopyrightDate": book.CopyrightDateString,
+		"Pages":         book.Pages,
+		"Genre":         book.Genre,
+	}
+	for field, want := range map[string]string{
+		"Isbn":          "9780261102217",
+		"Lccn":          "37019245",
+		"AuthorLast":    "Tolkien",
+		"AuthorFirst":   "J. R. R.",
+		"Title":         "The hobbit: there and back again",
+		"Location":      "London",
+		"Publisher":     "Allen & Unwin",
+		"CopyrightDate": "1937",
+		"Pages":         "310",
+		"Genre":         "Fantasy fiction",
+	} {
+		if got[field] != want {
+			t.Errorf("%s = %q, want %q", field, got[field], want)
+		}
+	}
+}
+
+func TestToBookDates(t *testing.T) {
+	author := Field{Tag: "100", Indicator1: '1', Subfields: []Subfield{{'a', "Tolkien, J. R. R.,"}}}
+	title := Field{Tag: "245", Indicator1: '1', Indicator2: '0', Subfields: []Subfield{{'a', "The hobbit /"}}}
+	imprint := func(tag string, ind2 byte, date string) Field {
+		return Field{Tag: tag, Indicator2: ind2, Subfields: []Subfield{{'c', date}}}
+	}
+	fixed := func(date1 string) Field {
+		return Field{Tag: "008", Value: "370921s" + date1 + "    enk           000 1 eng d"}
+	}
+	tests := []struct {
+		name   string
+		fields []Field
+		want   string
+	}{
+		{"264 year", []Field{imprint("264", '1', "1937.")}, "1937"},
+		{"264 copyright", []Field{imprint("264", '1', "c1937.")}, "1937"},
+		{"260 circa", []Field{imprint("260", ' ', "ca. 1937.")}, "1937~"},
+		{"260 uncertain decade", []Field{imprint("260", ' ', "[193-?]")}, "1930~/1939~"},
+		{"260 range", []Field{imprint("260", ' ', "1937-49.")}, "1937/1949"},
+		{"264 copyright statement", []Field{imprint("264", '4', "©1937")}, "1937"},
+		{"year in text", []Field{imprint("264", '1', "printed in 1937 or so")}, "1937"},
+		{"008 only", []Field{fixed("1937")}, "1937"},
+		{"008 unknown digit", []Field{fixed("193u")}, "1930/1939"},
+		{"046 over 264", []Field{imprint("264", '1', "1937."), {Tag: "046", Subfields: []Subfield{{'k', "19370921"}}}}, "1937-09-21"},
+		{"no date", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := &Record{Leader: "00000nam a2200000 i 4500", Fields: append([]Field{author, title}, test.fields...)}
+			book, _, ok := ToBook(record)
+			if !ok {
+				t.Fatal("record was skipped")
+			}
+			if book.CopyrightDateString != test.want {
+				t.Errorf("date = %q, want %q", book.CopyrightDateString, test.want)
+			}
+		})
+	}
+}
+
+func TestToBookFallbacks(t *testing.T) {
+	record := &Record{Leader: "00000nam a2200000 i 4500", Fields: []Field{
+		{Tag: "008", Value: "370921s1937    enk           000 1 eng d"},
+		{Tag: "245", Indicator1: '1', Indicator2: '0', Subfields: []Subfield{{'a', "The hobbit /"}}},
+		{Tag: "260", Subfields: []Subfield{{'a', "London :"}, {'b', "Allen & Unwin,"}, {'c', "[n.d.]"}}},
+		{Tag: "650", Indicator2: '0', Subfields: []Subfield{{'a', "Middle Earth (Imaginary place)"}}},
+		{Tag: "700", Indicator1: '1', Subfields: []Subfield{{'a', "Tolkien, J. R. R."}}},
+		{Tag: "700", Indicator1: '1', Subfields: []Subfield{{'a', "Baynes, Pauline."}}},
+	}}
+	book, warnings, ok := ToBook(record)
+	if !ok {
+		t.Fatalf("record was skipped: %v", warnings)
+	}
+	if book.AuthorLast != "Tolkien" || book.Publisher != "Allen & Unwin" || book.Genre != "Middle Earth (Imaginary place)" {
+		t.Errorf("got %+v", book)
+	}
+	if book.CopyrightDateString != "1937" {
+		t.Errorf("date = %q, want the 008 year", book.CopyrightDateString)
+	}
+	// first 700 used, second 700 dropped, 260 date without a year
+	if len(warnings) != 3 {
+		t.Errorf("warnings = %q, want 3", warnings)
+	}
+}
+
+func TestToBookSkipsWithoutTitleOrAuthor(t *testing.T) {
+	record := &Record{Leader: "00000nam a2200000 i 4500", Fields: []Field{
+		{Tag: "245", Indicator1: '0', Indicator2: '0', Subfields: []Subfield{{'a', "Beowulf"}}},
+	}}
+	if _, warnings, ok := ToBook(record); ok || len(warnings) == 0 {
+		t.Errorf("record without an author: ok %v, warnings %v", ok, warnings)
+	}
+}
@@ -0,0 +1,221 @@
+// Package marc reads MARC 21 records in the ISO 2709 exchange format used
+// by .mrc files.
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	SubfieldDelimiter  = 0x1F
+	FieldTerminator    = 0x1E
+	RecordTerminator   = 0x1D
+	LeaderLength       = 24
+	directoryEntrySize = 12
+)
+
+// ErrMalformed is returned for a record whose structure cannot be read. The
+// reader has already moved past it, so callers may keep reading.
+var ErrMalformed = errors.New("malformed MARC record")
+
+type Record struct {
+	Leader string
+	Fields []Field
+	// Lossy is set when MARC-8 text had characters that could not be decoded
+	Lossy bool
+}
+
+// Field is a control field (tags 001-009), which only has a Value, or a data
+// field with two indicators and subfields.
+type Field struct {
+	Tag        string
+	Indicator1 byte
+	Indicator2 byte
+	Value      string
+	Subfields  []Subfield
+}
+
+type Subfield struct {
+	Code  byte
+	Value string
+}
+
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (mr *Reader) Next() (*Record, error) {
+	// Some exports put line breaks between records
+	for {
+		b, err := mr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' && b != ' ' {
+			mr.r.UnreadByte()
+			break
+		}
+	}
+
+	prefix, err := mr.r.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated leader", ErrMalformed)
+	}
+	length, ok := number(prefix)
+	if !ok || length < LeaderLength+1 {
+		// Without a usable length skip to the end of the record
+		mr.r.ReadBytes(RecordTerminator)
+		return nil, fmt.Errorf("%w: record length %q is not a number", ErrMalformed, prefix)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(mr.r, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: record is shorter than its length %d", ErrMalformed, length)
+	}
+	if data[length-1] != RecordTerminator {
+		mr.r.ReadBytes(RecordTerminator)
+		return nil, fmt.Errorf("%w: record does not end at its length %d", ErrMalformed, length)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a single record including its leader and terminator.
+func Parse(data []byte) (*Record, error) {
+	if len(data) < LeaderLength+1 {
+		return nil, fmt.Errorf("%w: record too short", ErrMalformed)
+	}
+	leader := string(data[:LeaderLength])
+	base, ok := number([]byte(leader[12:17]))
+	if !ok || base <= LeaderLength || base > len(data) {
+		return nil, fmt.Errorf("%w: bad base address of data %q", ErrMalformed, leader[12:17])
+	}
+	directory := data[LeaderLength : base-1]
+	if data[base-1] != FieldTerminator || len(directory)%directoryEntrySize != 0 {
+		return nil, fmt.Errorf("%w: directory is not terminated", ErrMalformed)
+	}
+
+	record := &Record{Leader: leader}
+	for i := 0; i < len(directory); i += directoryEntrySize {
+		entry := directory[i : i+directoryEntrySize]
+		tag := string(entry[:3])
+		length, ok1 := number(entry[3:7])
+		start, ok2 := number(entry[7:12])
+		if !ok1 || !ok2 || start < 0 || length < 0 || base+start+length > len(data) {
+			return nil, fmt.Errorf("%w: bad directory entry for %s", ErrMalformed, tag)
+		}
+		value := bytes.TrimSuffix(data[base+start:base+start+length], []byte{FieldTerminator})
+		record.Fields = append(record.Fields, parseField(tag, value))
+	}
+	if !record.IsUnicode() {
+		record.decodeMarc8()
+	}
+	return record, nil
+}
+
+// number reads the digits of a fixed-width number in a leader or
+// directory. Unlike strconv.Atoi it takes no signs, which would let a
+// field point before the data.
+func number(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+func parseField(tag string, value []byte) Field {
+	field := Field{Tag: tag}
+	if IsControlTag(tag) {
+		field.Value = string(value)
+		return field
+	}
+
+	if len(value) > 0 {
+		field.Indicator1 = value[0]
+	}
+	if len(value) > 1 {
+		field.Indicator2 = value[1]
+	}
+	if len(value) > 2 {
+		// Anything before the first delimiter is not part of a subfield
+		for _, subfield := range bytes.Split(value[2:], []byte{SubfieldDelimiter})[1:] {
+			if len(subfield) == 0 {
+				continue
+			}
+			field.Subfields = append(field.Subfields, Subfield{Code: subfield[0], Value: string(subfield[1:])})
+		}
+	}
+	return field
+}
+
+func IsControlTag(tag string) bool {
+	return len(tag) == 3 && tag[0] == '0' && tag[1] == '0'
+}
+
+func (r *Record) decodeMarc8() {
+	decode := func(s string) string {
+		decoded, complete := decodeMarc8(s)
+		if !complete {
+			r.Lossy = true
+		}
+		return decoded
+	}
+	for i := range r.Fields {
+		r.Fields[i].Value = decode(r.Fields[i].Value)
+		for j := range r.Fields[i].Subfields {
+			r.Fields[i].Subfields[j].Value = decode(r.Fields[i].Subfields[j].Value)
+		}
+	}
+}
+
+// IsUnicode reports whether the leader declares UTF-8 rather than MARC-8.
+func (r *Record) IsUnicode() bool {
+	return len(r.Leader) > 9 && r.Leader[9] == 'a'
+}
+
+// ControlField returns the value of the first control field with tag.
+func (r *Record) ControlField(tag string) string {
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// DataFields returns every field with tag in record order.
+func (r *Record) DataFields(tag string) []Field {
+	var fields []Field
+	for _, f := range r.Fields {
+		if f.Tag == tag {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Subfield returns the first subfield with code.
+func (f Field) Subfield(code byte) string {
+	for _, s := range f.Subfields {
+		if s.Code == code {
+			return s.Value
+		}
+	}
+	return ""
+}
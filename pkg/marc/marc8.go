@@ -0,0 +1,91 @@
+package marc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// marc8Combining maps the ANSEL diacritics of MARC-8 to Unicode combining
+// marks. MARC-8 writes a diacritic before the letter it sits on and Unicode
+// after it.
+var marc8Combining = map[byte]rune{
+	0xE0: '̉', // hook above
+	0xE1: '̀', // grave
+	0xE2: '́', // acute
+	0xE3: '̂', // circumflex
+	0xE4: '̃', // tilde
+	0xE5: '̄', // macron
+	0xE6: '̆', // breve
+	0xE7: '̇', // dot above
+	0xE8: '̈', // umlaut
+	0xE9: '̌', // caron
+	0xEA: '̊', // ring above
+	0xED: '̕', // comma above right
+	0xEE: '̋', // double acute
+	0xEF: '̐', // candrabindu
+	0xF0: '̧', // cedilla
+	0xF1: '̨', // ogonek
+	0xF2: '̣', // dot below
+	0xF3: '̤', // double dot below
+	0xF4: '̥', // ring below
+	0xF5: '̳', // double underscore
+	0xF6: '̲', // underscore
+	0xF7: '̦', // comma below
+	0xF8: '̜', // right cedilla
+	0xF9: '̮', // breve below
+}
+
+// marc8Spacing maps the ANSEL letters and symbols that are not diacritics.
+var marc8Spacing = map[byte]rune{
+	0xA1: 'Ł', 0xA2: 'Ø', 0xA3: 'Đ', 0xA4: 'Þ', 0xA5: 'Æ', 0xA6: 'Œ',
+	0xA7: 'ʹ', 0xA8: '·', 0xA9: '♭', 0xAA: '®', 0xAB: '±', 0xAC: 'Ơ',
+	0xAD: 'Ư', 0xAE: 'ʼ', 0xB0: 'ʻ', 0xB1: 'ł', 0xB2: 'ø', 0xB3: 'đ',
+	0xB4: 'þ', 0xB5: 'æ', 0xB6: 'œ', 0xB7: 'ʺ', 0xB8: 'ı', 0xB9: '£',
+	0xBA: 'ð', 0xBC: 'ơ', 0xBD: 'ư', 0xC0: '°', 0xC1: 'ℓ', 0xC2: '℗',
+	0xC3: '©', 0xC4: '♯', 0xC5: '¿', 0xC6: '¡', 0xC7: 'ß', 0xC8: '€',
+}
+
+// decodeMarc8 converts the Latin subset of MARC-8 to UTF-8. It reports false
+// when some bytes, such as escapes to other character sets, were replaced.
+func decodeMarc8(s string) (string, bool) {
+	if isAsciiString(s) {
+		return s, true
+	}
+	var sb strings.Builder
+	var pending []rune
+	complete := true
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b == 0x1B {
+			// Escapes switch to character sets such as CJK that are not decoded
+			complete = false
+			continue
+		}
+		if mark, ok := marc8Combining[b]; ok {
+			pending = append(pending, mark)
+			continue
+		}
+		if r, ok := marc8Spacing[b]; ok {
+			sb.WriteRune(r)
+		} else if b < utf8.RuneSelf {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteRune(utf8.RuneError)
+			complete = false
+		}
+		for _, mark := range pending {
+			sb.WriteRune(mark)
+		}
+		pending = nil
+	}
+	return sb.String(), complete
+}
+
+func isAsciiString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
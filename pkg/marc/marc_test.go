@@ -0,0 +1,129 @@
+package marc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// encode writes fields as a binary record, each field a tag and its data
+// without the terminator.
+func encode(fields ...[2]string) []byte {
+	var directory, data bytes.Buffer
+	for _, f := range fields {
+		value := f[1] + string(rune(FieldTerminator))
+		fmt.Fprintf(&directory, "%s%04d%05d", f[0], len(value), data.Len())
+		data.WriteString(value)
+	}
+	directory.WriteByte(FieldTerminator)
+	base := LeaderLength + directory.Len()
+	length := base + data.Len() + 1
+	leader := fmt.Sprintf("%05dnam a22%05d a 4500", length, base)
+	return append([]byte(leader+directory.String()+data.String()), RecordTerminator)
+}
+
+func TestParse(t *testing.T) {
+	data := encode(
+		[2]string{"001", "12345"},
+		[2]string{"245", "10\x1faThe hobbit /\x1fcJ.R.R. Tolkien."},
+	)
+	record, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := record.ControlField("001"); got != "12345" {
+		t.Errorf("001 = %q, want 12345", got)
+	}
+	titles := record.DataFields("245")
+	if len(titles) != 1 {
+		t.Fatalf("got %d 245 fields, want 1", len(titles))
+	}
+	if titles[0].Indicator1 != '1' || titles[0].Indicator2 != '0' {
+		t.Errorf("indicators = %c%c, want 10", titles[0].Indicator1, titles[0].Indicator2)
+	}
+	if got := titles[0].Subfield('a'); got != "The hobbit /" {
+		t.Errorf("245 $a = %q", got)
+	}
+	if got := titles[0].Subfield('c'); got != "J.R.R. Tolkien." {
+		t.Errorf("245 $c = %q", got)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	good := encode([2]string{"245", "10\x1faThe hobbit"})
+	// corrupt replaces the bytes of good at offset
+	corrupt := func(offset int, s string) []byte {
+		data := append([]byte(nil), good...)
+		copy(data[offset:], s)
+		return data
+	}
+	entry := LeaderLength
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", good[:10]},
+		{"negative length", corrupt(entry+3, "-001")},
+		{"negative start", corrupt(entry+7, "-0001")},
+		{"signed length", corrupt(entry+3, "+015")},
+		{"blank in length", corrupt(entry+3, "0 15")},
+		{"length past the end", corrupt(entry+3, "9999")},
+		{"start past the end", corrupt(entry+7, "99999")},
+		{"negative base", corrupt(12, "-0037")},
+		{"base past the end", corrupt(12, "99999")},
+		{"unterminated directory", corrupt(entry+directoryEntrySize, "x")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.data)
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("got %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestReaderSkipsMalformed(t *testing.T) {
+	bad := encode([2]string{"245", "10\x1faBad"})
+	copy(bad[LeaderLength+3:], "-001")
+	good := encode([2]string{"001", "2"})
+
+	r := NewReader(bytes.NewReader(append(bad, good...)))
+	if _, err := r.Next(); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("first record: got %v, want ErrMalformed", err)
+	}
+	record, err := r.Next()
+	if err != nil {
+		t.Fatalf("second record: %v", err)
+	}
+	if got := record.ControlField("001"); got != "2" {
+		t.Errorf("001 = %q, want 2", got)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last record: got %v, want io.EOF", err)
+	}
+}
+
+// MARC-8 diacritics come before their letter and Unicode marks after it.
+func TestDecodeMarc8(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		complete bool
+	}{
+		{"Tolkien", "Tolkien", true},
+		{"Caf\xe2e", "Cafe\u0301", true},
+		{"\xe8Uber", "U\u0308ber", true},
+		{"\xb1od\xe2z", "\u0142odz\u0301", true},
+		{"\xc3 1937", "\u00a9 1937", true},
+		{"\x1b(Babc\xff", "(Babc\ufffd", false},
+	}
+	for _, test := range tests {
+		got, complete := decodeMarc8(test.in)
+		if got != test.want || complete != test.complete {
+			t.Errorf("decodeMarc8(%q) = %q, %v, want %q, %v", test.in, got, complete, test.want, test.complete)
+		}
+	}
+}
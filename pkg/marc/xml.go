@@ -0,0 +1,175 @@
+package marc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const XMLNamespace = "http://www.loc.gov/MARC21/slim"
+
+type xmlRecord struct {
+	XMLName       xml.Name          `xml:"record"`
+	Namespace     string            `xml:"xmlns,attr,omitempty"`
+	Leader        string            `xml:"leader"`
+	ControlFields []xmlControlField `xml:"controlfield"`
+	DataFields    []xmlDataField    `xml:"datafield"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subfields []xmlSubfield `xml:"subfield"`
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+func toXMLRecord(r *Record) xmlRecord {
+	x := xmlRecord{Leader: r.Leader}
+	for _, f := range r.Fields {
+		if IsControlTag(f.Tag) {
+			x.ControlFields = append(x.ControlFields, xmlControlField{Tag: f.Tag, Value: f.Value})
+			continue
+		}
+		d := xmlDataField{Tag: f.Tag, Ind1: indicator(f.Indicator1), Ind2: indicator(f.Indicator2)}
+		for _, s := range f.Subfields {
+			d.Subfields = append(d.Subfields, xmlSubfield{Code: string(s.Code), Value: s.Value})
+		}
+		x.DataFields = append(x.DataFields, d)
+	}
+	return x
+}
+
+func fromXMLRecord(x xmlRecord) *Record {
+	r := &Record{Leader: x.Leader}
+	for _, f := range x.ControlFields {
+		r.Fields = append(r.Fields, Field{Tag: f.Tag, Value: f.Value})
+	}
+	for _, f := range x.DataFields {
+		field := Field{Tag: f.Tag, Indicator1: indicatorByte(f.Ind1), Indicator2: indicatorByte(f.Ind2)}
+		for _, s := range f.Subfields {
+			if s.Code == "" {
+				continue
+			}
+			field.Subfields = append(field.Subfields, Subfield{Code: s.Code[0], Value: s.Value})
+		}
+		r.Fields = append(r.Fields, field)
+	}
+	return r
+}
+
+func indicator(b byte) string {
+	if b == 0 {
+		return " "
+	}
+	return string(b)
+}
+
+func indicatorByte(s string) byte {
+	if s == "" {
+		return ' '
+	}
+	return s[0]
+}
+
+// XMLWriter writes records as a MARCXML collection.
+type XMLWriter struct {
+	w       io.Writer
+	encoder *xml.Encoder
+	started bool
+}
+
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("  ", "  ")
+	return &XMLWriter{w: w, encoder: encoder}
+}
+
+func (xw *XMLWriter) Write(r *Record) error {
+	if !xw.started {
+		xw.started = true
+		_, err := fmt.Fprintf(xw.w, "%s<collection xmlns=\"%s\">\n", xml.Header, XMLNamespace)
+		if err != nil {
+			return err
+		}
+	}
+	return xw.encoder.Encode(toXMLRecord(r))
+}
+
+// Close ends the collection. It must be called even when no records were
+// written.
+func (xw *XMLWriter) Close() error {
+	if !xw.started {
+		_, err := fmt.Fprintf(xw.w, "%s<collection xmlns=\"%s\"/>\n", xml.Header, XMLNamespace)
+		return err
+	}
+	_, err := io.WriteString(xw.w, "\n</collection>\n")
+	return err
+}
+
+// WriteXMLRecord writes a single record as its own MARCXML document.
+func WriteXMLRecord(w io.Writer, r *Record) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(r.XML())
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// XML returns the record element, ready to be encoded with encoding/xml.
+// It declares the MARCXML namespace so it can be embedded in any document.
+func (r *Record) XML() interface{} {
+	x := toXMLRecord(r)
+	x.Namespace = XMLNamespace
+	return x
+}
+
+// XMLReader reads the records of a MARCXML document, whether it is a
+// collection or a single record.
+type XMLReader struct {
+	decoder *xml.Decoder
+}
+
+func NewXMLReader(r io.Reader) *XMLReader {
+	return &XMLReader{decoder: xml.NewDecoder(r)}
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (xr *XMLReader) Next() (*Record, error) {
+	for {
+		// Syntax errors end the document, so they are not ErrMalformed
+		token, err := xr.decoder.Token()
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read MARCXML: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+		var x xmlRecord
+		err = xr.decoder.DecodeElement(&x, &start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read MARCXML: %v", err)
+		}
+		return fromXMLRecord(x), nil
+	}
+}
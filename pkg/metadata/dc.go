@@ -0,0 +1,202 @@
+// Package metadata describes books in the MODS and Dublin Core schemas used
+// by digital repositories.
+package metadata
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+const (
+	OaiDcNamespace      = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	DcNamespace         = "http://purl.org/dc/elements/1.1/"
+	DcTermsNamespace    = "http://purl.org/dc/terms/"
+	XsiNamespace        = "http://www.w3.org/2001/XMLSchema-instance"
+	SrwDcNamespace      = "info:srw/schema/1/dc-schema"
+	oaiDcSchemaLocation = OaiDcNamespace + " http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+)
+
+// DublinCore holds the values of the Dublin Core element set a book has.
+type DublinCore struct {
+	// URI identifies the book itself
+	URI         string
+	Title       string
+	Creators    []string
+	Subjects    []string
+	Publisher   string
+	Date        string
+	Type        string
+	Format      string
+	Identifiers []string
+}
+
+// NewDublinCore describes book, which is published at uri.
+func NewDublinCore(book database.Book, uri string) DublinCore {
+	dc := DublinCore{
+		URI:         uri,
+		Title:       book.Title,
+		Publisher:   book.Publisher,
+		Type:        "Text",
+		Identifiers: Identifiers(book),
+	}
+	if creator := CreatorName(book); creator != "" {
+		dc.Creators = append(dc.Creators, creator)
+	}
+	if book.Genre != "" {
+		dc.Subjects = append(dc.Subjects, book.Genre)
+	}
+	if !book.CopyrightDate.IsZero() {
+		dc.Date = book.CopyrightDate.String()
+	}
+	if book.Pages != "" {
+		dc.Format = Extent(book)
+	}
+	if uri != "" {
+		dc.Identifiers = append(dc.Identifiers, uri)
+	}
+	return dc
+}
+
+// CreatorName writes an author inverted, as catalogs file them.
+func CreatorName(book database.Book) string {
+	if book.AuthorFirst == "" {
+		return book.AuthorLast
+	}
+	return book.AuthorLast + ", " + book.AuthorFirst
+}
+
+// Extent describes the page count, adding the unit when it is a number.
+func Extent(book database.Book) string {
+	if strings.Trim(book.Pages, "0123456789") == "" {
+		return book.Pages + " pages"
+	}
+	return book.Pages
+}
+
+// Identifiers returns the ISBN and LCCN of book as URIs.
+func Identifiers(book database.Book) []string {
+	var identifiers []string
+	if book.Isbn != "" {
+		identifiers = append(identifiers, "urn:isbn:"+book.Isbn)
+	}
+	if lccn := NormalizeLccn(book.Lccn); lccn != "" {
+		identifiers = append(identifiers, "info:lccn/"+lccn)
+	}
+	return identifiers
+}
+
+// NormalizeLccn applies the Library of Congress normalization used in
+// info:lccn URIs: blanks and anything after a slash are removed, and the
+// serial number after a hyphen is padded to six digits.
+func NormalizeLccn(lccn string) string {
+	lccn = strings.ReplaceAll(lccn, " ", "")
+	lccn, _, _ = strings.Cut(lccn, "/")
+	if prefix, serial, found := strings.Cut(lccn, "-"); found {
+		if len(serial) < 6 {
+			serial = strings.Repeat("0", 6-len(serial)) + serial
+		}
+		lccn = prefix + serial
+	}
+	return lccn
+}
+
+// dcElements are the Dublin Core elements shared by the record wrappers.
+type dcElements struct {
+	Title       string   `xml:"dc:title,omitempty"`
+	Creators    []string `xml:"dc:creator"`
+	Subjects    []string `xml:"dc:subject"`
+	Publisher   string   `xml:"dc:publisher,omitempty"`
+	Date        string   `xml:"dc:date,omitempty"`
+	Type        string   `xml:"dc:type,omitempty"`
+	Format      string   `xml:"dc:format,omitempty"`
+	Identifiers []string `xml:"dc:identifier"`
+}
+
+type oaiDc struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	OaiDcNs        string   `xml:"xmlns:oai_dc,attr"`
+	DcNs           string   `xml:"xmlns:dc,attr"`
+	XsiNs          string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	dcElements
+}
+
+type srwDc struct {
+	XMLName xml.Name `xml:"srw_dc:dc"`
+	SrwDcNs string   `xml:"xmlns:srw_dc,attr"`
+	DcNs    string   `xml:"xmlns:dc,attr"`
+	dcElements
+}
+
+func (dc DublinCore) elements() dcElements {
+	return dcElements{
+		Title:       dc.Title,
+		Creators:    dc.Creators,
+		Subjects:    dc.Subjects,
+		Publisher:   dc.Publisher,
+		Date:        dc.Date,
+		Type:        dc.Type,
+		Format:      dc.Format,
+		Identifiers: dc.Identifiers,
+	}
+}
+
+// OaiDc returns the oai_dc:dc element for the record, ready to be encoded
+// with encoding/xml. It declares its own namespaces so it can be embedded
+// in any document.
+func (dc DublinCore) OaiDc() interface{} {
+	return oaiDc{
+		OaiDcNs:        OaiDcNamespace,
+		DcNs:           DcNamespace,
+		XsiNs:          XsiNamespace,
+		SchemaLocation: oaiDcSchemaLocation,
+		dcElements:     dc.elements(),
+	}
+}
+
+// SrwDc returns the record as the srw_dc:dc element SRU servers answer
+// with, declaring its own namespaces like OaiDc.
+func (dc DublinCore) SrwDc() interface{} {
+	return srwDc{
+		SrwDcNs:    SrwDcNamespace,
+		DcNs:       DcNamespace,
+		dcElements: dc.elements(),
+	}
+}
+
+// JSONLDContext maps the dcterms prefix used by JSONLD.
+var JSONLDContext = map[string]interface{}{
+	"dcterms": DcTermsNamespace,
+}
+
+// JSONLD returns the record as a JSON-LD node using DCMI terms. It has no
+// @context so several nodes can share one in a @graph.
+func (dc DublinCore) JSONLD() map[string]interface{} {
+	node := map[string]interface{}{
+		"@type": "dcterms:BibliographicResource",
+	}
+	set := func(key string, value string) {
+		if value != "" {
+			node[key] = value
+		}
+	}
+	setAll := func(key string, values []string) {
+		if len(values) > 0 {
+			node[key] = values
+		}
+	}
+	set("@id", dc.URI)
+	set("dcterms:title", dc.Title)
+	setAll("dcterms:creator", dc.Creators)
+	setAll("dcterms:subject", dc.Subjects)
+	set("dcterms:publisher", dc.Publisher)
+	if dc.Date != "" {
+		node["dcterms:date"] = map[string]string{"@value": dc.Date, "@type": "http://www.w3.org/2001/XMLSchema#date"}
+	}
+	set("dcterms:type", dc.Type)
+	set("dcterms:extent", dc.Format)
+	setAll("dcterms:identifier", dc.Identifiers)
+	return node
+}
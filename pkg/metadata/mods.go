@@ -0,0 +1,167 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/database"
+)
+
+const ModsNamespace = "http://www.loc.gov/mods/v3"
+
+type Mods struct {
+	XMLName             xml.Name         `xml:"mods"`
+	Namespace           string           `xml:"xmlns,attr"`
+	Version             string           `xml:"version,attr"`
+	TitleInfo           *ModsTitleInfo   `xml:"titleInfo"`
+	Names               []ModsName       `xml:"name"`
+	TypeOfResource      string           `xml:"typeOfResource"`
+	Genre               string           `xml:"genre,omitempty"`
+	OriginInfo          *ModsOriginInfo  `xml:"originInfo"`
+	PhysicalDescription *ModsPhysical    `xml:"physicalDescription"`
+	Identifiers         []ModsIdentifier `xml:"identifier"`
+	Location            *ModsLocation    `xml:"location"`
+	RecordInfo          *ModsRecordInfo  `xml:"recordInfo"`
+}
+
+type ModsTitleInfo struct {
+	Title    string `xml:"title"`
+	SubTitle string `xml:"subTitle,omitempty"`
+}
+
+type ModsName struct {
+	Type      string         `xml:"type,attr"`
+	Usage     string         `xml:"usage,attr,omitempty"`
+	NameParts []ModsNamePart `xml:"namePart"`
+	Role      ModsRole       `xml:"role"`
+}
+
+type ModsNamePart struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type ModsRole struct {
+	RoleTerms []ModsRoleTerm `xml:"roleTerm"`
+}
+
+type ModsRoleTerm struct {
+	Type      string `xml:"type,attr"`
+	Authority string `xml:"authority,attr"`
+	Value     string `xml:",chardata"`
+}
+
+type ModsOriginInfo struct {
+	EventType     string     `xml:"eventType,attr"`
+	Place         *ModsPlace `xml:"place"`
+	Publisher     string     `xml:"publisher,omitempty"`
+	DateIssued    *ModsDate  `xml:"dateIssued"`
+	CopyrightDate *ModsDate  `xml:"copyrightDate"`
+}
+
+type ModsPlace struct {
+	PlaceTerm ModsPlaceTerm `xml:"placeTerm"`
+}
+
+type ModsPlaceTerm struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type ModsDate struct {
+	Encoding  string `xml:"encoding,attr"`
+	KeyDate   string `xml:"keyDate,attr,omitempty"`
+	Qualifier string `xml:"qualifier,attr,omitempty"`
+	Value     string `xml:",chardata"`
+}
+
+type ModsPhysical struct {
+	Extent string `xml:"extent"`
+}
+
+type ModsIdentifier struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type ModsLocation struct {
+	URL string `xml:"url"`
+}
+
+type ModsRecordInfo struct {
+	RecordIdentifier   string    `xml:"recordIdentifier,omitempty"`
+	RecordCreationDate *ModsDate `xml:"recordCreationDate"`
+}
+
+// NewMods describes book as a MODS record. uri, when set, is where the book
+// can be viewed.
+func NewMods(book database.Book, uri string) Mods {
+	mods := Mods{
+		Namespace:      ModsNamespace,
+		Version:        "3.7",
+		TypeOfResource: "text",
+		Genre:          book.Genre,
+	}
+
+	if book.Title != "" {
+		title, subTitle, _ := strings.Cut(book.Title, ": ")
+		mods.TitleInfo = &ModsTitleInfo{Title: title, SubTitle: subTitle}
+	}
+
+	if book.AuthorLast != "" {
+		name := ModsName{
+			Type:      "personal",
+			Usage:     "primary",
+			NameParts: []ModsNamePart{{Type: "family", Value: book.AuthorLast}},
+			Role: ModsRole{RoleTerms: []ModsRoleTerm{
+				{Type: "text", Authority: "marcrelator", Value: "author"},
+				{Type: "code", Authority: "marcrelator", Value: "aut"},
+			}},
+		}
+		if book.AuthorFirst != "" {
+			name.NameParts = append(name.NameParts, ModsNamePart{Type: "given", Value: book.AuthorFirst})
+		}
+		mods.Names = append(mods.Names, name)
+	}
+
+	origin := &ModsOriginInfo{EventType: "publication", Publisher: book.Publisher}
+	if book.Location != "" {
+		origin.Place = &ModsPlace{PlaceTerm: ModsPlaceTerm{Type: "text", Value: book.Location}}
+	}
+	if !book.CopyrightDate.IsZero() {
+		date := book.CopyrightDate
+		origin.DateIssued = &ModsDate{Encoding: "w3cdtf", KeyDate: "yes", Value: strconv.Itoa(date.Year)}
+		origin.CopyrightDate = &ModsDate{Encoding: "w3cdtf", Value: date.String()}
+		// W3CDTF has no way to write circa dates and ranges
+		if date.Circa || date.IsRange() {
+			origin.DateIssued.Qualifier = "approximate"
+			origin.CopyrightDate.Encoding = "edtf"
+		}
+	}
+	if origin.Place != nil || origin.Publisher != "" || origin.DateIssued != nil {
+		mods.OriginInfo = origin
+	}
+
+	if book.Pages != "" {
+		mods.PhysicalDescription = &ModsPhysical{Extent: Extent(book)}
+	}
+	if book.Isbn != "" {
+		mods.Identifiers = append(mods.Identifiers, ModsIdentifier{Type: "isbn", Value: book.Isbn})
+	}
+	if book.Lccn != "" {
+		mods.Identifiers = append(mods.Identifiers, ModsIdentifier{Type: "lccn", Value: book.Lccn})
+	}
+	if uri != "" {
+		mods.Identifiers = append(mods.Identifiers, ModsIdentifier{Type: "uri", Value: uri})
+		mods.Location = &ModsLocation{URL: uri}
+	}
+
+	if book.Id > 0 {
+		mods.RecordInfo = &ModsRecordInfo{RecordIdentifier: strconv.Itoa(book.Id)}
+		if !book.CreatedDate.IsZero() {
+			mods.RecordInfo.RecordCreationDate = &ModsDate{Encoding: "w3cdtf", Value: book.CreatedDate.Format("2006-01-02")}
+		}
+	}
+	return mods
+}
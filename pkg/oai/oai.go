@@ -0,0 +1,199 @@
+// Package oai holds the response documents of the Open Archives Initiative
+// Protocol for Metadata Harvesting, version 2.0.
+package oai
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	Namespace       = "http://www.openarchives.org/OAI/2.0/"
+	SchemaLocation  = Namespace + " http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+	XsiNamespace    = "http://www.w3.org/2001/XMLSchema-instance"
+	ProtocolVersion = "2.0"
+
+	IdentifierNamespace      = "http://www.openarchives.org/OAI/2.0/oai-identifier"
+	IdentifierSchemaLocation = IdentifierNamespace + " http://www.openarchives.org/OAI/2.0/oai-identifier.xsd"
+
+	// Granularity of every datestamp this package writes
+	Granularity = "YYYY-MM-DDThh:mm:ssZ"
+	TimeLayout  = "2006-01-02T15:04:05Z"
+	DayLayout   = "2006-01-02"
+)
+
+// Error codes defined by the protocol.
+const (
+	BadArgument             = "badArgument"
+	BadResumptionToken      = "badResumptionToken"
+	BadVerb                 = "badVerb"
+	CannotDisseminateFormat = "cannotDisseminateFormat"
+	IdDoesNotExist          = "idDoesNotExist"
+	NoRecordsMatch          = "noRecordsMatch"
+	NoMetadataFormats       = "noMetadataFormats"
+	NoSetHierarchy          = "noSetHierarchy"
+)
+
+// Response is the OAI-PMH root element. Exactly one of the verb elements
+// or Errors is set.
+type Response struct {
+	XMLName             xml.Name             `xml:"OAI-PMH"`
+	Namespace           string               `xml:"xmlns,attr"`
+	XsiNs               string               `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string               `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string               `xml:"responseDate"`
+	Request             Request              `xml:"request"`
+	Errors              []Error              `xml:"error"`
+	Identify            *Identify            `xml:"Identify"`
+	ListMetadataFormats *ListMetadataFormats `xml:"ListMetadataFormats"`
+	ListSets            *ListSets            `xml:"ListSets"`
+	GetRecord           *GetRecord           `xml:"GetRecord"`
+	ListIdentifiers     *ListIdentifiers     `xml:"ListIdentifiers"`
+	ListRecords         *ListRecords         `xml:"ListRecords"`
+}
+
+// Request echoes the request. Its arguments are left out when the verb or
+// an argument was rejected.
+type Request struct {
+	URL             string `xml:",chardata"`
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+}
+
+type Error struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type Identify struct {
+	RepositoryName    string        `xml:"repositoryName"`
+	BaseURL           string        `xml:"baseURL"`
+	ProtocolVersion   string        `xml:"protocolVersion"`
+	AdminEmails       []string      `xml:"adminEmail"`
+	EarliestDatestamp string        `xml:"earliestDatestamp"`
+	DeletedRecord     string        `xml:"deletedRecord"`
+	Granularity       string        `xml:"granularity"`
+	Descriptions      []Description `xml:"description"`
+}
+
+type Description struct {
+	Value interface{}
+}
+
+// RepositoryIdentifier describes the oai-identifier scheme the repository
+// names its items with.
+type RepositoryIdentifier struct {
+	XMLName              xml.Name `xml:"oai-identifier"`
+	Namespace            string   `xml:"xmlns,attr"`
+	XsiNs                string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation       string   `xml:"xsi:schemaLocation,attr"`
+	Scheme               string   `xml:"scheme"`
+	RepositoryIdentifier string   `xml:"repositoryIdentifier"`
+	Delimiter            string   `xml:"delimiter"`
+	SampleIdentifier     string   `xml:"sampleIdentifier"`
+}
+
+func NewRepositoryIdentifier(repository string, sample string) RepositoryIdentifier {
+	return RepositoryIdentifier{
+		Namespace:            IdentifierNamespace,
+		XsiNs:                XsiNamespace,
+		SchemaLocation:       IdentifierSchemaLocation,
+		Scheme:               "oai",
+		RepositoryIdentifier: repository,
+		Delimiter:            ":",
+		SampleIdentifier:     sample,
+	}
+}
+
+type MetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+type ListMetadataFormats struct {
+	Formats []MetadataFormat `xml:"metadataFormat"`
+}
+
+type Set struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type ListSets struct {
+	Sets            []Set            `xml:"set"`
+	ResumptionToken *ResumptionToken `xml:"resumptionToken"`
+}
+
+type Header struct {
+	// Status is "deleted" for a deleted item
+	Status     string   `xml:"status,attr,omitempty"`
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+// Record is an item's header and, unless the item was deleted, its
+// metadata.
+type Record struct {
+	Header   Header    `xml:"header"`
+	Metadata *Metadata `xml:"metadata"`
+}
+
+// Metadata holds one element, such as oai_dc:dc, declaring its own
+// namespaces.
+type Metadata struct {
+	Value interface{}
+}
+
+type GetRecord struct {
+	Record Record `xml:"record"`
+}
+
+type ListIdentifiers struct {
+	Headers         []Header         `xml:"header"`
+	ResumptionToken *ResumptionToken `xml:"resumptionToken"`
+}
+
+type ListRecords struct {
+	Records         []Record         `xml:"record"`
+	ResumptionToken *ResumptionToken `xml:"resumptionToken"`
+}
+
+// ResumptionToken continues an incomplete list. The last page of a list
+// carries an empty token.
+type ResumptionToken struct {
+	ExpirationDate string `xml:"expirationDate,attr,omitempty"`
+	Cursor         int    `xml:"cursor,attr"`
+	Value          string `xml:",chardata"`
+}
+
+// NewResponse starts a response to request made at now.
+func NewResponse(request Request, now time.Time) *Response {
+	return &Response{
+		Namespace:      Namespace,
+		XsiNs:          XsiNamespace,
+		SchemaLocation: SchemaLocation,
+		ResponseDate:   now.UTC().Format(TimeLayout),
+		Request:        request,
+	}
+}
+
+// AddError reports an error. The request arguments are no longer echoed
+// once the verb or an argument is bad.
+func (r *Response) AddError(code string, message string) {
+	if code == BadVerb || code == BadArgument {
+		r.Request = Request{URL: r.Request.URL}
+	}
+	r.Errors = append(r.Errors, Error{Code: code, Message: message})
+}
+
+// Datestamp formats t with the repository granularity.
+func Datestamp(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
@@ -0,0 +1,401 @@
+// Package oidc implements the relying party side of the OpenID Connect
+// authorization code flow with PKCE using only the standard library.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Discovery is the subset of the provider metadata document we rely on.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Provider is an issuer as seen with one Config. The discovery document
+// and signing keys are shared by every Provider of the same issuer and
+// client, while the Config is each caller's own, as the redirect URL can
+// differ between requests.
+type Provider struct {
+	Config    Config
+	Discovery Discovery
+
+	client *http.Client
+	keys   *keySet
+}
+
+// keySet caches the signing keys of an issuer.
+type keySet struct {
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IdToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Claims holds the verified ID token claims. Raw keeps every claim so
+// callers can read provider specific ones such as groups.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Nonce             string
+	Email             string
+	Name              string
+	PreferredUsername string
+	Raw               map[string]interface{}
+}
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+var providers = struct {
+	sync.Mutex
+	byIssuer map[string]*Provider
+}{byIssuer: map[string]*Provider{}}
+
+// NewProvider fetches the discovery document for config.Issuer. Discovery
+// and keys are cached per issuer and client so they survive between
+// requests, and each call gets its own Provider with config.
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	cacheKey := config.Issuer + " " + config.ClientId
+	providers.Lock()
+	cached, ok := providers.byIssuer[cacheKey]
+	providers.Unlock()
+	if ok {
+		return cached.with(config), nil
+	}
+
+	p := &Provider{
+		Config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   &keySet{keys: map[string]*rsa.PublicKey{}},
+	}
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	err := p.getJSON(ctx, wellKnown, &p.Discovery)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch discovery document: %v", err)
+	}
+	if strings.TrimSuffix(p.Discovery.Issuer, "/") != strings.TrimSuffix(config.Issuer, "/") {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", p.Discovery.Issuer, config.Issuer)
+	}
+
+	// Another request may have fetched the document meanwhile
+	providers.Lock()
+	defer providers.Unlock()
+	if cached, ok := providers.byIssuer[cacheKey]; ok {
+		return cached.with(config), nil
+	}
+	providers.byIssuer[cacheKey] = p
+	return p.with(config), nil
+}
+
+// with copies the provider for config, sharing its discovery and keys.
+func (p *Provider) with(config Config) *Provider {
+	copied := *p
+	copied.Config = config
+	return &copied
+}
+
+// RandomString returns a url safe random value for state, nonce and PKCE
+// verifiers.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge for verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientId)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", strings.Join(p.Config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.Discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.Discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientId)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientId), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach token endpoint: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s: %s", res.Status, body)
+	}
+
+	var token TokenResponse
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse token response: %v", err)
+	}
+	if token.IdToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return &token, nil
+}
+
+// VerifyIdToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token and returns its claims.
+func (p *Provider) VerifyIdToken(ctx context.Context, rawToken string, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	raw := map[string]interface{}{}
+	err = decodeSegment(parts[1], &raw)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Nonce, _ = raw["nonce"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.Name, _ = raw["name"].(string)
+	claims.PreferredUsername, _ = raw["preferred_username"].(string)
+
+	if claims.Issuer != p.Discovery.Issuer {
+		return nil, fmt.Errorf("id token issued by %q", claims.Issuer)
+	}
+	if !hasAudience(raw["aud"], p.Config.ClientId) {
+		return nil, fmt.Errorf("id token not issued for this client")
+	}
+	exp, ok := raw["exp"].(float64)
+	if !ok || time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// Strings reads a claim that may hold either a single string or a list of
+// strings, as group claims differ between providers.
+func (c *Claims) Strings(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return strings.Fields(strings.ReplaceAll(v, ",", " "))
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// LogoutURL returns where to send the browser to end the provider session,
+// or "" when the provider does not support RP initiated logout.
+func (p *Provider) LogoutURL(idToken string, postLogoutRedirect string) string {
+	if p.Discovery.EndSessionEndpoint == "" {
+		return ""
+	}
+	params := url.Values{}
+	if idToken != "" {
+		params.Set("id_token_hint", idToken)
+	}
+	if postLogoutRedirect != "" {
+		params.Set("post_logout_redirect_uri", postLogoutRedirect)
+	}
+	params.Set("client_id", p.Config.ClientId)
+
+	sep := "?"
+	if strings.Contains(p.Discovery.EndSessionEndpoint, "?") {
+		sep = "&"
+	}
+	return p.Discovery.EndSessionEndpoint + sep + params.Encode()
+}
+
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+
+	if key, ok := p.keys.keys[kid]; ok {
+		return key, nil
+	}
+
+	// Unknown key ids usually mean the provider rotated its keys
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(ctx, p.Discovery.JwksURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch signing keys: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys.keys = keys
+
+	key, ok := p.keys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hasAudience(aud interface{}, clientId string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientId
+	case []interface{}:
+		for _, a := range v {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
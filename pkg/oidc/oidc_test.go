@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewProviderKeepsEachConfig(t *testing.T) {
+	var fetches atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(Discovery{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JwksURI:               server.URL + "/keys",
+		})
+	}))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			redirect := fmt.Sprintf("https://host%d.example/auth/callback", i)
+			p, err := NewProvider(context.Background(), Config{Issuer: server.URL, ClientId: "library", RedirectURL: redirect})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			authURL, err := url.Parse(p.AuthCodeURL("state", "nonce", "verifier"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := authURL.Query().Get("redirect_uri"); got != redirect {
+				t.Errorf("redirect_uri = %q, want %q", got, redirect)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	p, err := NewProvider(context.Background(), Config{Issuer: server.URL, ClientId: "library"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := fetches.Load()
+	again, err := NewProvider(context.Background(), Config{Issuer: server.URL, ClientId: "library"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches.Load() != before {
+		t.Errorf("a cached issuer fetched its discovery document again")
+	}
+	if p.keys != again.keys {
+		t.Errorf("providers of the same issuer do not share keys")
+	}
+}
@@ -0,0 +1,144 @@
+// Package opds builds OPDS 1.2 catalogs, the Atom feeds e-reader apps use
+// to browse and search a collection, and the OpenSearch description that
+// tells them how to search it.
+package opds
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	AtomNamespace       = "http://www.w3.org/2005/Atom"
+	OpdsNamespace       = "http://opds-spec.org/2010/catalog"
+	OpenSearchNamespace = "http://a9.com/-/spec/opensearch/1.1/"
+	DcTermsNamespace    = "http://purl.org/dc/terms/"
+	ThreadingNamespace  = "http://purl.org/syndication/thread/1.0"
+)
+
+// Media types of the documents in a catalog.
+const (
+	NavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	AcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	EntryType       = "application/atom+xml;type=entry;profile=opds-catalog"
+	OpenSearchType  = "application/opensearchdescription+xml"
+)
+
+// Link relations defined by OPDS, next to the Atom ones such as self,
+// start, up, next and search.
+const (
+	RelAcquisition = "http://opds-spec.org/acquisition"
+	RelSortNew     = "http://opds-spec.org/sort/new"
+	RelSubsection  = "subsection"
+)
+
+type Feed struct {
+	XMLName      xml.Name  `xml:"feed"`
+	Namespace    string    `xml:"xmlns,attr"`
+	DcNs         string    `xml:"xmlns:dc,attr"`
+	OpdsNs       string    `xml:"xmlns:opds,attr"`
+	OpenSearchNs string    `xml:"xmlns:opensearch,attr"`
+	ThrNs        string    `xml:"xmlns:thr,attr"`
+	Id           string    `xml:"id"`
+	Title        string    `xml:"title"`
+	Updated      time.Time `xml:"updated"`
+	Author       *Person   `xml:"author"`
+	ItemsPerPage int       `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int       `xml:"opensearch:startIndex,omitempty"`
+	Links        []Link    `xml:"link"`
+	Entries      []Entry   `xml:"entry"`
+}
+
+// Entry is a navigation or book entry. Its namespace attributes are only
+// set when the entry is served as a document of its own.
+type Entry struct {
+	XMLName     xml.Name   `xml:"entry"`
+	Namespace   string     `xml:"xmlns,attr,omitempty"`
+	DcNs        string     `xml:"xmlns:dc,attr,omitempty"`
+	OpdsNs      string     `xml:"xmlns:opds,attr,omitempty"`
+	Id          string     `xml:"id"`
+	Title       string     `xml:"title"`
+	Updated     time.Time  `xml:"updated"`
+	Authors     []Person   `xml:"author"`
+	Publisher   string     `xml:"dc:publisher,omitempty"`
+	Issued      string     `xml:"dc:issued,omitempty"`
+	Identifiers []string   `xml:"dc:identifier"`
+	Categories  []Category `xml:"category"`
+	Content     *Content   `xml:"content"`
+	Links       []Link     `xml:"link"`
+}
+
+type Person struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type Link struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	// Count is the number of entries the linked feed holds
+	Count int `xml:"thr:count,attr,omitempty"`
+}
+
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type Content struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+// NewFeed starts a feed with the namespaces every catalog feed declares.
+func NewFeed(id string, title string, updated time.Time) *Feed {
+	return &Feed{
+		Namespace:    AtomNamespace,
+		DcNs:         DcTermsNamespace,
+		OpdsNs:       OpdsNamespace,
+		OpenSearchNs: OpenSearchNamespace,
+		ThrNs:        ThreadingNamespace,
+		Id:           id,
+		Title:        title,
+		Updated:      updated.UTC().Truncate(time.Second),
+	}
+}
+
+// Standalone declares the namespaces entry needs outside of a feed.
+func (e Entry) Standalone() Entry {
+	e.Namespace = AtomNamespace
+	e.DcNs = DcTermsNamespace
+	e.OpdsNs = OpdsNamespace
+	return e
+}
+
+// OpenSearchDescription tells clients how to build a search URL.
+type OpenSearchDescription struct {
+	XMLName        xml.Name        `xml:"OpenSearchDescription"`
+	Namespace      string          `xml:"xmlns,attr"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []OpenSearchURL `xml:"Url"`
+}
+
+type OpenSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// NewOpenSearchDescription describes a search answered with acquisition
+// feeds at template, which holds {searchTerms} and optionally {startPage?}.
+func NewOpenSearchDescription(name string, description string, template string) OpenSearchDescription {
+	return OpenSearchDescription{
+		Namespace:      OpenSearchNamespace,
+		ShortName:      name,
+		Description:    description,
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs:           []OpenSearchURL{{Type: AcquisitionType, Template: template}},
+	}
+}
@@ -0,0 +1,217 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// odsRepeatLimit caps how often a repeated row or cell with a value is
+// copied, how many empty cells are kept before a value and how many spaces
+// one element writes. Repeats are mostly used for the empty rest of a
+// sheet.
+const odsRepeatLimit = 1000
+
+type odsWorkbook struct {
+	archive *zip.Reader
+	sheets  []string
+}
+
+type odsRow struct {
+	Repeated int       `xml:"number-rows-repeated,attr"`
+	Cells    []odsCell `xml:",any"`
+}
+
+// odsCell is a table:table-cell, or a table:covered-table-cell hidden by a
+// merged cell.
+type odsCell struct {
+	XMLName  xml.Name
+	Repeated int    `xml:"number-columns-repeated,attr"`
+	Type     string `xml:"value-type,attr"`
+	Value    string `xml:"value,attr"`
+	Date     string `xml:"date-value,attr"`
+	Boolean  string `xml:"boolean-value,attr"`
+	Content  []byte `xml:",innerxml"`
+}
+
+func openODS(archive *zip.Reader) (*odsWorkbook, error) {
+	w := &odsWorkbook{archive: archive}
+	rows, err := openEntry(archive, "content.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d := xml.NewDecoder(rows.entry)
+	for {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		if start, ok := t.(xml.StartElement); ok && start.Name.Local == "table" {
+			w.sheets = append(w.sheets, attr(start, "name"))
+			d.Skip()
+		}
+	}
+	return w, nil
+}
+
+func attr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (w *odsWorkbook) Sheets() []string {
+	return w.sheets
+}
+
+func (w *odsWorkbook) Rows(name string) (*Rows, error) {
+	if name == "" && len(w.sheets) > 0 {
+		name = w.sheets[0]
+	}
+	rows, err := openEntry(w.archive, "content.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(rows.entry)
+	found := false
+	for !found {
+		t, err := d.Token()
+		if err != nil {
+			rows.Close()
+			return nil, ErrNoSheet
+		}
+		start, ok := t.(xml.StartElement)
+		if ok && start.Name.Local == "table" {
+			if attr(start, "name") == name {
+				found = true
+			} else {
+				d.Skip()
+			}
+		}
+	}
+
+	var repeat []string
+	repeats := 0
+	rows.next = func() ([]string, error) {
+		if repeats > 0 {
+			repeats--
+			return repeat, nil
+		}
+		for {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if end, ok := t.(xml.EndElement); ok && end.Name.Local == "table" {
+				return nil, io.EOF
+			}
+			start, ok := t.(xml.StartElement)
+			if !ok || start.Name.Local != "table-row" {
+				continue
+			}
+			var row odsRow
+			err = d.DecodeElement(&row, &start)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read sheet %s: %v", name, err)
+			}
+			cells := odsCells(row)
+			if row.Repeated > 1 && len(cells) > 0 {
+				repeat, repeats = cells, min(row.Repeated, odsRepeatLimit)-1
+			}
+			return cells, nil
+		}
+	}
+	return rows, nil
+}
+
+// odsCells writes the cells of a row as text. Repeated empty cells are only
+// filled in when a value follows them.
+func odsCells(row odsRow) []string {
+	var cells []string
+	blanks := 0
+	for _, cell := range row.Cells {
+		if cell.XMLName.Local != "table-cell" && cell.XMLName.Local != "covered-table-cell" {
+			continue
+		}
+		count := max(cell.Repeated, 1)
+		value := odsValue(cell)
+		if value == "" {
+			blanks = min(blanks+count, odsRepeatLimit)
+			continue
+		}
+		for ; blanks > 0; blanks-- {
+			cells = append(cells, "")
+		}
+		for i := 0; i < min(count, odsRepeatLimit); i++ {
+			cells = append(cells, value)
+		}
+	}
+	return cells
+}
+
+func odsValue(cell odsCell) string {
+	switch cell.Type {
+	case "float", "percentage", "currency":
+		return formatNumber(cell.Value)
+	case "date":
+		if len(cell.Date) >= len(DateLayout) {
+			return cell.Date[:len(DateLayout)]
+		}
+		return cell.Date
+	case "boolean":
+		return strings.ToUpper(cell.Boolean)
+	}
+	return odsText(cell.Content)
+}
+
+// odsText reads the paragraphs of a cell, keeping the spaces, tabs and line
+// breaks written as elements.
+func odsText(content []byte) string {
+	var paragraphs []string
+	var sb strings.Builder
+	depth := 0
+	d := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			depth++
+			switch t.Name.Local {
+			case "s":
+				n := 1
+				fmt.Sscan(attr(t, "c"), &n)
+				sb.WriteString(strings.Repeat(" ", min(max(n, 1), odsRepeatLimit)))
+			case "tab":
+				sb.WriteByte('\t')
+			case "line-break":
+				sb.WriteByte('\n')
+			case "annotation":
+				d.Skip()
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+			if t.Name.Local == "p" && depth == 0 {
+				paragraphs = append(paragraphs, sb.String())
+				sb.Reset()
+			}
+		case xml.CharData:
+			if depth > 0 {
+				sb.Write(t)
+			}
+		}
+	}
+	return strings.Join(paragraphs, "\n")
+}
@@ -0,0 +1,95 @@
+package spreadsheet
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOdsText(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`<text:p>The Hobbit</text:p>`, "The Hobbit"},
+		{`<text:p>a<text:s/>b</text:p>`, "a b"},
+		{`<text:p>a<text:s text:c="3"/>b</text:p>`, "a   b"},
+		{`<text:p>a<text:s text:c="0"/>b</text:p>`, "a b"},
+		{`<text:p>a<text:s text:c="-1"/>b</text:p>`, "a b"},
+		{`<text:p>a<text:s text:c="x"/>b</text:p>`, "a b"},
+		{`<text:p>a<text:tab/>b<text:line-break/>c</text:p>`, "a\tb\nc"},
+		{`<text:p>one</text:p><text:p>two</text:p>`, "one\ntwo"},
+		{`<text:p>a<office:annotation><text:p>note</text:p></office:annotation></text:p>`, "a"},
+	}
+	for _, test := range tests {
+		if got := odsText([]byte(test.content)); got != test.want {
+			t.Errorf("odsText(%s) = %q, want %q", test.content, got, test.want)
+		}
+	}
+
+	huge := odsText([]byte(`<text:p><text:s text:c="2000000000"/></text:p>`))
+	if len(huge) != odsRepeatLimit {
+		t.Errorf("a huge space count wrote %d spaces, want %d", len(huge), odsRepeatLimit)
+	}
+}
+
+func TestOdsCells(t *testing.T) {
+	tests := []struct {
+		name string
+		row  string
+		want []string
+	}{
+		{
+			"values",
+			`<table-row><table-cell value-type="string"><p>a</p></table-cell><table-cell value-type="float" value="3"/></table-row>`,
+			[]string{"a", "3"},
+		},
+		{
+			"blanks before a value",
+			`<table-row><table-cell number-columns-repeated="2"/><table-cell value-type="string"><p>c</p></table-cell></table-row>`,
+			[]string{"", "", "c"},
+		},
+		{
+			"repeated value",
+			`<table-row><table-cell number-columns-repeated="3" value-type="string"><p>x</p></table-cell></table-row>`,
+			[]string{"x", "x", "x"},
+		},
+		{
+			"trailing blanks",
+			`<table-row><table-cell value-type="string"><p>a</p></table-cell><table-cell number-columns-repeated="1024"/></table-row>`,
+			[]string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var row odsRow
+			if err := xml.Unmarshal([]byte(test.row), &row); err != nil {
+				t.Fatal(err)
+			}
+			if got := odsCells(row); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOdsCellsHugeRepeats(t *testing.T) {
+	row := `<table-row>` +
+		`<table-cell number-columns-repeated="2000000000"/>` +
+		`<table-cell value-type="string"><p>a</p></table-cell>` +
+		`<table-cell number-columns-repeated="2000000000"/>` +
+		`<table-cell number-columns-repeated="2000000000" value-type="string"><p>b</p></table-cell>` +
+		`</table-row>`
+	var parsed odsRow
+	if err := xml.Unmarshal([]byte(row), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	cells := odsCells(parsed)
+	if len(cells) > 4*odsRepeatLimit {
+		t.Fatalf("got %d cells", len(cells))
+	}
+	if !strings.Contains(strings.Join(cells, ""), "ab") {
+		t.Errorf("values a and b are missing from the row")
+	}
+}
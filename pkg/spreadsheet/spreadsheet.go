@@ -0,0 +1,110 @@
+// Package spreadsheet reads the rows of Office Open XML (.xlsx) and
+// OpenDocument (.ods) workbooks as text, one sheet at a time, and writes
+// XLSX workbooks.
+package spreadsheet
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownFormat is returned for a file that is neither an XLSX nor an
+// ODS workbook.
+var ErrUnknownFormat = errors.New("not an XLSX or ODS workbook")
+
+// ErrNoSheet is returned when a workbook has no sheet of the given name.
+var ErrNoSheet = errors.New("no such sheet")
+
+// DateLayout is how date cells are written.
+const DateLayout = "2006-01-02"
+
+// Workbook is an open XLSX or ODS file.
+type Workbook interface {
+	// Sheets names the sheets in workbook order
+	Sheets() []string
+	// Rows reads the sheet with name, or the first sheet when name is ""
+	Rows(name string) (*Rows, error)
+}
+
+// Rows reads the rows of a sheet. Rows without any values are left out,
+// and trailing empty cells are dropped.
+type Rows struct {
+	next  func() ([]string, error)
+	entry io.ReadCloser
+}
+
+// Read returns the next row, or io.EOF after the last one.
+func (r *Rows) Read() ([]string, error) {
+	for {
+		row, err := r.next()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+		if len(row) > 0 {
+			return row, nil
+		}
+	}
+}
+
+func (r *Rows) Close() error {
+	return r.entry.Close()
+}
+
+// Open reads a workbook, telling XLSX from ODS by the files it holds.
+func Open(r io.ReaderAt, size int64) (Workbook, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+	if findFile(archive, "xl/workbook.xml") != nil {
+		return openXLSX(archive)
+	}
+	if findFile(archive, "content.xml") != nil {
+		return openODS(archive)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func findFile(archive *zip.Reader, name string) *zip.File {
+	for _, f := range archive.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// openEntry opens a file of the archive for reading its rows.
+func openEntry(archive *zip.Reader, name string) (*Rows, error) {
+	f := findFile(archive, name)
+	if f == nil {
+		return nil, fmt.Errorf("workbook is missing %s", name)
+	}
+	entry, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", name, err)
+	}
+	return &Rows{entry: entry}, nil
+}
+
+// formatNumber writes a numeric cell, without the fraction or exponent
+// Excel may store a whole number with, so ISBNs and page counts read as
+// they were typed.
+func formatNumber(value string) string {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return value
+	}
+	if f == float64(int64(f)) && f < 1e18 && f > -1e18 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,102 @@
+package spreadsheet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestColumnName(t *testing.T) {
+	for _, i := range []int{0, 25, 26, 701, 702, xlsxColumns - 1} {
+		name := columnName(i)
+		if got := columnIndex(name + "1"); got != i {
+			t.Errorf("columnIndex(columnName(%d) = %q) = %d", i, name, got)
+		}
+	}
+	if got := columnName(27); got != "AB" {
+		t.Errorf("columnName(27) = %q, want AB", got)
+	}
+}
+
+// What XLSXWriter writes, Open reads back as the same text.
+func TestXLSXWriterRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	w, err := NewXLSXWriter(&b, "Books & more")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]interface{}{
+		{"Title", "ISBN", "Pages", "Copyright Date"},
+		{"The Hobbit <1st ed.> & more", "0261102214", 310, time.Date(1937, 9, 21, 0, 0, 0, 0, time.UTC)},
+		{"Old", "", 12, time.Date(1850, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"", "", 0, time.Time{}},
+		{"  spaced  ", "", 1, time.Time{}},
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wide := make([]interface{}, 30)
+	for i := range wide {
+		wide[i] = strconv.Itoa(i)
+	}
+	if err := w.WriteRow(wide...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow(1.5); err == nil {
+		t.Errorf("wrote a float cell")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	workbook, err := Open(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheets := workbook.Sheets(); !reflect.DeepEqual(sheets, []string{"Books & more"}) {
+		t.Errorf("sheets = %q", sheets)
+	}
+	if _, err := workbook.Rows("Other"); !errors.Is(err, ErrNoSheet) {
+		t.Errorf("Rows(Other) = %v, want ErrNoSheet", err)
+	}
+	r, err := workbook.Rows("Books & more")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Title", "ISBN", "Pages", "Copyright Date"},
+		{"The Hobbit <1st ed.> & more", "0261102214", "310", "1937-09-21"},
+		{"Old", "", "12", "1850-01-02"},
+		{"", "", "0"},
+		{"  spaced  ", "", "1"},
+		make([]string, 30),
+	}
+	for i := range want[5] {
+		want[5][i] = strconv.Itoa(i)
+	}
+	for i, row := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("row %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, row) {
+			t.Errorf("row %d = %q, want %q", i+1, got, row)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("after the last row: %v, want io.EOF", err)
+	}
+}
+
+func TestOpenUnknownFormat(t *testing.T) {
+	data := []byte("Title,ISBN\n")
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Open(csv) = %v, want ErrUnknownFormat", err)
+	}
+}
@@ -0,0 +1,336 @@
+package spreadsheet
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type xlsxWorkbook struct {
+	archive *zip.Reader
+	sheets  []xlsxSheet
+	strings []string
+	// dateStyles marks the cell styles that format numbers as dates
+	dateStyles map[int]bool
+	date1904   bool
+}
+
+type xlsxSheet struct {
+	Name string
+	Path string
+}
+
+type xlsxWorkbookXML struct {
+	WorkbookPr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		Id   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var sb strings.Builder
+	for _, run := range t.Runs {
+		sb.WriteString(run.T)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		Id   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtId int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxRow struct {
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxCell struct {
+	Ref    string    `xml:"r,attr"`
+	Type   string    `xml:"t,attr"`
+	Style  int       `xml:"s,attr"`
+	Value  string    `xml:"v"`
+	Inline *xlsxText `xml:"is"`
+}
+
+func openXLSX(archive *zip.Reader) (*xlsxWorkbook, error) {
+	w := &xlsxWorkbook{archive: archive, dateStyles: map[int]bool{}}
+
+	var workbook xlsxWorkbookXML
+	err := decodeEntry(archive, "xl/workbook.xml", &workbook)
+	if err != nil {
+		return nil, err
+	}
+	w.date1904 = workbook.WorkbookPr.Date1904
+	var rels xlsxRelationships
+	err = decodeEntry(archive, "xl/_rels/workbook.xml.rels", &rels)
+	if err != nil {
+		return nil, err
+	}
+	for _, sheet := range workbook.Sheets {
+		for _, rel := range rels.Relationships {
+			if rel.Id != sheet.Id {
+				continue
+			}
+			target := strings.TrimPrefix(rel.Target, "/")
+			if !strings.HasPrefix(rel.Target, "/") {
+				target = path.Join("xl", rel.Target)
+			}
+			w.sheets = append(w.sheets, xlsxSheet{Name: sheet.Name, Path: target})
+		}
+	}
+
+	// Workbooks with only numbers have no shared strings or styles
+	if findFile(archive, "xl/sharedStrings.xml") != nil {
+		var shared xlsxSharedStrings
+		err = decodeEntry(archive, "xl/sharedStrings.xml", &shared)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range shared.Items {
+			w.strings = append(w.strings, item.String())
+		}
+	}
+	if findFile(archive, "xl/styles.xml") != nil {
+		var styles xlsxStyles
+		err = decodeEntry(archive, "xl/styles.xml", &styles)
+		if err != nil {
+			return nil, err
+		}
+		custom := map[int]string{}
+		for _, format := range styles.NumFmts {
+			custom[format.Id] = format.Code
+		}
+		for i, xf := range styles.CellXfs {
+			if code, ok := custom[xf.NumFmtId]; ok {
+				w.dateStyles[i] = isDateFormat(code)
+			} else {
+				w.dateStyles[i] = isBuiltinDateFormat(xf.NumFmtId)
+			}
+		}
+	}
+	return w, nil
+}
+
+func decodeEntry(archive *zip.Reader, name string, v interface{}) error {
+	f := findFile(archive, name)
+	if f == nil {
+		return fmt.Errorf("workbook is missing %s", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", name, err)
+	}
+	defer r.Close()
+	err = xml.NewDecoder(r).Decode(v)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", name, err)
+	}
+	return nil
+}
+
+func (w *xlsxWorkbook) Sheets() []string {
+	var names []string
+	for _, sheet := range w.sheets {
+		names = append(names, sheet.Name)
+	}
+	return names
+}
+
+func (w *xlsxWorkbook) Rows(name string) (*Rows, error) {
+	var sheet *xlsxSheet
+	for i := range w.sheets {
+		if w.sheets[i].Name == name || (name == "" && i == 0) {
+			sheet = &w.sheets[i]
+			break
+		}
+	}
+	if sheet == nil {
+		return nil, ErrNoSheet
+	}
+	rows, err := openEntry(w.archive, sheet.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(rows.entry)
+	rows.next = func() ([]string, error) {
+		for {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			start, ok := t.(xml.StartElement)
+			if !ok || start.Name.Local != "row" {
+				continue
+			}
+			var row xlsxRow
+			err = d.DecodeElement(&row, &start)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read sheet %s: %v", sheet.Name, err)
+			}
+			cells, err := w.cells(row)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read sheet %s: %v", sheet.Name, err)
+			}
+			return cells, nil
+		}
+	}
+	return rows, nil
+}
+
+// cells writes the cells of a row as text, placing each in the column its
+// reference names since empty cells are left out of the file. A cell whose
+// reference names no column follows the one before it.
+func (w *xlsxWorkbook) cells(row xlsxRow) ([]string, error) {
+	var cells []string
+	for _, cell := range row.Cells {
+		column := -1
+		if cell.Ref != "" {
+			column = columnIndex(cell.Ref)
+		}
+		if column < 0 {
+			column = len(cells)
+		}
+		if column >= xlsxColumns {
+			return nil, fmt.Errorf("cell %s is past the last column", cell.Ref)
+		}
+		for len(cells) <= column {
+			cells = append(cells, "")
+		}
+		cells[column] = w.value(cell)
+	}
+	return cells, nil
+}
+
+func (w *xlsxWorkbook) value(cell xlsxCell) string {
+	switch cell.Type {
+	case "s":
+		i, err := strconv.Atoi(cell.Value)
+		if err != nil || i < 0 || i >= len(w.strings) {
+			return ""
+		}
+		return w.strings[i]
+	case "inlineStr":
+		if cell.Inline == nil {
+			return ""
+		}
+		return cell.Inline.String()
+	case "b":
+		if cell.Value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "str", "e":
+		return cell.Value
+	case "d":
+		if len(cell.Value) >= len(DateLayout) {
+			return cell.Value[:len(DateLayout)]
+		}
+		return cell.Value
+	}
+	if cell.Value == "" {
+		return ""
+	}
+	if w.dateStyles[cell.Style] {
+		serial, err := strconv.ParseFloat(cell.Value, 64)
+		if err == nil {
+			return serialDate(serial, w.date1904).Format(DateLayout)
+		}
+	}
+	return formatNumber(cell.Value)
+}
+
+// xlsxColumns is how many columns a sheet has, A to XFD.
+const xlsxColumns = 16384
+
+// columnIndex reads the column of a cell reference such as AB12, which is
+// -1 when the reference has no letters. Columns past the last one are all
+// returned as xlsxColumns.
+func columnIndex(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = min(column*26+int(r-'A'+1), xlsxColumns+1)
+	}
+	return column - 1
+}
+
+// serialDate converts a spreadsheet date, counted in days from the end of
+// 1899, or from 1904 in workbooks made on old Macs.
+func serialDate(serial float64, date1904 bool) time.Time {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	return epoch.AddDate(0, 0, int(days))
+}
+
+// isBuiltinDateFormat is true for the number formats Excel defines that
+// show a date.
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 17) || id == 22 || (id >= 27 && id <= 36) || (id >= 50 && id <= 58)
+}
+
+// isDateFormat is true for a custom number format that shows a day, month
+// or year, ignoring quoted text, escapes and [colour] sections. An m next
+// to hours or seconds is minutes instead.
+func isDateFormat(code string) bool {
+	quoted, bracketed, escaped := false, false, false
+	seen := map[rune]bool{}
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted:
+			quoted = r != '"'
+		case bracketed:
+			bracketed = r != ']'
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = true
+		case r == '[':
+			bracketed = true
+		default:
+			seen[r] = true
+		}
+	}
+	return seen['d'] || seen['y'] || (seen['m'] && !seen['h'] && !seen['s'])
+}
@@ -0,0 +1,73 @@
+package spreadsheet
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"Z9", 25},
+		{"AA10", 26},
+		{"AB12", 27},
+		{"XFD1", xlsxColumns - 1},
+		{"XFE1", xlsxColumns},
+		{"ZZZZZZZZZZZZZZZZ1", xlsxColumns},
+		{"1", -1},
+		{"", -1},
+	}
+	for _, test := range tests {
+		if got := columnIndex(test.ref); got != test.want {
+			t.Errorf("columnIndex(%q) = %d, want %d", test.ref, got, test.want)
+		}
+	}
+}
+
+func TestXlsxCells(t *testing.T) {
+	tests := []struct {
+		name string
+		row  string
+		want []string
+		bad  bool
+	}{
+		{"in order", `<row><c r="A1" t="str"><v>a</v></c><c r="B1" t="str"><v>b</v></c></row>`, []string{"a", "b"}, false},
+		{"gap", `<row><c r="A1" t="str"><v>a</v></c><c r="C1" t="str"><v>c</v></c></row>`, []string{"a", "", "c"}, false},
+		{"no references", `<row><c t="str"><v>a</v></c><c t="str"><v>b</v></c></row>`, []string{"a", "b"}, false},
+		{"reference without letters", `<row><c r="A1" t="str"><v>a</v></c><c r="1" t="str"><v>b</v></c></row>`, []string{"a", "b"}, false},
+		{"last column", `<row><c r="XFD1" t="str"><v>z</v></c></row>`, nil, false},
+		{"past the last column", `<row><c r="ZZZZZZ1" t="str"><v>z</v></c></row>`, nil, true},
+	}
+	w := &xlsxWorkbook{dateStyles: map[int]bool{}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var row xlsxRow
+			if err := xml.Unmarshal([]byte(test.row), &row); err != nil {
+				t.Fatal(err)
+			}
+			got, err := w.cells(row)
+			if test.bad {
+				if err == nil {
+					t.Errorf("got %d cells, want an error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want == nil {
+				if len(got) != xlsxColumns || got[xlsxColumns-1] != "z" {
+					t.Errorf("got %d cells, want the value in column %d", len(got), xlsxColumns)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Package sru holds the responses of the Search/Retrieve via URL protocol,
+// in both its 1.2 and 2.0 versions, and the ZeeRex record that explains a
+// server.
+package sru
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+const (
+	Namespace12           = "http://www.loc.gov/zing/srw/"
+	Namespace20           = "http://docs.oasis-open.org/ns/search-ws/sruResponse"
+	DiagnosticNamespace12 = "http://www.loc.gov/zing/srw/diagnostic/"
+	DiagnosticNamespace20 = "http://docs.oasis-open.org/ns/search-ws/diagnostic"
+	ExplainNamespace      = "http://explain.z3950.org/dtd/2.0/"
+	ExplainSchema         = "http://explain.z3950.org/dtd/2.0/"
+	diagnosticURI         = "info:srw/diagnostic/1/"
+)
+
+// Codes of the diagnostics in the SRU diagnostic list.
+const (
+	GeneralSystemError          = 1
+	UnsupportedOperation        = 4
+	UnsupportedVersion          = 5
+	UnsupportedParameterValue   = 6
+	MandatoryParameterMissing   = 7
+	UnsupportedParameter        = 8
+	QuerySyntaxError            = 10
+	UnsupportedContextSet       = 15
+	UnsupportedIndex            = 16
+	UnsupportedRelation         = 19
+	UnsupportedRelationModifier = 20
+	EmptyTermUnsupported        = 27
+	InvalidTermFormat           = 36
+	UnsupportedBooleanOperator  = 37
+	UnsupportedBooleanModifier  = 46
+	FirstRecordOutOfRange       = 61
+	UnknownSchema               = 66
+	UnsupportedRecordPacking    = 71
+	XPathUnsupported            = 72
+	SortNotSupported            = 80
+	TooManySortKeys             = 84
+	StylesheetsUnsupported      = 110
+	DatabaseDoesNotExist        = 235
+)
+
+// Diagnostic reports why a request failed. It is also an error, so code
+// building a response can return one.
+type Diagnostic struct {
+	XMLName   xml.Name `xml:"diag:diagnostic"`
+	Namespace string   `xml:"xmlns:diag,attr"`
+	URI       string   `xml:"diag:uri"`
+	Details   string   `xml:"diag:details,omitempty"`
+	Message   string   `xml:"diag:message,omitempty"`
+}
+
+func NewDiagnostic(code int, details string, message string) *Diagnostic {
+	return &Diagnostic{URI: fmt.Sprintf("%s%d", diagnosticURI, code), Details: details, Message: message}
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Details != "" {
+		return fmt.Sprintf("%s: %s (%s)", d.URI, d.Message, d.Details)
+	}
+	return fmt.Sprintf("%s: %s", d.URI, d.Message)
+}
+
+type Diagnostics struct {
+	Diagnostics []*Diagnostic
+}
+
+type SearchRetrieveResponse struct {
+	XMLName            xml.Name     `xml:"zs:searchRetrieveResponse"`
+	Namespace          string       `xml:"xmlns:zs,attr"`
+	Version            string       `xml:"zs:version"`
+	NumberOfRecords    int          `xml:"zs:numberOfRecords"`
+	Records            *Records     `xml:"zs:records"`
+	NextRecordPosition int          `xml:"zs:nextRecordPosition,omitempty"`
+	Diagnostics        *Diagnostics `xml:"zs:diagnostics"`
+}
+
+type ExplainResponse struct {
+	XMLName     xml.Name     `xml:"zs:explainResponse"`
+	Namespace   string       `xml:"xmlns:zs,attr"`
+	Version     string       `xml:"zs:version"`
+	Record      *Record      `xml:"zs:record"`
+	Diagnostics *Diagnostics `xml:"zs:diagnostics"`
+}
+
+type Records struct {
+	Records []Record `xml:"zs:record"`
+}
+
+// Record carries one record in a schema. Version 1.2 says how it is
+// packed in Packing, 2.0 in XMLEscaping.
+type Record struct {
+	Schema      string     `xml:"zs:recordSchema"`
+	Packing     string     `xml:"zs:recordPacking,omitempty"`
+	XMLEscaping string     `xml:"zs:recordXMLEscaping,omitempty"`
+	Data        RecordData `xml:"zs:recordData"`
+	Position    int        `xml:"zs:recordPosition,omitempty"`
+}
+
+// RecordData holds the record element, or the record as escaped text when
+// Value is nil.
+type RecordData struct {
+	Value interface{}
+	Text  string `xml:",chardata"`
+}
+
+// Namespaces returns the response and diagnostic namespaces of version.
+func Namespaces(version string) (string, string) {
+	if version == "2.0" {
+		return Namespace20, DiagnosticNamespace20
+	}
+	return Namespace12, DiagnosticNamespace12
+}
+
+// NewDiagnostics wraps diagnostics for a response of version, or returns
+// nil when there are none.
+func NewDiagnostics(version string, diagnostics []*Diagnostic) *Diagnostics {
+	if len(diagnostics) == 0 {
+		return nil
+	}
+	_, namespace := Namespaces(version)
+	for _, d := range diagnostics {
+		d.Namespace = namespace
+	}
+	return &Diagnostics{Diagnostics: diagnostics}
+}
+
+// Explain is the ZeeRex description of a server.
+type Explain struct {
+	XMLName      xml.Name     `xml:"explain"`
+	Namespace    string       `xml:"xmlns,attr"`
+	ServerInfo   ServerInfo   `xml:"serverInfo"`
+	DatabaseInfo DatabaseInfo `xml:"databaseInfo"`
+	IndexInfo    IndexInfo    `xml:"indexInfo"`
+	SchemaInfo   SchemaInfo   `xml:"schemaInfo"`
+	ConfigInfo   ConfigInfo   `xml:"configInfo"`
+}
+
+type ServerInfo struct {
+	Protocol  string `xml:"protocol,attr"`
+	Version   string `xml:"version,attr"`
+	Transport string `xml:"transport,attr"`
+	Host      string `xml:"host"`
+	Port      int    `xml:"port"`
+	Database  string `xml:"database"`
+}
+
+type DatabaseInfo struct {
+	Title       string `xml:"title"`
+	Description string `xml:"description,omitempty"`
+}
+
+type IndexInfo struct {
+	Sets    []IndexSet `xml:"set"`
+	Indexes []Index    `xml:"index"`
+}
+
+type IndexSet struct {
+	Identifier string `xml:"identifier,attr"`
+	Name       string `xml:"name,attr"`
+}
+
+type Index struct {
+	Search bool     `xml:"search,attr"`
+	Sort   bool     `xml:"sort,attr"`
+	Title  string   `xml:"title"`
+	Map    IndexMap `xml:"map"`
+}
+
+type IndexMap struct {
+	Name IndexName `xml:"name"`
+}
+
+type IndexName struct {
+	Set   string `xml:"set,attr"`
+	Value string `xml:",chardata"`
+}
+
+type SchemaInfo struct {
+	Schemas []Schema `xml:"schema"`
+}
+
+type Schema struct {
+	Identifier string `xml:"identifier,attr"`
+	Name       string `xml:"name,attr"`
+	Title      string `xml:"title"`
+}
+
+type ConfigInfo struct {
+	Defaults []ConfigValue `xml:"default"`
+	Settings []ConfigValue `xml:"setting"`
+	Supports []ConfigValue `xml:"supports"`
+}
+
+type ConfigValue struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
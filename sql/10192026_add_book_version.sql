@@ -0,0 +1,6 @@
+ALTER TABLE master_books
+ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Line the version up with the newest snapshot in book_versions
+UPDATE master_books
+SET version = (SELECT COALESCE(MAX(version), 1) FROM book_versions WHERE book_id = master_books.id);
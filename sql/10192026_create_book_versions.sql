@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS book_versions (
+  id INTEGER PRIMARY KEY,
+  book_id INTEGER NOT NULL,
+  version INTEGER NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  note TEXT DEFAULT NULL,
+  lccn TEXT DEFAULT NULL,
+  isbn TEXT DEFAULT NULL,
+  title TEXT DEFAULT NULL,
+  author_first TEXT DEFAULT NULL,
+  author_last TEXT DEFAULT NULL,
+  copyright_date DATE DEFAULT NULL,
+  publisher TEXT DEFAULT NULL,
+  location TEXT DEFAULT NULL,
+  genre TEXT DEFAULT NULL,
+  pages TEXT DEFAULT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS book_versions_book_id_version ON book_versions (book_id, version);
+
+-- Every existing book starts out with a single snapshot of its current state
+INSERT INTO book_versions (
+  book_id,
+  version,
+  note,
+  lccn,
+  isbn,
+  title,
+  author_first,
+  author_last,
+  copyright_date,
+  publisher,
+  location,
+  genre,
+  pages
+)
+SELECT id, 1, 'Initial version', lccn, isbn, title, author_first, author_last, copyright_date, publisher, location, genre, pages
+FROM master_books;
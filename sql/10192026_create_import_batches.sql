@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS import_batches (
+  id INTEGER PRIMARY KEY,
+  library_id INTEGER NOT NULL REFERENCES libraries (id),
+  user_id INTEGER DEFAULT NULL REFERENCES users (id),
+  filename TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  finished_at TIMESTAMP DEFAULT NULL,
+  state TEXT NOT NULL DEFAULT 'running',
+  created INTEGER NOT NULL DEFAULT 0,
+  updated INTEGER NOT NULL DEFAULT 0,
+  skipped INTEGER NOT NULL DEFAULT 0,
+  held INTEGER NOT NULL DEFAULT 0,
+  error TEXT DEFAULT NULL,
+  undone_at TIMESTAMP DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS import_batches_library_id ON import_batches (library_id);
+
+-- The records an import skipped, with why
+CREATE TABLE IF NOT EXISTS import_batch_errors (
+  import_batch_id INTEGER NOT NULL REFERENCES import_batches (id),
+  record_number INTEGER NOT NULL,
+  identifier TEXT DEFAULT NULL,
+  title TEXT DEFAULT NULL,
+  message TEXT DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS import_batch_errors_import_batch_id ON import_batch_errors (import_batch_id);
+
+-- Books and versions written by an import name its batch, so undoing it
+-- knows what to delete and what to restore
+ALTER TABLE master_books
+ADD COLUMN import_batch_id INTEGER DEFAULT NULL REFERENCES import_batches (id);
+ALTER TABLE book_versions
+ADD COLUMN import_batch_id INTEGER DEFAULT NULL REFERENCES import_batches (id);
+CREATE INDEX IF NOT EXISTS book_versions_import_batch_id ON book_versions (import_batch_id);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS libraries (
+  id INTEGER PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  name TEXT NOT NULL
+);
+INSERT INTO libraries (id, name) VALUES (1, 'Home');
+
+ALTER TABLE master_books
+ADD COLUMN library_id INTEGER NOT NULL DEFAULT 1 REFERENCES libraries (id);
+CREATE INDEX IF NOT EXISTS master_books_library_id ON master_books (library_id);
+
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  name TEXT DEFAULT NULL,
+  email TEXT DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS library_users (
+  library_id INTEGER NOT NULL REFERENCES libraries (id),
+  user_id INTEGER NOT NULL REFERENCES users (id),
+  PRIMARY KEY (library_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+  library_id INTEGER NOT NULL REFERENCES libraries (id),
+  key TEXT NOT NULL,
+  value TEXT DEFAULT NULL,
+  PRIMARY KEY (library_id, key)
+);
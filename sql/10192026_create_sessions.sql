@@ -0,0 +1,15 @@
+ALTER TABLE users
+ADD COLUMN issuer TEXT DEFAULT NULL;
+ALTER TABLE users
+ADD COLUMN subject TEXT DEFAULT NULL;
+ALTER TABLE users
+ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer';
+CREATE UNIQUE INDEX IF NOT EXISTS users_issuer_subject ON users (issuer, subject);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id),
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL,
+  id_token TEXT DEFAULT NULL
+);
@@ -0,0 +1,16 @@
+-- Instance settings belong to no library, so their library_id is NULL
+-- rather than 0, which no library has. SQLite cannot drop NOT NULL from a
+-- column, so the table is rebuilt.
+CREATE TABLE settings_instance (
+  library_id INTEGER DEFAULT NULL REFERENCES libraries (id),
+  key TEXT NOT NULL,
+  value TEXT DEFAULT NULL
+);
+INSERT INTO settings_instance (library_id, key, value)
+SELECT NULLIF(library_id, 0), key, value FROM settings;
+DROP TABLE settings;
+ALTER TABLE settings_instance RENAME TO settings;
+
+-- NULLs never collide in a UNIQUE index, so instance settings are kept
+-- unique under 0
+CREATE UNIQUE INDEX settings_library_id_key ON settings (IFNULL(library_id, 0), key);
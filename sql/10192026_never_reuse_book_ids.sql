@@ -0,0 +1,41 @@
+-- Without AUTOINCREMENT SQLite gives the id of the newest book to the next
+-- one once it is deleted, which then collides with the deleted book's
+-- versions and tombstone. SQLite cannot change a primary key in place, so
+-- the table is rebuilt.
+CREATE TABLE master_books_autoincrement (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  lccn TEXT DEFAULT NULL,
+  isbn TEXT DEFAULT NULL,
+  title TEXT DEFAULT NULL,
+  author_first TEXT DEFAULT NULL,
+  author_last TEXT DEFAULT NULL,
+  copyright_date DATE DEFAULT NULL,
+  publisher TEXT DEFAULT NULL,
+  location TEXT DEFAULT NULL,
+  genre TEXT DEFAULT NULL,
+  pages TEXT DEFAULT NULL,
+  version INTEGER NOT NULL DEFAULT 1,
+  library_id INTEGER NOT NULL DEFAULT 1 REFERENCES libraries (id),
+  updated_at TIMESTAMP DEFAULT NULL,
+  import_batch_id INTEGER DEFAULT NULL REFERENCES import_batches (id)
+);
+INSERT INTO master_books_autoincrement (
+  id, created_at, lccn, isbn, title, author_first, author_last, copyright_date,
+  publisher, location, genre, pages, version, library_id, updated_at, import_batch_id
+)
+SELECT id, created_at, lccn, isbn, title, author_first, author_last, copyright_date,
+  publisher, location, genre, pages, version, library_id, updated_at, import_batch_id
+FROM master_books;
+DROP TABLE master_books;
+ALTER TABLE master_books_autoincrement RENAME TO master_books;
+CREATE INDEX IF NOT EXISTS master_books_library_id ON master_books (library_id);
+CREATE INDEX IF NOT EXISTS master_books_library_id_updated_at ON master_books (library_id, updated_at);
+
+-- Ids already freed by deleted books are not handed out again either
+DELETE FROM sqlite_sequence WHERE name = 'master_books';
+INSERT INTO sqlite_sequence (name, seq) SELECT 'master_books', MAX(id) FROM (
+  SELECT MAX(id) AS id FROM master_books
+  UNION ALL SELECT MAX(book_id) FROM book_versions
+  UNION ALL SELECT MAX(book_id) FROM deleted_books
+);
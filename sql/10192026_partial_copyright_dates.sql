@@ -0,0 +1,12 @@
+-- Copyright dates are EDTF text such as 1954, 1954-03, 1954~ or 1950/1959.
+-- A missing date is NULL rather than year 1, and dates imported as full
+-- timestamps keep only their day
+UPDATE master_books SET copyright_date = NULL
+WHERE CAST(copyright_date AS TEXT) = '' OR CAST(copyright_date AS TEXT) LIKE '0001-01-01%';
+UPDATE master_books SET copyright_date = substr(CAST(copyright_date AS TEXT), 1, 10)
+WHERE length(CAST(copyright_date AS TEXT)) > 11;
+
+UPDATE book_versions SET copyright_date = NULL
+WHERE CAST(copyright_date AS TEXT) = '' OR CAST(copyright_date AS TEXT) LIKE '0001-01-01%';
+UPDATE book_versions SET copyright_date = substr(CAST(copyright_date AS TEXT), 1, 10)
+WHERE length(CAST(copyright_date AS TEXT)) > 11;
@@ -0,0 +1,16 @@
+-- SQLite cannot add a column defaulting to CURRENT_TIMESTAMP, so every
+-- insert and update sets updated_at itself
+ALTER TABLE master_books
+ADD COLUMN updated_at TIMESTAMP DEFAULT NULL;
+
+UPDATE master_books SET updated_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+CREATE INDEX IF NOT EXISTS master_books_library_id_updated_at ON master_books (library_id, updated_at);
+
+-- Deleted books leave a tombstone so harvesters learn about the deletion
+CREATE TABLE IF NOT EXISTS deleted_books (
+  book_id INTEGER PRIMARY KEY,
+  library_id INTEGER NOT NULL REFERENCES libraries (id),
+  deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  genre TEXT DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS deleted_books_library_id_deleted_at ON deleted_books (library_id, deleted_at);
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mlibrary-htmx/pkg/cql"
+	"mlibrary-htmx/pkg/database"
+	"mlibrary-htmx/pkg/marc"
+	"mlibrary-htmx/pkg/metadata"
+	"mlibrary-htmx/pkg/sru"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Each library is a database of its own at sruPath/<library id>, which
+// clients reach without signing in.
+const sruPath = "/sru"
+
+const SRU_DEFAULT_RECORDS = 10
+const SRU_MAX_RECORDS = 100
+
+type sruSchema struct {
+	Name       string
+	Identifier string
+	Title      string
+	Record     func(book database.Book, uri string) interface{}
+}
+
+var sruSchemas = []sruSchema{
+	{
+		Name:       "dc",
+		Identifier: "info:srw/schema/1/dc-v1.1",
+		Title:      "Dublin Core",
+		Record: func(book database.Book, uri string) interface{} {
+			return metadata.NewDublinCore(book, uri).SrwDc()
+		},
+	},
+	{
+		Name:       "marcxml",
+		Identifier: "info:srw/schema/1/marcxml-v1.1",
+		Title:      "MARCXML",
+		Record: func(book database.Book, uri string) interface{} {
+			return marc.FromBook(book).XML()
+		},
+	},
+	{
+		Name:       "mods",
+		Identifier: "info:srw/schema/1/mods-v3.7",
+		Title:      "MODS",
+		Record: func(book database.Book, uri string) interface{} {
+			return metadata.NewMods(book, uri)
+		},
+	},
+}
+
+// sruContextSets are the context sets indexes can be named from, with the
+// identifiers a query may assign them by.
+var sruContextSets = []sru.IndexSet{
+	{Name: "cql", Identifier: "info:srw/cql-context-set/1/cql-v1.2"},
+	{Name: "dc", Identifier: "info:srw/cql-context-set/1/dc-v1.1"},
+	{Name: "bath", Identifier: "http://zing.z3950.org/cql/bath/2.0/"},
+	{Name: "rec", Identifier: "info:srw/cql-context-set/2/rec-1.1"},
+}
+
+// Indexes without a prefix are looked up in the default context set.
+const sruDefaultContextSet = "dc"
+
+type sruIndexKind int
+
+const (
+	sruText sruIndexKind = iota
+	sruDate
+	sruNumber
+	sruAll
+)
+
+type sruIndex struct {
+	Set     string
+	Name    string
+	Title   string
+	Kind    sruIndexKind
+	Columns []string
+	// Sort is the column sortBy orders by, if the index can sort
+	Sort string
+}
+
+var sruTextColumns = []string{"title", "author_last", "author_first", "publisher", "location", "genre", "isbn", "lccn"}
+
+var sruIndexes = []sruIndex{
+	{Set: "cql", Name: "serverChoice", Title: "Any field", Columns: sruTextColumns},
+	{Set: "cql", Name: "allRecords", Title: "All records", Kind: sruAll},
+	{Set: "dc", Name: "title", Title: "Title", Columns: []string{"title"}, Sort: "title"},
+	{Set: "dc", Name: "creator", Title: "Author", Columns: []string{"author_last", "author_first"}, Sort: "author_last"},
+	{Set: "dc", Name: "subject", Title: "Genre", Columns: []string{"genre"}, Sort: "genre"},
+	{Set: "dc", Name: "publisher", Title: "Publisher", Columns: []string{"publisher"}, Sort: "publisher"},
+	{Set: "dc", Name: "date", Title: "Copyright date", Kind: sruDate, Columns: []string{"copyright_date"}, Sort: "copyright_date"},
+	{Set: "dc", Name: "identifier", Title: "ISBN or LCCN", Columns: []string{"isbn", "lccn"}},
+	{Set: "bath", Name: "isbn", Title: "ISBN", Columns: []string{"isbn"}, Sort: "isbn"},
+	{Set: "bath", Name: "lccn", Title: "LCCN", Columns: []string{"lccn"}, Sort: "lccn"},
+	{Set: "rec", Name: "identifier", Title: "Record id", Kind: sruNumber, Columns: []string{"id"}, Sort: "id"},
+}
+
+var sruDateTerm = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// sruRequest is a request once its version and operation are known.
+type sruRequest struct {
+	version string
+	args    map[string]string
+}
+
+// sruParameters are the request parameters SRU defines, with the
+// diagnostic for those this server does not support.
+var sruParameters = map[string]int{
+	"operation": 0, "version": 0, "query": 0, "queryType": 0, "startRecord": 0,
+	"maximumRecords": 0, "recordSchema": 0, "recordPacking": 0, "recordXMLEscaping": 0,
+	"resultSetTTL": 0, "httpAccept": 0,
+	"sortKeys":    sru.SortNotSupported,
+	"recordXPath": sru.XPathUnsupported,
+	"stylesheet":  sru.StylesheetsUnsupported,
+}
+
+// HandleSru answers SRU 1.2 and 2.0 searchRetrieve and explain requests.
+func HandleSru(c echo.Context) error {
+	request, diagnostics := readSruRequest(c)
+	operation := sruOperation(request)
+
+	switch operation {
+	case "explain":
+		return sruExplain(c, request, diagnostics)
+	case "searchRetrieve":
+		response := &sru.SearchRetrieveResponse{Version: request.version}
+		if len(diagnostics) == 0 {
+			err := sruSearchRetrieve(c, request, response)
+			var diagnostic *sru.Diagnostic
+			if errors.As(err, &diagnostic) {
+				diagnostics = append(diagnostics, diagnostic)
+			} else if err != nil {
+				c.Logger().Error(err)
+				return err
+			}
+		}
+		response.Namespace, _ = sru.Namespaces(request.version)
+		response.Diagnostics = sru.NewDiagnostics(request.version, diagnostics)
+		return renderSru(c, response)
+	default:
+		diagnostics = append(diagnostics, sru.NewDiagnostic(sru.UnsupportedOperation, operation, "Unsupported operation"))
+		return sruExplain(c, request, diagnostics)
+	}
+}
+
+// readSruRequest reads the version and parameters of a request, with the
+// diagnostics for those that are not supported.
+func readSruRequest(c echo.Context) (sruRequest, []*sru.Diagnostic) {
+	request := sruRequest{version: "2.0", args: map[string]string{}}
+	for key, values := range c.QueryParams() {
+		request.args[key] = values[0]
+	}
+
+	var diagnostics []*sru.Diagnostic
+	switch version := request.args["version"]; version {
+	case "", "2.0":
+	case "1.1", "1.2":
+		request.version = "1.2"
+	default:
+		diagnostics = append(diagnostics, sru.NewDiagnostic(sru.UnsupportedVersion, "2.0", "Unsupported version"))
+	}
+	for key := range request.args {
+		code, known := sruParameters[key]
+		if !known && !strings.HasPrefix(key, "x-") {
+			code = sru.UnsupportedParameter
+		}
+		if code != 0 {
+			diagnostics = append(diagnostics, sru.NewDiagnostic(code, key, "Unsupported parameter"))
+		}
+	}
+	return request, diagnostics
+}
+
+// sruOperation is the operation a request asks for. Without one, a query
+// is a searchRetrieve and anything else an explain.
+func sruOperation(request sruRequest) string {
+	operation := request.args["operation"]
+	if operation == "" {
+		operation = "explain"
+		if request.args["query"] != "" {
+			operation = "searchRetrieve"
+		}
+	}
+	return operation
+}
+
+// sruUnknownDatabase answers a request to a library that does not exist
+// with a diagnostic, which SRU clients read, rather than an HTTP error.
+func sruUnknownDatabase(c echo.Context) error {
+	request, _ := readSruRequest(c)
+	diagnostic := sru.NewDiagnostic(sru.DatabaseDoesNotExist, c.Param("library"), "Database does not exist")
+	namespace, _ := sru.Namespaces(request.version)
+	diagnostics := sru.NewDiagnostics(request.version, []*sru.Diagnostic{diagnostic})
+	if sruOperation(request) == "searchRetrieve" {
+		return renderSru(c, &sru.SearchRetrieveResponse{Namespace: namespace, Version: request.version, Diagnostics: diagnostics})
+	}
+	return renderSru(c, &sru.ExplainResponse{Namespace: namespace, Version: request.version, Diagnostics: diagnostics})
+}
+
+func renderSru(c echo.Context, response interface{}) error {
+	b, err := xml.MarshalIndent(response, "", "  ")
+	if err != nil {
+		c.Logger().Error(err)
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
+
+// sruRecord packs a record element as the request asked.
+func sruRecord(request sruRequest, schema string, value interface{}, position int) (sru.Record, error) {
+	record := sru.Record{Schema: schema, Position: position}
+	escaping := request.args["recordXMLEscaping"]
+	if request.version == "1.2" {
+		escaping = request.args["recordPacking"]
+	} else if packing := request.args["recordPacking"]; packing != "" && packing != "packed" {
+		return record, sru.NewDiagnostic(sru.UnsupportedRecordPacking, packing, "Unsupported record packing")
+	}
+	if escaping == "" {
+		escaping = "xml"
+	}
+	switch escaping {
+	case "xml":
+		record.Data.Value = value
+	case "string":
+		b, err := xml.Marshal(value)
+		if err != nil {
+			return record, err
+		}
+		record.Data.Text = string(b)
+	default:
+		return record, sru.NewDiagnostic(sru.UnsupportedRecordPacking, escaping, "Unsupported record packing")
+	}
+	if request.version == "1.2" {
+		record.Packing = escaping
+	} else {
+		record.XMLEscaping = escaping
+	}
+	return record, nil
+}
+
+func sruSearchRetrieve(c echo.Context, request sruRequest, response *sru.SearchRetrieveResponse) error {
+	library := currentLibrary(c)
+	if request.args["query"] == "" {
+		return sru.NewDiagnostic(sru.MandatoryParameterMissing, "query", "Mandatory parameter not supplied")
+	}
+	if queryType := request.args["queryType"]; queryType != "" && queryType != "cql" {
+		return sru.NewDiagnostic(sru.UnsupportedParameterValue, "queryType", "Unsupported parameter value")
+	}
+	start, err := sruNumberParameter(request.args, "startRecord", 1, 1)
+	if err != nil {
+		return err
+	}
+	maximum, err := sruNumberParameter(request.args, "maximumRecords", SRU_DEFAULT_RECORDS, 0)
+	if err != nil {
+		return err
+	}
+	if maximum > SRU_MAX_RECORDS {
+		maximum = SRU_MAX_RECORDS
+	}
+
+	schema := sruSchemas[0]
+	if name := request.args["recordSchema"]; name != "" {
+		found := false
+		for _, s := range sruSchemas {
+			if s.Name == name || s.Identifier == name {
+				schema, found = s, true
+			}
+		}
+		if !found {
+			return sru.NewDiagnostic(sru.UnknownSchema, name, "Unknown schema for retrieval")
+		}
+	}
+
+	parsed, err := cql.Parse(request.args["query"])
+	if err != nil {
+		return sru.NewDiagnostic(sru.QuerySyntaxError, request.args["query"], err.Error())
+	}
+	filter, err := sruFilter(parsed.Root)
+	if err != nil {
+		return err
+	}
+	query := database.BookQuery{Filter: &filter}
+	query.Sort, query.Desc, err = sruSort(parsed)
+	if err != nil {
+		return err
+	}
+
+	response.NumberOfRecords, err = database.CountBooks(library.Id, query)
+	if err != nil {
+		return err
+	}
+	if maximum == 0 || response.NumberOfRecords == 0 {
+		return nil
+	}
+	if start > response.NumberOfRecords {
+		return sru.NewDiagnostic(sru.FirstRecordOutOfRange, strconv.Itoa(start), "First record position out of range")
+	}
+
+	query.Offset = start - 1
+	query.Limit = maximum
+	page, err := database.ListBooks(library.Id, query)
+	if err != nil {
+		return err
+	}
+	response.Records = &sru.Records{}
+	for i, book := range page.Books {
+		uri := bookURL(ExportOptions{BaseURL: baseURL(c)}, book)
+		record, err := sruRecord(request, schema.Identifier, schema.Record(book, uri), start+i)
+		if err != nil {
+			return err
+		}
+		response.Records.Records = append(response.Records.Records, record)
+	}
+	if next := start + len(page.Books); next <= response.NumberOfRecords {
+		response.NextRecordPosition = next
+	}
+	return nil
+}
+
+// sruNumberParameter reads a whole number parameter that must be at least min.
+func sruNumberParameter(args map[string]string, name string, fallback int, min int) (int, error) {
+	value, ok := args[name]
+	if !ok {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		return 0, sru.NewDiagnostic(sru.UnsupportedParameterValue, name, "Unsupported parameter value")
+	}
+	return n, nil
+}
+
+// findSruIndex resolves an index name, which may carry a context set
+// prefix, using the prefixes the query assigned.
+func findSruIndex(name string, prefixes map[string]string) (sruIndex, error) {
+	prefix, indexName, found := strings.Cut(name, ".")
+	if !found {
+		prefix, indexName = "", name
+	}
+	set := prefix
+	if identifier, ok := prefixes[prefix]; ok {
+		set = ""
+		for _, s := range sruContextSets {
+			if s.Identifier == identifier {
+				set = s.Name
+			}
+		}
+		if set == "" {
+			return sruIndex{}, sru.NewDiagnostic(sru.UnsupportedContextSet, identifier, "Unsupported context set")
+		}
+	} else if prefix == "" {
+		set = sruDefaultContextSet
+	}
+
+	knownSet := false
+	for _, index := range sruIndexes {
+		if strings.EqualFold(index.Set, set) {
+			knownSet = true
+			if strings.EqualFold(index.Name, indexName) {
+				return index, nil
+			}
+		}
+	}
+	if !knownSet {
+		return sruIndex{}, sru.NewDiagnostic(sru.UnsupportedContextSet, prefix, "Unsupported context set")
+	}
+	return sruIndex{}, sru.NewDiagnostic(sru.UnsupportedIndex, name, "Unsupported index")
+}
+
+// sruFilter translates a CQL query into a filter on the books.
+func sruFilter(node cql.Node) (database.BookFilter, error) {
+	switch n := node.(type) {
+	case *cql.Boolean:
+		if len(n.Modifiers) > 0 {
+			return database.BookFilter{}, sru.NewDiagnostic(sru.UnsupportedBooleanModifier, n.Modifiers[0].Name, "Unsupported boolean modifier")
+		}
+		left, err := sruFilter(n.Left)
+		if err != nil {
+			return left, err
+		}
+		right, err := sruFilter(n.Right)
+		if err != nil {
+			return right, err
+		}
+		switch n.Operator {
+		case "and":
+			return database.AndFilters(left, right), nil
+		case "or":
+			return database.OrFilters(left, right), nil
+		case "not":
+			return database.AndFilters(left, database.NotFilter(right)), nil
+		}
+		return database.BookFilter{}, sru.NewDiagnostic(sru.UnsupportedBooleanOperator, n.Operator, "Unsupported boolean operator")
+	case *cql.Clause:
+		return sruClauseFilter(n)
+	}
+	return database.BookFilter{}, fmt.Errorf("unknown CQL node %T", node)
+}
+
+func sruClauseFilter(clause *cql.Clause) (database.BookFilter, error) {
+	index, err := findSruIndex(clause.Index, clause.Prefixes)
+	if err != nil {
+		return database.BookFilter{}, err
+	}
+	if index.Kind == sruAll {
+		return database.AllBooks, nil
+	}
+
+	masked := true
+	for _, modifier := range clause.Relation.Modifiers {
+		switch modifier.Name {
+		case "ignorecase", "cql.ignorecase", "masked", "cql.masked":
+		case "unmasked", "cql.unmasked":
+			masked = false
+		default:
+			return database.BookFilter{}, sru.NewDiagnostic(sru.UnsupportedRelationModifier, modifier.Name, "Unsupported relation modifier")
+		}
+	}
+	if strings.TrimSpace(clause.Term) == "" {
+		return database.BookFilter{}, sru.NewDiagnostic(sru.EmptyTermUnsupported, "", "Empty term unsupported")
+	}
+	relation := clause.Relation.Name
+	unsupported := sru.NewDiagnostic(sru.UnsupportedRelation, relation, "Unsupported relation")
+
+	switch index.Kind {
+	case sruDate, sruNumber:
+		terms := []string{clause.Term}
+		if relation == "within" {
+			terms = strings.Fields(clause.Term)
+			if len(terms) != 2 {
+				return database.BookFilter{}, sru.NewDiagnostic(sru.InvalidTermFormat, clause.Term, "Term in invalid format for index or relation")
+			}
+		}
+		var values []interface{}
+		for _, term := range terms {
+			value, ok := sruComparable(index, term)
+			if !ok {
+				return database.BookFilter{}, sru.NewDiagnostic(sru.InvalidTermFormat, term, "Term in invalid format for index or relation")
+			}
+			values = append(values, value)
+		}
+		column := index.Columns[0]
+		switch relation {
+		case "=", "==", "<>", "<", ">", "<=", ">=":
+			comparison := relation
+			if comparison == "==" {
+				comparison = "="
+			}
+			return database.FieldCompare(column, comparison, values[0]), nil
+		case "within":
+			return database.AndFilters(database.FieldCompare(column, ">=", values[0]), database.FieldCompare(column, "<=", values[1])), nil
+		}
+		return database.BookFilter{}, unsupported
+	}
+
+	// matches finds a pattern in any of the index columns
+	matches := func(pattern string) database.BookFilter {
+		var filters []database.BookFilter
+		for _, column := range index.Columns {
+			filters = append(filters, database.FieldLike(column, pattern))
+		}
+		return database.OrFilters(filters...)
+	}
+	switch relation {
+	case "=", "adj", "scr", "cql.=":
+		return matches("%" + sruPattern(clause.Term, masked) + "%"), nil
+	case "==", "exact":
+		return matches(sruPattern(clause.Term, masked)), nil
+	case "<>":
+		return database.NotFilter(matches(sruPattern(clause.Term, masked))), nil
+	case "any", "all":
+		var filters []database.BookFilter
+		for _, word := range strings.Fields(clause.Term) {
+			filters = append(filters, matches("%"+sruPattern(word, masked)+"%"))
+		}
+		if relation == "any" {
+			return database.OrFilters(filters...), nil
+		}
+		return database.AndFilters(filters...), nil
+	}
+	return database.BookFilter{}, unsupported
+}
+
+// sruComparable reads a term of a date or number index.
+func sruComparable(index sruIndex, term string) (interface{}, bool) {
+	if index.Kind == sruNumber {
+		n, err := strconv.Atoi(term)
+		return n, err == nil
+	}
+	return term, sruDateTerm.MatchString(term)
+}
+
+// sruPattern turns a CQL term into a LIKE pattern. * and ? mask any number
+// of characters and a single one unless the term is unmasked, and a
+// backslash keeps the next character as it is.
+func sruPattern(term string, masked bool) string {
+	var sb strings.Builder
+	escaped := false
+	for _, r := range term {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+			continue
+		case masked && r == '*':
+			sb.WriteByte('%')
+			continue
+		case masked && r == '?':
+			sb.WriteByte('_')
+			continue
+		}
+		if r == '%' || r == '_' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// sruSort reads the sortBy clause. The book queries order by a single
+// column, so only one key is supported.
+func sruSort(query *cql.Query) (string, bool, error) {
+	if len(query.SortKeys) == 0 {
+		return "", false, nil
+	}
+	if len(query.SortKeys) > 1 {
+		return "", false, sru.NewDiagnostic(sru.TooManySortKeys, "1", "Too many sort keys")
+	}
+	key := query.SortKeys[0]
+	index, err := findSruIndex(key.Index, query.Prefixes)
+	if err != nil {
+		return "", false, err
+	}
+	if index.Sort == "" {
+		return "", false, sru.NewDiagnostic(sru.SortNotSupported, key.Index, "Sort not supported")
+	}
+	desc := false
+	for _, modifier := range key.Modifiers {
+		switch modifier.Name {
+		case "sort.ascending", "ascending":
+			desc = false
+		case "sort.descending", "descending":
+			desc = true
+		case "sort.ignorecase", "ignorecase":
+		default:
+			return "", false, sru.NewDiagnostic(sru.SortNotSupported, modifier.Name, "Sort not supported")
+		}
+	}
+	return index.Sort, desc, nil
+}
+
+func sruExplain(c echo.Context, request sruRequest, diagnostics []*sru.Diagnostic) error {
+	library := currentLibrary(c)
+	host, port, err := net.SplitHostPort(c.Request().Host)
+	if err != nil {
+		host, port = c.Request().Host, "80"
+		if c.Scheme() == "https" {
+			port = "443"
+		}
+	}
+	portNumber, _ := strconv.Atoi(port)
+
+	explain := sru.Explain{
+		Namespace: sru.ExplainNamespace,
+		ServerInfo: sru.ServerInfo{
+			Protocol:  "SRU",
+			Version:   request.version,
+			Transport: c.Scheme(),
+			Host:      host,
+			Port:      portNumber,
+			Database:  fmt.Sprintf("%s/%d", strings.TrimPrefix(sruPath, "/"), library.Id),
+		},
+		DatabaseInfo: sru.DatabaseInfo{Title: library.Name, Description: "The books of " + library.Name},
+		IndexInfo:    sru.IndexInfo{Sets: sruContextSets},
+		ConfigInfo: sru.ConfigInfo{
+			Defaults: []sru.ConfigValue{
+				{Type: "numberOfRecords", Value: strconv.Itoa(SRU_DEFAULT_RECORDS)},
+				{Type: "contextSet", Value: sruDefaultContextSet},
+				{Type: "index", Value: "cql.serverChoice"},
+			},
+			Settings: []sru.ConfigValue{{Type: "maximumRecords", Value: strconv.Itoa(SRU_MAX_RECORDS)}},
+			Supports: []sru.ConfigValue{
+				{Type: "relation", Value: "="}, {Type: "relation", Value: "=="}, {Type: "relation", Value: "<>"},
+				{Type: "relation", Value: "<"}, {Type: "relation", Value: ">"}, {Type: "relation", Value: "<="},
+				{Type: "relation", Value: ">="}, {Type: "relation", Value: "any"}, {Type: "relation", Value: "all"},
+				{Type: "relation", Value: "adj"}, {Type: "relation", Value: "exact"}, {Type: "relation", Value: "within"},
+				{Type: "booleanModifier", Value: "none"},
+				{Type: "maskingCharacter", Value: "*"}, {Type: "maskingCharacter", Value: "?"},
+				{Type: "sort", Value: "single key"},
+			},
+		},
+	}
+	for _, index := range sruIndexes {
+		explain.IndexInfo.Indexes = append(explain.IndexInfo.Indexes, sru.Index{
+			Search: true,
+			Sort:   index.Sort != "",
+			Title:  index.Title,
+			Map:    sru.IndexMap{Name: sru.IndexName{Set: index.Set, Value: index.Name}},
+		})
+	}
+	for _, schema := range sruSchemas {
+		explain.SchemaInfo.Schemas = append(explain.SchemaInfo.Schemas, sru.Schema{Identifier: schema.Identifier, Name: schema.Name, Title: schema.Title})
+	}
+
+	response := &sru.ExplainResponse{Version: request.version}
+	response.Namespace, _ = sru.Namespaces(request.version)
+	record, err := sruRecord(request, sru.ExplainSchema, explain, 0)
+	if err != nil {
+		var diagnostic *sru.Diagnostic
+		if !errors.As(err, &diagnostic) {
+			c.Logger().Error(err)
+			return err
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	} else {
+		response.Record = &record
+	}
+	response.Diagnostics = sru.NewDiagnostics(request.version, diagnostics)
+	return renderSru(c, response)
+}